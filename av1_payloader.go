@@ -0,0 +1,147 @@
+package gostream
+
+// The OBU types that matter for packetizing AV1.
+// See https://aomediacodec.github.io/av1-spec/#obu-header-semantics.
+const (
+	av1OBUSequenceHeader    = 1
+	av1OBUTemporalDelimiter = 2
+	av1OBUTileList          = 8
+)
+
+// The bits of the aggregation header that starts every AV1 RTP payload.
+// See https://aomediacodec.github.io/av1-rtp-spec/#44-av1-aggregation-header.
+const (
+	av1AggregationZ = 0b10000000
+	av1AggregationY = 0b01000000
+	av1AggregationN = 0b00001000
+)
+
+// av1Payloader packetizes temporal units of AV1 as produced by encoders: a sequence
+// of OBUs that each carry their size. Every OBU is sent as its own OBU element with
+// its size field removed, fragmenting those that do not fit in a packet. Temporal
+// delimiters and tile lists are dropped as the RTP payload format requires. Unlike
+// codecs.AV1Payloader, which sends a whole payload as a single OBU element, this
+// lets receivers tell the OBUs of a temporal unit apart.
+type av1Payloader struct{}
+
+// Payload fragments a temporal unit across one or more payloads of at most mtu bytes.
+func (p *av1Payloader) Payload(mtu uint16, temporalUnit []byte) [][]byte {
+	obus, sequenceHeader := parseAV1OBUs(temporalUnit)
+	if len(obus) == 0 || mtu <= 3 {
+		return nil
+	}
+
+	var payloads [][]byte
+	current := []byte{0}
+	if sequenceHeader {
+		current[0] |= av1AggregationN
+	}
+	flush := func(continues bool) {
+		if continues {
+			current[0] |= av1AggregationY
+		}
+		payloads = append(payloads, current)
+		current = []byte{0}
+		if continues {
+			current[0] |= av1AggregationZ
+		}
+	}
+	for _, obu := range obus {
+		for len(obu) != 0 {
+			room := int(mtu) - len(current)
+			if size := leb128Size(len(obu)) + len(obu); size <= room {
+				current = appendLEB128(current, len(obu))
+				current = append(current, obu...)
+				break
+			}
+			// fragment the rest of the OBU to fill up the payload
+			fragmentSize := room - leb128Size(room)
+			if fragmentSize <= 0 {
+				flush(false)
+				continue
+			}
+			current = appendLEB128(current, fragmentSize)
+			current = append(current, obu[:fragmentSize]...)
+			obu = obu[fragmentSize:]
+			flush(true)
+		}
+	}
+	if len(current) > 1 {
+		flush(false)
+	}
+	return payloads
+}
+
+// parseAV1OBUs splits a temporal unit into the OBUs that should be sent, with their size
+// fields removed. It also reports whether there is a sequence header, which starts a new
+// coded video sequence. Parsing stops at the first malformed OBU.
+func parseAV1OBUs(temporalUnit []byte) (obus [][]byte, sequenceHeader bool) {
+	for len(temporalUnit) != 0 {
+		header := temporalUnit[0]
+		obuType := (header >> 3) & 0b1111
+		headerSize := 1
+		if header&0b100 != 0 {
+			// extension header
+			headerSize = 2
+		}
+		if len(temporalUnit) < headerSize {
+			return obus, sequenceHeader
+		}
+
+		rest := temporalUnit[headerSize:]
+		size := len(rest)
+		if header&0b10 != 0 {
+			var n int
+			size, n = readLEB128(rest)
+			if n == 0 || size > len(rest)-n {
+				return obus, sequenceHeader
+			}
+			rest = rest[n:]
+		}
+
+		switch obuType {
+		case av1OBUTemporalDelimiter, av1OBUTileList:
+		default:
+			if obuType == av1OBUSequenceHeader {
+				sequenceHeader = true
+			}
+			obu := make([]byte, 0, headerSize+size)
+			obu = append(obu, header&^0b10)
+			obu = append(obu, temporalUnit[1:headerSize]...)
+			obu = append(obu, rest[:size]...)
+			obus = append(obus, obu)
+		}
+		temporalUnit = rest[size:]
+	}
+	return obus, sequenceHeader
+}
+
+// readLEB128 decodes an unsigned LEB128 value, returning it and how many bytes it took up.
+// It returns zero bytes if the value is malformed.
+func readLEB128(b []byte) (int, int) {
+	var value int
+	for i := 0; i < len(b) && i < 8; i++ {
+		value |= int(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func leb128Size(value int) int {
+	size := 1
+	for value >= 0x80 {
+		value >>= 7
+		size++
+	}
+	return size
+}
+
+func appendLEB128(b []byte, value int) []byte {
+	for value >= 0x80 {
+		b = append(b, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+	return append(b, byte(value))
+}
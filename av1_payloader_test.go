@@ -0,0 +1,64 @@
+package gostream
+
+import (
+	"bytes"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+// av1OBU returns an OBU of the given type that carries its size, as encoders produce.
+func av1OBU(obuType byte, payload []byte) []byte {
+	return append(appendLEB128([]byte{obuType<<3 | 0b10}, len(payload)), payload...)
+}
+
+func TestAV1Payloader(t *testing.T) {
+	sequenceHeader := bytes.Repeat([]byte{1}, 10)
+	frame := bytes.Repeat([]byte{2}, 300)
+	temporalUnit := append(av1OBU(av1OBUTemporalDelimiter, nil), av1OBU(av1OBUSequenceHeader, sequenceHeader)...)
+	temporalUnit = append(temporalUnit, av1OBU(6, frame)...)
+
+	t.Run("aggregates", func(t *testing.T) {
+		payloads := (&av1Payloader{}).Payload(1200, temporalUnit)
+		test.That(t, payloads, test.ShouldHaveLength, 1)
+		expected := []byte{av1AggregationN, 11, 1 << 3}
+		expected = append(expected, sequenceHeader...)
+		expected = append(appendLEB128(expected, 301), 6<<3)
+		expected = append(expected, frame...)
+		test.That(t, payloads[0], test.ShouldResemble, expected)
+	})
+
+	t.Run("fragments", func(t *testing.T) {
+		payloads := (&av1Payloader{}).Payload(100, temporalUnit)
+		test.That(t, len(payloads), test.ShouldBeGreaterThan, 3)
+		var obus [][]byte
+		var fragment []byte
+		for i, payload := range payloads {
+			test.That(t, len(payload), test.ShouldBeLessThanOrEqualTo, 100)
+			header := payload[0]
+			test.That(t, header&av1AggregationN != 0, test.ShouldEqual, i == 0)
+			test.That(t, header&av1AggregationZ != 0, test.ShouldEqual, fragment != nil)
+			rest := payload[1:]
+			for len(rest) != 0 {
+				size, n := readLEB128(rest)
+				test.That(t, n, test.ShouldBeGreaterThan, 0)
+				fragment = append(fragment, rest[n:n+size]...)
+				rest = rest[n+size:]
+				if len(rest) == 0 && header&av1AggregationY != 0 {
+					break
+				}
+				obus = append(obus, fragment)
+				fragment = nil
+			}
+		}
+		test.That(t, fragment, test.ShouldBeNil)
+		test.That(t, obus, test.ShouldResemble, [][]byte{
+			append([]byte{1 << 3}, sequenceHeader...),
+			append([]byte{6 << 3}, frame...),
+		})
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		test.That(t, (&av1Payloader{}).Payload(1200, []byte{6<<3 | 0b10, 0xff}), test.ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,503 @@
+package gostream
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// DefaultMinVideoBitRate is the lowest bit rate, in bits per second, that congestion control
+// will lower a video stream to by default.
+const DefaultMinVideoBitRate = 100_000
+
+// CongestionControlConfig describes how a Stream adapts its video bit rate to the bandwidth
+// available to its peers.
+type CongestionControlConfig struct {
+	// Enabled turns on estimating bandwidth from the RTCP feedback sent by peers. The
+	// bit rate follows packet loss and, for peers sending transport-wide congestion control
+	// feedback, how the delay of packets changes. Peers only send that feedback about
+	// packets numbered by the interceptor that webrtc.ConfigureTWCCHeaderExtensionSender
+	// registers. Encoders that cannot change their bit rate without being rebuilt are
+	// rebuilt to change it at most once every two seconds.
+	Enabled bool
+
+	// MinBitRate and MaxBitRate bound the bit rate chosen by congestion control. They
+	// default to DefaultMinVideoBitRate and the initial bit rate of the video encoder.
+	MinBitRate int
+	MaxBitRate int
+}
+
+// Constants from the loss based controller of Google Congestion Control.
+// See https://datatracker.ietf.org/doc/html/draft-ietf-rmcat-gcc-02#section-6.
+const (
+	lossIncreaseThreshold = 0.02
+	lossDecreaseThreshold = 0.1
+	lossIncreaseFactor    = 1.08
+	lossIncreaseInterval  = time.Second
+)
+
+// Constants for the delay based controller, which follows Google Congestion Control in
+// spirit but detects overuse from a smoothed delay gradient rather than a Kalman filter.
+// See https://datatracker.ietf.org/doc/html/draft-ietf-rmcat-gcc-02#section-5.
+const (
+	// packets sent within delayGroupDuration of each other, such as those of a frame,
+	// are grouped together.
+	delayGroupDuration = 5 * time.Millisecond
+
+	// the delay gradient is how much longer than they were sent apart groups arrived apart,
+	// relative to how long they were sent apart. The network is overused once its smoothed
+	// value, which weighs each group by delayGradientSmoothing, passes
+	// delayOveruseThreshold: a queue building up by that much per second sent.
+	delayGradientSmoothing = 0.1
+	delayOveruseThreshold  = 0.1
+
+	// on overuse the estimate drops to delayDecreaseFactor of the rate at which packets
+	// arrived over the last receiveRateWindow, at most once every delayDecreaseInterval
+	// to give the queue time to drain.
+	delayDecreaseFactor   = 0.85
+	delayDecreaseInterval = 500 * time.Millisecond
+	receiveRateWindow     = time.Second
+)
+
+// A bandwidthEstimator estimates the bandwidth available for sending media to a single
+// peer. Its estimate is the lower of one that follows the packet loss reported by the peer
+// in receiver reports and transport-wide congestion control feedback, and one that follows
+// how the delay of packets described by the transport-wide feedback changes, and is capped
+// by any receiver estimated maximum bit rate (REMB) that the peer sends.
+type bandwidthEstimator struct {
+	estimate     float64
+	remb         float64
+	minBitRate   float64
+	maxBitRate   float64
+	lastIncrease time.Time
+
+	// delayEstimate is only set once transport-wide feedback has described two groups
+	// of packets that were sent. Arrival times are on the clock of the peer.
+	delayEstimate     float64
+	group, lastGroup  packetGroup
+	delayGradient     float64
+	received          []receivedPacket
+	lastDelayIncrease time.Time
+	lastDelayDecrease time.Time
+}
+
+// A packetGroup is packets sent close together, described by when the first and last of
+// them were sent and when the last of them arrived.
+type packetGroup struct {
+	firstSent, lastSent time.Time
+	arrival             time.Duration
+}
+
+// A receivedPacket is when a packet of the given size, in bytes, arrived.
+type receivedPacket struct {
+	arrival time.Duration
+	size    int
+}
+
+func newBandwidthEstimator(initialBitRate, minBitRate, maxBitRate int) *bandwidthEstimator {
+	return &bandwidthEstimator{
+		estimate:   float64(initialBitRate),
+		minBitRate: float64(minBitRate),
+		maxBitRate: float64(maxBitRate),
+	}
+}
+
+// update feeds RTCP packets from the peer into the estimator and returns the new estimate.
+// Only feedback about the given media SSRCs is considered. sent, if set, returns when
+// the packet with the given transport-wide sequence number was sent to the peer, if it
+// was one of its packets.
+func (be *bandwidthEstimator) update(
+	pkts []rtcp.Packet,
+	ssrcs []webrtc.SSRC,
+	sent func(sequenceNumber uint16) (sentPacket, bool),
+	now time.Time,
+) int {
+	isOurs := func(ssrc uint32) bool {
+		for _, ours := range ssrcs {
+			if uint32(ours) == ssrc {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, pkt := range pkts {
+		switch pkt := pkt.(type) {
+		case *rtcp.ReceiverReport:
+			for _, report := range pkt.Reports {
+				if isOurs(report.SSRC) {
+					be.onLoss(float64(report.FractionLost)/256, now)
+				}
+			}
+		case *rtcp.SenderReport:
+			for _, report := range pkt.Reports {
+				if isOurs(report.SSRC) {
+					be.onLoss(float64(report.FractionLost)/256, now)
+				}
+			}
+		case *rtcp.TransportLayerCC:
+			if received, total := transportCCReceived(pkt); total != 0 {
+				be.onLoss(1-float64(received)/float64(total), now)
+			}
+			if sent == nil {
+				continue
+			}
+			for _, arrival := range transportCCArrivals(pkt) {
+				if packet, ok := sent(arrival.sequenceNumber); ok {
+					be.onArrival(packet, arrival.at, now)
+				}
+			}
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			for _, ssrc := range pkt.SSRCs {
+				if isOurs(ssrc) {
+					be.remb = float64(pkt.Bitrate)
+					break
+				}
+			}
+		}
+	}
+	return be.bitRate()
+}
+
+// onLoss adjusts the estimate based on the fraction of packets lost since the last report.
+func (be *bandwidthEstimator) onLoss(fractionLost float64, now time.Time) {
+	switch {
+	case fractionLost > lossDecreaseThreshold:
+		be.estimate *= 1 - 0.5*fractionLost
+	case fractionLost < lossIncreaseThreshold:
+		if now.Sub(be.lastIncrease) < lossIncreaseInterval {
+			return
+		}
+		be.lastIncrease = now
+		be.estimate *= lossIncreaseFactor
+	default:
+	}
+	// do not let the estimate drift outside of the bounds or it will take too
+	// long to react once conditions change.
+	be.estimate = math.Max(be.minBitRate, math.Min(be.maxBitRate, be.estimate))
+}
+
+// onArrival groups the sent packets by when they were sent and adjusts the delay based
+// estimate each time a group is complete.
+func (be *bandwidthEstimator) onArrival(packet sentPacket, arrival time.Duration, now time.Time) {
+	be.received = append(be.received, receivedPacket{arrival: arrival, size: packet.size})
+	for len(be.received) > 1 && arrival-be.received[0].arrival > receiveRateWindow {
+		be.received = be.received[1:]
+	}
+
+	switch {
+	case be.group.firstSent.IsZero():
+		be.group = packetGroup{firstSent: packet.at, lastSent: packet.at, arrival: arrival}
+		return
+	case packet.at.Before(be.group.firstSent):
+		// packets of groups already complete, such as retransmissions, are late
+		return
+	case packet.at.Sub(be.group.firstSent) <= delayGroupDuration:
+		be.group.lastSent = packet.at
+		if arrival > be.group.arrival {
+			be.group.arrival = arrival
+		}
+		return
+	}
+
+	if !be.lastGroup.firstSent.IsZero() {
+		sentApart := be.group.lastSent.Sub(be.lastGroup.lastSent)
+		arrivedApart := be.group.arrival - be.lastGroup.arrival
+		if sentApart > 0 {
+			gradient := float64(arrivedApart-sentApart) / float64(sentApart)
+			be.delayGradient += delayGradientSmoothing * (gradient - be.delayGradient)
+			be.onDelayGradient(now)
+		}
+	}
+	be.lastGroup = be.group
+	be.group = packetGroup{firstSent: packet.at, lastSent: packet.at, arrival: arrival}
+}
+
+// onDelayGradient adjusts the delay based estimate based on whether the smoothed delay
+// gradient shows that packets are queueing up in the network.
+func (be *bandwidthEstimator) onDelayGradient(now time.Time) {
+	if be.delayEstimate == 0 {
+		be.delayEstimate = be.estimate
+	}
+	if be.delayGradient > delayOveruseThreshold {
+		if now.Sub(be.lastDelayDecrease) < delayDecreaseInterval {
+			return
+		}
+		be.lastDelayDecrease = now
+		if rate := be.receiveRate(); rate != 0 {
+			be.delayEstimate = delayDecreaseFactor * rate
+		} else {
+			be.delayEstimate *= delayDecreaseFactor
+		}
+	} else {
+		if now.Sub(be.lastDelayIncrease) < lossIncreaseInterval {
+			return
+		}
+		be.lastDelayIncrease = now
+		be.delayEstimate *= lossIncreaseFactor
+	}
+	be.delayEstimate = math.Max(be.minBitRate, math.Min(be.maxBitRate, be.delayEstimate))
+}
+
+// receiveRate returns the rate, in bits per second, at which packets that were sent arrived
+// over the last receiveRateWindow, or 0 if too few have arrived to tell.
+func (be *bandwidthEstimator) receiveRate() float64 {
+	if len(be.received) < 2 {
+		return 0
+	}
+	elapsed := be.received[len(be.received)-1].arrival - be.received[0].arrival
+	if elapsed <= 0 {
+		return 0
+	}
+	var bytes int
+	// the first packet arrived at the start of the window
+	for _, packet := range be.received[1:] {
+		bytes += packet.size
+	}
+	return float64(bytes*8) / elapsed.Seconds()
+}
+
+// bitRate returns the current estimate, capped by the REMB if one has been received.
+func (be *bandwidthEstimator) bitRate() int {
+	estimate := be.estimate
+	if be.delayEstimate != 0 {
+		estimate = math.Min(estimate, be.delayEstimate)
+	}
+	if be.remb != 0 {
+		estimate = math.Min(estimate, be.remb)
+	}
+	return int(math.Max(be.minBitRate, math.Min(be.maxBitRate, estimate)))
+}
+
+// transportCCReceived counts how many of the packets described by the feedback
+// were received.
+func transportCCReceived(pkt *rtcp.TransportLayerCC) (received, total int) {
+	transportCCStatuses(pkt, func(symbol uint16) {
+		total++
+		if symbol != rtcp.TypeTCCPacketNotReceived {
+			received++
+		}
+	})
+	return received, total
+}
+
+// A packetArrival is when the packet with a transport-wide sequence number arrived, on the
+// clock of the peer.
+type packetArrival struct {
+	sequenceNumber uint16
+	at             time.Duration
+}
+
+// transportCCArrivals returns when each of the packets described by the feedback that
+// were received arrived, in order of their sequence numbers.
+func transportCCArrivals(pkt *rtcp.TransportLayerCC) []packetArrival {
+	var arrivals []packetArrival
+	sequenceNumber := pkt.BaseSequenceNumber
+	// the reference time is in multiples of 64ms and each packet arrived the delta after
+	// the one before it, or the reference time for the first
+	at := time.Duration(pkt.ReferenceTime) * 64 * time.Millisecond
+	transportCCStatuses(pkt, func(symbol uint16) {
+		if symbol != rtcp.TypeTCCPacketNotReceived && len(arrivals) < len(pkt.RecvDeltas) {
+			at += time.Duration(pkt.RecvDeltas[len(arrivals)].Delta) * time.Microsecond
+			arrivals = append(arrivals, packetArrival{sequenceNumber: sequenceNumber, at: at})
+		}
+		sequenceNumber++
+	})
+	return arrivals
+}
+
+// transportCCStatuses calls the function with the status symbol of each of the packets
+// described by the feedback, in order of their sequence numbers.
+func transportCCStatuses(pkt *rtcp.TransportLayerCC, status func(symbol uint16)) {
+	remaining := int(pkt.PacketStatusCount)
+	for _, chunk := range pkt.PacketChunks {
+		switch chunk := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			for i := 0; i < int(chunk.RunLength) && remaining > 0; i++ {
+				status(chunk.PacketStatusSymbol)
+				remaining--
+			}
+		case *rtcp.StatusVectorChunk:
+			for _, symbol := range chunk.SymbolList {
+				if remaining == 0 {
+					break
+				}
+				status(symbol)
+				remaining--
+			}
+		}
+	}
+}
+
+// sentPacketHistorySize is how many of the latest packets sent to a peer on a track are
+// remembered for matching transport-wide feedback against.
+const sentPacketHistorySize = 1 << 10
+
+// A sentPacket is when a packet of the given size, in bytes, was sent with a transport-wide
+// sequence number.
+type sentPacket struct {
+	sequenceNumber uint16
+	at             time.Time
+	size           int
+}
+
+// sentPackets remembers the latest packets sent to a peer on a track by their transport-wide
+// sequence numbers, which are shared with the other tracks sent to the peer.
+type sentPackets struct {
+	packets [sentPacketHistorySize]sentPacket
+}
+
+func (sp *sentPackets) add(packet sentPacket) {
+	sp.packets[int(packet.sequenceNumber)%sentPacketHistorySize] = packet
+}
+
+// get returns the packet with the given sequence number, if it is remembered.
+func (sp *sentPackets) get(sequenceNumber uint16) (sentPacket, bool) {
+	packet := sp.packets[int(sequenceNumber)%sentPacketHistorySize]
+	if packet.at.IsZero() || packet.sequenceNumber != sequenceNumber {
+		return sentPacket{}, false
+	}
+	return packet, true
+}
+
+// Hysteresis for changing the bit rate of the encoder. Decreases relieve congestion so they
+// only wait long enough for the last one to show up in the feedback of peers, but increases
+// wait a little longer to avoid constantly retuning encoders.
+const (
+	congestionDecreaseThreshold = 0.95
+	congestionDecreaseInterval  = 500 * time.Millisecond
+	congestionIncreaseThreshold = 1.1
+	congestionIncreaseInterval  = 2 * time.Second
+)
+
+// A congestionController sets the video bit rate of a stream to what the most constrained
+// of its peers can receive.
+type congestionController struct {
+	mu           sync.Mutex
+	config       CongestionControlConfig
+	estimators   map[*webrtc.RTPSender]*bandwidthEstimator
+	bitRate      int
+	lastChange   time.Time
+	lastDecrease time.Time
+	setBitRate   func(bitRate int, now time.Time) error
+	logger       golog.Logger
+
+	// sentPacket returns when the packet with the given transport-wide sequence number
+	// was sent to the peer with the given SSRC, if it is remembered.
+	sentPacket func(ssrc webrtc.SSRC, sequenceNumber uint16) (sentPacket, bool)
+}
+
+// newCongestionController returns a controller that changes the bit rate with setBitRate,
+// which should retune encoders rather than rebuild them where it can since every rebuilt
+// encoder starts with a key frame, the largest of frames, just as the network is congested.
+// A bit rate is only kept once setBitRate succeeds; otherwise it is set again on the next
+// adjustment.
+func newCongestionController(
+	config CongestionControlConfig,
+	initialBitRate int,
+	setBitRate func(bitRate int, now time.Time) error,
+	sentPacket func(ssrc webrtc.SSRC, sequenceNumber uint16) (sentPacket, bool),
+	logger golog.Logger,
+) *congestionController {
+	if config.MinBitRate == 0 {
+		config.MinBitRate = DefaultMinVideoBitRate
+	}
+	if config.MaxBitRate == 0 {
+		config.MaxBitRate = initialBitRate
+	}
+	return &congestionController{
+		config:     config,
+		estimators: map[*webrtc.RTPSender]*bandwidthEstimator{},
+		bitRate:    initialBitRate,
+		setBitRate: setBitRate,
+		sentPacket: sentPacket,
+		logger:     logger,
+	}
+}
+
+// handleRTCP updates the estimate for the peer behind the given sender.
+func (cc *congestionController) handleRTCP(sender *webrtc.RTPSender, pkts []rtcp.Packet) {
+	var ssrcs []webrtc.SSRC
+	for _, encoding := range sender.GetParameters().Encodings {
+		ssrcs = append(ssrcs, encoding.SSRC)
+	}
+	cc.handleFeedback(sender, ssrcs, pkts, time.Now())
+}
+
+// handleFeedback updates the estimate for the peer behind the given sender, which sends
+// the given SSRCs, from its feedback received at the given time.
+func (cc *congestionController) handleFeedback(
+	sender *webrtc.RTPSender,
+	ssrcs []webrtc.SSRC,
+	pkts []rtcp.Packet,
+	now time.Time,
+) {
+	sent := func(sequenceNumber uint16) (sentPacket, bool) {
+		for _, ssrc := range ssrcs {
+			if packet, ok := cc.sentPacket(ssrc, sequenceNumber); ok {
+				return packet, true
+			}
+		}
+		return sentPacket{}, false
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	estimator, ok := cc.estimators[sender]
+	if !ok {
+		estimator = newBandwidthEstimator(cc.bitRate, cc.config.MinBitRate, cc.config.MaxBitRate)
+		cc.estimators[sender] = estimator
+	}
+	estimator.update(pkts, ssrcs, sent, now)
+	cc.adjust(now)
+}
+
+// removeSender forgets about the peer behind the given sender.
+func (cc *congestionController) removeSender(sender *webrtc.RTPSender) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.estimators, sender)
+	cc.adjust(time.Now())
+}
+
+// adjust sets the bit rate to the lowest estimate across all peers. It assumes mu is held.
+func (cc *congestionController) adjust(now time.Time) {
+	if len(cc.estimators) == 0 {
+		return
+	}
+	target := math.MaxInt
+	for _, estimator := range cc.estimators {
+		if bitRate := estimator.bitRate(); bitRate < target {
+			target = bitRate
+		}
+	}
+
+	ratio := float64(target) / float64(cc.bitRate)
+	switch {
+	case ratio <= congestionDecreaseThreshold && now.Sub(cc.lastDecrease) >= congestionDecreaseInterval:
+	case target > cc.bitRate &&
+		(ratio >= congestionIncreaseThreshold || target == cc.config.MaxBitRate) &&
+		now.Sub(cc.lastChange) >= congestionIncreaseInterval:
+	default:
+		return
+	}
+	cc.logger.Debugw("adjusting video bit rate", "from", cc.bitRate, "to", target)
+	if err := cc.setBitRate(target, now); err != nil {
+		if errors.Is(err, errBitRateRebuildThrottled) {
+			cc.logger.Debugw("waiting to adjust video bit rate", "bit_rate", target, "error", err)
+		} else {
+			cc.logger.Errorw("error adjusting video bit rate", "bit_rate", target, "error", err)
+		}
+		return
+	}
+	if target < cc.bitRate {
+		cc.lastDecrease = now
+	}
+	cc.bitRate = target
+	cc.lastChange = now
+}
@@ -0,0 +1,204 @@
+package gostream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+func TestBandwidthEstimator(t *testing.T) {
+	const ssrc = 1234
+	ssrcs := []webrtc.SSRC{ssrc}
+	lossReport := func(fractionLost uint8) []rtcp.Packet {
+		return []rtcp.Packet{&rtcp.ReceiverReport{
+			Reports: []rtcp.ReceptionReport{{SSRC: ssrc, FractionLost: fractionLost}},
+		}}
+	}
+	now := time.Now()
+
+	t.Run("decreases on heavy loss", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		// 64/256 = 25% loss
+		test.That(t, be.update(lossReport(64), ssrcs, nil, now), test.ShouldEqual, 875_000)
+	})
+
+	t.Run("holds on moderate loss", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		// 13/256 = ~5% loss
+		test.That(t, be.update(lossReport(13), ssrcs, nil, now), test.ShouldEqual, 1_000_000)
+	})
+
+	t.Run("increases at most once per interval", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		test.That(t, be.update(lossReport(0), ssrcs, nil, now), test.ShouldEqual, 1_080_000)
+		test.That(t, be.update(lossReport(0), ssrcs, nil, now.Add(time.Millisecond)), test.ShouldEqual, 1_080_000)
+		test.That(t, be.update(lossReport(0), ssrcs, nil, now.Add(lossIncreaseInterval)), test.ShouldEqual, 1_166_400)
+	})
+
+	t.Run("stays within bounds", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 900_000, 1_050_000)
+		test.That(t, be.update(lossReport(255), ssrcs, nil, now), test.ShouldEqual, 900_000)
+		be = newBandwidthEstimator(1_000_000, 900_000, 1_050_000)
+		test.That(t, be.update(lossReport(0), ssrcs, nil, now), test.ShouldEqual, 1_050_000)
+	})
+
+	t.Run("ignores other ssrcs", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		test.That(t, be.update(lossReport(255), []webrtc.SSRC{ssrc + 1}, nil, now), test.ShouldEqual, 1_000_000)
+	})
+
+	t.Run("capped by remb", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		remb := []rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 500_000, SSRCs: []uint32{ssrc}}}
+		test.That(t, be.update(remb, ssrcs, nil, now), test.ShouldEqual, 500_000)
+	})
+
+	t.Run("uses transport-wide feedback", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		feedback := []rtcp.Packet{&rtcp.TransportLayerCC{
+			PacketStatusCount: 8,
+			PacketChunks: []rtcp.PacketStatusChunk{
+				&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketReceivedSmallDelta, RunLength: 6},
+				&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketNotReceived, RunLength: 2},
+			},
+		}}
+		test.That(t, be.update(feedback, ssrcs, nil, now), test.ShouldEqual, 875_000)
+	})
+}
+
+// testTransportCCFeedback returns feedback that the count packets from the given sequence
+// number on all arrived, the given time apart.
+func testTransportCCFeedback(sequenceNumber uint16, count int, apart time.Duration) *rtcp.TransportLayerCC {
+	feedback := &rtcp.TransportLayerCC{
+		BaseSequenceNumber: sequenceNumber,
+		PacketStatusCount:  uint16(count),
+		PacketChunks: []rtcp.PacketStatusChunk{
+			&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketReceivedSmallDelta, RunLength: uint16(count)},
+		},
+	}
+	for i := 0; i < count; i++ {
+		delta := apart
+		if i == 0 {
+			delta = 0
+		}
+		feedback.RecvDeltas = append(feedback.RecvDeltas, &rtcp.RecvDelta{
+			Type:  rtcp.TypeTCCPacketReceivedSmallDelta,
+			Delta: delta.Microseconds(),
+		})
+	}
+	return feedback
+}
+
+func TestBandwidthEstimatorDelay(t *testing.T) {
+	ssrcs := []webrtc.SSRC{1234}
+	now := time.Now()
+	// a 5000 byte frame is sent every 33ms
+	const frameInterval = 33 * time.Millisecond
+	sent := func(sequenceNumber uint16) (sentPacket, bool) {
+		return sentPacket{
+			sequenceNumber: sequenceNumber,
+			at:             now.Add(time.Duration(sequenceNumber) * frameInterval),
+			size:           5000,
+		}, true
+	}
+
+	t.Run("decreases when packets queue up", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		// frames arriving 40ms apart arrive at 1Mbps
+		feedback := []rtcp.Packet{testTransportCCFeedback(0, 20, 40*time.Millisecond)}
+		test.That(t, be.update(feedback, ssrcs, sent, now), test.ShouldAlmostEqual, 850_000, 1)
+		// at most once per interval
+		feedback = []rtcp.Packet{testTransportCCFeedback(20, 20, 40*time.Millisecond)}
+		test.That(t, be.update(feedback, ssrcs, sent, now.Add(time.Millisecond)), test.ShouldAlmostEqual, 850_000, 1)
+	})
+
+	t.Run("follows loss while delay is steady", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		feedback := []rtcp.Packet{testTransportCCFeedback(0, 20, frameInterval)}
+		test.That(t, be.update(feedback, ssrcs, sent, now), test.ShouldEqual, 1_080_000)
+	})
+
+	t.Run("ignores packets it did not send", func(t *testing.T) {
+		be := newBandwidthEstimator(1_000_000, 100_000, 2_000_000)
+		feedback := []rtcp.Packet{testTransportCCFeedback(0, 20, 40*time.Millisecond)}
+		notSent := func(uint16) (sentPacket, bool) { return sentPacket{}, false }
+		test.That(t, be.update(feedback, ssrcs, notSent, now), test.ShouldEqual, 1_080_000)
+	})
+}
+
+func TestTransportCCArrivals(t *testing.T) {
+	feedback := &rtcp.TransportLayerCC{
+		BaseSequenceNumber: 10,
+		PacketStatusCount:  3,
+		ReferenceTime:      1,
+		PacketChunks: []rtcp.PacketStatusChunk{&rtcp.StatusVectorChunk{
+			SymbolSize: rtcp.TypeTCCSymbolSizeTwoBit,
+			SymbolList: []uint16{
+				rtcp.TypeTCCPacketReceivedSmallDelta,
+				rtcp.TypeTCCPacketNotReceived,
+				rtcp.TypeTCCPacketReceivedLargeDelta,
+				// padding past the packet status count
+				rtcp.TypeTCCPacketNotReceived,
+			},
+		}},
+		RecvDeltas: []*rtcp.RecvDelta{{Delta: 1000}, {Delta: 2000}},
+	}
+	test.That(t, transportCCArrivals(feedback), test.ShouldResemble, []packetArrival{
+		{sequenceNumber: 10, at: 65 * time.Millisecond},
+		{sequenceNumber: 12, at: 67 * time.Millisecond},
+	})
+	received, total := transportCCReceived(feedback)
+	test.That(t, received, test.ShouldEqual, 2)
+	test.That(t, total, test.ShouldEqual, 3)
+}
+
+func TestCongestionController(t *testing.T) {
+	var bitRates []int
+	var throttled bool
+	cc := newCongestionController(
+		CongestionControlConfig{Enabled: true},
+		1_000_000,
+		func(bitRate int, now time.Time) error {
+			if throttled {
+				return errBitRateRebuildThrottled
+			}
+			bitRates = append(bitRates, bitRate)
+			return nil
+		},
+		func(webrtc.SSRC, uint16) (sentPacket, bool) { return sentPacket{}, false },
+		golog.NewTestLogger(t),
+	)
+	const ssrc = 1234
+	// 64/256 = 25% loss
+	heavyLoss := []rtcp.Packet{&rtcp.ReceiverReport{
+		Reports: []rtcp.ReceptionReport{{SSRC: ssrc, FractionLost: 64}},
+	}}
+	sender := &webrtc.RTPSender{}
+	now := time.Now()
+
+	cc.handleFeedback(sender, []webrtc.SSRC{ssrc}, heavyLoss, now)
+	test.That(t, bitRates, test.ShouldResemble, []int{875_000})
+	// decreases wait for the last one to take effect
+	cc.handleFeedback(sender, []webrtc.SSRC{ssrc}, heavyLoss, now.Add(time.Millisecond))
+	test.That(t, bitRates, test.ShouldResemble, []int{875_000})
+	cc.handleFeedback(sender, []webrtc.SSRC{ssrc}, heavyLoss, now.Add(congestionDecreaseInterval))
+	test.That(t, bitRates, test.ShouldResemble, []int{875_000, 669_921})
+
+	// the most constrained peer sets the bit rate
+	cc.handleFeedback(&webrtc.RTPSender{}, []webrtc.SSRC{ssrc}, heavyLoss, now.Add(2*congestionDecreaseInterval))
+	test.That(t, bitRates, test.ShouldResemble, []int{875_000, 669_921, 586_180})
+
+	// a bit rate that could not be set is not kept, so it is set on the next feedback
+	throttled = true
+	cc.handleFeedback(sender, []webrtc.SSRC{ssrc}, heavyLoss, now.Add(3*congestionDecreaseInterval))
+	test.That(t, cc.bitRate, test.ShouldEqual, 586_180)
+	throttled = false
+	cc.handleFeedback(sender, []webrtc.SSRC{ssrc}, heavyLoss, now.Add(3*congestionDecreaseInterval+time.Millisecond))
+	test.That(t, bitRates, test.ShouldHaveLength, 4)
+	test.That(t, bitRates[3], test.ShouldBeLessThan, 586_180)
+	test.That(t, cc.bitRate, test.ShouldEqual, bitRates[3])
+}
@@ -0,0 +1,277 @@
+package gostream
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// describeVideoKeyFrame returns whether the given frame of video of the given MIME type
+// is a key frame and, if it carries what is needed to describe the track it starts, the
+// description. Only VP8, VP9 and H.264 video is understood.
+func describeVideoKeyFrame(mimeType string, frame []byte) (track muxedTrack, keyFrame, described bool) {
+	track.mimeType = mimeType
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		track.width, track.height, keyFrame = vp8KeyFrameSize(frame)
+		return track, keyFrame, keyFrame
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		track.width, track.height, keyFrame = vp9KeyFrameSize(frame)
+		return track, keyFrame, keyFrame
+	case strings.ToLower(webrtc.MimeTypeH264):
+		for _, nal := range splitAnnexB(frame) {
+			if len(nal) == 0 {
+				continue
+			}
+			switch nal[0] & 0x1F {
+			case h264NALTypeIDR:
+				keyFrame = true
+			case h264NALTypeSPS:
+				if track.sps == nil {
+					track.sps = nal
+				}
+			case h264NALTypePPS:
+				if track.pps == nil {
+					track.pps = nal
+				}
+			}
+		}
+		if !keyFrame || track.sps == nil || track.pps == nil {
+			return track, keyFrame, false
+		}
+		var ok bool
+		track.width, track.height, ok = h264SPSSize(track.sps)
+		return track, keyFrame, ok
+	default:
+		return track, false, false
+	}
+}
+
+// vp8KeyFrameSize reads the dimensions of a VP8 key frame from its header.
+// See https://datatracker.ietf.org/doc/html/rfc6386#section-9.1.
+func vp8KeyFrameSize(frame []byte) (width, height int, ok bool) {
+	if len(frame) < 10 || frame[0]&0x01 != 0 {
+		return 0, 0, false
+	}
+	if frame[3] != 0x9D || frame[4] != 0x01 || frame[5] != 0x2A {
+		return 0, 0, false
+	}
+	width = int(binary.LittleEndian.Uint16(frame[6:]) & 0x3FFF)
+	height = int(binary.LittleEndian.Uint16(frame[8:]) & 0x3FFF)
+	return width, height, true
+}
+
+// vp9KeyFrameSize reads the dimensions of a VP9 key frame from its uncompressed header.
+// See section 6.2 of the VP9 bitstream specification.
+func vp9KeyFrameSize(frame []byte) (width, height int, ok bool) {
+	r := bitReader{data: frame}
+	if r.read(2) != 2 {
+		return 0, 0, false
+	}
+	profile := r.read(1) | r.read(1)<<1
+	if profile == 3 {
+		r.read(1)
+	}
+	// a shown existing frame and inter frames are not key frames
+	if r.read(1) == 1 || r.read(1) != 0 {
+		return 0, 0, false
+	}
+	// show_frame and error_resilient_mode
+	r.read(2)
+	if r.read(24) != 0x498342 {
+		return 0, 0, false
+	}
+	if profile >= 2 {
+		// ten_or_twelve_bit
+		r.read(1)
+	}
+	const colorSpaceRGB = 7
+	if r.read(3) != colorSpaceRGB {
+		// color_range
+		r.read(1)
+		if profile == 1 || profile == 3 {
+			// subsampling_x, subsampling_y and reserved_zero
+			r.read(3)
+		}
+	} else if profile == 1 || profile == 3 {
+		// reserved_zero
+		r.read(1)
+	}
+	width = int(r.read(16)) + 1
+	height = int(r.read(16)) + 1
+	if r.overflow {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// The types of the H.264 NAL units that muxers care about.
+const (
+	h264NALTypeIDR = 5
+	h264NALTypeSPS = 7
+	h264NALTypePPS = 8
+	h264NALTypeAUD = 9
+)
+
+// h264SPSSize reads the dimensions of H.264 video from its sequence parameter set.
+// See section 7.3.2.1.1 of the H.264 specification.
+func h264SPSSize(sps []byte) (width, height int, ok bool) {
+	if len(sps) < 4 {
+		return 0, 0, false
+	}
+	r := bitReader{data: unescapeRBSP(sps[1:])}
+	profile := r.read(8)
+	// constraint flags and level
+	r.read(16)
+	// seq_parameter_set_id
+	r.readUE()
+
+	chromaFormat := uint32(1)
+	separateColorPlanes := false
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.readUE()
+		if chromaFormat == 3 {
+			separateColorPlanes = r.read(1) == 1
+		}
+		// bit depths and qpprime_y_zero_transform_bypass_flag
+		r.readUE()
+		r.readUE()
+		r.read(1)
+		if r.read(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.read(1) == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				lastScale, nextScale := int32(8), int32(8)
+				for j := 0; j < size && nextScale != 0; j++ {
+					nextScale = (lastScale + r.readSE() + 256) % 256
+					if nextScale != 0 {
+						lastScale = nextScale
+					}
+				}
+			}
+		}
+	}
+
+	// log2_max_frame_num_minus4
+	r.readUE()
+	switch r.readUE() {
+	case 0:
+		// log2_max_pic_order_cnt_lsb_minus4
+		r.readUE()
+	case 1:
+		// delta_pic_order_always_zero_flag, offset_for_non_ref_pic and offset_for_top_to_bottom_field
+		r.read(1)
+		r.readSE()
+		r.readSE()
+		for i := r.readUE(); i > 0 && !r.overflow; i-- {
+			r.readSE()
+		}
+	}
+	// max_num_ref_frames and gaps_in_frame_num_value_allowed_flag
+	r.readUE()
+	r.read(1)
+
+	widthInMBs := int(r.readUE()) + 1
+	heightInMapUnits := int(r.readUE()) + 1
+	frameMBsOnly := int(r.read(1))
+	if frameMBsOnly == 0 {
+		// mb_adaptive_frame_field_flag
+		r.read(1)
+	}
+	// direct_8x8_inference_flag
+	r.read(1)
+	width = widthInMBs * 16
+	height = (2 - frameMBsOnly) * heightInMapUnits * 16
+	if r.read(1) == 1 {
+		left, right, top, bottom := int(r.readUE()), int(r.readUE()), int(r.readUE()), int(r.readUE())
+		cropX, cropY := 1, 2-frameMBsOnly
+		if chromaFormat != 0 && !separateColorPlanes {
+			if chromaFormat != 3 {
+				cropX = 2
+			}
+			if chromaFormat == 1 {
+				cropY *= 2
+			}
+		}
+		width -= cropX * (left + right)
+		height -= cropY * (top + bottom)
+	}
+	if r.overflow || width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// unescapeRBSP removes the emulation prevention bytes from the payload of a NAL unit.
+func unescapeRBSP(data []byte) []byte {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+// A bitReader reads big endian bit fields. Reading past the end of its data reads
+// zeros and sets overflow.
+type bitReader struct {
+	data     []byte
+	offset   int
+	overflow bool
+}
+
+func (r *bitReader) read(bits int) uint32 {
+	var value uint32
+	for i := 0; i < bits; i++ {
+		value <<= 1
+		if r.offset/8 >= len(r.data) {
+			r.overflow = true
+		} else {
+			value |= uint32(r.data[r.offset/8]>>(7-r.offset%8)) & 1
+		}
+		r.offset++
+	}
+	return value
+}
+
+// readUE reads an unsigned Exp-Golomb code.
+func (r *bitReader) readUE() uint32 {
+	leadingZeros := 0
+	for r.read(1) == 0 {
+		if r.overflow || leadingZeros == 31 {
+			r.overflow = true
+			return 0
+		}
+		leadingZeros++
+	}
+	return 1<<leadingZeros - 1 + r.read(leadingZeros)
+}
+
+// readSE reads a signed Exp-Golomb code.
+func (r *bitReader) readSE() int32 {
+	value := r.readUE()
+	if value%2 == 1 {
+		return int32(value/2 + 1)
+	}
+	return -int32(value / 2)
+}
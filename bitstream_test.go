@@ -0,0 +1,97 @@
+package gostream
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+// testVP8KeyFrame returns the start of a VP8 key frame with the given dimensions.
+func testVP8KeyFrame(width, height int) []byte {
+	frame := []byte{0x10, 0x02, 0x00, 0x9D, 0x01, 0x2A, 0, 0, 0, 0, 1, 2, 3}
+	binary.LittleEndian.PutUint16(frame[6:], uint16(width))
+	binary.LittleEndian.PutUint16(frame[8:], uint16(height))
+	return frame
+}
+
+var (
+	// testH264SPS is the SPS of 64x48 baseline profile video.
+	testH264SPS = []byte{0x67, 0x42, 0xC0, 0x1E, 0xDA, 0x11, 0xE4}
+	testH264PPS = []byte{0x68, 0xCE, 0x38, 0x80}
+)
+
+// testH264KeyFrame returns an access unit of 64x48 H.264 video with its parameter sets
+// and an IDR slice.
+func testH264KeyFrame() []byte {
+	frame := []byte{0, 0, 0, 1, 0x09, 0xF0, 0, 0, 0, 1}
+	frame = append(frame, testH264SPS...)
+	frame = append(frame, 0, 0, 0, 1)
+	frame = append(frame, testH264PPS...)
+	return append(frame, 0, 0, 1, 0x65, 0x88, 0x84, 0x21)
+}
+
+// testH264Frame is an access unit of H.264 video with a non-IDR slice.
+var testH264Frame = []byte{0, 0, 0, 1, 0x41, 0x9A, 0x02}
+
+func TestDescribeVideoKeyFrame(t *testing.T) {
+	track, keyFrame, described := describeVideoKeyFrame(webrtc.MimeTypeVP8, testVP8KeyFrame(640, 480))
+	test.That(t, keyFrame, test.ShouldBeTrue)
+	test.That(t, described, test.ShouldBeTrue)
+	test.That(t, track, test.ShouldResemble, muxedTrack{mimeType: webrtc.MimeTypeVP8, width: 640, height: 480})
+	_, keyFrame, _ = describeVideoKeyFrame(webrtc.MimeTypeVP8, []byte{0x11, 0x02, 0x00, 1, 2, 3})
+	test.That(t, keyFrame, test.ShouldBeFalse)
+
+	// profile 0 key frame with the BT.601 color space, then a 320x240 frame size
+	vp9 := []byte{0x82, 0x49, 0x83, 0x42, 0x00, 0x13, 0xF0, 0x0E, 0xF0}
+	track, keyFrame, described = describeVideoKeyFrame(webrtc.MimeTypeVP9, vp9)
+	test.That(t, keyFrame, test.ShouldBeTrue)
+	test.That(t, described, test.ShouldBeTrue)
+	test.That(t, track.width, test.ShouldEqual, 320)
+	test.That(t, track.height, test.ShouldEqual, 240)
+	// the same frame as an inter frame
+	vp9[0] = 0x86
+	_, keyFrame, _ = describeVideoKeyFrame(webrtc.MimeTypeVP9, vp9)
+	test.That(t, keyFrame, test.ShouldBeFalse)
+	_, keyFrame, _ = describeVideoKeyFrame(webrtc.MimeTypeVP9, []byte{0x82, 0x49})
+	test.That(t, keyFrame, test.ShouldBeFalse)
+
+	track, keyFrame, described = describeVideoKeyFrame(webrtc.MimeTypeH264, testH264KeyFrame())
+	test.That(t, keyFrame, test.ShouldBeTrue)
+	test.That(t, described, test.ShouldBeTrue)
+	test.That(t, track, test.ShouldResemble, muxedTrack{
+		mimeType: webrtc.MimeTypeH264,
+		width:    64,
+		height:   48,
+		sps:      testH264SPS,
+		pps:      testH264PPS,
+	})
+	_, keyFrame, _ = describeVideoKeyFrame(webrtc.MimeTypeH264, testH264Frame)
+	test.That(t, keyFrame, test.ShouldBeFalse)
+	// an IDR slice without parameter sets cannot describe the video
+	_, keyFrame, described = describeVideoKeyFrame(webrtc.MimeTypeH264, []byte{0, 0, 1, 0x65, 0x88})
+	test.That(t, keyFrame, test.ShouldBeTrue)
+	test.That(t, described, test.ShouldBeFalse)
+
+	_, keyFrame, _ = describeVideoKeyFrame(webrtc.MimeTypeAV1, testVP8KeyFrame(640, 480))
+	test.That(t, keyFrame, test.ShouldBeFalse)
+}
+
+func TestH264SPSSize(t *testing.T) {
+	width, height, ok := h264SPSSize(testH264SPS)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, width, test.ShouldEqual, 64)
+	test.That(t, height, test.ShouldEqual, 48)
+
+	// high profile 1920x1088 cropped to 1080 lines
+	width, height, ok = h264SPSSize([]byte{0x67, 0x64, 0x00, 0x28, 0xAC, 0xDA, 0x01, 0xE0, 0x08, 0x9F, 0x95})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, width, test.ShouldEqual, 1920)
+	test.That(t, height, test.ShouldEqual, 1080)
+
+	_, _, ok = h264SPSSize(testH264SPS[:5])
+	test.That(t, ok, test.ShouldBeFalse)
+
+	test.That(t, unescapeRBSP([]byte{1, 0, 0, 3, 1, 0, 0, 3, 0, 3}), test.ShouldResemble, []byte{1, 0, 0, 1, 0, 0, 0, 3})
+}
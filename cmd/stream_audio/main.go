@@ -14,11 +14,11 @@ import (
 	"github.com/gen2brain/malgo"
 	// register microphone drivers.
 	_ "github.com/pion/mediadevices/pkg/driver/microphone"
+	"github.com/pion/mediadevices/pkg/wave"
 	"github.com/pion/webrtc/v3"
 	"github.com/pkg/errors"
 	"go.uber.org/multierr"
 	goutils "go.viam.com/utils"
-	hopus "gopkg.in/hraban/opus.v2"
 
 	"github.com/viamrobotics/gostream"
 	"github.com/viamrobotics/gostream/codec/opus"
@@ -162,32 +162,23 @@ func decodeAndPlayTrack(ctx context.Context, track *webrtc.TrackRemote) {
 			channels := codec.Channels
 			sampleRate := codec.ClockRate
 
-			dec, err := hopus.NewDecoder(int(sampleRate), int(channels))
+			dec, err := opus.NewDecoder(int(sampleRate), int(channels), logger)
 			if err != nil {
 				panic(err)
 			}
+			defer dec.Close()
 
-			const maxOpusFrameSizeMs = 60
-			maxFrameSize := float32(channels) * maxOpusFrameSizeMs * float32(sampleRate) / 1000
-			dataPool := sync.Pool{
-				New: func() interface{} {
-					newData := make([]float32, int(maxFrameSize))
-					return &newData
-				},
-			}
-			decodeRTPData := func() (*[]float32, int, error) {
+			decodeRTPData := func() (*wave.Float32Interleaved, int, error) {
 				data, _, err := track.ReadRTP()
 				if err != nil {
 					return nil, 0, err
 				}
-				if len(data.Payload) == 0 {
-					return nil, 0, nil
+				chunk, err := dec.Decode(ctx, data.Payload)
+				if err != nil || chunk == nil {
+					return nil, 0, err
 				}
-
-				pcmData := dataPool.Get().(*[]float32)
-				n, err := dec.DecodeFloat32(data.Payload, *pcmData)
-				pcmActual := (*pcmData)[:n*int(channels)]
-				return &pcmActual, n, err
+				pcm := chunk.(*wave.Float32Interleaved)
+				return pcm, pcm.Size.Len, nil
 			}
 
 			var periodSizeInFrames int
@@ -212,7 +203,7 @@ func decodeAndPlayTrack(ctx context.Context, track *webrtc.TrackRemote) {
 			deviceConfig.PeriodSizeInFrames = uint32(periodSizeInFrames)
 			sizeInBytes := malgo.SampleSizeInBytes(deviceConfig.Playback.Format)
 
-			pcmChan := make(chan *[]float32)
+			pcmChan := make(chan *wave.Float32Interleaved)
 
 			onSendFrames := func(pOutput, _ []byte, frameCount uint32) {
 				if ctx.Err() != nil {
@@ -223,7 +214,7 @@ func decodeAndPlayTrack(ctx context.Context, track *webrtc.TrackRemote) {
 				case <-ctx.Done():
 					return
 				case pcm := <-pcmChan:
-					pcmToWrite := *pcm
+					pcmToWrite := pcm.Data
 					if len(pcmToWrite) > int(samplesRequested) {
 						logger.Errorw("not enough samples requested; trimming our own data", "samples_requested", samplesRequested)
 						pcmToWrite = pcmToWrite[:samplesRequested]
@@ -233,7 +224,6 @@ func decodeAndPlayTrack(ctx context.Context, track *webrtc.TrackRemote) {
 					if err := binary.Write(buf, hostEndian, pcmToWrite); err != nil {
 						logger.Errorw("error writing to pcm buf", "error", err)
 					}
-					dataPool.Put(pcm)
 				}
 			}
 
@@ -31,6 +31,7 @@ type Arguments struct {
 	Camera     bool                `flag:"camera,usage=use camera"`
 	DupeStream bool                `flag:"dupe_stream,usage=duplicate stream"`
 	Dump       bool                `flag:"dump"`
+	Metrics    bool                `flag:"metrics,usage=serve prometheus metrics at /metrics"`
 }
 
 func mainWithArgs(ctx context.Context, args []string, logger golog.Logger) error {
@@ -64,6 +65,7 @@ func mainWithArgs(ctx context.Context, args []string, logger golog.Logger) error
 		int(argsParsed.Port),
 		argsParsed.Camera,
 		argsParsed.DupeStream,
+		argsParsed.Metrics,
 		logger,
 	)
 }
@@ -73,6 +75,7 @@ func runServer(
 	port int,
 	camera bool,
 	dupeStream bool,
+	metrics bool,
 	logger golog.Logger,
 ) (err error) {
 	var videoSource gostream.VideoSource
@@ -95,7 +98,8 @@ func runServer(
 	if err != nil {
 		return err
 	}
-	server, err := gostream.NewStandaloneStreamServer(port, logger, nil, stream)
+	serverOpts := []gostream.StandaloneStreamServerOption{gostream.WithStandaloneMetrics(metrics)}
+	server, err := gostream.NewStandaloneStreamServer(port, logger, serverOpts, stream)
 	if err != nil {
 		return err
 	}
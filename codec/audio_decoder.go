@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"context"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/wave"
+)
+
+// An AudioDecoder is anything that can decode bytes into audio chunks. This means that the decoder
+// must only be given data in the format dictated by a type (see AudioDecoderFactory.MIMEType).
+type AudioDecoder interface {
+	// Decode decodes a single encoded packet. It returns a nil chunk without error if the
+	// packet does not produce any audio.
+	Decode(ctx context.Context, data []byte) (wave.Audio, error)
+	Close()
+}
+
+// An AudioDecoderFactory produces AudioDecoders and provides information about the underlying decoder itself.
+type AudioDecoderFactory interface {
+	New(sampleRate, channelCount int, logger golog.Logger) (AudioDecoder, error)
+	MIMEType() string
+}
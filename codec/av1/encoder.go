@@ -0,0 +1,266 @@
+// Package av1 contains the AV1 video codec, backed by libaom.
+package av1
+
+// #cgo pkg-config: aom
+// #include <stdlib.h>
+// #include <aom/aom_encoder.h>
+// #include <aom/aomcx.h>
+//
+// // aom_codec_control is variadic so it cannot be called from Go.
+// aom_codec_err_t set_cpu_used(aom_codec_ctx_t *ctx, int cpu_used) {
+//   return aom_codec_control(ctx, AOME_SET_CPUUSED, cpu_used);
+// }
+//
+// aom_codec_err_t set_screen_content(aom_codec_ctx_t *ctx) {
+//   return aom_codec_control(ctx, AV1E_SET_TUNE_CONTENT, AOM_CONTENT_SCREEN);
+// }
+//
+// // the frame of a packet is in a union that Go cannot access.
+// int frame_of(const aom_codec_cx_pkt_t *pkt, void **buf, size_t *sz) {
+//   if (pkt->kind != AOM_CODEC_CX_FRAME_PKT) {
+//     return 0;
+//   }
+//   *buf = pkt->data.frame.buf;
+//   *sz = pkt->data.frame.sz;
+//   return 1;
+// }
+//
+// // Go memory is only referenced by the image for the duration of the call.
+// aom_codec_err_t encode_planes(
+//     aom_codec_ctx_t *ctx, aom_image_t *img, aom_codec_pts_t pts, aom_enc_frame_flags_t flags,
+//     unsigned char *y, unsigned char *cb, unsigned char *cr, int y_stride, int c_stride) {
+//   img->planes[AOM_PLANE_Y] = y;
+//   img->planes[AOM_PLANE_U] = cb;
+//   img->planes[AOM_PLANE_V] = cr;
+//   img->stride[AOM_PLANE_Y] = y_stride;
+//   img->stride[AOM_PLANE_U] = c_stride;
+//   img->stride[AOM_PLANE_V] = c_stride;
+//   aom_codec_err_t err = aom_codec_encode(ctx, img, pts, 1, flags);
+//   img->planes[AOM_PLANE_Y] = img->planes[AOM_PLANE_U] = img->planes[AOM_PLANE_V] = NULL;
+//   return err;
+// }
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/edaniels/golog"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+	"github.com/viamrobotics/gostream/internal/yuv"
+)
+
+// DefaultCPUUsed is the libaom speed setting used unless a preset is given. It is the
+// fastest setting that libaom supports for real-time encoding.
+const DefaultCPUUsed = 10
+
+type encoder struct {
+	mu            sync.Mutex
+	ctx           *C.aom_codec_ctx_t
+	cfg           *C.aom_codec_enc_cfg_t
+	img           *C.aom_image_t
+	width, height int
+	pts           int64
+	forceKeyFrame bool
+	closed        bool
+	logger        golog.Logger
+}
+
+// NewEncoder returns an AV1 encoder that can encode images of the given width and height. It will
+// also ensure that it produces key frames at the given interval. Of the given options, all but the
+// profile are supported; the preset is the libaom speed setting ("cpu-used"), from "0" (slowest)
+// to "10" (fastest), optionally suffixed with "-screen" to tune for screen content (e.g. "9-screen").
+func NewEncoder(
+	width, height, keyFrameInterval int,
+	opts ourcodec.VideoEncoderOptions,
+	logger golog.Logger,
+) (ourcodec.VideoEncoder, error) {
+	opts = opts.WithDefaults()
+	cpuUsed, screenContent, err := parsePreset(opts.Preset)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := (*C.aom_codec_enc_cfg_t)(C.calloc(1, C.sizeof_aom_codec_enc_cfg_t))
+	if ec := C.aom_codec_enc_config_default(C.aom_codec_av1_cx(), cfg, C.AOM_USAGE_REALTIME); ec != C.AOM_CODEC_OK {
+		C.free(unsafe.Pointer(cfg))
+		return nil, fmt.Errorf("aom_codec_enc_config_default failed (%d)", ec)
+	}
+	cfg.g_w = C.uint(width)
+	cfg.g_h = C.uint(height)
+	// each frame lasts one tick, which rate control uses to spread the bit rate across frames
+	cfg.g_timebase.num = 1
+	cfg.g_timebase.den = C.int(opts.FrameRate)
+	// frames must come out as soon as they go in for real-time streaming
+	cfg.g_lag_in_frames = 0
+	cfg.g_pass = C.AOM_RC_ONE_PASS
+	cfg.kf_mode = C.AOM_KF_AUTO
+	cfg.kf_max_dist = C.uint(keyFrameInterval)
+	if err := applyOptions(cfg, opts); err != nil {
+		C.free(unsafe.Pointer(cfg))
+		return nil, err
+	}
+
+	ctx := (*C.aom_codec_ctx_t)(C.calloc(1, C.sizeof_aom_codec_ctx_t))
+	if ec := C.aom_codec_enc_init_ver(ctx, C.aom_codec_av1_cx(), cfg, 0, C.AOM_ENCODER_ABI_VERSION); ec != C.AOM_CODEC_OK {
+		C.free(unsafe.Pointer(ctx))
+		C.free(unsafe.Pointer(cfg))
+		return nil, fmt.Errorf("aom_codec_enc_init failed (%d)", ec)
+	}
+	enc := &encoder{ctx: ctx, cfg: cfg, width: width, height: height, logger: logger}
+	if ec := C.set_cpu_used(ctx, C.int(cpuUsed)); ec != C.AOM_CODEC_OK {
+		enc.Close()
+		return nil, fmt.Errorf("setting cpu-used failed (%d)", ec)
+	}
+	if screenContent {
+		if ec := C.set_screen_content(ctx); ec != C.AOM_CODEC_OK {
+			enc.Close()
+			return nil, fmt.Errorf("tuning for screen content failed (%d)", ec)
+		}
+	}
+
+	// the planes of the image are pointed at each frame while it is encoded.
+	img := (*C.aom_image_t)(C.calloc(1, C.sizeof_aom_image_t))
+	if C.aom_img_alloc(img, C.AOM_IMG_FMT_I420, C.uint(width), C.uint(height), 1) == nil {
+		C.free(unsafe.Pointer(img))
+		enc.Close()
+		return nil, errors.New("aom_img_alloc failed")
+	}
+	enc.img = img
+	return enc, nil
+}
+
+// parsePreset returns the libaom speed setting and whether to tune for screen content.
+func parsePreset(preset string) (int, bool, error) {
+	if preset == "" {
+		return DefaultCPUUsed, false, nil
+	}
+	speed, screenContent := preset, false
+	if trimmed := strings.TrimSuffix(preset, "-screen"); trimmed != preset {
+		speed, screenContent = trimmed, true
+	}
+	cpuUsed, err := strconv.Atoi(speed)
+	if err != nil || cpuUsed < 0 || cpuUsed > 10 {
+		return 0, false, fmt.Errorf("unknown av1 preset %q", preset)
+	}
+	return cpuUsed, screenContent, nil
+}
+
+// applyOptions sets the rate control of the given libaom configuration from the options.
+func applyOptions(cfg *C.aom_codec_enc_cfg_t, opts ourcodec.VideoEncoderOptions) error {
+	cfg.rc_target_bitrate = C.uint(opts.BitRate / 1000)
+	switch opts.RateControl {
+	case ourcodec.RateControlVBR:
+		cfg.rc_end_usage = C.AOM_VBR
+	case ourcodec.RateControlCBR:
+		cfg.rc_end_usage = C.AOM_CBR
+	default:
+		return fmt.Errorf("unsupported rate control mode: %s", opts.RateControl)
+	}
+	if opts.MaxBitRate > opts.BitRate {
+		cfg.rc_overshoot_pct = C.uint((opts.MaxBitRate - opts.BitRate) * 100 / opts.BitRate)
+	}
+	if opts.MinQuantizer != 0 {
+		cfg.rc_min_quantizer = C.uint(opts.MinQuantizer)
+	}
+	if opts.MaxQuantizer != 0 {
+		cfg.rc_max_quantizer = C.uint(opts.MaxQuantizer)
+	}
+	return nil
+}
+
+// Encode encodes the given image into a temporal unit of OBUs.
+func (v *encoder) Encode(_ context.Context, img image.Image) ([]byte, error) {
+	i420, release, err := yuv.ToI420(img)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if bounds := i420.Bounds(); bounds.Dx() != v.width || bounds.Dy() != v.height {
+		return nil, fmt.Errorf("expected %dx%d image but got %dx%d", v.width, v.height, bounds.Dx(), bounds.Dy())
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return nil, errors.New("encoder is closed")
+	}
+
+	var flags C.aom_enc_frame_flags_t
+	if v.forceKeyFrame {
+		flags |= C.AOM_EFLAG_FORCE_KF
+	}
+	if ec := C.encode_planes(
+		v.ctx, v.img, C.aom_codec_pts_t(v.pts), flags,
+		(*C.uchar)(&i420.Y[0]), (*C.uchar)(&i420.Cb[0]), (*C.uchar)(&i420.Cr[0]),
+		C.int(i420.YStride), C.int(i420.CStride),
+	); ec != C.AOM_CODEC_OK {
+		return nil, fmt.Errorf("aom_codec_encode failed (%d)", ec)
+	}
+	v.pts++
+	v.forceKeyFrame = false
+
+	var data []byte
+	var iter C.aom_codec_iter_t
+	for {
+		pkt := C.aom_codec_get_cx_data(v.ctx, &iter)
+		if pkt == nil {
+			break
+		}
+		var buf unsafe.Pointer
+		var size C.size_t
+		if C.frame_of(pkt, &buf, &size) == 0 {
+			continue
+		}
+		data = append(data, C.GoBytes(buf, C.int(size))...)
+	}
+	return data, nil
+}
+
+// SetBitRate changes the target bit rate of the encoder without rebuilding it.
+func (v *encoder) SetBitRate(bitRate int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("encoder is closed")
+	}
+	v.cfg.rc_target_bitrate = C.uint(bitRate / 1000)
+	if ec := C.aom_codec_enc_config_set(v.ctx, v.cfg); ec != C.AOM_CODEC_OK {
+		return fmt.Errorf("aom_codec_enc_config_set failed (%d)", ec)
+	}
+	return nil
+}
+
+// ForceKeyFrame makes the next encoded frame a key frame.
+func (v *encoder) ForceKeyFrame() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.forceKeyFrame = true
+	return nil
+}
+
+// Close releases the resources of the codec.
+func (v *encoder) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return
+	}
+	v.closed = true
+	if ec := C.aom_codec_destroy(v.ctx); ec != C.AOM_CODEC_OK {
+		v.logger.Errorw("error destroying av1 codec", "error", ec)
+	}
+	C.free(unsafe.Pointer(v.ctx))
+	C.free(unsafe.Pointer(v.cfg))
+	if v.img != nil {
+		C.aom_img_free(v.img)
+		C.free(unsafe.Pointer(v.img))
+	}
+}
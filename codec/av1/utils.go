@@ -0,0 +1,34 @@
+package av1
+
+import (
+	"github.com/edaniels/golog"
+
+	"github.com/viamrobotics/gostream"
+	"github.com/viamrobotics/gostream/codec"
+)
+
+// DefaultStreamConfig configures AV1 as the encoder for a stream.
+var DefaultStreamConfig gostream.StreamConfig
+
+func init() {
+	DefaultStreamConfig.VideoEncoderFactory = NewEncoderFactory()
+}
+
+// NewEncoderFactory returns an AV1 encoder factory.
+func NewEncoderFactory() codec.VideoEncoderFactory {
+	return &factory{}
+}
+
+type factory struct{}
+
+func (f *factory) New(
+	width, height, keyFrameInterval int,
+	opts codec.VideoEncoderOptions,
+	logger golog.Logger,
+) (codec.VideoEncoder, error) {
+	return NewEncoder(width, height, keyFrameInterval, opts, logger)
+}
+
+func (f *factory) MIMEType() string {
+	return "video/AV1"
+}
@@ -0,0 +1,17 @@
+package codec
+
+// A BitRateController is an encoder whose target bit rate can be changed after it has been
+// created. VideoEncoders and AudioEncoders may optionally implement it; video encoders that
+// do not are rebuilt by the stream instead.
+type BitRateController interface {
+	// SetBitRate changes the target bit rate, in bits per second, of subsequently encoded media.
+	SetBitRate(bitRate int) error
+}
+
+// A KeyFrameController is a VideoEncoder that can be asked to produce a key frame on demand,
+// such as when a new viewer joins or a viewer reports picture loss. Video encoders that do not
+// implement it are rebuilt by the stream instead.
+type KeyFrameController interface {
+	// ForceKeyFrame makes the next encoded frame a key frame.
+	ForceKeyFrame() error
+}
@@ -0,0 +1,133 @@
+// Package g711 contains the G.711 audio codec in both its μ-law (PCMU) and A-law (PCMA)
+// variants. It is implemented in pure Go.
+package g711
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/wave"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+	"github.com/viamrobotics/gostream/codec/internal/pcm"
+)
+
+// SampleRate is the sample rate of G.711 audio.
+const SampleRate = 8000
+
+// Law determines the companding of a G.711 codec.
+type Law string
+
+// The set of allowed G.711 laws.
+const (
+	LawMu Law = "pcmu"
+	LawA  Law = "pcma"
+)
+
+type encoder struct {
+	law       Law
+	resampler *pcm.Resampler
+	logger    golog.Logger
+}
+
+// NewEncoder returns a G.711 encoder of the given law for audio of any sample rate and channel
+// count. Audio is downmixed to mono and resampled to 8kHz, and each chunk is encoded on its own.
+func NewEncoder(
+	law Law,
+	sampleRate, channelCount int,
+	latency time.Duration,
+	logger golog.Logger,
+) (ourcodec.AudioEncoder, error) {
+	switch law {
+	case LawMu, LawA:
+	default:
+		return nil, fmt.Errorf("unsupported g711 law: %s", law)
+	}
+	if sampleRate <= 0 || channelCount <= 0 {
+		return nil, fmt.Errorf("invalid audio of %d channels at %dHz", channelCount, sampleRate)
+	}
+	return &encoder{law: law, resampler: pcm.NewResampler(SampleRate), logger: logger}, nil
+}
+
+// Encode encodes the given audio chunk.
+func (a *encoder) Encode(_ context.Context, chunk wave.Audio) ([]byte, bool, error) {
+	samples := a.resampler.Resample(chunk)
+	if len(samples) == 0 {
+		return nil, false, nil
+	}
+	encoded := make([]byte, len(samples))
+	for i, sample := range samples {
+		if a.law == LawMu {
+			encoded[i] = EncodeMuLaw(sample)
+		} else {
+			encoded[i] = EncodeALaw(sample)
+		}
+	}
+	return encoded, true, nil
+}
+
+func (a *encoder) Close() {}
+
+// The upper bounds of each segment of the companding curves.
+var (
+	muLawSegmentEnds = [8]int{0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF}
+	aLawSegmentEnds  = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+)
+
+// segment returns the first segment that the value falls in, or 8 if it is beyond them.
+func segment(value int, ends *[8]int) int {
+	for i, end := range ends {
+		if value <= end {
+			return i
+		}
+	}
+	return len(ends)
+}
+
+// EncodeMuLaw compands a 16-bit linear sample with the μ-law of G.711.
+func EncodeMuLaw(sample int16) byte {
+	const (
+		bias = 0x84 >> 2
+		clip = 8159
+	)
+	value := int(sample) >> 2
+	mask := byte(0xFF)
+	if value < 0 {
+		value = -value
+		mask = 0x7F
+	}
+	if value > clip {
+		value = clip
+	}
+	value += bias
+
+	seg := segment(value, &muLawSegmentEnds)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	return (byte(seg<<4) | byte(value>>(seg+1))&0xF) ^ mask
+}
+
+// EncodeALaw compands a 16-bit linear sample with the A-law of G.711.
+func EncodeALaw(sample int16) byte {
+	value := int(sample) >> 3
+	mask := byte(0xD5)
+	if value < 0 {
+		value = -value - 1
+		mask = 0x55
+	}
+
+	seg := segment(value, &aLawSegmentEnds)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	encoded := byte(seg << 4)
+	if seg < 2 {
+		encoded |= byte(value>>1) & 0xF
+	} else {
+		encoded |= byte(value>>seg) & 0xF
+	}
+	return encoded ^ mask
+}
@@ -0,0 +1,64 @@
+package g711
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/wave"
+	"go.viam.com/test"
+)
+
+func TestEncodeMuLaw(t *testing.T) {
+	for _, tc := range []struct {
+		sample  int16
+		encoded byte
+	}{
+		{0, 0xFF},
+		{-1, 0x7E},
+		{1000, 0xCE},
+		{-1000, 0x4E},
+		{32767, 0x80},
+		{-32768, 0x00},
+	} {
+		test.That(t, EncodeMuLaw(tc.sample), test.ShouldEqual, tc.encoded)
+	}
+}
+
+func TestEncodeALaw(t *testing.T) {
+	for _, tc := range []struct {
+		sample  int16
+		encoded byte
+	}{
+		{0, 0xD5},
+		{-1, 0x55},
+		{1000, 0xFA},
+		{-1000, 0x7A},
+		{32767, 0xAA},
+		{-32768, 0x2A},
+	} {
+		test.That(t, EncodeALaw(tc.sample), test.ShouldEqual, tc.encoded)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	enc, err := NewEncoder(LawA, 48000, 2, 20*time.Millisecond, golog.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer enc.Close()
+
+	// 20ms of stereo silence at 48kHz is 20ms of mono at 8kHz
+	chunk := wave.NewInt16Interleaved(wave.ChunkInfo{Len: 960, Channels: 2, SamplingRate: 48000})
+	for i := 0; i < 3; i++ {
+		encoded, ready, err := enc.Encode(context.Background(), chunk)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, ready, test.ShouldBeTrue)
+		test.That(t, len(encoded), test.ShouldBeBetweenOrEqual, 159, 161)
+		for _, b := range encoded {
+			test.That(t, b, test.ShouldEqual, 0xD5)
+		}
+	}
+
+	_, err = NewEncoder("pcmx", 48000, 2, 20*time.Millisecond, golog.NewTestLogger(t))
+	test.That(t, err, test.ShouldNotBeNil)
+}
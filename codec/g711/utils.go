@@ -0,0 +1,42 @@
+package g711
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/edaniels/golog"
+
+	"github.com/viamrobotics/gostream"
+	"github.com/viamrobotics/gostream/codec"
+)
+
+// DefaultStreamConfig configures G.711 μ-law as the audio encoder for a stream.
+var DefaultStreamConfig gostream.StreamConfig
+
+func init() {
+	DefaultStreamConfig.AudioEncoderFactory = NewEncoderFactory(LawMu)
+}
+
+// NewEncoderFactory returns a G.711 audio encoder factory for the given law.
+func NewEncoderFactory(law Law) codec.AudioEncoderFactory {
+	return &factory{law}
+}
+
+type factory struct {
+	law Law
+}
+
+func (f *factory) New(sampleRate, channelCount int, latency time.Duration, logger golog.Logger) (codec.AudioEncoder, error) {
+	return NewEncoder(f.law, sampleRate, channelCount, latency, logger)
+}
+
+func (f *factory) MIMEType() string {
+	switch f.law {
+	case LawMu:
+		return "audio/PCMU"
+	case LawA:
+		return "audio/PCMA"
+	default:
+		panic(fmt.Errorf("unknown g711 law %q", f.law))
+	}
+}
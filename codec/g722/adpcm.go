@@ -0,0 +1,238 @@
+package g722
+
+// This is the sub-band ADPCM of ITU-T G.722 at 64kbit/s, following the block
+// structure of the reference implementation.
+
+var (
+	qmfCoefficients = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+
+	q6   = [32]int{0, 35, 72, 110, 150, 190, 233, 276, 323, 370, 422, 473, 530, 587, 650, 714, 786, 858, 940, 1023, 1121, 1219, 1339, 1458, 1612, 1765, 1980, 2195, 2557, 2919, 0, 0}
+	iln  = [32]int{0, 63, 62, 31, 30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 0}
+	ilp  = [32]int{0, 61, 60, 59, 58, 57, 56, 55, 54, 53, 52, 51, 50, 49, 48, 47, 46, 45, 44, 43, 42, 41, 40, 39, 38, 37, 36, 35, 34, 33, 32, 0}
+	wl   = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	rl42 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	ilb  = [32]int{2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383, 2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834, 2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371, 3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008}
+	qm4  = [16]int{0, -20456, -12896, -8968, -6288, -4240, -2584, -1200, 20456, 12896, 8968, 6288, 4240, 2584, 1200, 0}
+	qm2  = [4]int{-7408, -1616, 7408, 1616}
+	ihn  = [3]int{0, 1, 0}
+	ihp  = [3]int{0, 3, 2}
+	wh   = [3]int{0, -214, 798}
+	rh2  = [4]int{2, 1, 2, 1}
+)
+
+// band is the adaptive predictor and quantizer state of one sub-band.
+type band struct {
+	s, sp, sz int
+	r         [3]int
+	a, ap     [3]int
+	p         [3]int
+	d         [7]int
+	b, bp     [7]int
+	sg        [7]int
+	nb, det   int
+}
+
+// adpcmState is the state of a G.722 encoder.
+type adpcmState struct {
+	x     [24]int
+	bands [2]band
+}
+
+func newADPCMState() *adpcmState {
+	st := &adpcmState{}
+	st.bands[0].det = 32
+	st.bands[1].det = 8
+	return st
+}
+
+func saturate(v int) int {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return v
+}
+
+// encode encodes pairs of 16kHz samples into one byte each. The number of samples
+// must be even.
+func (st *adpcmState) encode(samples []int16) []byte {
+	encoded := make([]byte, 0, len(samples)/2)
+	for j := 0; j+1 < len(samples); j += 2 {
+		// transmit QMF
+		copy(st.x[:22], st.x[2:])
+		st.x[22] = int(samples[j])
+		st.x[23] = int(samples[j+1])
+		var sumEven, sumOdd int
+		for i := 0; i < 12; i++ {
+			sumOdd += st.x[2*i] * qmfCoefficients[i]
+			sumEven += st.x[2*i+1] * qmfCoefficients[11-i]
+		}
+		xLow := (sumEven + sumOdd) >> 14
+		xHigh := (sumEven - sumOdd) >> 14
+
+		low := &st.bands[0]
+		// block 1L, SUBTRA and QUANTL
+		el := saturate(xLow - low.s)
+		wd := el
+		if el < 0 {
+			wd = -(el + 1)
+		}
+		i := 1
+		for ; i < 30; i++ {
+			if wd < (q6[i]*low.det)>>12 {
+				break
+			}
+		}
+		iLow := ilp[i]
+		if el < 0 {
+			iLow = iln[i]
+		}
+		// block 2L, INVQAL
+		ril := iLow >> 2
+		dLow := (low.det * qm4[ril]) >> 15
+		// block 3L, LOGSCL and SCALEL
+		low.nb = (low.nb*127)>>7 + wl[rl42[ril]]
+		if low.nb < 0 {
+			low.nb = 0
+		} else if low.nb > 18432 {
+			low.nb = 18432
+		}
+		low.det = scale(low.nb, 8)
+		low.update(dLow)
+
+		high := &st.bands[1]
+		// block 1H, SUBTRA and QUANTH
+		eh := saturate(xHigh - high.s)
+		wd = eh
+		if eh < 0 {
+			wd = -(eh + 1)
+		}
+		mih := 1
+		if wd >= (564*high.det)>>12 {
+			mih = 2
+		}
+		iHigh := ihp[mih]
+		if eh < 0 {
+			iHigh = ihn[mih]
+		}
+		// block 2H, INVQAH
+		dHigh := (high.det * qm2[iHigh]) >> 15
+		// block 3H, LOGSCH and SCALEH
+		high.nb = (high.nb*127)>>7 + wh[rh2[iHigh]]
+		if high.nb < 0 {
+			high.nb = 0
+		} else if high.nb > 22528 {
+			high.nb = 22528
+		}
+		high.det = scale(high.nb, 10)
+		high.update(dHigh)
+
+		encoded = append(encoded, byte(iHigh<<6|iLow))
+	}
+	return encoded
+}
+
+// scale converts a logarithmic quantizer scale factor to a linear one.
+func scale(nb, shift int) int {
+	wd1 := (nb >> 6) & 31
+	wd2 := shift - (nb >> 11)
+	if wd2 < 0 {
+		return (ilb[wd1] << -wd2) << 2
+	}
+	return (ilb[wd1] >> wd2) << 2
+}
+
+// update adapts the predictor of the band to the given quantized difference signal.
+// It is block 4 of G.722.
+func (b *band) update(d int) {
+	// RECONS and PARREC
+	b.d[0] = d
+	b.r[0] = saturate(b.s + d)
+	b.p[0] = saturate(b.sz + d)
+
+	// UPPOL2
+	for i := 0; i < 3; i++ {
+		b.sg[i] = b.p[i] >> 15
+	}
+	wd1 := saturate(b.a[1] << 2)
+	wd2 := wd1
+	if b.sg[0] == b.sg[1] {
+		wd2 = -wd1
+	}
+	if wd2 > 32767 {
+		wd2 = 32767
+	}
+	wd3 := wd2 >> 7
+	if b.sg[0] == b.sg[2] {
+		wd3 += 128
+	} else {
+		wd3 -= 128
+	}
+	wd3 += (b.a[2] * 32512) >> 15
+	if wd3 > 12288 {
+		wd3 = 12288
+	} else if wd3 < -12288 {
+		wd3 = -12288
+	}
+	b.ap[2] = wd3
+
+	// UPPOL1
+	b.sg[0] = b.p[0] >> 15
+	b.sg[1] = b.p[1] >> 15
+	wd1 = -192
+	if b.sg[0] == b.sg[1] {
+		wd1 = 192
+	}
+	wd2 = (b.a[1] * 32640) >> 15
+	b.ap[1] = saturate(wd1 + wd2)
+	wd3 = saturate(15360 - b.ap[2])
+	if b.ap[1] > wd3 {
+		b.ap[1] = wd3
+	} else if b.ap[1] < -wd3 {
+		b.ap[1] = -wd3
+	}
+
+	// UPZERO
+	wd1 = 128
+	if d == 0 {
+		wd1 = 0
+	}
+	b.sg[0] = d >> 15
+	for i := 1; i < 7; i++ {
+		b.sg[i] = b.d[i] >> 15
+		wd2 = -wd1
+		if b.sg[i] == b.sg[0] {
+			wd2 = wd1
+		}
+		wd3 = (b.b[i] * 32640) >> 15
+		b.bp[i] = saturate(wd2 + wd3)
+	}
+
+	// DELAYA
+	for i := 6; i > 0; i-- {
+		b.d[i] = b.d[i-1]
+		b.b[i] = b.bp[i]
+	}
+	for i := 2; i > 0; i-- {
+		b.r[i] = b.r[i-1]
+		b.p[i] = b.p[i-1]
+		b.a[i] = b.ap[i]
+	}
+
+	// FILTEP
+	wd1 = (b.a[1] * saturate(b.r[1]+b.r[1])) >> 15
+	wd2 = (b.a[2] * saturate(b.r[2]+b.r[2])) >> 15
+	b.sp = saturate(wd1 + wd2)
+
+	// FILTEZ
+	b.sz = 0
+	for i := 6; i > 0; i-- {
+		b.sz += (b.b[i] * saturate(b.d[i]+b.d[i])) >> 15
+	}
+	b.sz = saturate(b.sz)
+
+	// PREDIC
+	b.s = saturate(b.sp + b.sz)
+}
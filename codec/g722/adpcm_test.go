@@ -0,0 +1,81 @@
+package g722
+
+import (
+	"math"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+var qm6 = [64]int{
+	-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+	-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+	-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+	-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+	24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+	10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+	4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+	1688, 1360, 1040, 728, 432, 136, -432, -136,
+}
+
+// decode is the G.722 decoder of the reference implementation, used to check that
+// encoded audio decodes back to what was encoded.
+func decode(encoded []byte) []int16 {
+	st := newADPCMState()
+	limit := func(v int) int {
+		return int(math.Max(-16384, math.Min(16383, float64(v))))
+	}
+	decoded := make([]int16, 0, 2*len(encoded))
+	for _, code := range encoded {
+		iLow := int(code & 0x3F)
+		iHigh := int(code>>6) & 0x03
+
+		low := &st.bands[0]
+		rLow := limit(low.s + (low.det*qm6[iLow])>>15)
+		ril := iLow >> 2
+		dLow := (low.det * qm4[ril]) >> 15
+		low.nb = int(math.Max(0, math.Min(18432, float64((low.nb*127)>>7+wl[rl42[ril]]))))
+		low.det = scale(low.nb, 8)
+		low.update(dLow)
+
+		high := &st.bands[1]
+		dHigh := (high.det * qm2[iHigh]) >> 15
+		rHigh := limit(dHigh + high.s)
+		high.nb = int(math.Max(0, math.Min(22528, float64((high.nb*127)>>7+wh[rh2[iHigh]]))))
+		high.det = scale(high.nb, 10)
+		high.update(dHigh)
+
+		copy(st.x[:22], st.x[2:])
+		st.x[22] = rLow + rHigh
+		st.x[23] = rLow - rHigh
+		var out1, out2 int
+		for i := 0; i < 12; i++ {
+			out2 += st.x[2*i] * qmfCoefficients[i]
+			out1 += st.x[2*i+1] * qmfCoefficients[11-i]
+		}
+		decoded = append(decoded, int16(saturate(out1>>11)), int16(saturate(out2>>11)))
+	}
+	return decoded
+}
+
+func TestADPCMRoundTrip(t *testing.T) {
+	// a second of a 1kHz tone at 16kHz
+	samples := make([]int16, SampleRate)
+	for i := range samples {
+		samples[i] = int16(10000 * math.Sin(2*math.Pi*1000*float64(i)/SampleRate))
+	}
+	encoded := newADPCMState().encode(samples)
+	test.That(t, encoded, test.ShouldHaveLength, len(samples)/2)
+	decoded := decode(encoded)
+
+	// the QMFs delay the signal by 22 samples
+	const delay = 22
+	var signal, noise float64
+	for i := SampleRate / 10; i < len(samples); i++ {
+		signal += float64(samples[i-delay]) * float64(samples[i-delay])
+		diff := float64(decoded[i]) - float64(samples[i-delay])
+		noise += diff * diff
+	}
+	snr := 10 * math.Log10(signal/noise)
+	test.That(t, snr, test.ShouldBeGreaterThan, 40)
+}
@@ -0,0 +1,52 @@
+// Package g722 contains the G.722 audio codec at 64kbit/s. It is implemented in pure Go.
+package g722
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/wave"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+	"github.com/viamrobotics/gostream/codec/internal/pcm"
+)
+
+// SampleRate is the sample rate of G.722 audio. Note that RTP uses a clock rate of
+// 8kHz for G.722 for historical reasons.
+const SampleRate = 16000
+
+type encoder struct {
+	state     *adpcmState
+	resampler *pcm.Resampler
+	// pending is a sample left over from the previous chunk since samples are
+	// encoded in pairs.
+	pending []int16
+	logger  golog.Logger
+}
+
+// NewEncoder returns a G.722 encoder for audio of any sample rate and channel count. Audio
+// is downmixed to mono and resampled to 16kHz, and each chunk is encoded on its own.
+func NewEncoder(sampleRate, channelCount int, latency time.Duration, logger golog.Logger) (ourcodec.AudioEncoder, error) {
+	if sampleRate <= 0 || channelCount <= 0 {
+		return nil, fmt.Errorf("invalid audio of %d channels at %dHz", channelCount, sampleRate)
+	}
+	return &encoder{state: newADPCMState(), resampler: pcm.NewResampler(SampleRate), logger: logger}, nil
+}
+
+// Encode encodes the given audio chunk.
+func (a *encoder) Encode(_ context.Context, chunk wave.Audio) ([]byte, bool, error) {
+	samples := append(a.pending, a.resampler.Resample(chunk)...)
+	a.pending = nil
+	if len(samples)%2 != 0 {
+		a.pending = []int16{samples[len(samples)-1]}
+		samples = samples[:len(samples)-1]
+	}
+	if len(samples) == 0 {
+		return nil, false, nil
+	}
+	return a.state.encode(samples), true, nil
+}
+
+func (a *encoder) Close() {}
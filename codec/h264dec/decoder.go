@@ -0,0 +1,154 @@
+// Package h264dec contains an H.264 video decoder backed by libavcodec. It is kept apart from
+// the x264 encoder so that encoding does not require libavcodec.
+package h264dec
+
+// #cgo pkg-config: libavcodec libavutil
+// #include <errno.h>
+// #include <libavcodec/avcodec.h>
+//
+// // AVERROR is a macro so it cannot be used from Go. Returns 1 if a frame was received,
+// // 0 if more data is needed and a negative error otherwise.
+// int decode_packet(AVCodecContext *ctx, AVPacket *pkt, AVFrame *frame, uint8_t *data, int size) {
+//   pkt->data = data;
+//   pkt->size = size;
+//   int ret = avcodec_send_packet(ctx, pkt);
+//   pkt->data = NULL;
+//   pkt->size = 0;
+//   if (ret < 0 && ret != AVERROR(EAGAIN)) {
+//     return ret;
+//   }
+//   ret = avcodec_receive_frame(ctx, frame);
+//   if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) {
+//     return 0;
+//   }
+//   return ret < 0 ? ret : 1;
+// }
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"unsafe"
+
+	"github.com/edaniels/golog"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+)
+
+type decoder struct {
+	mu     sync.Mutex
+	ctx    *C.AVCodecContext
+	pkt    *C.AVPacket
+	frame  *C.AVFrame
+	closed bool
+	logger golog.Logger
+}
+
+// NewDecoder returns an H.264 decoder. x264 can only encode, so decoding is done with libavcodec.
+func NewDecoder(logger golog.Logger) (ourcodec.VideoDecoder, error) {
+	codec := C.avcodec_find_decoder(C.AV_CODEC_ID_H264)
+	if codec == nil {
+		return nil, errors.New("libavcodec has no h264 decoder")
+	}
+	dec := &decoder{logger: logger}
+	dec.ctx = C.avcodec_alloc_context3(codec)
+	if dec.ctx == nil {
+		return nil, errors.New("avcodec_alloc_context3 failed")
+	}
+	// frame threading delays output by a frame per thread.
+	dec.ctx.flags |= C.AV_CODEC_FLAG_LOW_DELAY
+	dec.ctx.thread_type = C.FF_THREAD_SLICE
+	if ret := C.avcodec_open2(dec.ctx, codec, nil); ret < 0 {
+		dec.free()
+		return nil, fmt.Errorf("avcodec_open2 failed: %w", avError(ret))
+	}
+	dec.pkt = C.av_packet_alloc()
+	dec.frame = C.av_frame_alloc()
+	if dec.pkt == nil || dec.frame == nil {
+		dec.free()
+		return nil, errors.New("error allocating libavcodec packet or frame")
+	}
+	return dec, nil
+}
+
+// Decode decodes a single H.264 access unit, in Annex B format, into an image.
+func (d *decoder) Decode(_ context.Context, data []byte) (image.Image, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, errors.New("decoder is closed")
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	// libavcodec copies the data of packets that it does not own.
+	ret := C.decode_packet(d.ctx, d.pkt, d.frame, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.int(len(data)))
+	if ret < 0 {
+		return nil, fmt.Errorf("error decoding h264 frame: %w", avError(ret))
+	}
+	if ret == 0 {
+		return nil, nil
+	}
+	defer C.av_frame_unref(d.frame)
+
+	switch d.frame.format {
+	case C.AV_PIX_FMT_YUV420P, C.AV_PIX_FMT_YUVJ420P:
+	default:
+		return nil, fmt.Errorf("unsupported h264 pixel format: %d", d.frame.format)
+	}
+	return d.toYCbCr(), nil
+}
+
+// toYCbCr copies the planes of the decoded frame, which are owned by the decoder.
+func (d *decoder) toYCbCr() *image.YCbCr {
+	width, height := int(d.frame.width), int(d.frame.height)
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	copyPlane := func(dst []byte, dstStride, plane, rows int) {
+		src := unsafe.Pointer(d.frame.data[plane])
+		srcStride := int(d.frame.linesize[plane])
+		for row := 0; row < rows; row++ {
+			srcRow := unsafe.Slice((*byte)(unsafe.Add(src, row*srcStride)), dstStride)
+			copy(dst[row*dstStride:(row+1)*dstStride], srcRow)
+		}
+	}
+	copyPlane(img.Y, img.YStride, 0, height)
+	copyPlane(img.Cb, img.CStride, 1, (height+1)/2)
+	copyPlane(img.Cr, img.CStride, 2, (height+1)/2)
+	return img
+}
+
+// Close releases the resources of the decoder.
+func (d *decoder) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	d.free()
+}
+
+func (d *decoder) free() {
+	if d.frame != nil {
+		C.av_frame_free(&d.frame)
+	}
+	if d.pkt != nil {
+		C.av_packet_free(&d.pkt)
+	}
+	if d.ctx != nil {
+		C.avcodec_free_context(&d.ctx)
+	}
+}
+
+// avError describes a libav error code.
+func avError(code C.int) error {
+	buf := make([]byte, C.AV_ERROR_MAX_STRING_SIZE)
+	if C.av_strerror(code, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf))) < 0 {
+		return fmt.Errorf("libav error %d", code)
+	}
+	return errors.New(C.GoString((*C.char)(unsafe.Pointer(&buf[0]))))
+}
@@ -0,0 +1,22 @@
+package h264dec
+
+import (
+	"github.com/edaniels/golog"
+
+	"github.com/viamrobotics/gostream/codec"
+)
+
+// NewDecoderFactory returns an H.264 decoder factory.
+func NewDecoderFactory() codec.VideoDecoderFactory {
+	return &decoderFactory{}
+}
+
+type decoderFactory struct{}
+
+func (f *decoderFactory) New(logger golog.Logger) (codec.VideoDecoder, error) {
+	return NewDecoder(logger)
+}
+
+func (f *decoderFactory) MIMEType() string {
+	return "video/H264"
+}
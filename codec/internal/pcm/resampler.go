@@ -0,0 +1,97 @@
+// Package pcm converts audio to the mono 16-bit PCM that telephony codecs consume.
+package pcm
+
+import (
+	"math"
+
+	"github.com/pion/mediadevices/pkg/wave"
+)
+
+// A Resampler downmixes audio to mono and resamples it to a fixed rate. It keeps
+// state between chunks so that consecutive chunks resample into continuous audio.
+type Resampler struct {
+	rate int
+
+	// pos is where in the next chunk, in samples of its rate, the next output
+	// sample falls. It is -1 or more; -1 is the last sample of the previous chunk.
+	pos  float64
+	last float64
+
+	// history holds the end of the previous chunk for the low-pass filter.
+	history []float64
+}
+
+// NewResampler returns a Resampler that produces audio at the given sample rate.
+func NewResampler(rate int) *Resampler {
+	return &Resampler{rate: rate}
+}
+
+// Resample returns the given chunk as mono samples at the rate of the Resampler.
+// Samples that fall after the last sample of the chunk are only returned with the
+// next chunk, so the number of samples returned for chunks of the same duration
+// can differ slightly.
+func (r *Resampler) Resample(chunk wave.Audio) []int16 {
+	info := chunk.ChunkInfo()
+	if info.Len == 0 || info.Channels == 0 || info.SamplingRate == 0 {
+		return nil
+	}
+	mono := make([]float64, info.Len)
+	for i := range mono {
+		var sum float64
+		for ch := 0; ch < info.Channels; ch++ {
+			sum += float64(chunk.At(i, ch).Int() >> 16)
+		}
+		mono[i] = sum / float64(info.Channels)
+	}
+
+	step := float64(info.SamplingRate) / float64(r.rate)
+	if step > 1 {
+		// average over the span of each output sample so that frequencies
+		// above what the output rate can carry do not alias.
+		mono = r.lowPass(mono, int(step))
+	}
+
+	samples := make([]int16, 0, int(float64(len(mono))/step)+1)
+	for ; r.pos < float64(len(mono)-1); r.pos += step {
+		i := int(math.Floor(r.pos))
+		frac := r.pos - float64(i)
+		a := r.last
+		if i >= 0 {
+			a = mono[i]
+		}
+		b := mono[i+1]
+		samples = append(samples, toInt16(a+(b-a)*frac))
+	}
+	r.pos -= float64(len(mono))
+	r.last = mono[len(mono)-1]
+	return samples
+}
+
+// lowPass applies a moving average of the given width to the samples.
+func (r *Resampler) lowPass(samples []float64, width int) []float64 {
+	if width <= 1 {
+		return samples
+	}
+	padded := append(r.history, samples...)
+	for len(padded) < len(samples)+width-1 {
+		padded = append([]float64{0}, padded...)
+	}
+	padded = padded[len(padded)-len(samples)-width+1:]
+
+	filtered := make([]float64, len(samples))
+	var sum float64
+	for i := 0; i < width-1; i++ {
+		sum += padded[i]
+	}
+	for i := range filtered {
+		sum += padded[i+width-1]
+		filtered[i] = sum / float64(width)
+		sum -= padded[i]
+	}
+	r.history = append(r.history[:0], padded[len(padded)-width+1:]...)
+	return filtered
+}
+
+func toInt16(sample float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(sample))))
+}
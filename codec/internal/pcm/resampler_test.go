@@ -0,0 +1,51 @@
+package pcm
+
+import (
+	"testing"
+
+	"github.com/pion/mediadevices/pkg/wave"
+	"go.viam.com/test"
+)
+
+func constantChunk(rate, channels, n int, value int16) wave.Audio {
+	chunk := wave.NewInt16Interleaved(wave.ChunkInfo{Len: n, Channels: channels, SamplingRate: rate})
+	for i := range chunk.Data {
+		chunk.Data[i] = value
+	}
+	return chunk
+}
+
+func TestResampler(t *testing.T) {
+	t.Run("downsamples continuously", func(t *testing.T) {
+		r := NewResampler(8000)
+		var total int
+		for i := 0; i < 50; i++ {
+			// 20ms of 48kHz stereo
+			samples := r.Resample(constantChunk(48000, 2, 960, 1000))
+			test.That(t, len(samples), test.ShouldBeBetweenOrEqual, 159, 161)
+			total += len(samples)
+			if i > 0 {
+				for _, s := range samples {
+					test.That(t, s, test.ShouldEqual, 1000)
+				}
+			}
+		}
+		test.That(t, total, test.ShouldBeBetweenOrEqual, 7999, 8000)
+	})
+
+	t.Run("upsamples", func(t *testing.T) {
+		r := NewResampler(16000)
+		samples := r.Resample(constantChunk(8000, 1, 160, -2000))
+		test.That(t, len(samples), test.ShouldBeBetweenOrEqual, 318, 320)
+		for _, s := range samples {
+			test.That(t, s, test.ShouldEqual, -2000)
+		}
+	})
+
+	t.Run("downmixes", func(t *testing.T) {
+		r := NewResampler(8000)
+		chunk := wave.NewInt16Interleaved(wave.ChunkInfo{Len: 2, Channels: 2, SamplingRate: 8000})
+		copy(chunk.Data, []int16{1000, 3000, -1000, -3000})
+		test.That(t, r.Resample(chunk), test.ShouldResemble, []int16{2000})
+	})
+}
@@ -0,0 +1,62 @@
+// Package mjpeg contains a motion JPEG video codec that encodes each frame as its own
+// JPEG image with the standard library. It needs neither cgo nor hardware support.
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+
+	"github.com/edaniels/golog"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+)
+
+// DefaultQuality is the JPEG quality used by DefaultStreamConfig.
+const DefaultQuality = jpeg.DefaultQuality
+
+// maxDimension is the largest width or height that can be sent over RTP.
+const maxDimension = 2040
+
+type encoder struct {
+	width, height int
+	quality       int
+	buf           bytes.Buffer
+	logger        golog.Logger
+}
+
+// NewEncoder returns a motion JPEG encoder that can encode images of the given width and height
+// at the given quality, from 1 to 100. Every frame is a key frame so none of the given options
+// are supported.
+func NewEncoder(width, height, quality int, logger golog.Logger) (ourcodec.VideoEncoder, error) {
+	if quality < 1 || quality > 100 {
+		return nil, fmt.Errorf("jpeg quality must be between 1 and 100; got %d", quality)
+	}
+	if width > maxDimension || height > maxDimension {
+		return nil, fmt.Errorf("mjpeg cannot encode images larger than %dx%d", maxDimension, maxDimension)
+	}
+	return &encoder{width: width, height: height, quality: quality, logger: logger}, nil
+}
+
+// Encode encodes the given image as a JPEG image.
+func (v *encoder) Encode(_ context.Context, img image.Image) ([]byte, error) {
+	if bounds := img.Bounds(); bounds.Dx() != v.width || bounds.Dy() != v.height {
+		return nil, fmt.Errorf("expected %dx%d image but got %dx%d", v.width, v.height, bounds.Dx(), bounds.Dy())
+	}
+	if _, ok := img.(*image.Gray); ok {
+		// grayscale JPEG images cannot be sent over RTP
+		rgba := image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = rgba
+	}
+	v.buf.Reset()
+	if err := jpeg.Encode(&v.buf, img, &jpeg.Options{Quality: v.quality}); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), v.buf.Bytes()...), nil
+}
+
+func (v *encoder) Close() {}
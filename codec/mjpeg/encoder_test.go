@@ -0,0 +1,40 @@
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/edaniels/golog"
+	"go.viam.com/test"
+)
+
+func TestEncoder(t *testing.T) {
+	logger := golog.NewTestLogger(t)
+	enc, err := NewEncoder(64, 48, DefaultQuality, logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer enc.Close()
+
+	for _, img := range []image.Image{
+		image.NewRGBA(image.Rect(0, 0, 64, 48)),
+		image.NewGray(image.Rect(0, 0, 64, 48)),
+	} {
+		encoded, err := enc.Encode(context.Background(), img)
+		test.That(t, err, test.ShouldBeNil)
+		decoded, err := jpeg.Decode(bytes.NewReader(encoded))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, decoded.Bounds(), test.ShouldResemble, img.Bounds())
+		_, isColor := decoded.(*image.YCbCr)
+		test.That(t, isColor, test.ShouldBeTrue)
+	}
+
+	_, err = enc.Encode(context.Background(), image.NewRGBA(image.Rect(0, 0, 32, 32)))
+	test.That(t, err, test.ShouldNotBeNil)
+
+	_, err = NewEncoder(64, 48, 0, logger)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = NewEncoder(4096, 48, DefaultQuality, logger)
+	test.That(t, err, test.ShouldNotBeNil)
+}
@@ -0,0 +1,37 @@
+package mjpeg
+
+import (
+	"github.com/edaniels/golog"
+
+	"github.com/viamrobotics/gostream"
+	"github.com/viamrobotics/gostream/codec"
+)
+
+// DefaultStreamConfig configures motion JPEG as the encoder for a stream.
+var DefaultStreamConfig gostream.StreamConfig
+
+func init() {
+	DefaultStreamConfig.VideoEncoderFactory = NewEncoderFactory(DefaultQuality)
+}
+
+// NewEncoderFactory returns a motion JPEG encoder factory that encodes at the given quality,
+// from 1 to 100.
+func NewEncoderFactory(quality int) codec.VideoEncoderFactory {
+	return &factory{quality}
+}
+
+type factory struct {
+	quality int
+}
+
+func (f *factory) New(
+	width, height, keyFrameInterval int,
+	opts codec.VideoEncoderOptions,
+	logger golog.Logger,
+) (codec.VideoEncoder, error) {
+	return NewEncoder(width, height, f.quality, logger)
+}
+
+func (f *factory) MIMEType() string {
+	return gostream.MimeTypeJPEG
+}
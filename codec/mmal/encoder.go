@@ -11,6 +11,7 @@ import (
 	"github.com/pion/mediadevices/pkg/codec"
 	"github.com/pion/mediadevices/pkg/codec/mmal"
 	"github.com/pion/mediadevices/pkg/prop"
+	"go.viam.com/utils"
 )
 
 type encoder struct {
@@ -19,13 +20,16 @@ type encoder struct {
 	logger golog.Logger
 }
 
-// Gives suitable results. Probably want to make this configurable this in the future.
-const bitrate = 3_200_000
-
 // NewEncoder returns an MMAL encoder that can encode images of the given width and height. It will
-// also ensure that it produces key frames at the given interval.
-func NewEncoder(width, height, keyFrameInterval int, logger golog.Logger) (ourcodec.VideoEncoder, error) {
+// also ensure that it produces key frames at the given interval. Of the given options, only the
+// bit rate is supported.
+func NewEncoder(
+	width, height, keyFrameInterval int,
+	opts ourcodec.VideoEncoderOptions,
+	logger golog.Logger,
+) (ourcodec.VideoEncoder, error) {
 	enc := &encoder{logger: logger}
+	opts = opts.WithDefaults()
 
 	var builder codec.VideoEncoderBuilder
 	params, err := mmal.NewParams()
@@ -33,7 +37,7 @@ func NewEncoder(width, height, keyFrameInterval int, logger golog.Logger) (ourco
 		return nil, err
 	}
 	builder = &params
-	params.BitRate = bitrate
+	params.BitRate = opts.BitRate
 	params.KeyFrameInterval = keyFrameInterval
 
 	codec, err := builder.BuildVideoEncoder(enc, prop.Media{
@@ -64,3 +68,8 @@ func (v *encoder) Encode(_ context.Context, img image.Image) ([]byte, error) {
 	release()
 	return dataCopy, err
 }
+
+// Close releases the resources of the codec.
+func (v *encoder) Close() {
+	utils.UncheckedError(v.codec.Close())
+}
@@ -21,8 +21,12 @@ func NewEncoderFactory() codec.VideoEncoderFactory {
 
 type factory struct{}
 
-func (f *factory) New(width, height, keyFrameInterval int, logger golog.Logger) (codec.VideoEncoder, error) {
-	return NewEncoder(width, height, keyFrameInterval, logger)
+func (f *factory) New(
+	width, height, keyFrameInterval int,
+	opts codec.VideoEncoderOptions,
+	logger golog.Logger,
+) (codec.VideoEncoder, error) {
+	return NewEncoder(width, height, keyFrameInterval, opts, logger)
 }
 
 func (f *factory) MIMEType() string {
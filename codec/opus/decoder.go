@@ -0,0 +1,72 @@
+package opus
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/wave"
+	"gopkg.in/hraban/opus.v2"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+)
+
+// The longest duration, in milliseconds, that an opus packet can hold.
+const maxPacketDurationMs = 120
+
+type decoder struct {
+	mu           sync.Mutex
+	dec          *opus.Decoder
+	sampleRate   int
+	channelCount int
+	pcm          []float32
+	closed       bool
+	logger       golog.Logger
+}
+
+// NewDecoder returns an Opus decoder that decodes packets into audio chunks of the given
+// sample rate and channel count.
+func NewDecoder(sampleRate, channelCount int, logger golog.Logger) (ourcodec.AudioDecoder, error) {
+	dec, err := opus.NewDecoder(sampleRate, channelCount)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{
+		dec:          dec,
+		sampleRate:   sampleRate,
+		channelCount: channelCount,
+		pcm:          make([]float32, channelCount*maxPacketDurationMs*sampleRate/1000),
+		logger:       logger,
+	}, nil
+}
+
+// Decode decodes a single opus packet into an audio chunk.
+func (d *decoder) Decode(_ context.Context, data []byte) (wave.Audio, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, errors.New("decoder is closed")
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	n, err := d.dec.DecodeFloat32(data, d.pcm)
+	if err != nil {
+		return nil, err
+	}
+	chunk := wave.NewFloat32Interleaved(wave.ChunkInfo{
+		Len:          n,
+		Channels:     d.channelCount,
+		SamplingRate: d.sampleRate,
+	})
+	copy(chunk.Data, d.pcm[:n*d.channelCount])
+	return chunk, nil
+}
+
+func (d *decoder) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+}
@@ -3,6 +3,7 @@ package opus
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"sync"
 	"time"
@@ -25,11 +26,20 @@ type encoder struct {
 	cancelCtx               context.Context
 	cancelFunc              func()
 	activeBackgroundWorkers sync.WaitGroup
+
+	bitRateMu      sync.Mutex
+	pendingBitRate int
 }
 
 // Gives suitable results. Probably want to make this configurable this in the future.
 const bitrate = 32000
 
+// The range of bit rates supported by opus.
+const (
+	minBitRate = 500
+	maxBitRate = 512000
+)
+
 type encodedData struct {
 	data []byte
 	err  error
@@ -98,6 +108,7 @@ func (a *encoder) Read() (chunk wave.Audio, release func(), err error) {
 	if err := a.cancelCtx.Err(); err != nil {
 		return nil, func() {}, err
 	}
+	a.applyPendingBitRate()
 
 	select {
 	case <-a.cancelCtx.Done():
@@ -136,6 +147,38 @@ func (a *encoder) Encode(ctx context.Context, chunk wave.Audio) ([]byte, bool, e
 	}
 }
 
+// SetBitRate changes the target bit rate of the encoder. The change takes effect
+// starting with the next chunk read by the codec.
+func (a *encoder) SetBitRate(bitRate int) error {
+	if bitRate < minBitRate || bitRate > maxBitRate {
+		return fmt.Errorf("opus bit rate must be between %d and %d; got %d", minBitRate, maxBitRate, bitRate)
+	}
+	a.bitRateMu.Lock()
+	defer a.bitRateMu.Unlock()
+	a.pendingBitRate = bitRate
+	return nil
+}
+
+// applyPendingBitRate sets any pending bit rate on the codec. It is only called while the codec
+// is reading so that the change never races with an encode in progress.
+func (a *encoder) applyPendingBitRate() {
+	a.bitRateMu.Lock()
+	bitRate := a.pendingBitRate
+	a.pendingBitRate = 0
+	a.bitRateMu.Unlock()
+	if bitRate == 0 {
+		return
+	}
+
+	controller, ok := a.codec.Controller().(codec.BitRateController)
+	if !ok {
+		return
+	}
+	if err := controller.SetBitRate(bitRate); err != nil {
+		a.logger.Errorw("error setting bit rate", "bit_rate", bitRate, "error", err)
+	}
+}
+
 func (a *encoder) Close() {
 	a.cancelFunc()
 	a.activeBackgroundWorkers.Wait()
@@ -30,3 +30,18 @@ func (f *factory) New(sampleRate, channelCount int, latency time.Duration, logge
 func (f *factory) MIMEType() string {
 	return "audio/opus"
 }
+
+// NewDecoderFactory returns an Opus audio decoder factory.
+func NewDecoderFactory() codec.AudioDecoderFactory {
+	return &decoderFactory{}
+}
+
+type decoderFactory struct{}
+
+func (f *decoderFactory) New(sampleRate, channelCount int, logger golog.Logger) (codec.AudioDecoder, error) {
+	return NewDecoder(sampleRate, channelCount, logger)
+}
+
+func (f *decoderFactory) MIMEType() string {
+	return "audio/opus"
+}
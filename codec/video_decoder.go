@@ -0,0 +1,24 @@
+package codec
+
+import (
+	"context"
+	"image"
+
+	"github.com/edaniels/golog"
+)
+
+// A VideoDecoder is anything that can decode bytes into images. This means that the decoder
+// must only be given data in the format dictated by a type (see VideoDecoderFactory.MIMEType).
+type VideoDecoder interface {
+	// Decode decodes a single encoded frame. It returns a nil image without error if the
+	// frame does not produce an image yet, such as when the decoder is still waiting for
+	// a key frame.
+	Decode(ctx context.Context, data []byte) (image.Image, error)
+	Close()
+}
+
+// A VideoDecoderFactory produces VideoDecoders and provides information about the underlying decoder itself.
+type VideoDecoderFactory interface {
+	New(logger golog.Logger) (VideoDecoder, error)
+	MIMEType() string
+}
@@ -1,4 +1,5 @@
-// Package codec defines the encoder and factory interfaces for encoding video frames and audio chunks.
+// Package codec defines the encoder, decoder and factory interfaces for encoding and decoding video
+// frames and audio chunks.
 package codec
 
 import (
@@ -13,15 +14,78 @@ import (
 // latency.
 const DefaultKeyFrameInterval = 30
 
+// DefaultVideoBitRate is the default target bit rate (in bits per second) of video encoders.
+// It gives suitable results for most streams.
+const DefaultVideoBitRate = 3_200_000
+
 // A VideoEncoder is anything that can encode images into bytes. This means that
 // the encoder must follow some type of format dictated by a type (see EncoderFactory.MimeType).
 // An encoder that produces bytes of different encoding formats per call is invalid.
 type VideoEncoder interface {
 	Encode(ctx context.Context, img image.Image) ([]byte, error)
+	Close()
 }
 
 // A VideoEncoderFactory produces VideoEncoders and provides information about the underlying encoder itself.
 type VideoEncoderFactory interface {
-	New(height, width, keyFrameInterval int, logger golog.Logger) (VideoEncoder, error)
+	New(width, height, keyFrameInterval int, opts VideoEncoderOptions, logger golog.Logger) (VideoEncoder, error)
 	MIMEType() string
 }
+
+// A RateControlMode determines how an encoder spends bits in order to meet its target bit rate.
+type RateControlMode string
+
+// The set of known rate control modes.
+const (
+	// RateControlVBR lets the bit rate vary with the complexity of the content.
+	RateControlVBR RateControlMode = "vbr"
+	// RateControlCBR keeps the bit rate as close to the target as possible.
+	RateControlCBR RateControlMode = "cbr"
+)
+
+// VideoEncoderOptions tune the quality and bandwidth of a VideoEncoder. Any field left
+// as its zero value uses the encoder's default. Encoders ignore options they do not support.
+type VideoEncoderOptions struct {
+	// BitRate is the target bit rate in bits per second.
+	BitRate int
+
+	// MaxBitRate is the highest bit rate in bits per second that the encoder may
+	// burst to. It only has an effect in variable bit rate mode.
+	MaxBitRate int
+
+	// RateControl is the rate control mode of the encoder.
+	RateControl RateControlMode
+
+	// Preset trades off encoding speed against quality (e.g. "ultrafast" for x264
+	// or "realtime" for vpx).
+	Preset string
+
+	// Profile is the codec profile to produce (e.g. "baseline" or "high" for H.264).
+	Profile string
+
+	// MinQuantizer and MaxQuantizer bound the quantization parameter (QP) used by the
+	// encoder. Lower values mean higher quality.
+	MinQuantizer int
+	MaxQuantizer int
+
+	// FrameRate is the rate, in frames per second, at which images are given to the
+	// encoder, which it uses to spread its bit rate across frames. Streams set it to
+	// their target frame rate.
+	FrameRate int
+}
+
+// WithDefaults returns a copy of the options with the target bit rate, rate control
+// mode and frame rate filled in if unset.
+func (opts VideoEncoderOptions) WithDefaults() VideoEncoderOptions {
+	if opts.BitRate == 0 {
+		opts.BitRate = DefaultVideoBitRate
+	}
+	if opts.RateControl == "" {
+		opts.RateControl = RateControlVBR
+	}
+	if opts.FrameRate == 0 {
+		// streams take frames at this rate unless told otherwise
+		opts.FrameRate = DefaultKeyFrameInterval
+	}
+	return opts
+}
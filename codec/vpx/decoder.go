@@ -0,0 +1,117 @@
+package vpx
+
+// #cgo pkg-config: vpx
+// #include <stdlib.h>
+// #include <vpx/vpx_decoder.h>
+// #include <vpx/vp8dx.h>
+//
+// // vpx_codec_dec_init is a macro so it cannot be called from Go.
+// vpx_codec_err_t decoder_init(vpx_codec_ctx_t *ctx, vpx_codec_iface_t *iface) {
+//   return vpx_codec_dec_init(ctx, iface, NULL, 0);
+// }
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"unsafe"
+
+	"github.com/edaniels/golog"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+)
+
+type decoder struct {
+	mu     sync.Mutex
+	ctx    *C.vpx_codec_ctx_t
+	closed bool
+	logger golog.Logger
+}
+
+// NewDecoder returns a vpx decoder of the given type.
+func NewDecoder(codecVersion Version, logger golog.Logger) (ourcodec.VideoDecoder, error) {
+	var iface *C.vpx_codec_iface_t
+	switch codecVersion {
+	case Version8:
+		iface = C.vpx_codec_vp8_dx()
+	case Version9:
+		iface = C.vpx_codec_vp9_dx()
+	default:
+		return nil, fmt.Errorf("unsupported vpx version: %s", codecVersion)
+	}
+
+	ctx := (*C.vpx_codec_ctx_t)(C.calloc(1, C.sizeof_vpx_codec_ctx_t))
+	if status := C.decoder_init(ctx, iface); status != C.VPX_CODEC_OK {
+		C.free(unsafe.Pointer(ctx))
+		return nil, fmt.Errorf("error initializing vpx decoder: %s", C.GoString(C.vpx_codec_err_to_string(status)))
+	}
+	return &decoder{ctx: ctx, logger: logger}, nil
+}
+
+// Decode decodes a single vpx frame into an image.
+func (d *decoder) Decode(_ context.Context, data []byte) (image.Image, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, errors.New("decoder is closed")
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	if status := C.vpx_codec_decode(
+		d.ctx,
+		(*C.uint8_t)(unsafe.Pointer(&data[0])),
+		C.uint(len(data)),
+		nil,
+		0,
+	); status != C.VPX_CODEC_OK {
+		return nil, fmt.Errorf("error decoding vpx frame: %s", C.GoString(C.vpx_codec_error(d.ctx)))
+	}
+
+	// there is at most one frame per call since frame threading is not enabled.
+	var iter C.vpx_codec_iter_t
+	var img image.Image
+	for frame := C.vpx_codec_get_frame(d.ctx, &iter); frame != nil; frame = C.vpx_codec_get_frame(d.ctx, &iter) {
+		if frame.fmt != C.VPX_IMG_FMT_I420 {
+			return nil, fmt.Errorf("unsupported vpx image format: %d", frame.fmt)
+		}
+		img = toYCbCr(frame)
+	}
+	return img, nil
+}
+
+// toYCbCr copies the planes of the given I420 image, which are owned by the decoder.
+func toYCbCr(frame *C.vpx_image_t) *image.YCbCr {
+	width, height := int(frame.d_w), int(frame.d_h)
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	copyPlane := func(dst []byte, dstStride int, plane C.int, rows int) {
+		src := unsafe.Pointer(frame.planes[plane])
+		srcStride := int(frame.stride[plane])
+		for row := 0; row < rows; row++ {
+			srcRow := unsafe.Slice((*byte)(unsafe.Add(src, row*srcStride)), dstStride)
+			copy(dst[row*dstStride:(row+1)*dstStride], srcRow)
+		}
+	}
+	copyPlane(img.Y, img.YStride, C.VPX_PLANE_Y, height)
+	copyPlane(img.Cb, img.CStride, C.VPX_PLANE_U, (height+1)/2)
+	copyPlane(img.Cr, img.CStride, C.VPX_PLANE_V, (height+1)/2)
+	return img
+}
+
+// Close releases the resources of the decoder.
+func (d *decoder) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	if status := C.vpx_codec_destroy(d.ctx); status != C.VPX_CODEC_OK {
+		d.logger.Errorw("error destroying vpx decoder", "error", C.GoString(C.vpx_codec_err_to_string(status)))
+	}
+	C.free(unsafe.Pointer(d.ctx))
+}
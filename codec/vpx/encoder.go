@@ -3,13 +3,17 @@ package vpx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"image"
+	"strings"
+	"time"
 
 	"github.com/edaniels/golog"
 	"github.com/pion/mediadevices/pkg/codec"
 	"github.com/pion/mediadevices/pkg/codec/vpx"
 	"github.com/pion/mediadevices/pkg/prop"
+	"go.viam.com/utils"
 
 	ourcodec "github.com/viamrobotics/gostream/codec"
 )
@@ -29,35 +33,50 @@ const (
 	Version9 Version = "vp9"
 )
 
-// Gives suitable results. Probably want to make this configurable this in the future.
-const bitrate = 3_200_000
+// deadlines maps the names of the vpx encoding deadlines to their values.
+var deadlines = map[string]time.Duration{
+	"realtime": time.Microsecond,
+	"good":     time.Second,
+	"best":     0,
+}
 
 // NewEncoder returns a vpx encoder of the given type that can encode images of the given width and height. It will
-// also ensure that it produces key frames at the given interval.
-func NewEncoder(codecVersion Version, width, height, keyFrameInterval int, logger golog.Logger) (ourcodec.VideoEncoder, error) {
+// also ensure that it produces key frames at the given interval. Of the given options, all but the profile
+// are supported; the preset names a vpx deadline ("realtime", "good" or "best"). The bit rate cannot
+// be changed while the encoder is running, so streams restart the encoder to change it.
+func NewEncoder(
+	codecVersion Version,
+	width, height, keyFrameInterval int,
+	opts ourcodec.VideoEncoderOptions,
+	logger golog.Logger,
+) (ourcodec.VideoEncoder, error) {
 	enc := &encoder{logger: logger}
+	opts = opts.WithDefaults()
 
 	var builder codec.VideoEncoderBuilder
+	var params *vpx.Params
 	switch codecVersion {
 	case Version8:
-		params, err := vpx.NewVP8Params()
+		vp8Params, err := vpx.NewVP8Params()
 		if err != nil {
 			return nil, err
 		}
-		builder = &params
-		params.BitRate = bitrate
-		params.KeyFrameInterval = keyFrameInterval
+		builder = &vp8Params
+		params = &vp8Params.Params
 	case Version9:
-		params, err := vpx.NewVP9Params()
+		vp9Params, err := vpx.NewVP9Params()
 		if err != nil {
 			return nil, err
 		}
-		builder = &params
-		params.BitRate = bitrate
-		params.KeyFrameInterval = keyFrameInterval
+		builder = &vp9Params
+		params = &vp9Params.Params
 	default:
 		return nil, fmt.Errorf("unsupported vpx version: %s", codecVersion)
 	}
+	if err := applyOptions(params, opts); err != nil {
+		return nil, err
+	}
+	params.KeyFrameInterval = keyFrameInterval
 
 	codec, err := builder.BuildVideoEncoder(enc, prop.Media{
 		Video: prop.Video{
@@ -73,6 +92,36 @@ func NewEncoder(codecVersion Version, width, height, keyFrameInterval int, logge
 	return enc, nil
 }
 
+// applyOptions sets the given vpx parameters from the options.
+func applyOptions(params *vpx.Params, opts ourcodec.VideoEncoderOptions) error {
+	params.BitRate = opts.BitRate
+	switch opts.RateControl {
+	case ourcodec.RateControlVBR:
+		params.RateControlEndUsage = vpx.RateControlVBR
+	case ourcodec.RateControlCBR:
+		params.RateControlEndUsage = vpx.RateControlCBR
+	default:
+		return fmt.Errorf("unsupported rate control mode: %s", opts.RateControl)
+	}
+	if opts.MaxBitRate > opts.BitRate {
+		params.RateControlOvershootPercent = uint((opts.MaxBitRate - opts.BitRate) * 100 / opts.BitRate)
+	}
+	if opts.Preset != "" {
+		deadline, ok := deadlines[strings.ToLower(opts.Preset)]
+		if !ok {
+			return fmt.Errorf("unknown vpx deadline %q", opts.Preset)
+		}
+		params.Deadline = deadline
+	}
+	if opts.MinQuantizer != 0 {
+		params.RateControlMinQuantizer = uint(opts.MinQuantizer)
+	}
+	if opts.MaxQuantizer != 0 {
+		params.RateControlMaxQuantizer = uint(opts.MaxQuantizer)
+	}
+	return nil
+}
+
 // Read returns an image for codec to process.
 func (v *encoder) Read() (img image.Image, release func(), err error) {
 	return v.img, nil, nil
@@ -87,3 +136,17 @@ func (v *encoder) Encode(_ context.Context, img image.Image) ([]byte, error) {
 	release()
 	return dataCopy, err
 }
+
+// Close releases the resources of the codec.
+func (v *encoder) Close() {
+	utils.UncheckedError(v.codec.Close())
+}
+
+// ForceKeyFrame makes the next encoded frame a key frame.
+func (v *encoder) ForceKeyFrame() error {
+	controller, ok := v.codec.Controller().(codec.KeyFrameController)
+	if !ok {
+		return errors.New("codec cannot force key frames")
+	}
+	return controller.ForceKeyFrame()
+}
@@ -25,17 +25,42 @@ type factory struct {
 	codecVersion Version
 }
 
-func (f *factory) New(width, height, keyFrameInterval int, logger golog.Logger) (codec.VideoEncoder, error) {
-	return NewEncoder(f.codecVersion, width, height, keyFrameInterval, logger)
+func (f *factory) New(
+	width, height, keyFrameInterval int,
+	opts codec.VideoEncoderOptions,
+	logger golog.Logger,
+) (codec.VideoEncoder, error) {
+	return NewEncoder(f.codecVersion, width, height, keyFrameInterval, opts, logger)
 }
 
 func (f *factory) MIMEType() string {
-	switch f.codecVersion {
+	return mimeType(f.codecVersion)
+}
+
+// NewDecoderFactory returns a vpx decoder factory for the given vpx codec.
+func NewDecoderFactory(codecVersion Version) codec.VideoDecoderFactory {
+	return &decoderFactory{codecVersion}
+}
+
+type decoderFactory struct {
+	codecVersion Version
+}
+
+func (f *decoderFactory) New(logger golog.Logger) (codec.VideoDecoder, error) {
+	return NewDecoder(f.codecVersion, logger)
+}
+
+func (f *decoderFactory) MIMEType() string {
+	return mimeType(f.codecVersion)
+}
+
+func mimeType(codecVersion Version) string {
+	switch codecVersion {
 	case Version8:
 		return "video/vp8"
 	case Version9:
 		return "video/vp9"
 	default:
-		panic(fmt.Errorf("unknown codec version %q", f.codecVersion))
+		panic(fmt.Errorf("unknown codec version %q", codecVersion))
 	}
 }
@@ -1,66 +1,285 @@
 // Package x264 contains the x264 video codec.
 package x264
 
+// #cgo pkg-config: x264
+// #include <stdint.h>
+// #include <stdlib.h>
+// #include <x264.h>
+//
+// // x264_encoder_open is a macro so it cannot be called from Go.
+// x264_t *open_encoder(x264_param_t *param) {
+//   return x264_encoder_open(param);
+// }
+//
+// // Go memory is only referenced by the picture for the duration of the call.
+// int encode_planes(
+//     x264_t *enc, x264_picture_t *pic, int type, int64_t pts,
+//     unsigned char *y, unsigned char *cb, unsigned char *cr, int y_stride, int c_stride,
+//     x264_nal_t **nals, int *nal_count) {
+//   x264_picture_t out;
+//   pic->img.i_csp = X264_CSP_I420;
+//   pic->img.i_plane = 3;
+//   pic->img.plane[0] = y;
+//   pic->img.plane[1] = cb;
+//   pic->img.plane[2] = cr;
+//   pic->img.i_stride[0] = y_stride;
+//   pic->img.i_stride[1] = c_stride;
+//   pic->img.i_stride[2] = c_stride;
+//   pic->i_type = type;
+//   pic->i_pts = pts;
+//   int ret = x264_encoder_encode(enc, nals, nal_count, pic, &out);
+//   pic->img.plane[0] = pic->img.plane[1] = pic->img.plane[2] = NULL;
+//   return ret;
+// }
+//
+// x264_nal_t *nal_at(x264_nal_t *nals, int i) {
+//   return &nals[i];
+// }
+import "C"
+
 import (
 	"context"
+	"errors"
+	"fmt"
 	"image"
+	"strings"
+	"sync"
+	"unsafe"
 
 	"github.com/edaniels/golog"
-	"github.com/pion/mediadevices/pkg/codec"
-	"github.com/pion/mediadevices/pkg/codec/x264"
-	"github.com/pion/mediadevices/pkg/prop"
 
 	ourcodec "github.com/viamrobotics/gostream/codec"
+	"github.com/viamrobotics/gostream/internal/yuv"
 )
 
-type encoder struct {
-	codec  codec.ReadCloser
-	img    image.Image
-	logger golog.Logger
+// presets are the names of the x264 presets.
+var presets = map[string]bool{
+	"ultrafast": true,
+	"superfast": true,
+	"veryfast":  true,
+	"faster":    true,
+	"fast":      true,
+	"medium":    true,
+	"slow":      true,
+	"slower":    true,
+	"veryslow":  true,
+	"placebo":   true,
+}
+
+// profiles are the names of the x264 profiles that can encode 8-bit 4:2:0 video.
+var profiles = map[string]bool{
+	"baseline": true,
+	"main":     true,
+	"high":     true,
 }
 
-// Gives suitable results. Probably want to make this configurable this in the future.
-const bitrate = 3_200_000
+type encoder struct {
+	mu            sync.Mutex
+	enc           *C.x264_t
+	param         *C.x264_param_t
+	pic           *C.x264_picture_t
+	width, height int
+	pts           int64
+	forceKeyFrame bool
+	closed        bool
+	logger        golog.Logger
+}
 
 // NewEncoder returns an x264 encoder that can encode images of the given width and height. It will
-// also ensure that it produces key frames at the given interval.
-func NewEncoder(width, height, keyFrameInterval int, logger golog.Logger) (ourcodec.VideoEncoder, error) {
-	enc := &encoder{logger: logger}
+// also ensure that it produces key frames at the given interval. All of the given options are
+// supported; the preset defaults to "ultrafast" and the profile to "high".
+func NewEncoder(
+	width, height, keyFrameInterval int,
+	opts ourcodec.VideoEncoderOptions,
+	logger golog.Logger,
+) (ourcodec.VideoEncoder, error) {
+	opts = opts.WithDefaults()
+	preset := "ultrafast"
+	if opts.Preset != "" {
+		preset = strings.ToLower(opts.Preset)
+		if !presets[preset] {
+			return nil, fmt.Errorf("unknown x264 preset %q", opts.Preset)
+		}
+	}
+	profile := "high"
+	if opts.Profile != "" {
+		profile = strings.ToLower(opts.Profile)
+		if !profiles[profile] {
+			return nil, fmt.Errorf("unsupported x264 profile %q", opts.Profile)
+		}
+	}
 
-	var builder codec.VideoEncoderBuilder
-	params, err := x264.NewParams()
-	if err != nil {
-		return nil, err
+	enc := &encoder{width: width, height: height, logger: logger}
+	enc.param = (*C.x264_param_t)(C.calloc(1, C.sizeof_x264_param_t))
+	if enc.param == nil {
+		return nil, errors.New("failed to allocate x264 parameters")
+	}
+	cPreset := C.CString(preset)
+	defer C.free(unsafe.Pointer(cPreset))
+	cTune := C.CString("zerolatency")
+	defer C.free(unsafe.Pointer(cTune))
+	if C.x264_param_default_preset(enc.param, cPreset, cTune) != 0 {
+		enc.Close()
+		return nil, fmt.Errorf("x264_param_default_preset failed for %q", preset)
 	}
-	builder = &params
-	params.BitRate = bitrate
-	params.KeyFrameInterval = keyFrameInterval
 
-	codec, err := builder.BuildVideoEncoder(enc, prop.Media{
-		Video: prop.Video{
-			Width:  width,
-			Height: height,
-		},
-	})
-	if err != nil {
+	enc.param.i_csp = C.X264_CSP_I420
+	enc.param.i_width = C.int(width)
+	enc.param.i_height = C.int(height)
+	enc.param.i_fps_num = C.uint32_t(opts.FrameRate)
+	enc.param.i_fps_den = 1
+	// every frame is one tick apart
+	enc.param.i_timebase_num = 1
+	enc.param.i_timebase_den = C.uint32_t(opts.FrameRate)
+	enc.param.i_keyint_max = C.int(keyFrameInterval)
+	// every key frame carries the parameter sets so that peers can start decoding at any of them
+	enc.param.b_repeat_headers = 1
+	enc.param.b_annexb = 1
+	enc.param.i_log_level = C.X264_LOG_ERROR
+	if err := applyOptions(enc.param, opts); err != nil {
+		enc.Close()
 		return nil, err
 	}
-	enc.codec = codec
+	cProfile := C.CString(profile)
+	defer C.free(unsafe.Pointer(cProfile))
+	if C.x264_param_apply_profile(enc.param, cProfile) != 0 {
+		enc.Close()
+		return nil, fmt.Errorf("x264_param_apply_profile failed for %q", profile)
+	}
 
+	enc.enc = C.open_encoder(enc.param)
+	if enc.enc == nil {
+		enc.Close()
+		return nil, errors.New("x264_encoder_open failed")
+	}
+	// the planes of the picture are set to those of each image as it is encoded
+	enc.pic = (*C.x264_picture_t)(C.calloc(1, C.sizeof_x264_picture_t))
+	if enc.pic == nil {
+		enc.Close()
+		return nil, errors.New("failed to allocate x264 picture")
+	}
+	C.x264_picture_init(enc.pic)
 	return enc, nil
 }
 
-// Read returns an image for codec to process.
-func (v *encoder) Read() (img image.Image, release func(), err error) {
-	return v.img, nil, nil
+// applyOptions sets the rate control of the given x264 parameters from the options.
+func applyOptions(param *C.x264_param_t, opts ourcodec.VideoEncoderOptions) error {
+	bitRate := opts.BitRate / 1000
+	switch opts.RateControl {
+	case ourcodec.RateControlVBR:
+		param.rc.i_rc_method = C.X264_RC_ABR
+		param.rc.i_bitrate = C.int(bitRate)
+		if opts.MaxBitRate > opts.BitRate {
+			param.rc.i_vbv_max_bitrate = C.int(opts.MaxBitRate / 1000)
+			param.rc.i_vbv_buffer_size = C.int(opts.MaxBitRate / 1000)
+		} else {
+			// without a maximum, bursts are capped at the bit rate but may use up to two
+			// seconds of it
+			param.rc.i_vbv_max_bitrate = C.int(bitRate)
+			param.rc.i_vbv_buffer_size = C.int(2 * bitRate)
+		}
+	case ourcodec.RateControlCBR:
+		param.rc.i_rc_method = C.X264_RC_ABR
+		param.rc.i_bitrate = C.int(bitRate)
+		param.rc.i_vbv_max_bitrate = C.int(bitRate)
+		param.rc.i_vbv_buffer_size = C.int(bitRate)
+	default:
+		return fmt.Errorf("unsupported rate control mode: %s", opts.RateControl)
+	}
+	if opts.MinQuantizer != 0 {
+		param.rc.i_qp_min = C.int(opts.MinQuantizer)
+	}
+	if opts.MaxQuantizer != 0 {
+		param.rc.i_qp_max = C.int(opts.MaxQuantizer)
+	}
+	return nil
 }
 
-// Encode asks the codec to process the given image.
+// Encode encodes the given image into an access unit of NAL units in Annex B format.
 func (v *encoder) Encode(_ context.Context, img image.Image) ([]byte, error) {
-	v.img = img
-	data, release, err := v.codec.Read()
-	dataCopy := make([]byte, len(data))
-	copy(dataCopy, data)
-	release()
-	return dataCopy, err
+	i420, release, err := yuv.ToI420(img)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if bounds := i420.Bounds(); bounds.Dx() != v.width || bounds.Dy() != v.height {
+		return nil, fmt.Errorf("expected %dx%d image but got %dx%d", v.width, v.height, bounds.Dx(), bounds.Dy())
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return nil, errors.New("encoder is closed")
+	}
+
+	frameType := C.int(C.X264_TYPE_AUTO)
+	if v.forceKeyFrame {
+		frameType = C.X264_TYPE_IDR
+	}
+	var nals *C.x264_nal_t
+	var nalCount C.int
+	if ret := C.encode_planes(
+		v.enc, v.pic, frameType, C.int64_t(v.pts),
+		(*C.uchar)(&i420.Y[0]), (*C.uchar)(&i420.Cb[0]), (*C.uchar)(&i420.Cr[0]),
+		C.int(i420.YStride), C.int(i420.CStride),
+		&nals, &nalCount,
+	); ret < 0 {
+		return nil, fmt.Errorf("x264_encoder_encode failed (%d)", ret)
+	}
+	v.pts++
+	v.forceKeyFrame = false
+
+	var data []byte
+	for i := C.int(0); i < nalCount; i++ {
+		nal := C.nal_at(nals, i)
+		data = append(data, C.GoBytes(unsafe.Pointer(nal.p_payload), nal.i_payload)...)
+	}
+	return data, nil
+}
+
+// SetBitRate changes the target bit rate of the encoder without rebuilding it.
+func (v *encoder) SetBitRate(bitRate int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("encoder is closed")
+	}
+	// x264 only changes the bit rate of encoders with a VBV, which all of ours have, so the
+	// VBV is scaled to keep the same headroom for bursts
+	oldBitRate := float64(v.param.rc.i_bitrate)
+	newBitRate := float64(bitRate / 1000)
+	v.param.rc.i_vbv_max_bitrate = C.int(float64(v.param.rc.i_vbv_max_bitrate) * newBitRate / oldBitRate)
+	v.param.rc.i_vbv_buffer_size = C.int(float64(v.param.rc.i_vbv_buffer_size) * newBitRate / oldBitRate)
+	v.param.rc.i_bitrate = C.int(newBitRate)
+	if ret := C.x264_encoder_reconfig(v.enc, v.param); ret < 0 {
+		return fmt.Errorf("x264_encoder_reconfig failed (%d)", ret)
+	}
+	return nil
+}
+
+// ForceKeyFrame makes the next encoded frame a key frame.
+func (v *encoder) ForceKeyFrame() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.forceKeyFrame = true
+	return nil
+}
+
+// Close releases the resources of the codec.
+func (v *encoder) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return
+	}
+	v.closed = true
+	if v.pic != nil {
+		C.free(unsafe.Pointer(v.pic))
+	}
+	if v.enc != nil {
+		C.x264_encoder_close(v.enc)
+	}
+	if v.param != nil {
+		C.free(unsafe.Pointer(v.param))
+	}
 }
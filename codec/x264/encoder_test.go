@@ -13,6 +13,8 @@ import (
 	"github.com/edaniels/golog"
 	"github.com/nfnt/resize"
 	"go.viam.com/test"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
 )
 
 const (
@@ -59,7 +61,7 @@ func BenchmarkEncodeRGBA(b *testing.B) {
 	imgCyan := getResizedImageFromFile(b, "../../data/cyan.png")
 	imgFuchsia := getResizedImageFromFile(b, "../../data/fuchsia.png")
 	ctx := context.Background()
-	encoder, err := NewEncoder(Width, Height, DefaultKeyFrameInterval, logger)
+	encoder, err := NewEncoder(Width, Height, DefaultKeyFrameInterval, ourcodec.VideoEncoderOptions{}, logger)
 	test.That(b, err, test.ShouldBeNil)
 
 	b.ResetTimer()
@@ -87,7 +89,7 @@ func BenchmarkEncodeYCbCr(b *testing.B) {
 	imgCY, err := convertToYCbCr(b, imgCyan)
 	test.That(b, err, test.ShouldBeNil)
 
-	encoder, err := NewEncoder(Width, Height, DefaultKeyFrameInterval, logger)
+	encoder, err := NewEncoder(Width, Height, DefaultKeyFrameInterval, ourcodec.VideoEncoderOptions{}, logger)
 	test.That(b, err, test.ShouldBeNil)
 
 	ctx := context.Background()
@@ -103,3 +105,23 @@ func BenchmarkEncodeYCbCr(b *testing.B) {
 		w = !w
 	}
 }
+
+func TestEncoderSetBitRate(t *testing.T) {
+	enc, err := NewEncoder(Width, Height, DefaultKeyFrameInterval, ourcodec.VideoEncoderOptions{}, golog.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer enc.Close()
+	img := image.NewYCbCr(image.Rect(0, 0, Width, Height), image.YCbCrSubsampleRatio420)
+	_, err = enc.Encode(context.Background(), img)
+	test.That(t, err, test.ShouldBeNil)
+
+	// the default options can be retuned, so streams do not rebuild the encoder
+	controller, ok := enc.(ourcodec.BitRateController)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, controller.SetBitRate(1_000_000), test.ShouldBeNil)
+	param := enc.(*encoder).param
+	test.That(t, int(param.rc.i_bitrate), test.ShouldEqual, 1000)
+	test.That(t, int(param.rc.i_vbv_max_bitrate), test.ShouldEqual, 1000)
+	test.That(t, int(param.rc.i_vbv_buffer_size), test.ShouldEqual, 2000)
+	_, err = enc.Encode(context.Background(), img)
+	test.That(t, err, test.ShouldBeNil)
+}
@@ -0,0 +1,272 @@
+// Package x265 contains the x265 video codec.
+package x265
+
+// #cgo pkg-config: x265
+// #include <stdlib.h>
+// #include <x265.h>
+//
+// // x265_encoder_open is a macro so it cannot be called from Go.
+// x265_encoder *open_encoder(x265_param *param) {
+//   return x265_encoder_open(param);
+// }
+//
+// // Go memory is only referenced by the picture for the duration of the call.
+// int encode_planes(
+//     x265_encoder *enc, x265_picture *pic, int slice_type, int64_t pts,
+//     unsigned char *y, unsigned char *cb, unsigned char *cr, int y_stride, int c_stride,
+//     x265_nal **nals, uint32_t *nal_count) {
+//   pic->planes[0] = y;
+//   pic->planes[1] = cb;
+//   pic->planes[2] = cr;
+//   pic->stride[0] = y_stride;
+//   pic->stride[1] = c_stride;
+//   pic->stride[2] = c_stride;
+//   pic->sliceType = slice_type;
+//   pic->pts = pts;
+//   int ret = x265_encoder_encode(enc, nals, nal_count, pic, NULL);
+//   pic->planes[0] = pic->planes[1] = pic->planes[2] = NULL;
+//   return ret;
+// }
+//
+// x265_nal *nal_at(x265_nal *nals, uint32_t i) {
+//   return &nals[i];
+// }
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/edaniels/golog"
+
+	ourcodec "github.com/viamrobotics/gostream/codec"
+	"github.com/viamrobotics/gostream/internal/yuv"
+)
+
+// presets are the names of the x265 presets.
+var presets = map[string]bool{
+	"ultrafast": true,
+	"superfast": true,
+	"veryfast":  true,
+	"faster":    true,
+	"fast":      true,
+	"medium":    true,
+	"slow":      true,
+	"slower":    true,
+	"veryslow":  true,
+	"placebo":   true,
+}
+
+// profiles are the names of the x265 profiles that can encode 8-bit 4:2:0 video.
+var profiles = map[string]bool{
+	"main":             true,
+	"main-intra":       true,
+	"mainstillpicture": true,
+}
+
+type encoder struct {
+	mu            sync.Mutex
+	enc           *C.x265_encoder
+	param         *C.x265_param
+	pic           *C.x265_picture
+	width, height int
+	pts           int64
+	forceKeyFrame bool
+	closed        bool
+	logger        golog.Logger
+}
+
+// NewEncoder returns an x265 encoder that can encode images of the given width and height. It will
+// also ensure that it produces key frames at the given interval. All of the given options are
+// supported; the preset defaults to "ultrafast" and the profile to "main".
+func NewEncoder(
+	width, height, keyFrameInterval int,
+	opts ourcodec.VideoEncoderOptions,
+	logger golog.Logger,
+) (ourcodec.VideoEncoder, error) {
+	opts = opts.WithDefaults()
+	preset := "ultrafast"
+	if opts.Preset != "" {
+		preset = strings.ToLower(opts.Preset)
+		if !presets[preset] {
+			return nil, fmt.Errorf("unknown x265 preset %q", opts.Preset)
+		}
+	}
+	profile := "main"
+	if opts.Profile != "" {
+		profile = strings.ToLower(opts.Profile)
+		if !profiles[profile] {
+			return nil, fmt.Errorf("unsupported x265 profile %q", opts.Profile)
+		}
+	}
+
+	enc := &encoder{width: width, height: height, logger: logger}
+	enc.param = C.x265_param_alloc()
+	if enc.param == nil {
+		return nil, errors.New("x265_param_alloc failed")
+	}
+	cPreset := C.CString(preset)
+	defer C.free(unsafe.Pointer(cPreset))
+	cTune := C.CString("zerolatency")
+	defer C.free(unsafe.Pointer(cTune))
+	if C.x265_param_default_preset(enc.param, cPreset, cTune) != 0 {
+		enc.Close()
+		return nil, fmt.Errorf("x265_param_default_preset failed for %q", preset)
+	}
+
+	enc.param.sourceWidth = C.int(width)
+	enc.param.sourceHeight = C.int(height)
+	enc.param.internalCsp = C.X265_CSP_I420
+	enc.param.fpsNum = C.uint32_t(opts.FrameRate)
+	enc.param.fpsDenom = 1
+	enc.param.keyframeMax = C.int(keyFrameInterval)
+	// every key frame carries the parameter sets so that peers can start decoding at any of them
+	enc.param.bRepeatHeaders = 1
+	enc.param.bAnnexB = 1
+	enc.param.logLevel = C.X265_LOG_ERROR
+	if err := applyOptions(enc.param, opts); err != nil {
+		enc.Close()
+		return nil, err
+	}
+	cProfile := C.CString(profile)
+	defer C.free(unsafe.Pointer(cProfile))
+	if C.x265_param_apply_profile(enc.param, cProfile) != 0 {
+		enc.Close()
+		return nil, fmt.Errorf("x265_param_apply_profile failed for %q", profile)
+	}
+
+	enc.enc = C.open_encoder(enc.param)
+	if enc.enc == nil {
+		enc.Close()
+		return nil, errors.New("x265_encoder_open failed")
+	}
+	enc.pic = C.x265_picture_alloc()
+	if enc.pic == nil {
+		enc.Close()
+		return nil, errors.New("x265_picture_alloc failed")
+	}
+	C.x265_picture_init(enc.param, enc.pic)
+	return enc, nil
+}
+
+// applyOptions sets the rate control of the given x265 parameters from the options.
+func applyOptions(param *C.x265_param, opts ourcodec.VideoEncoderOptions) error {
+	bitRate := opts.BitRate / 1000
+	switch opts.RateControl {
+	case ourcodec.RateControlVBR:
+		param.rc.rateControlMode = C.X265_RC_ABR
+		param.rc.bitrate = C.int(bitRate)
+		if opts.MaxBitRate > opts.BitRate {
+			param.rc.vbvMaxBitrate = C.int(opts.MaxBitRate / 1000)
+			param.rc.vbvBufferSize = C.int(opts.MaxBitRate / 1000)
+		}
+	case ourcodec.RateControlCBR:
+		param.rc.rateControlMode = C.X265_RC_ABR
+		param.rc.bitrate = C.int(bitRate)
+		param.rc.vbvMaxBitrate = C.int(bitRate)
+		param.rc.vbvBufferSize = C.int(bitRate)
+	default:
+		return fmt.Errorf("unsupported rate control mode: %s", opts.RateControl)
+	}
+	if opts.MinQuantizer != 0 {
+		param.rc.qpMin = C.int(opts.MinQuantizer)
+	}
+	if opts.MaxQuantizer != 0 {
+		param.rc.qpMax = C.int(opts.MaxQuantizer)
+	}
+	return nil
+}
+
+// Encode encodes the given image into an access unit of NAL units in Annex B format.
+func (v *encoder) Encode(_ context.Context, img image.Image) ([]byte, error) {
+	i420, release, err := yuv.ToI420(img)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if bounds := i420.Bounds(); bounds.Dx() != v.width || bounds.Dy() != v.height {
+		return nil, fmt.Errorf("expected %dx%d image but got %dx%d", v.width, v.height, bounds.Dx(), bounds.Dy())
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return nil, errors.New("encoder is closed")
+	}
+
+	sliceType := C.int(C.X265_TYPE_AUTO)
+	if v.forceKeyFrame {
+		sliceType = C.X265_TYPE_IDR
+	}
+	var nals *C.x265_nal
+	var nalCount C.uint32_t
+	if ret := C.encode_planes(
+		v.enc, v.pic, sliceType, C.int64_t(v.pts),
+		(*C.uchar)(&i420.Y[0]), (*C.uchar)(&i420.Cb[0]), (*C.uchar)(&i420.Cr[0]),
+		C.int(i420.YStride), C.int(i420.CStride),
+		&nals, &nalCount,
+	); ret < 0 {
+		return nil, fmt.Errorf("x265_encoder_encode failed (%d)", ret)
+	}
+	v.pts++
+	v.forceKeyFrame = false
+
+	var data []byte
+	for i := C.uint32_t(0); i < nalCount; i++ {
+		nal := C.nal_at(nals, i)
+		data = append(data, C.GoBytes(unsafe.Pointer(nal.payload), C.int(nal.sizeBytes))...)
+	}
+	return data, nil
+}
+
+// SetBitRate changes the target bit rate of the encoder without rebuilding it.
+func (v *encoder) SetBitRate(bitRate int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return errors.New("encoder is closed")
+	}
+	if v.param.rc.vbvMaxBitrate != 0 {
+		// keep the same headroom for bursts
+		headroom := float64(v.param.rc.vbvMaxBitrate) / float64(v.param.rc.bitrate)
+		v.param.rc.vbvMaxBitrate = C.int(float64(bitRate/1000) * headroom)
+		v.param.rc.vbvBufferSize = v.param.rc.vbvMaxBitrate
+	}
+	v.param.rc.bitrate = C.int(bitRate / 1000)
+	if ret := C.x265_encoder_reconfig(v.enc, v.param); ret < 0 {
+		return fmt.Errorf("x265_encoder_reconfig failed (%d)", ret)
+	}
+	return nil
+}
+
+// ForceKeyFrame makes the next encoded frame a key frame.
+func (v *encoder) ForceKeyFrame() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.forceKeyFrame = true
+	return nil
+}
+
+// Close releases the resources of the codec.
+func (v *encoder) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return
+	}
+	v.closed = true
+	if v.pic != nil {
+		C.x265_picture_free(v.pic)
+	}
+	if v.enc != nil {
+		C.x265_encoder_close(v.enc)
+	}
+	if v.param != nil {
+		C.x265_param_free(v.param)
+	}
+}
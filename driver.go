@@ -17,11 +17,26 @@ func (err *DriverInUseError) Error() string {
 }
 
 // driverRefManager is a lockable map of drivers and reference counts of video readers
-// that use them.
+// that use them. counts mirrors the reference counts of refs, which cannot be read.
 type driverRefManager struct {
-	refs map[string]utils.RefCountedValue
-	mu   sync.Mutex
+	refs   map[string]utils.RefCountedValue
+	counts map[string]int
+	mu     sync.Mutex
 }
 
 // initialize a global driverRefManager.
-var driverRefs = driverRefManager{refs: map[string]utils.RefCountedValue{}}
+var driverRefs = driverRefManager{
+	refs:   map[string]utils.RefCountedValue{},
+	counts: map[string]int{},
+}
+
+// refCounts returns how many media sources reference each driver, by label.
+func (m *driverRefManager) refCounts() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]int, len(m.counts))
+	for label, count := range m.counts {
+		counts[label] = count
+	}
+	return counts
+}
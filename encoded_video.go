@@ -0,0 +1,49 @@
+package gostream
+
+import (
+	"github.com/pion/mediadevices/pkg/driver"
+	"github.com/pion/mediadevices/pkg/prop"
+)
+
+// An EncodedVideoFrame is a frame of video that has already been encoded, such as one
+// produced by a camera with a hardware encoder.
+type EncodedVideoFrame struct {
+	// Data is the encoded frame. For H.264 it is an access unit in Annex B format;
+	// key frames should carry their SPS and PPS.
+	Data []byte
+
+	// MIMEType is the codec the frame is encoded in (e.g. webrtc.MimeTypeH264).
+	// If empty, the codec of the stream it is input to is assumed.
+	MIMEType string
+
+	// KeyFrame is whether the frame can be decoded without any of the frames before it.
+	KeyFrame bool
+}
+
+type (
+	// An EncodedVideoReader is anything that can read and recycle encoded video frames.
+	EncodedVideoReader = MediaReader[EncodedVideoFrame]
+
+	// An EncodedVideoReaderFunc is a helper to turn a function into an EncodedVideoReader.
+	EncodedVideoReaderFunc = MediaReaderFunc[EncodedVideoFrame]
+
+	// An EncodedVideoSource is responsible for producing encoded video frames when
+	// requested. Unlike a VideoSource, every frame it produces is sent to peers since
+	// later frames cannot be decoded without the ones before them. It should produce
+	// key frames regularly since new peers wait for one before receiving anything.
+	EncodedVideoSource = MediaSource[EncodedVideoFrame]
+
+	// An EncodedVideoStream streams encoded video forever until closed.
+	EncodedVideoStream = MediaStream[EncodedVideoFrame]
+)
+
+// NewEncodedVideoSource instantiates a new encoded video source.
+func NewEncodedVideoSource(r EncodedVideoReader, p prop.Video) EncodedVideoSource {
+	return newMediaSource(nil, r, p)
+}
+
+// NewEncodedVideoSourceForDriver instantiates a new encoded video source and references
+// the given driver.
+func NewEncodedVideoSourceForDriver(d driver.Driver, r EncodedVideoReader, p prop.Video) EncodedVideoSource {
+	return newMediaSource(d, r, p)
+}
@@ -0,0 +1,370 @@
+package gostream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.viam.com/utils"
+)
+
+// An EventBufferConfig configures keeping the latest encoded media of a stream in memory
+// so that what just happened can be saved to a file when something of interest happens
+// (see Stream.SaveEvent).
+type EventBufferConfig struct {
+	// Duration is how much of the latest media is kept. Since saved files start at a
+	// video key frame, up to a key frame interval more than it is kept. The stream only
+	// keeps media if it is positive, in which case it is started when created and keeps
+	// encoding without peers until it is stopped.
+	Duration time.Duration
+
+	// MaxSize, in bytes, limits how much media is kept, even if that is less than
+	// Duration. Media is not limited by size if it is zero.
+	MaxSize int64
+
+	// Recording configures the files that events are saved to in the same way as for
+	// NewStreamRecorder. Each event is saved to a single file, so its file limits are
+	// ignored.
+	Recording RecorderConfig
+}
+
+// eventSegment is media kept by an event buffer that a file can start with. Its first
+// media is a video key frame or, for streams without video, a chunk of audio.
+type eventSegment struct {
+	start time.Time
+	media []recordedMedia
+	size  int64
+}
+
+// eventSave is an event being saved to a file from the media kept by an event buffer
+// and the media that follows it.
+type eventSave struct {
+	// end and after are guarded by the mutex of the buffer. Media after end is not saved.
+	// Until there is media to measure it from, end is zero and after is used instead.
+	end   time.Time
+	after time.Duration
+
+	// dropped is set once a video frame is dropped because the queue is full, until one
+	// is queued again. It is guarded by the mutex of the buffer.
+	dropped bool
+
+	queue    chan recordedMedia
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// path and err are set once done is closed.
+	path string
+	err  error
+	done chan struct{}
+}
+
+// next returns the next media to save, or false once the stream has stopped and all of
+// the media queued before then has been returned.
+func (save *eventSave) next() (recordedMedia, bool) {
+	select {
+	case media := <-save.queue:
+		return media, true
+	case <-save.stopped:
+	}
+	select {
+	case media := <-save.queue:
+		return media, true
+	default:
+		return recordedMedia{}, false
+	}
+}
+
+// stop tells the save that the stream has stopped.
+func (save *eventSave) stop() {
+	save.stopOnce.Do(func() { close(save.stopped) })
+}
+
+// An eventBuffer keeps the latest encoded media of a stream and saves it, along with the
+// media that follows, when asked to.
+type eventBuffer struct {
+	mu        sync.Mutex
+	name      string
+	config    EventBufferConfig
+	recording RecorderConfig
+	segments  []eventSegment
+	size      int64
+
+	// newest is the time of the latest media given to the buffer since the stream started.
+	newest time.Time
+
+	// savedUntil is the time of the latest media saved, which is not saved again.
+	savedUntil time.Time
+
+	// saving is the event being saved, if any.
+	saving *eventSave
+
+	requestKeyFrame         func()
+	activeBackgroundWorkers sync.WaitGroup
+}
+
+// newEventBuffer returns a buffer of the media of the given stream that receives the
+// encoded output of the stream from now on.
+func newEventBuffer(bs *basicStream, config EventBufferConfig) (*eventBuffer, error) {
+	if config.Duration <= 0 || config.MaxSize < 0 {
+		return nil, errors.New("event buffer must have a positive duration and cannot have a negative size")
+	}
+	recording, err := config.Recording.forStream(bs)
+	if err != nil {
+		return nil, err
+	}
+	recording.MaxFileSize = 0
+	recording.MaxFileDuration = 0
+	if recording.Logger == nil {
+		recording.Logger = bs.logger
+	}
+	if _, err := newRecorder(recording); err != nil {
+		return nil, err
+	}
+
+	eb := &eventBuffer{
+		name:            bs.name,
+		config:          config,
+		recording:       recording,
+		requestKeyFrame: bs.requestKeyFrame,
+	}
+	if recording.VideoMIMEType != "" {
+		mimeType := recording.VideoMIMEType
+		bs.videoTrackLocal.addSink(&trackSink{
+			mimeType: mimeType,
+			write: func(data []byte, keyFrame bool, timestamp time.Time) {
+				_, isKeyFrame, described := describeVideoKeyFrame(mimeType, data)
+				eb.add(recordedMedia{
+					video:     true,
+					data:      append([]byte(nil), data...),
+					keyFrame:  keyFrame || isKeyFrame,
+					timestamp: timestamp,
+				}, described)
+			},
+			stop: eb.stop,
+		})
+	}
+	if recording.AudioMIMEType != "" {
+		withVideo := recording.VideoMIMEType != ""
+		bs.audioTrackLocal.addSink(&trackSink{
+			mimeType: recording.AudioMIMEType,
+			write: func(data []byte, keyFrame bool, timestamp time.Time) {
+				eb.add(recordedMedia{
+					data:      append([]byte(nil), data...),
+					keyFrame:  true,
+					timestamp: timestamp,
+				}, !withVideo)
+			},
+			stop: eb.stop,
+		})
+	}
+	return eb, nil
+}
+
+// add keeps the given media, which a file can start with if start is set, and queues it
+// to be saved if an event is being saved.
+func (eb *eventBuffer) add(media recordedMedia, start bool) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.newest = media.timestamp
+
+	if start {
+		eb.segments = append(eb.segments, eventSegment{start: media.timestamp})
+	}
+	// media that no file can start with is not kept
+	if len(eb.segments) != 0 {
+		last := &eb.segments[len(eb.segments)-1]
+		last.media = append(last.media, media)
+		last.size += int64(len(media.data))
+		eb.size += int64(len(media.data))
+		eb.trim()
+	}
+
+	save := eb.saving
+	if save == nil {
+		return
+	}
+	if save.end.IsZero() {
+		save.end = media.timestamp.Add(save.after)
+	}
+	if media.video {
+		media.afterDrop = save.dropped
+	}
+	select {
+	case save.queue <- media:
+		if media.video {
+			save.dropped = false
+		}
+	default:
+		if media.video {
+			save.dropped = true
+		}
+	}
+}
+
+// trim drops the oldest segments that are no longer needed to keep the configured
+// duration of media, or that make the media kept too big. The latest segment is always
+// kept. It assumes mu is held.
+func (eb *eventBuffer) trim() {
+	for len(eb.segments) > 1 {
+		if eb.newest.Sub(eb.segments[1].start) < eb.config.Duration &&
+			(eb.config.MaxSize == 0 || eb.size <= eb.config.MaxSize) {
+			return
+		}
+		eb.size -= eb.segments[0].size
+		eb.segments[0] = eventSegment{}
+		eb.segments = eb.segments[1:]
+	}
+}
+
+// stop drops the media kept since the stream stopped and finalizes the event being
+// saved, if any.
+func (eb *eventBuffer) stop() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.segments = nil
+	eb.size = 0
+	eb.newest = time.Time{}
+	eb.savedUntil = time.Time{}
+	if eb.saving != nil {
+		eb.saving.stop()
+	}
+}
+
+// save saves the media kept, other than what an earlier event already saved, and the
+// media that follows it for the given duration to a file. If an event is already being
+// saved, it is extended instead. It returns the path of the file once it is finalized.
+func (eb *eventBuffer) save(ctx context.Context, after time.Duration) (string, error) {
+	if after < 0 {
+		return "", errors.New("cannot save a negative duration after an event")
+	}
+	eb.mu.Lock()
+	save := eb.saving
+	if save != nil {
+		switch {
+		case save.end.IsZero():
+			if after > save.after {
+				save.after = after
+			}
+		case eb.newest.Add(after).After(save.end):
+			save.end = eb.newest.Add(after)
+		}
+	} else {
+		var err error
+		save, err = eb.startSave(after)
+		if err != nil {
+			eb.mu.Unlock()
+			return "", err
+		}
+	}
+	eb.mu.Unlock()
+
+	select {
+	case <-save.done:
+		return save.path, save.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// startSave starts saving an event that lasts for the given duration after the latest
+// media. It assumes mu is held.
+func (eb *eventBuffer) startSave(after time.Duration) (*eventSave, error) {
+	save := &eventSave{
+		after:   after,
+		queue:   make(chan recordedMedia, recorderQueueSize),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if !eb.newest.IsZero() {
+		save.end = eb.newest.Add(after)
+	}
+
+	recording := eb.recording
+	onFile := recording.OnFile
+	// an event is saved to more than one file if its video changes format
+	recording.OnFile = func(path string) {
+		if save.path == "" {
+			save.path = path
+		}
+		if onFile != nil {
+			onFile(path)
+		}
+	}
+	rec, err := newRecorder(recording)
+	if err != nil {
+		return nil, err
+	}
+	if err := rec.Start(); err != nil {
+		return nil, err
+	}
+	// without a key frame kept, the file cannot start until the stream sends one
+	rec.requestKeyFrame = eb.requestKeyFrame
+
+	var kept []recordedMedia
+	for _, segment := range eb.segments {
+		if segment.start.After(eb.savedUntil) {
+			kept = append(kept, segment.media...)
+		}
+	}
+	eb.saving = save
+	eb.activeBackgroundWorkers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer eb.activeBackgroundWorkers.Done()
+		eb.runSave(save, rec, kept)
+	})
+	return save, nil
+}
+
+// runSave records the kept media of the event being saved and then the media queued for
+// it until the event ends or the stream stops.
+func (eb *eventBuffer) runSave(save *eventSave, rec *recorder, kept []recordedMedia) {
+	defer close(save.done)
+	var last time.Time
+	record := func(media recordedMedia) {
+		if err := rec.record(media); err != nil && save.err == nil {
+			save.err = err
+		}
+		last = media.timestamp
+	}
+	for _, media := range kept {
+		record(media)
+	}
+	for {
+		media, ok := save.next()
+		if !ok || eb.ends(save, media) {
+			break
+		}
+		record(media)
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.saving = nil
+	if last.After(eb.savedUntil) {
+		eb.savedUntil = last
+	}
+	// the file is finalized before another event can be saved so that it cannot be
+	// recorded over
+	if err := rec.Close(); err != nil && save.err == nil {
+		save.err = err
+	}
+	if save.path == "" && save.err == nil {
+		save.err = fmt.Errorf("stream %q had no media to save for the event", eb.name)
+	}
+}
+
+// ends returns whether the given media is after the end of the event being saved.
+func (eb *eventBuffer) ends(save *eventSave, media recordedMedia) bool {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	return media.timestamp.After(save.end)
+}
+
+func (bs *basicStream) SaveEvent(ctx context.Context, after time.Duration) (string, error) {
+	if bs.eventBuffer == nil {
+		return "", fmt.Errorf("stream %q does not keep media for events", bs.name)
+	}
+	return bs.eventBuffer.save(ctx, after)
+}
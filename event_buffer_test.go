@@ -0,0 +1,190 @@
+package gostream
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+// waitForEventSave waits until the event buffer is saving an event that ends at the given
+// time.
+func waitForEventSave(t *testing.T, eb *eventBuffer, end time.Time) {
+	t.Helper()
+	for i := 0; ; i++ {
+		eb.mu.Lock()
+		saving := eb.saving != nil && eb.saving.end.Equal(end)
+		eb.mu.Unlock()
+		if saving {
+			return
+		}
+		test.That(t, i, test.ShouldBeLessThan, 5000)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// eventSaveResult is what a call to SaveEvent returned.
+type eventSaveResult struct {
+	path string
+	err  error
+}
+
+func saveEvent(stream Stream, after time.Duration) <-chan eventSaveResult {
+	result := make(chan eventSaveResult, 1)
+	go func() {
+		path, err := stream.SaveEvent(context.Background(), after)
+		result <- eventSaveResult{path, err}
+	}()
+	return result
+}
+
+func TestEventBuffer(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewStream(StreamConfig{
+			EncodedVideoMIMEType: webrtc.MimeTypeVP8,
+			EventBuffer:          EventBufferConfig{Duration: -time.Second},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewStream(StreamConfig{
+			EncodedVideoMIMEType: webrtc.MimeTypeVP8,
+			EventBuffer: EventBufferConfig{
+				Duration:  time.Second,
+				Recording: RecorderConfig{Format: RecordingFormatFragmentedMP4},
+			},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+
+		stream, err := NewStream(StreamConfig{EncodedVideoMIMEType: webrtc.MimeTypeVP8})
+		test.That(t, err, test.ShouldBeNil)
+		_, err = stream.SaveEvent(context.Background(), time.Second)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	dir := t.TempDir()
+	files := newRecordedFiles()
+	stream, err := NewStream(StreamConfig{
+		Name:                 "cam",
+		EncodedVideoMIMEType: webrtc.MimeTypeVP8,
+		EventBuffer: EventBufferConfig{
+			Duration:  250 * time.Millisecond,
+			Recording: RecorderConfig{Dir: dir, OnFile: files.onFile},
+		},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	bs := stream.(*basicStream)
+	// media is kept whether or not any peer receives it
+	test.That(t, bs.videoTrackLocal.boundMIMETypes(), test.ShouldResemble, []string{webrtc.MimeTypeVP8})
+	test.That(t, streamStarted(stream), test.ShouldBeTrue)
+	peers := &streamState{stream: stream}
+	peers.Start()
+	peers.Stop()
+	test.That(t, streamStarted(stream), test.ShouldBeTrue)
+	_, err = stream.SaveEvent(context.Background(), -time.Second)
+	test.That(t, err, test.ShouldNotBeNil)
+
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	writeFrame := func(i int) {
+		data := []byte{0x11}
+		if i%3 == 0 {
+			data = testVP8KeyFrame(64, 48)
+		}
+		test.That(t, bs.videoTrackLocal.WriteData(webrtc.MimeTypeVP8, data, at(i*100)), test.ShouldBeNil)
+	}
+	for i := 0; i < 9; i++ {
+		writeFrame(i)
+	}
+
+	// the buffer keeps at least its duration of media from a key frame
+	result := saveEvent(stream, 200*time.Millisecond)
+	waitForEventSave(t, bs.eventBuffer, at(1000))
+	writeFrame(9)
+	// saving again while saving extends the event
+	extended := saveEvent(stream, 250*time.Millisecond)
+	waitForEventSave(t, bs.eventBuffer, at(1150))
+	for i := 10; i < 13; i++ {
+		writeFrame(i)
+	}
+
+	saved := <-result
+	test.That(t, saved.err, test.ShouldBeNil)
+	test.That(t, saved.path, test.ShouldEqual, filepath.Join(dir, "cam-20230102T030405.300Z.webm"))
+	test.That(t, <-extended, test.ShouldResemble, saved)
+	test.That(t, files.Paths(), test.ShouldResemble, []string{saved.path})
+	clusters := recordedClusters(t, saved.path)
+	test.That(t, clusters, test.ShouldHaveLength, 3)
+	var blocks int
+	for _, cluster := range clusters {
+		blocks += len(findEBML(cluster, mkvIDSimpleBlock))
+	}
+	test.That(t, blocks, test.ShouldEqual, 9)
+
+	// media already saved is not saved again and stopping the stream finalizes the event
+	result = saveEvent(stream, time.Hour)
+	waitForEventSave(t, bs.eventBuffer, at(1200).Add(time.Hour))
+	for i := 13; i < 16; i++ {
+		writeFrame(i)
+	}
+	stream.Stop()
+	// the event is finalized by the time the stream has stopped
+	test.That(t, files.Paths(), test.ShouldHaveLength, 2)
+	saved = <-result
+	test.That(t, saved.err, test.ShouldBeNil)
+	test.That(t, saved.path, test.ShouldEqual, filepath.Join(dir, "cam-20230102T030406.200Z.webm"))
+	clusters = recordedClusters(t, saved.path)
+	test.That(t, clusters, test.ShouldHaveLength, 2)
+	test.That(t, findEBML(clusters[0], mkvIDSimpleBlock), test.ShouldHaveLength, 3)
+	test.That(t, findEBML(clusters[1], mkvIDSimpleBlock), test.ShouldHaveLength, 1)
+
+	// nothing is kept once the stream stops
+	bs.eventBuffer.mu.Lock()
+	test.That(t, bs.eventBuffer.segments, test.ShouldBeEmpty)
+	bs.eventBuffer.mu.Unlock()
+	result = saveEvent(stream, time.Hour)
+	waitForEventSave(t, bs.eventBuffer, time.Time{})
+	stream.Stop()
+	test.That(t, (<-result).err, test.ShouldNotBeNil)
+}
+
+func TestEventBufferTrim(t *testing.T) {
+	eb := &eventBuffer{config: EventBufferConfig{Duration: time.Second, MaxSize: 10}}
+	start := time.Now()
+	add := func(ms, size int, canStart bool) {
+		eb.add(recordedMedia{data: make([]byte, size), timestamp: start.Add(time.Duration(ms) * time.Millisecond)}, canStart)
+	}
+	starts := func() []time.Time {
+		var starts []time.Time
+		for _, segment := range eb.segments {
+			starts = append(starts, segment.start)
+		}
+		return starts
+	}
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	// media before anything a file can start with is not kept
+	add(0, 1, false)
+	test.That(t, eb.segments, test.ShouldBeEmpty)
+	add(100, 1, true)
+	add(200, 1, false)
+	add(600, 1, true)
+	add(1000, 1, false)
+	add(1100, 1, false)
+	test.That(t, starts(), test.ShouldResemble, []time.Time{at(100), at(600)})
+	// the oldest segment goes once the next one covers the duration
+	add(1600, 1, true)
+	test.That(t, starts(), test.ShouldResemble, []time.Time{at(600), at(1600)})
+	test.That(t, eb.size, test.ShouldEqual, 4)
+	// or once media is too big, though the latest segment is always kept
+	add(1700, 7, false)
+	test.That(t, starts(), test.ShouldResemble, []time.Time{at(1600)})
+	add(1800, 20, false)
+	test.That(t, starts(), test.ShouldResemble, []time.Time{at(1600)})
+	test.That(t, eb.size, test.ShouldEqual, 28)
+}
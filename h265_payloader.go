@@ -0,0 +1,88 @@
+package gostream
+
+// The NAL unit types that matter for packetizing H.265.
+// See https://datatracker.ietf.org/doc/html/rfc7798#section-4.4.
+const (
+	h265NALAccessUnitDelimiter = 35
+	h265NALFillerData          = 38
+	h265NALFragmentationUnit   = 49
+)
+
+// h265Payloader packetizes access units of H.265 in Annex B format as described by
+// RFC 7798. Each NAL unit is sent in its own packet, or split across fragmentation
+// units if it does not fit. Access unit delimiters and filler data are dropped.
+type h265Payloader struct{}
+
+// Payload fragments an access unit across one or more payloads of at most mtu bytes.
+func (p *h265Payloader) Payload(mtu uint16, accessUnit []byte) [][]byte {
+	// the payload header and FU header of a fragmentation unit
+	const fuOverhead = 3
+	if mtu <= fuOverhead {
+		return nil
+	}
+
+	var payloads [][]byte
+	for _, nal := range splitAnnexB(accessUnit) {
+		if len(nal) < 2 {
+			continue
+		}
+		nalType := (nal[0] >> 1) & 0b111111
+		if nalType == h265NALAccessUnitDelimiter || nalType == h265NALFillerData {
+			continue
+		}
+		if len(nal) <= int(mtu) {
+			payloads = append(payloads, append([]byte(nil), nal...))
+			continue
+		}
+
+		// the payload header is the NAL unit header with the type replaced
+		payloadHeader := [2]byte{nal[0]&0b10000001 | h265NALFragmentationUnit<<1, nal[1]}
+		data := nal[2:]
+		maxFragmentSize := int(mtu) - fuOverhead
+		for start := true; len(data) != 0; start = false {
+			fragmentSize := len(data)
+			if fragmentSize > maxFragmentSize {
+				fragmentSize = maxFragmentSize
+			}
+			fuHeader := nalType
+			if start {
+				fuHeader |= 0b10000000
+			}
+			if fragmentSize == len(data) {
+				fuHeader |= 0b01000000
+			}
+			payload := make([]byte, 0, fuOverhead+fragmentSize)
+			payload = append(payload, payloadHeader[0], payloadHeader[1], fuHeader)
+			payload = append(payload, data[:fragmentSize]...)
+			payloads = append(payloads, payload)
+			data = data[fragmentSize:]
+		}
+	}
+	return payloads
+}
+
+// splitAnnexB returns the NAL units of a byte stream in Annex B format, without their
+// start codes.
+func splitAnnexB(stream []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(stream); i++ {
+		if stream[i] != 0 || stream[i+1] != 0 || stream[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			end := i
+			// the zero byte of a four byte start code belongs to the next start code
+			if end > start && stream[end-1] == 0 {
+				end--
+			}
+			nals = append(nals, stream[start:end])
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(stream) {
+		nals = append(nals, stream[start:])
+	}
+	return nals
+}
@@ -0,0 +1,48 @@
+package gostream
+
+import (
+	"bytes"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestH265Payloader(t *testing.T) {
+	// VPS, SPS, PPS and an IDR slice with an access unit delimiter in front
+	vps := []byte{32 << 1, 1, 1, 2}
+	sps := []byte{33 << 1, 1, 3, 4}
+	pps := []byte{34 << 1, 1, 5}
+	aud := []byte{35 << 1, 1, 0x50}
+	idr := append([]byte{19 << 1, 1}, bytes.Repeat([]byte{6}, 250)...)
+	var accessUnit []byte
+	for i, nal := range [][]byte{aud, vps, sps, pps, idr} {
+		if i%2 == 0 {
+			accessUnit = append(accessUnit, 0, 0, 0, 1)
+		} else {
+			accessUnit = append(accessUnit, 0, 0, 1)
+		}
+		accessUnit = append(accessUnit, nal...)
+	}
+
+	t.Run("single NAL units", func(t *testing.T) {
+		payloads := (&h265Payloader{}).Payload(1200, accessUnit)
+		test.That(t, payloads, test.ShouldResemble, [][]byte{vps, sps, pps, idr})
+	})
+
+	t.Run("fragmentation units", func(t *testing.T) {
+		payloads := (&h265Payloader{}).Payload(100, accessUnit)
+		test.That(t, payloads[:3], test.ShouldResemble, [][]byte{vps, sps, pps})
+		fragments := payloads[3:]
+		test.That(t, fragments, test.ShouldHaveLength, 3)
+		var data []byte
+		for i, fragment := range fragments {
+			test.That(t, len(fragment), test.ShouldBeLessThanOrEqualTo, 100)
+			test.That(t, fragment[:2], test.ShouldResemble, []byte{49 << 1, 1})
+			test.That(t, fragment[2]&0b111111, test.ShouldEqual, 19)
+			test.That(t, fragment[2]&0b10000000 != 0, test.ShouldEqual, i == 0)
+			test.That(t, fragment[2]&0b01000000 != 0, test.ShouldEqual, i == len(fragments)-1)
+			data = append(data, fragment[3:]...)
+		}
+		test.That(t, data, test.ShouldResemble, idr[2:])
+	})
+}
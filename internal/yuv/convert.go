@@ -0,0 +1,43 @@
+// Package yuv converts images to the planar YUV 4:2:0 (I420) format that video encoders consume.
+package yuv
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/pion/mediadevices/pkg/io/video"
+)
+
+// ToI420 returns the image in the planar YUV 4:2:0 format, with the first sample of each plane
+// at the top left of the image. Images that are already in that format are returned as they are.
+// The returned function must be called once the converted image is no longer used.
+func ToI420(img image.Image) (*image.YCbCr, func(), error) {
+	bounds := img.Bounds()
+	if yuv, ok := img.(*image.YCbCr); ok && yuv.SubsampleRatio == image.YCbCrSubsampleRatio420 {
+		// the planes of a sub-image start at its top left, but its chroma samples only
+		// line up with its luma if it starts on an even row and column
+		if bounds.Min.X%2 == 0 && bounds.Min.Y%2 == 0 {
+			return yuv, func() {}, nil
+		}
+	}
+	if bounds.Min != (image.Point{}) {
+		// conversions read images as if they start at the origin
+		rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+		img = rgba
+	}
+	reader := video.ToI420(video.ReaderFunc(func() (image.Image, func(), error) {
+		return img, func() {}, nil
+	}))
+	converted, release, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	yuv, ok := converted.(*image.YCbCr)
+	if !ok {
+		release()
+		return nil, nil, fmt.Errorf("cannot convert %T to I420", img)
+	}
+	return yuv, release, nil
+}
@@ -0,0 +1,57 @@
+package yuv
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestToI420(t *testing.T) {
+	t.Run("returns I420 images as they are", func(t *testing.T) {
+		img := image.NewYCbCr(image.Rect(0, 0, 8, 8), image.YCbCrSubsampleRatio420)
+		converted, release, err := ToI420(img)
+		test.That(t, err, test.ShouldBeNil)
+		release()
+		test.That(t, converted, test.ShouldEqual, img)
+
+		sub := img.SubImage(image.Rect(2, 2, 6, 6)).(*image.YCbCr)
+		converted, release, err = ToI420(sub)
+		test.That(t, err, test.ShouldBeNil)
+		release()
+		test.That(t, converted, test.ShouldEqual, sub)
+	})
+
+	t.Run("converts sub-images from their top left", func(t *testing.T) {
+		rgba := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		gray := image.NewYCbCr(image.Rect(0, 0, 8, 8), image.YCbCrSubsampleRatio420)
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				luma := uint8(y*32 + x*4)
+				rgba.Set(x, y, color.Gray{Y: luma})
+				gray.Y[gray.YOffset(x, y)] = luma
+				gray.Cb[gray.COffset(x, y)] = 128
+				gray.Cr[gray.COffset(x, y)] = 128
+			}
+		}
+
+		// the chroma of an I420 sub-image that starts on an odd column does not line up
+		// with its luma, so it is converted too
+		for _, img := range []image.Image{
+			rgba.SubImage(image.Rect(1, 3, 5, 7)),
+			gray.SubImage(image.Rect(1, 3, 5, 7)),
+		} {
+			converted, release, err := ToI420(img)
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, converted.Bounds(), test.ShouldResemble, image.Rect(0, 0, 4, 4))
+			test.That(t, converted.SubsampleRatio, test.ShouldEqual, image.YCbCrSubsampleRatio420)
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					test.That(t, converted.Y[y*converted.YStride+x], test.ShouldAlmostEqual, (y+3)*32+(x+1)*4, 1)
+				}
+			}
+			release()
+		}
+	})
+}
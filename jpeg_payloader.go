@@ -0,0 +1,166 @@
+package gostream
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// MimeTypeJPEG is the MIME type of JPEG video sent over RTP as described by RFC 2435.
+// Browsers do not support it so only peers that register it with their media engine,
+// usually with a clock rate of 90kHz and the static payload type 26, can receive it.
+const MimeTypeJPEG = "video/JPEG"
+
+// The JPEG markers that matter for packetizing JPEG.
+const (
+	jpegMarkerSOF0 = 0xC0
+	jpegMarkerSOI  = 0xD8
+	jpegMarkerEOI  = 0xD9
+	jpegMarkerSOS  = 0xDA
+	jpegMarkerDQT  = 0xDB
+	jpegMarkerDRI  = 0xDD
+)
+
+const (
+	// jpegHeaderSize is the size of the main JPEG header of every payload.
+	// See https://datatracker.ietf.org/doc/html/rfc2435#section-3.1.
+	jpegHeaderSize = 8
+	// jpegDynamicQ marks that the quantization tables are sent in the first payload of
+	// each frame instead of being derived from a quality factor.
+	jpegDynamicQ = 255
+	// jpegMaxDimension is the largest width or height that the header can describe.
+	jpegMaxDimension = 2040
+)
+
+// jpegPayloader packetizes baseline JPEG images as described by RFC 2435. Only the
+// entropy-coded scan of an image is sent, preceded by its quantization tables in the
+// first payload, so images must use the standard Huffman tables and be 4:2:2 or 4:2:0
+// YCbCr (as produced by image/jpeg). Images that cannot be sent are dropped.
+type jpegPayloader struct{}
+
+// A jpegImage is what RFC 2435 sends of a JPEG image.
+type jpegImage struct {
+	jpegType      byte
+	width, height int
+	// qTables are the luma and chroma quantization tables, in zig-zag order.
+	qTables [2][]byte
+	scan    []byte
+}
+
+// Payload fragments a JPEG image across one or more payloads of at most mtu bytes.
+func (p *jpegPayloader) Payload(mtu uint16, frame []byte) [][]byte {
+	img, err := parseJPEG(frame)
+	if err != nil {
+		return nil
+	}
+
+	qHeader := make([]byte, 4, 4+len(img.qTables[0])+len(img.qTables[1]))
+	binary.BigEndian.PutUint16(qHeader[2:], uint16(len(img.qTables[0])+len(img.qTables[1])))
+	qHeader = append(qHeader, img.qTables[0]...)
+	qHeader = append(qHeader, img.qTables[1]...)
+	if int(mtu) <= jpegHeaderSize+len(qHeader) {
+		return nil
+	}
+
+	var payloads [][]byte
+	for offset := 0; offset < len(img.scan); {
+		payload := make([]byte, jpegHeaderSize, mtu)
+		// the first byte is type-specific and unused, followed by the 24-bit offset
+		binary.BigEndian.PutUint32(payload, uint32(offset))
+		payload[4] = img.jpegType
+		payload[5] = jpegDynamicQ
+		payload[6] = byte((img.width + 7) / 8)
+		payload[7] = byte((img.height + 7) / 8)
+		if offset == 0 {
+			payload = append(payload, qHeader...)
+		}
+		size := int(mtu) - len(payload)
+		if remaining := len(img.scan) - offset; size > remaining {
+			size = remaining
+		}
+		payload = append(payload, img.scan[offset:offset+size]...)
+		payloads = append(payloads, payload)
+		offset += size
+	}
+	return payloads
+}
+
+// parseJPEG finds the parts of a baseline JPEG image that RFC 2435 sends.
+func parseJPEG(data []byte) (*jpegImage, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegMarkerSOI {
+		return nil, errors.New("not a JPEG image")
+	}
+	var img jpegImage
+	tables := map[byte][]byte{}
+	var tableIDs [2]byte
+	var haveFrame bool
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, errors.New("expected JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// fill byte
+			i++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		segment := data[i+4 : i+2+length]
+		switch marker {
+		case jpegMarkerDQT:
+			for len(segment) != 0 {
+				if segment[0]>>4 != 0 || len(segment) < 65 {
+					return nil, errors.New("only 8-bit quantization tables are supported")
+				}
+				tables[segment[0]&0xF] = segment[1:65]
+				segment = segment[65:]
+			}
+		case jpegMarkerSOF0:
+			if len(segment) != 15 || segment[5] != 3 {
+				return nil, errors.New("only YCbCr JPEG images are supported")
+			}
+			img.height = int(binary.BigEndian.Uint16(segment[1:]))
+			img.width = int(binary.BigEndian.Uint16(segment[3:]))
+			switch segment[7] {
+			case 0x21:
+				img.jpegType = 0
+			case 0x22:
+				img.jpegType = 1
+			default:
+				return nil, errors.New("only 4:2:2 and 4:2:0 JPEG images are supported")
+			}
+			if segment[10] != 0x11 || segment[13] != 0x11 || segment[11] != segment[14] {
+				return nil, errors.New("unsupported JPEG chroma components")
+			}
+			tableIDs = [2]byte{segment[8], segment[11]}
+			haveFrame = true
+		case jpegMarkerDRI:
+			if len(segment) == 2 && binary.BigEndian.Uint16(segment) != 0 {
+				return nil, errors.New("JPEG restart intervals are not supported")
+			}
+		case jpegMarkerSOS:
+			if !haveFrame {
+				return nil, errors.New("JPEG scan before frame header")
+			}
+			if img.width > jpegMaxDimension || img.height > jpegMaxDimension {
+				return nil, errors.New("JPEG image too large")
+			}
+			for j, id := range tableIDs {
+				table, ok := tables[id]
+				if !ok {
+					return nil, errors.New("missing JPEG quantization table")
+				}
+				img.qTables[j] = table
+			}
+			img.scan = data[i+2+length:]
+			if n := len(img.scan); n >= 2 && img.scan[n-2] == 0xFF && img.scan[n-1] == jpegMarkerEOI {
+				img.scan = img.scan[:n-2]
+			}
+			return &img, nil
+		}
+		i += 2 + length
+	}
+	return nil, errors.New("JPEG image has no scan")
+}
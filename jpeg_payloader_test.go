@@ -0,0 +1,60 @@
+package gostream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestJPEGPayloader(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 60; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 4), uint8(x + y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	test.That(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}), test.ShouldBeNil)
+	frame := buf.Bytes()
+
+	parsed, err := parseJPEG(frame)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, parsed.jpegType, test.ShouldEqual, 1)
+	test.That(t, parsed.width, test.ShouldEqual, 100)
+	test.That(t, parsed.height, test.ShouldEqual, 60)
+	test.That(t, parsed.qTables[0], test.ShouldHaveLength, 64)
+	test.That(t, parsed.qTables[1], test.ShouldHaveLength, 64)
+	test.That(t, bytes.HasSuffix(frame, append(append([]byte(nil), parsed.scan...), 0xFF, jpegMarkerEOI)), test.ShouldBeTrue)
+
+	payloads := (&jpegPayloader{}).Payload(300, frame)
+	test.That(t, len(payloads), test.ShouldBeGreaterThan, 1)
+	var scan []byte
+	for i, payload := range payloads {
+		test.That(t, len(payload), test.ShouldBeLessThanOrEqualTo, 300)
+		offset := binary.BigEndian.Uint32(payload) & 0xFFFFFF
+		test.That(t, offset, test.ShouldEqual, len(scan))
+		test.That(t, payload[4:8], test.ShouldResemble, []byte{1, jpegDynamicQ, 13, 8})
+		data := payload[jpegHeaderSize:]
+		if i == 0 {
+			test.That(t, data[:4], test.ShouldResemble, []byte{0, 0, 0, 128})
+			test.That(t, data[4:68], test.ShouldResemble, parsed.qTables[0])
+			test.That(t, data[68:132], test.ShouldResemble, parsed.qTables[1])
+			data = data[132:]
+		}
+		scan = append(scan, data...)
+	}
+	test.That(t, scan, test.ShouldResemble, parsed.scan)
+
+	t.Run("unsupported", func(t *testing.T) {
+		gray := image.NewGray(image.Rect(0, 0, 16, 16))
+		buf.Reset()
+		test.That(t, jpeg.Encode(&buf, gray, nil), test.ShouldBeNil)
+		test.That(t, (&jpegPayloader{}).Payload(1200, buf.Bytes()), test.ShouldBeEmpty)
+		test.That(t, (&jpegPayloader{}).Payload(1200, []byte{1, 2, 3, 4}), test.ShouldBeEmpty)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pion/mediadevices/pkg/driver"
 	"github.com/pion/mediadevices/pkg/driver/camera"
@@ -25,6 +26,15 @@ type (
 		Close(ctx context.Context) error
 	}
 
+	// A TimestampedMediaReader is a MediaReader that knows when the media it reads was
+	// captured, such as a reader of samples that carry their capture time.
+	TimestampedMediaReader[T any] interface {
+		MediaReader[T]
+
+		// ReadTimestamped is like Read but also returns when the media was captured.
+		ReadTimestamped(ctx context.Context) (data T, release func(), captured time.Time, err error)
+	}
+
 	// A MediaReaderFunc is a helper to turn a function into a MediaReader.
 	MediaReaderFunc[T any] func(ctx context.Context) (T, func(), error)
 
@@ -40,6 +50,16 @@ type (
 		Close(ctx context.Context) error
 	}
 
+	// A TimestampedMediaStream is a MediaStream that knows when its media was captured.
+	// The streams of media sources are timestamped with the capture time given by their
+	// readers, if they are TimestampedMediaReaders, or else with when the media was read.
+	TimestampedMediaStream[T any] interface {
+		MediaStream[T]
+
+		// NextTimestamped is like Next but also returns when the media was captured.
+		NextTimestamped(ctx context.Context) (T, func(), time.Time, error)
+	}
+
 	// A MediaSource can produce Streams of Ts.
 	MediaSource[T any] interface {
 		// Stream returns a stream that makes a best effort to return consecutive media elements
@@ -66,6 +86,53 @@ func (mrf MediaReaderFunc[T]) Close(ctx context.Context) error {
 	return nil
 }
 
+// A timestampedMediaReaderFunc is a helper to turn a function into a TimestampedMediaReader.
+type timestampedMediaReaderFunc[T any] func(ctx context.Context) (T, func(), time.Time, error)
+
+// Read calls the underlying function to get a media.
+func (mrf timestampedMediaReaderFunc[T]) Read(ctx context.Context) (T, func(), error) {
+	media, release, _, err := mrf(ctx)
+	return media, release, err
+}
+
+// ReadTimestamped calls the underlying function to get a media along with when it was captured.
+func (mrf timestampedMediaReaderFunc[T]) ReadTimestamped(ctx context.Context) (T, func(), time.Time, error) {
+	return mrf(ctx)
+}
+
+// Close does nothing.
+func (mrf timestampedMediaReaderFunc[T]) Close(ctx context.Context) error {
+	return nil
+}
+
+// readTimestamped reads media from the reader along with when it was captured. Media of
+// readers that do not know when it was captured is timestamped with when it was read.
+func readTimestamped[T any](ctx context.Context, reader MediaReader[T]) (T, func(), time.Time, error) {
+	if timestamped, ok := reader.(TimestampedMediaReader[T]); ok {
+		media, release, captured, err := timestamped.ReadTimestamped(ctx)
+		if err == nil && captured.IsZero() {
+			captured = time.Now()
+		}
+		return media, release, captured, err
+	}
+	media, release, err := reader.Read(ctx)
+	return media, release, time.Now(), err
+}
+
+// nextTimestamped returns the next media of the stream along with when it was captured. Media
+// of streams that do not know when it was captured is timestamped with when it was returned.
+func nextTimestamped[T any](ctx context.Context, stream MediaStream[T]) (T, func(), time.Time, error) {
+	if timestamped, ok := stream.(TimestampedMediaStream[T]); ok {
+		media, release, captured, err := timestamped.NextTimestamped(ctx)
+		if err == nil && captured.IsZero() {
+			captured = time.Now()
+		}
+		return media, release, captured, err
+	}
+	media, release, err := stream.Next(ctx)
+	return media, release, time.Now(), err
+}
+
 // A mediaReaderFuncNoCtx is a helper to turn a function into a MediaReader that cannot
 // accept a context argument.
 type mediaReaderFuncNoCtx[T any] func() (T, func(), error)
@@ -109,13 +176,23 @@ type mediaSource[T any, U any] struct {
 	producerConsumersMu sync.Mutex
 }
 
+// These count the producers and consumers of every media source, for metrics.
+var (
+	// activeMediaProducers is how many producers are reading from their sources.
+	activeMediaProducers int64
+	// activeMediaConsumers is how many media streams are open.
+	activeMediaConsumers int64
+	// mediaProduced is how much media producers have read successfully.
+	mediaProduced uint64
+)
+
 type producerConsumer[T any, U any] struct {
 	rootCancelCtx           context.Context
 	cancelCtx               context.Context
 	cancel                  func()
 	mimeType                string
 	activeBackgroundWorkers sync.WaitGroup
-	readWrapper             MediaReader[T]
+	readWrapper             func(ctx context.Context) (T, func(), time.Time, error)
 	current                 *mediaRefReleasePairWithError[T]
 	currentMu               sync.RWMutex
 	producerCond            *sync.Cond
@@ -175,6 +252,7 @@ func newMediaSource[T, U any](d driver.Driver, r MediaReader[T], p U) MediaSourc
 			driverRefs.refs[label] = utils.NewRefCountedValue(d)
 			driverRefs.refs[label].Ref()
 		}
+		driverRefs.counts[label]++
 	}
 
 	cancelCtx, cancel := context.WithCancel(context.Background())
@@ -200,6 +278,7 @@ func (pc *producerConsumer[T, U]) start() {
 	}
 
 	pc.activeBackgroundWorkers.Add(1)
+	atomic.AddInt64(&activeMediaProducers, 1)
 
 	utils.ManagedGo(func() {
 		first := true
@@ -255,7 +334,10 @@ func (pc *producerConsumer[T, U]) start() {
 				} else {
 					first = false
 				}
-				media, release, err := pc.readWrapper.Read(pc.cancelCtx)
+				media, release, captured, err := pc.readWrapper(pc.cancelCtx)
+				if err == nil {
+					atomic.AddUint64(&mediaProduced, 1)
+				}
 				ref := utils.NewRefCountedValue(struct{}{})
 				ref.Ref()
 
@@ -270,21 +352,26 @@ func (pc *producerConsumer[T, U]) start() {
 							release()
 						}
 					}
-				}, err}
+				}, captured, err}
 				pc.currentMu.Unlock()
 				if lastRelease != nil {
 					lastRelease()
 				}
 			}()
 		}
-	}, func() { defer pc.activeBackgroundWorkers.Done(); pc.cancel() })
+	}, func() {
+		defer pc.activeBackgroundWorkers.Done()
+		atomic.AddInt64(&activeMediaProducers, -1)
+		pc.cancel()
+	})
 }
 
 type mediaRefReleasePairWithError[T any] struct {
-	Media   T
-	Ref     utils.RefCountedValue
-	Release func()
-	Err     error
+	Media    T
+	Ref      utils.RefCountedValue
+	Release  func()
+	Captured time.Time
+	Err      error
 }
 
 func (pc *producerConsumer[T, U]) Stop() {
@@ -334,6 +421,14 @@ type MediaReleasePairWithError[T any] struct {
 	Err     error
 }
 
+// TimestampedMediaReleasePairWithError contains the result of fetching media along with
+// when it was captured. If Timestamp is zero, the media is timestamped with when it is
+// returned from the stream.
+type TimestampedMediaReleasePairWithError[T any] struct {
+	MediaReleasePairWithError[T]
+	Timestamp time.Time
+}
+
 // NewMediaStreamForChannel returns a MediaStream backed by a channel.
 func NewMediaStreamForChannel[T any](ctx context.Context) (context.Context, MediaStream[T], chan<- MediaReleasePairWithError[T]) {
 	cancelCtx, cancel := context.WithCancel(ctx)
@@ -345,21 +440,44 @@ func NewMediaStreamForChannel[T any](ctx context.Context) (context.Context, Medi
 	}, ch
 }
 
+// NewTimestampedMediaStreamForChannel returns a TimestampedMediaStream backed by a channel
+// of media along with when it was captured.
+func NewTimestampedMediaStreamForChannel[T any](
+	ctx context.Context,
+) (context.Context, TimestampedMediaStream[T], chan<- TimestampedMediaReleasePairWithError[T]) {
+	cancelCtx, cancel := context.WithCancel(ctx)
+	ch := make(chan TimestampedMediaReleasePairWithError[T])
+	return cancelCtx, &mediaStreamFromChannel[T]{
+		timestampedMedia: ch,
+		cancelCtx:        cancelCtx,
+		cancel:           cancel,
+	}, ch
+}
+
+// mediaStreamFromChannel receives media from only one of its channels; the other is nil.
 type mediaStreamFromChannel[T any] struct {
-	media     chan MediaReleasePairWithError[T]
-	cancelCtx context.Context
-	cancel    func()
+	media            chan MediaReleasePairWithError[T]
+	timestampedMedia chan TimestampedMediaReleasePairWithError[T]
+	cancelCtx        context.Context
+	cancel           func()
 }
 
 func (ms *mediaStreamFromChannel[T]) Next(ctx context.Context) (T, func(), error) {
+	media, release, _, err := ms.NextTimestamped(ctx)
+	return media, release, err
+}
+
+func (ms *mediaStreamFromChannel[T]) NextTimestamped(ctx context.Context) (T, func(), time.Time, error) {
 	var zero T
 	select {
 	case <-ms.cancelCtx.Done():
-		return zero, nil, ms.cancelCtx.Err()
+		return zero, nil, time.Time{}, ms.cancelCtx.Err()
 	case <-ctx.Done():
-		return zero, nil, ctx.Err()
+		return zero, nil, time.Time{}, ctx.Err()
 	case pair := <-ms.media:
-		return pair.Media, pair.Release, pair.Err
+		return pair.Media, pair.Release, time.Time{}, pair.Err
+	case pair := <-ms.timestampedMedia:
+		return pair.Media, pair.Release, pair.Timestamp, pair.Err
 	}
 }
 
@@ -374,16 +492,25 @@ type mediaStream[T any, U any] struct {
 	prodCon   *producerConsumer[T, U]
 	cancelCtx context.Context
 	cancel    func()
+
+	// closed is set atomically by the first Close, which is the only one that releases
+	// the stream, since Next holds mu while it waits.
+	closed int32
 }
 
 func (ms *mediaStream[T, U]) Next(ctx context.Context) (T, func(), error) {
+	media, release, _, err := ms.NextTimestamped(ctx)
+	return media, release, err
+}
+
+func (ms *mediaStream[T, U]) NextTimestamped(ctx context.Context) (T, func(), time.Time, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 	// lock keeps us sequential and prevents misuse
 
 	var zero T
 	if err := ms.cancelCtx.Err(); err != nil {
-		return zero, nil, err
+		return zero, nil, time.Time{}, err
 	}
 
 	ms.prodCon.consumerCond.L.Lock()
@@ -397,10 +524,10 @@ func (ms *mediaStream[T, U]) Next(ctx context.Context) (T, func(), error) {
 	select {
 	case <-ms.cancelCtx.Done():
 		ms.prodCon.consumerCond.L.Unlock()
-		return zero, nil, ms.cancelCtx.Err()
+		return zero, nil, time.Time{}, ms.cancelCtx.Err()
 	case <-ctx.Done():
 		ms.prodCon.consumerCond.L.Unlock()
-		return zero, nil, ctx.Err()
+		return zero, nil, time.Time{}, ctx.Err()
 	default:
 	}
 
@@ -417,7 +544,7 @@ func (ms *mediaStream[T, U]) Next(ctx context.Context) (T, func(), error) {
 	}
 
 	if err := waitForNext(); err != nil {
-		return zero, nil, err
+		return zero, nil, time.Time{}, err
 	}
 
 	isAvailable := func() bool {
@@ -429,7 +556,7 @@ func (ms *mediaStream[T, U]) Next(ctx context.Context) (T, func(), error) {
 	for !isAvailable() {
 		ms.prodCon.consumerCond.L.Lock()
 		if err := waitForNext(); err != nil {
-			return zero, nil, err
+			return zero, nil, time.Time{}, err
 		}
 	}
 
@@ -439,14 +566,18 @@ func (ms *mediaStream[T, U]) Next(ctx context.Context) (T, func(), error) {
 	defer ms.prodCon.currentMu.RUnlock()
 	current := ms.prodCon.current
 	if current.Err != nil {
-		return zero, nil, current.Err
+		return zero, nil, time.Time{}, current.Err
 	}
 	current.Ref.Ref()
-	return current.Media, current.Release, nil
+	return current.Media, current.Release, current.Captured, nil
 }
 
 func (ms *mediaStream[T, U]) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&ms.closed, 0, 1) {
+		return nil
+	}
 	ms.cancel()
+	atomic.AddInt64(&activeMediaConsumers, -1)
 	ms.prodCon.errHandlersMu.Lock()
 	delete(ms.prodCon.errHandlers, ms)
 	ms.prodCon.errHandlersMu.Unlock()
@@ -478,10 +609,10 @@ func (ms *mediaSource[T, U]) Stream(ctx context.Context, errHandlers ...ErrorHan
 			condMu:        condMu,
 			errHandlers:   map[*mediaStream[T, U]][]ErrorHandler{},
 		}
-		prodCon.readWrapper = MediaReaderFunc[T](func(ctx context.Context) (T, func(), error) {
-			media, release, err := ms.reader.Read(ctx)
+		prodCon.readWrapper = func(ctx context.Context) (T, func(), time.Time, error) {
+			media, release, captured, err := readTimestamped(ctx, ms.reader)
 			if err == nil {
-				return media, release, nil
+				return media, release, captured, nil
 			}
 
 			prodCon.errHandlersMu.Lock()
@@ -492,8 +623,8 @@ func (ms *mediaSource[T, U]) Stream(ctx context.Context, errHandlers ...ErrorHan
 				}
 			}
 			var zero T
-			return zero, nil, err
-		})
+			return zero, nil, time.Time{}, err
+		}
 		ms.producerConsumers[mimeType] = prodCon
 	}
 	ms.producerConsumersMu.Unlock()
@@ -515,6 +646,7 @@ func (ms *mediaSource[T, U]) Stream(ctx context.Context, errHandlers ...ErrorHan
 		prodCon.errHandlersMu.Unlock()
 	}
 	prodCon.start()
+	atomic.AddInt64(&activeMediaConsumers, 1)
 
 	return stream, nil
 }
@@ -537,8 +669,10 @@ func (ms *mediaSource[T, U]) Close(ctx context.Context) error {
 
 	label := ms.driver.Info().Label
 	if rcv, ok := driverRefs.refs[label]; ok {
+		driverRefs.counts[label]--
 		if rcv.Deref() {
 			delete(driverRefs.refs, label)
+			delete(driverRefs.counts, label)
 			return multierr.Combine(err, ms.driver.Close())
 		}
 	} else {
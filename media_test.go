@@ -6,7 +6,9 @@ import (
 	"image"
 	"image/png"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pion/mediadevices/pkg/prop"
 	"go.viam.com/test"
@@ -77,3 +79,99 @@ func TestReadMedia(t *testing.T) {
 	test.That(t, err, test.ShouldBeNil)
 	test.That(t, red, test.ShouldNotEqual, blue)
 }
+
+// timestampedImageSource reads the same image, captured at the given time.
+type timestampedImageSource struct {
+	img      image.Image
+	captured time.Time
+}
+
+func (s *timestampedImageSource) Read(ctx context.Context) (image.Image, func(), error) {
+	img, release, _, err := s.ReadTimestamped(ctx)
+	return img, release, err
+}
+
+func (s *timestampedImageSource) ReadTimestamped(_ context.Context) (image.Image, func(), time.Time, error) {
+	return s.img, func() {}, s.captured, nil
+}
+
+func (s *timestampedImageSource) Close(_ context.Context) error {
+	return nil
+}
+
+func TestMediaStreamCaptureTimes(t *testing.T) {
+	img := pngToImage(t, "data/red.png")
+	captured := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	next := func(source VideoSource) (image.Image, time.Time) {
+		t.Helper()
+		stream, err := source.Stream(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		defer func() {
+			test.That(t, stream.Close(context.Background()), test.ShouldBeNil)
+		}()
+		actual, release, timestamp, err := stream.(TimestampedMediaStream[image.Image]).NextTimestamped(context.Background())
+		test.That(t, err, test.ShouldBeNil)
+		release()
+		return actual, timestamp
+	}
+
+	// media is timestamped with its capture time if the reader knows it
+	source := NewVideoSource(&timestampedImageSource{img: img, captured: captured}, prop.Video{})
+	actual, timestamp := next(source)
+	test.That(t, actual, test.ShouldEqual, img)
+	test.That(t, timestamp, test.ShouldEqual, captured)
+	// which sources built on it keep
+	actual, timestamp = next(NewResizeVideoSource(source, 10, 10))
+	test.That(t, actual.Bounds().Size(), test.ShouldResemble, image.Pt(10, 10))
+	test.That(t, timestamp, test.ShouldEqual, captured)
+
+	// and otherwise with when it was read
+	before := time.Now()
+	actual, timestamp = next(NewVideoSource(&imageSource{Images: []image.Image{img}}, prop.Video{}))
+	test.That(t, actual, test.ShouldEqual, img)
+	test.That(t, timestamp, test.ShouldHappenOnOrBetween, before, time.Now())
+}
+
+func TestMediaStreamForChannel(t *testing.T) {
+	img := pngToImage(t, "data/red.png")
+
+	_, stream, ch := NewMediaStreamForChannel[image.Image](context.Background())
+	go func() {
+		ch <- MediaReleasePairWithError[image.Image]{Media: img, Release: func() {}}
+	}()
+	actual, _, timestamp, err := stream.(TimestampedMediaStream[image.Image]).NextTimestamped(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, actual, test.ShouldEqual, img)
+	test.That(t, timestamp.IsZero(), test.ShouldBeTrue)
+	test.That(t, stream.Close(context.Background()), test.ShouldBeNil)
+
+	captured := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, timestampedStream, timestampedCh := NewTimestampedMediaStreamForChannel[image.Image](context.Background())
+	go func() {
+		timestampedCh <- TimestampedMediaReleasePairWithError[image.Image]{
+			MediaReleasePairWithError: MediaReleasePairWithError[image.Image]{Media: img, Release: func() {}},
+			Timestamp:                 captured,
+		}
+	}()
+	actual, _, timestamp, err = timestampedStream.NextTimestamped(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, actual, test.ShouldEqual, img)
+	test.That(t, timestamp, test.ShouldEqual, captured)
+	test.That(t, timestampedStream.Close(context.Background()), test.ShouldBeNil)
+	_, _, _, err = timestampedStream.NextTimestamped(context.Background())
+	test.That(t, err, test.ShouldBeError, context.Canceled)
+}
+
+func TestMediaStreamCloseTwice(t *testing.T) {
+	source := NewVideoSource(&imageSource{Images: []image.Image{pngToImage(t, "data/red.png")}}, prop.Video{})
+	defer func() {
+		test.That(t, source.Close(context.Background()), test.ShouldBeNil)
+	}()
+	before := atomic.LoadInt64(&activeMediaConsumers)
+	stream, err := source.Stream(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, atomic.LoadInt64(&activeMediaConsumers), test.ShouldEqual, before+1)
+	test.That(t, stream.Close(context.Background()), test.ShouldBeNil)
+	test.That(t, stream.Close(context.Background()), test.ShouldBeNil)
+	test.That(t, atomic.LoadInt64(&activeMediaConsumers), test.ShouldEqual, before)
+}
@@ -3,6 +3,7 @@ package gostream
 import (
 	"context"
 	"sync"
+	"time"
 
 	"go.uber.org/multierr"
 )
@@ -33,13 +34,18 @@ func (ems *embeddedMediaStream[T, U]) initStream(ctx context.Context) error {
 }
 
 func (ems *embeddedMediaStream[T, U]) Next(ctx context.Context) (T, func(), error) {
+	media, release, _, err := ems.NextTimestamped(ctx)
+	return media, release, err
+}
+
+func (ems *embeddedMediaStream[T, U]) NextTimestamped(ctx context.Context) (T, func(), time.Time, error) {
 	ems.mu.Lock()
 	defer ems.mu.Unlock()
 	if err := ems.initStream(ctx); err != nil {
 		var zero T
-		return zero, nil, err
+		return zero, nil, time.Time{}, err
 	}
-	return ems.stream.Next(ctx)
+	return nextTimestamped(ctx, ems.stream)
 }
 
 func (ems *embeddedMediaStream[T, U]) Close(ctx context.Context) error {
@@ -55,7 +61,11 @@ func (ems *embeddedMediaStream[T, U]) Close(ctx context.Context) error {
 // intended to be embedded/composed by another source. It defers the creation
 // of its media stream.
 func NewEmbeddedMediaStreamFromReader[T, U any](reader MediaReader[T], p U) MediaStream[T] {
-	src := newMediaSource[T](nil, MediaReaderFunc[T](reader.Read), p)
+	var wrapped MediaReader[T] = MediaReaderFunc[T](reader.Read)
+	if timestamped, ok := reader.(TimestampedMediaReader[T]); ok {
+		wrapped = timestampedMediaReaderFunc[T](timestamped.ReadTimestamped)
+	}
+	src := newMediaSource[T](nil, wrapped, p)
 	stream := NewEmbeddedMediaStream[T, U](src)
 	return &embeddedMediaReaderStream[T, U]{
 		src:    src,
@@ -72,6 +82,10 @@ func (emrs *embeddedMediaReaderStream[T, U]) Next(ctx context.Context) (T, func(
 	return emrs.stream.Next(ctx)
 }
 
+func (emrs *embeddedMediaReaderStream[T, U]) NextTimestamped(ctx context.Context) (T, func(), time.Time, error) {
+	return nextTimestamped(ctx, emrs.stream)
+}
+
 func (emrs *embeddedMediaReaderStream[T, U]) Close(ctx context.Context) error {
 	return multierr.Combine(emrs.stream.Close(ctx), emrs.src.Close(ctx))
 }
@@ -0,0 +1,198 @@
+package gostream
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "gostream"
+
+// A metricsCollector collects prometheus metrics of the streams of a stream server and the
+// peers receiving them, along with those of the media sources and drivers of the process.
+// Metrics are read from the stats of each stream whenever they are collected.
+type metricsCollector struct {
+	server *streamServer
+
+	frameRate          *prometheus.Desc
+	videoFramesIn      *prometheus.Desc
+	videoFramesOut     *prometheus.Desc
+	videoFramesDropped *prometheus.Desc
+	audioChunksIn      *prometheus.Desc
+	audioChunksOut     *prometheus.Desc
+	rtpPacketsSent     *prometheus.Desc
+	rtpBytesSent       *prometheus.Desc
+	peers              *prometheus.Desc
+	stageDuration      *prometheus.Desc
+	stageWaiting       *prometheus.Desc
+	stageDropped       *prometheus.Desc
+	peerConnections    *prometheus.Desc
+	mediaProducers     *prometheus.Desc
+	mediaConsumers     *prometheus.Desc
+	mediaProduced      *prometheus.Desc
+	driverRefs         *prometheus.Desc
+}
+
+func newMetricsCollector(server *streamServer) *metricsCollector {
+	streamDesc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "stream", name),
+			help,
+			append([]string{"stream"}, labels...),
+			nil,
+		)
+	}
+	return &metricsCollector{
+		server: server,
+
+		frameRate: streamDesc("frame_rate",
+			"Rate, in frames per second, at which video frames were taken for encoding over the last second."),
+		videoFramesIn: streamDesc("video_frames_in_total",
+			"Video frames given to the stream, whether or not they were encoded."),
+		videoFramesOut: streamDesc("video_frames_out_total",
+			"Encoded video frames written to peers, once for each simulcast layer and codec."),
+		videoFramesDropped: streamDesc("video_frames_dropped_total",
+			"Video frames dropped before being written to peers."),
+		audioChunksIn: streamDesc("audio_chunks_in_total",
+			"Audio chunks given to the stream."),
+		audioChunksOut: streamDesc("audio_chunks_out_total",
+			"Encoded audio chunks written to peers."),
+		rtpPacketsSent: streamDesc("rtp_packets_sent_total",
+			"RTP packets sent to every peer."),
+		rtpBytesSent: streamDesc("rtp_bytes_sent_total",
+			"Bytes of RTP payloads sent to every peer."),
+		peers: streamDesc("peers",
+			"Peers receiving the stream."),
+		stageDuration: streamDesc("pipeline_stage_duration_seconds",
+			"Time spent converting, encoding or packetizing each video frame.", "stage"),
+		stageWaiting: streamDesc("pipeline_stage_waiting_seconds_total",
+			"Time video frames spent waiting for each stage of encoding.", "stage"),
+		stageDropped: streamDesc("pipeline_stage_dropped_frames_total",
+			"Video frames dropped while waiting for each stage of pipelined encoding.", "stage"),
+		peerConnections: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "peer_connections"),
+			"Peer connections receiving at least one stream.",
+			nil, nil,
+		),
+		mediaProducers: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "media", "producers"),
+			"Producers reading from media sources.",
+			nil, nil,
+		),
+		mediaConsumers: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "media", "consumers"),
+			"Open streams of media sources.",
+			nil, nil,
+		),
+		mediaProduced: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "media", "produced_total"),
+			"Media read from media sources by producers.",
+			nil, nil,
+		),
+		driverRefs: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "driver", "refs"),
+			"Media sources referencing each driver.",
+			[]string{"driver"}, nil,
+		),
+	}
+}
+
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.frameRate,
+		c.videoFramesIn,
+		c.videoFramesOut,
+		c.videoFramesDropped,
+		c.audioChunksIn,
+		c.audioChunksOut,
+		c.rtpPacketsSent,
+		c.rtpBytesSent,
+		c.peers,
+		c.stageDuration,
+		c.stageWaiting,
+		c.stageDropped,
+		c.peerConnections,
+		c.mediaProducers,
+		c.mediaConsumers,
+		c.mediaProduced,
+		c.driverRefs,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.server.mu.RLock()
+	streams := make([]Stream, 0, len(c.server.streams))
+	for _, stream := range c.server.streams {
+		streams = append(streams, stream.stream)
+	}
+	// a peer receiving more than one track of a stream is only counted once
+	peers := map[string]int{}
+	for _, pcStreams := range c.server.activePeerStreams {
+		for name := range pcStreams {
+			peers[name]++
+		}
+	}
+	peerConnections := len(c.server.activePeerStreams)
+	c.server.mu.RUnlock()
+
+	for _, stream := range streams {
+		c.collectStream(ch, stream.Name(), stream.Stats(), peers[stream.Name()])
+	}
+	ch <- prometheus.MustNewConstMetric(c.peerConnections, prometheus.GaugeValue, float64(peerConnections))
+	ch <- prometheus.MustNewConstMetric(c.mediaProducers, prometheus.GaugeValue,
+		float64(atomic.LoadInt64(&activeMediaProducers)))
+	ch <- prometheus.MustNewConstMetric(c.mediaConsumers, prometheus.GaugeValue,
+		float64(atomic.LoadInt64(&activeMediaConsumers)))
+	ch <- prometheus.MustNewConstMetric(c.mediaProduced, prometheus.CounterValue,
+		float64(atomic.LoadUint64(&mediaProduced)))
+	for label, count := range driverRefs.refCounts() {
+		ch <- prometheus.MustNewConstMetric(c.driverRefs, prometheus.GaugeValue, float64(count), label)
+	}
+}
+
+func (c *metricsCollector) collectStream(ch chan<- prometheus.Metric, name string, stats StreamStats, peers int) {
+	counter := func(desc *prometheus.Desc, value uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), name)
+	}
+	ch <- prometheus.MustNewConstMetric(c.frameRate, prometheus.GaugeValue, stats.FrameRate, name)
+	counter(c.videoFramesIn, stats.VideoFramesIn)
+	counter(c.videoFramesOut, stats.VideoFramesOut)
+	counter(c.videoFramesDropped, stats.VideoFramesDropped)
+	counter(c.audioChunksIn, stats.AudioChunksIn)
+	counter(c.audioChunksOut, stats.AudioChunksOut)
+	counter(c.rtpPacketsSent, stats.PacketsSent)
+	counter(c.rtpBytesSent, stats.BytesSent)
+	ch <- prometheus.MustNewConstMetric(c.peers, prometheus.GaugeValue, float64(peers), name)
+
+	for _, stage := range []struct {
+		name  string
+		stats PipelineStageStats
+	}{
+		{"convert", stats.Pipeline.Convert},
+		{"encode", stats.Pipeline.Encode},
+		{"packetize", stats.Pipeline.Packetize},
+	} {
+		// prometheus buckets are cumulative
+		buckets := make(map[float64]uint64, len(PipelineBusyBuckets))
+		var cumulative uint64
+		for i, bound := range PipelineBusyBuckets {
+			if i < len(stage.stats.BusyHistogram) {
+				cumulative += stage.stats.BusyHistogram[i]
+			}
+			buckets[bound.Seconds()] = cumulative
+		}
+		ch <- prometheus.MustNewConstHistogram(
+			c.stageDuration,
+			stage.stats.Frames,
+			stage.stats.Busy.Seconds(),
+			buckets,
+			name, stage.name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.stageWaiting, prometheus.CounterValue, stage.stats.Waiting.Seconds(), name, stage.name)
+		ch <- prometheus.MustNewConstMetric(
+			c.stageDropped, prometheus.CounterValue, float64(stage.stats.Dropped), name, stage.name)
+	}
+}
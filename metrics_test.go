@@ -0,0 +1,89 @@
+package gostream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.viam.com/test"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	factory := newFakeVideoEncoderFactory(false)
+	stream, err := NewStream(StreamConfig{Name: "camera", VideoEncoderFactory: factory, TargetFrameRate: 1000})
+	test.That(t, err, test.ShouldBeNil)
+	bindTestPeer(t, stream)
+	stream.Start()
+	defer stream.Stop()
+	server, err := NewStreamServer(stream)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() {
+		test.That(t, server.Close(), test.ShouldBeNil)
+	}()
+
+	inputTestFrame(t, stream, factory)
+	deadline := time.Now().Add(5 * time.Second)
+	for stream.Stats().VideoFramesOut != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a frame to be written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a peer receiving none of the tracks of the stream yet is still counted
+	ss := server.(*streamServer)
+	ss.activePeerStreams[&webrtc.PeerConnection{}] = map[string]*peerState{
+		"camera": {id: "peer", stream: ss.streams[0]},
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newMetricsCollector(ss))
+	families, err := registry.Gather()
+	test.That(t, err, test.ShouldBeNil)
+	metrics := map[string][]*dto.Metric{}
+	for _, family := range families {
+		metrics[family.GetName()] = family.GetMetric()
+	}
+
+	framesOut := metrics["gostream_stream_video_frames_out_total"]
+	test.That(t, framesOut, test.ShouldHaveLength, 1)
+	test.That(t, framesOut[0].GetLabel()[0].GetValue(), test.ShouldEqual, "camera")
+	test.That(t, framesOut[0].GetCounter().GetValue(), test.ShouldEqual, 1)
+	test.That(t, metrics["gostream_stream_peers"][0].GetGauge().GetValue(), test.ShouldEqual, 1)
+	test.That(t, metrics["gostream_peer_connections"][0].GetGauge().GetValue(), test.ShouldEqual, 1)
+
+	// a histogram for each stage of encoding
+	durations := metrics["gostream_stream_pipeline_stage_duration_seconds"]
+	test.That(t, durations, test.ShouldHaveLength, 3)
+	for _, duration := range durations {
+		histogram := duration.GetHistogram()
+		test.That(t, histogram.GetSampleCount(), test.ShouldEqual, 1)
+		buckets := histogram.GetBucket()
+		test.That(t, buckets, test.ShouldHaveLength, len(PipelineBusyBuckets))
+		test.That(t, buckets[len(buckets)-1].GetCumulativeCount(), test.ShouldBeLessThanOrEqualTo, 1)
+	}
+}
+
+func TestStandaloneStreamServerMetrics(t *testing.T) {
+	stream, err := NewStream(StreamConfig{Name: "camera", VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+	test.That(t, err, test.ShouldBeNil)
+	server, err := NewStandaloneStreamServer(0, golog.NewTestLogger(t), nil, stream)
+	test.That(t, err, test.ShouldBeNil)
+
+	recorder := httptest.NewRecorder()
+	server.(*standaloneStreamServer).metricsHandler().ServeHTTP(
+		recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	test.That(t, recorder.Code, test.ShouldEqual, http.StatusOK)
+	body, err := io.ReadAll(recorder.Body)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(body), test.ShouldContainSubstring, `gostream_stream_frame_rate{stream="camera"} 0`)
+	test.That(t, string(body), test.ShouldContainSubstring, "go_goroutines")
+}
@@ -0,0 +1,227 @@
+package gostream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edaniels/golog"
+	"go.viam.com/utils"
+	"goji.io/pat"
+)
+
+// mjpegBoundary separates the JPEG images of an MJPEG stream.
+const mjpegBoundary = "gostreamframe"
+
+// A videoFrameReader is a stream whose video frames can be read as they are taken.
+type videoFrameReader interface {
+	// videoFrames returns a stream of the video frames of the stream, which must not be
+	// modified.
+	videoFrames(ctx context.Context) (VideoStream, error)
+
+	// videoFrameInterval is how long the stream waits between taking video frames to encode.
+	videoFrameInterval() time.Duration
+}
+
+func (bs *basicStream) videoFrameInterval() time.Duration {
+	return time.Second / time.Duration(bs.config.TargetFrameRate)
+}
+
+// An mjpegBroadcaster encodes the video frames of a stream as JPEG images once for all of the
+// viewers of its MJPEG stream, at most as often as the stream takes frames to encode. Frames are
+// read from the video source of the stream, sharing its reads with the stream, so viewers do not
+// start the stream and video is not encoded for WebRTC unless there are peers.
+type mjpegBroadcaster struct {
+	mu      sync.Mutex
+	frames  videoFrameReader
+	viewers map[chan []byte]struct{}
+	logger  golog.Logger
+
+	// latest is the image last sent while there are viewers, which new viewers start with.
+	latest []byte
+
+	// stopBroadcast stops sending images while there are viewers.
+	stopBroadcast func()
+}
+
+func newMJPEGBroadcaster(frames videoFrameReader, logger golog.Logger) *mjpegBroadcaster {
+	return &mjpegBroadcaster{
+		frames:  frames,
+		viewers: map[chan []byte]struct{}{},
+		logger:  logger,
+	}
+}
+
+// subscribe adds a viewer, which receives the latest JPEG image each time a frame is read.
+// Images are dropped while the viewer has not received the one before. The returned function
+// removes the viewer.
+func (b *mjpegBroadcaster) subscribe() (<-chan []byte, func()) {
+	images := make(chan []byte, 1)
+
+	b.mu.Lock()
+	b.viewers[images] = struct{}{}
+	if b.latest != nil {
+		images <- b.latest
+	}
+	if len(b.viewers) == 1 {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		utils.PanicCapturingGo(func() {
+			defer close(done)
+			b.broadcast(ctx)
+		})
+		b.stopBroadcast = func() {
+			cancel()
+			<-done
+		}
+	}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return images, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.viewers, images)
+			var stopBroadcast func()
+			if len(b.viewers) == 0 {
+				stopBroadcast = b.stopBroadcast
+				b.stopBroadcast = nil
+				b.latest = nil
+			}
+			b.mu.Unlock()
+			// sending takes the lock, so the broadcast is waited on without it
+			if stopBroadcast != nil {
+				stopBroadcast()
+			}
+		})
+	}
+}
+
+// broadcast sends the video frames of the stream to the viewers until the context is done.
+func (b *mjpegBroadcaster) broadcast(ctx context.Context) {
+	frames, err := b.frames.videoFrames(ctx)
+	if err != nil {
+		b.logger.Errorw("error reading mjpeg frames", "error", err)
+		return
+	}
+	defer closeWhenDone(ctx, frames)()
+	// sources produce frames as quickly as they can, so they are read at the rate the stream
+	// takes them at
+	ticker := time.NewTicker(b.frames.videoFrameInterval())
+	defer ticker.Stop()
+	for {
+		img, release, err := frames.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.logger.Debugw("error getting mjpeg frame", "error", err)
+			continue
+		}
+		data, _, err := encodeSnapshot(img, SnapshotMIMETypeJPEG, 0, 0)
+		if release != nil {
+			release()
+		}
+		if err != nil {
+			b.logger.Errorw("error encoding mjpeg frame", "error", err)
+			continue
+		}
+		b.send(data)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// send gives the image to each viewer, in place of any image it has yet to receive.
+func (b *mjpegBroadcaster) send(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// a broadcast being stopped has no viewers left to send to
+	if len(b.viewers) == 0 {
+		return
+	}
+	b.latest = data
+	for images := range b.viewers {
+		select {
+		case <-images:
+		default:
+		}
+		// images are only sent with the lock held, so there is now room
+		images <- data
+	}
+}
+
+// mjpegBroadcaster returns the broadcaster for the given stream, creating it on first use.
+func (ss *standaloneStreamServer) mjpegBroadcaster(name string, frames videoFrameReader) *mjpegBroadcaster {
+	ss.mjpegMu.Lock()
+	defer ss.mjpegMu.Unlock()
+	b, ok := ss.mjpegBroadcasters[name]
+	if !ok {
+		b = newMJPEGBroadcaster(frames, ss.logger)
+		ss.mjpegBroadcasters[name] = b
+	}
+	return b
+}
+
+// mjpegHandler serves the video of the stream named in the path as an MJPEG stream, that is
+// a multipart/x-mixed-replace response of JPEG images, until the client goes away or the
+// given context is done.
+func (ss *standaloneStreamServer) mjpegHandler(ctx context.Context) http.Handler {
+	streamServer := ss.streamServer.(*streamServer)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := pat.Param(r, "stream")
+		stream, ok := streamServer.namedStream(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no stream for %q", name), http.StatusNotFound)
+			return
+		}
+		frames, ok := stream.(videoFrameReader)
+		if !ok {
+			http.Error(w, fmt.Sprintf("stream %q cannot be served as mjpeg", name), http.StatusNotImplemented)
+			return
+		}
+		images, unsubscribe := ss.mjpegBroadcaster(name, frames).subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+		w.Header().Set("Cache-Control", "no-store")
+		// each image is followed by the boundary so that clients can show it before the
+		// next one arrives
+		if _, err := fmt.Fprintf(w, "--%s\r\n", mjpegBoundary); err != nil {
+			return
+		}
+		flusher, _ := w.(http.Flusher)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		for {
+			var data []byte
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Context().Done():
+				return
+			case data = <-images:
+			}
+			_, err := fmt.Fprintf(w, "Content-Type: %s\r\nContent-Length: %d\r\n\r\n", SnapshotMIMETypeJPEG, len(data))
+			if err == nil {
+				_, err = w.Write(data)
+			}
+			if err == nil {
+				_, err = fmt.Fprintf(w, "\r\n--%s\r\n", mjpegBoundary)
+			}
+			if err != nil {
+				ss.logger.Debugw("error writing mjpeg frame", "error", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package gostream
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/prop"
+	"go.viam.com/test"
+	"goji.io"
+	"goji.io/pat"
+)
+
+// newMJPEGTestStream returns a stream along with where to send the frames of the video
+// source it reads from.
+func newMJPEGTestStream(t *testing.T) (Stream, chan<- image.Image) {
+	t.Helper()
+	factory := newFakeVideoEncoderFactory(false)
+	stream, err := NewStream(StreamConfig{Name: "camera", VideoEncoderFactory: factory, TargetFrameRate: 1000})
+	test.That(t, err, test.ShouldBeNil)
+	// the source is only read while its frames are wanted
+	frames := make(chan image.Image)
+	source := NewVideoSource(VideoReaderFunc(func(ctx context.Context) (image.Image, func(), error) {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case img := <-frames:
+			return img, func() {}, nil
+		}
+	}), prop.Video{})
+	t.Cleanup(func() {
+		test.That(t, source.Close(context.Background()), test.ShouldBeNil)
+	})
+	stream.(*basicStream).attachVideoSource(context.Background(), source)
+	return stream, frames
+}
+
+// streamStarted returns whether the stream is started.
+func streamStarted(stream Stream) bool {
+	bs := stream.(*basicStream)
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return bs.started
+}
+
+func TestMJPEGBroadcaster(t *testing.T) {
+	stream, input := newMJPEGTestStream(t)
+	broadcaster := newMJPEGBroadcaster(stream.(videoFrameReader), golog.NewTestLogger(t))
+
+	// viewers read from the source without starting the stream
+	first, unsubscribeFirst := broadcaster.subscribe()
+	second, unsubscribeSecond := broadcaster.subscribe()
+
+	input <- testSnapshotFrame(40, 20)
+	firstData := <-first
+	secondData := <-second
+	// each frame is only encoded once
+	test.That(t, &secondData[0], test.ShouldEqual, &firstData[0])
+	decoded, err := jpeg.Decode(bytes.NewReader(firstData))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds().Size(), test.ShouldResemble, image.Pt(40, 20))
+
+	// a viewer that falls behind only gets the latest frame
+	input <- testSnapshotFrame(40, 20)
+	<-first
+	input <- testSnapshotFrame(20, 10)
+	<-first
+	decoded, err = jpeg.Decode(bytes.NewReader(<-second))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds().Size(), test.ShouldResemble, image.Pt(20, 10))
+	select {
+	case <-second:
+		t.Fatal("expected no more frames")
+	default:
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	unsubscribeSecond()
+	test.That(t, streamStarted(stream), test.ShouldBeFalse)
+
+	// a new viewer starts with the next frame of the source
+	third, unsubscribeThird := broadcaster.subscribe()
+	defer unsubscribeThird()
+	input <- testSnapshotFrame(20, 10)
+	decoded, err = jpeg.Decode(bytes.NewReader(<-third))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds().Size(), test.ShouldResemble, image.Pt(20, 10))
+}
+
+func TestStandaloneStreamServerMJPEG(t *testing.T) {
+	stream, input := newMJPEGTestStream(t)
+	server, err := NewStandaloneStreamServer(0, golog.NewTestLogger(t), nil, stream)
+	test.That(t, err, test.ShouldBeNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mux := goji.NewMux()
+	mux.Handle(pat.Get("/mjpeg/:stream"), server.(*standaloneStreamServer).mjpegHandler(ctx))
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/mjpeg/unknown")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp.Body.Close(), test.ShouldBeNil)
+	test.That(t, resp.StatusCode, test.ShouldEqual, http.StatusNotFound)
+
+	get := func() (*http.Response, *multipart.Reader) {
+		resp, err := http.Get(httpServer.URL + "/mjpeg/camera")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp.StatusCode, test.ShouldEqual, http.StatusOK)
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, mediaType, test.ShouldEqual, "multipart/x-mixed-replace")
+		return resp, multipart.NewReader(resp.Body, params["boundary"])
+	}
+	readFrame := func(reader *multipart.Reader) image.Image {
+		part, err := reader.NextPart()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, part.Header.Get("Content-Type"), test.ShouldEqual, SnapshotMIMETypeJPEG)
+		img, err := jpeg.Decode(part)
+		test.That(t, err, test.ShouldBeNil)
+		return img
+	}
+	broadcasting := func() bool {
+		broadcaster := server.(*standaloneStreamServer).mjpegBroadcaster("camera", nil)
+		broadcaster.mu.Lock()
+		defer broadcaster.mu.Unlock()
+		return broadcaster.stopBroadcast != nil
+	}
+
+	first, firstReader := get()
+	input <- testSnapshotFrame(40, 20)
+	test.That(t, readFrame(firstReader).Bounds().Size(), test.ShouldResemble, image.Pt(40, 20))
+	second, secondReader := get()
+	test.That(t, readFrame(secondReader).Bounds().Size(), test.ShouldResemble, image.Pt(40, 20))
+	input <- testSnapshotFrame(20, 10)
+	test.That(t, readFrame(firstReader).Bounds().Size(), test.ShouldResemble, image.Pt(20, 10))
+	test.That(t, readFrame(secondReader).Bounds().Size(), test.ShouldResemble, image.Pt(20, 10))
+
+	test.That(t, streamStarted(stream), test.ShouldBeFalse)
+
+	// the source is read until the viewers go away
+	test.That(t, first.Body.Close(), test.ShouldBeNil)
+	test.That(t, broadcasting(), test.ShouldBeTrue)
+	// or the server shuts down
+	cancel()
+	_, err = secondReader.NextPart()
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, second.Body.Close(), test.ShouldBeNil)
+	for i := 0; broadcasting(); i++ {
+		test.That(t, i, test.ShouldBeLessThan, 5000)
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,534 @@
+package gostream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// mp4VideoTimescale and mp4AudioTimescale are the units per second of the times of
+	// video and audio samples, which match their RTP clock rates.
+	mp4VideoTimescale = 90000
+	mp4AudioTimescale = 48000
+
+	// fmp4MaxAudioFragmentDuration is how much audio is kept before it is written as a
+	// fragment when there is no video to start fragments at key frames.
+	fmp4MaxAudioFragmentDuration = time.Second
+
+	// mp4DefaultVideoSampleDuration and mp4DefaultAudioSampleDuration are the durations, in
+	// their timescales, of the last sample of a track if it is the only one.
+	mp4DefaultVideoSampleDuration = mp4VideoTimescale / 30
+	mp4DefaultAudioSampleDuration = mp4AudioTimescale / 50
+)
+
+// The flags of samples in a track run. Key frames and audio depend on no other sample.
+const (
+	mp4SampleFlagsSync    = 0x02000000
+	mp4SampleFlagsNonSync = 0x01010000
+)
+
+// fmp4CanMux returns whether fragmented MP4 files written by an fmp4Writer can hold the
+// codec of the given MIME type.
+func fmp4CanMux(mimeType string) bool {
+	return strings.EqualFold(mimeType, webrtc.MimeTypeH264) || strings.EqualFold(mimeType, webrtc.MimeTypeOpus)
+}
+
+// An fmp4Sample is a frame of a track waiting to be written in a fragment. Its time and
+// duration are in the timescale of the track.
+type fmp4Sample struct {
+	data     []byte
+	time     int64
+	duration uint32
+	keyFrame bool
+}
+
+// An fmp4Writer muxes frames of already encoded H.264 and Opus into a fragmented MP4 file.
+// The initialization segment, which describes the tracks, is written up front. Frames are
+// then kept in memory until a video key frame starts the next fragment or, without video,
+// until enough audio has been kept. Each fragment is written with one call to Write so
+// that a writer can serve fragments as media segments, as HLS does.
+type fmp4Writer struct {
+	w        io.Writer
+	tracks   []muxedTrack
+	hasVideo bool
+
+	// written is how much has been written to w and buffered is the size of the samples
+	// waiting to be.
+	written  int64
+	buffered int64
+	sequence uint32
+
+	// samples are waiting to be written for each track. The duration of the last sample of
+	// a track is only known once the next one is written, so it waits for the next fragment
+	// unless it was just given a duration. ends are the times that the last written sample
+	// of each track ends at.
+	samples [][]fmp4Sample
+	ends    []int64
+}
+
+// newFMP4Writer writes the initialization segment of a fragmented MP4 file with the given
+// tracks, numbered from one in order, and returns a writer of their frames. H.264 tracks
+// must carry their parameter sets.
+func newFMP4Writer(w io.Writer, tracks []muxedTrack) (*fmp4Writer, error) {
+	if len(tracks) == 0 {
+		return nil, errors.New("an MP4 file needs at least one track")
+	}
+	fw := &fmp4Writer{
+		w:       w,
+		tracks:  tracks,
+		samples: make([][]fmp4Sample, len(tracks)),
+		ends:    make([]int64, len(tracks)),
+	}
+	for _, track := range tracks {
+		if !fmp4CanMux(track.mimeType) {
+			return nil, errors.New("fragmented MP4 cannot hold " + track.mimeType)
+		}
+		if track.isVideo() {
+			if track.sps == nil || track.pps == nil {
+				return nil, errors.New("H.264 track needs an SPS and PPS")
+			}
+			fw.hasVideo = true
+		}
+	}
+	if err := fw.write(fw.initSegment()); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+func (fw *fmp4Writer) write(data []byte) error {
+	n, err := fw.w.Write(data)
+	fw.written += int64(n)
+	return err
+}
+
+// size returns how many bytes of the file have been written or are waiting to be.
+func (fw *fmp4Writer) size() int64 {
+	return fw.written + fw.buffered
+}
+
+// timescale returns the units per second of the times of the given track.
+func (fw *fmp4Writer) timescale(track int) uint32 {
+	if fw.tracks[track].isVideo() {
+		return mp4VideoTimescale
+	}
+	return mp4AudioTimescale
+}
+
+// writeFrame writes a frame of the given track (indexed from zero), captured the given
+// time after the start of the file. H.264 frames are access units in Annex B format.
+func (fw *fmp4Writer) writeFrame(track int, data []byte, keyFrame bool, elapsed time.Duration) error {
+	if track < 0 || track >= len(fw.tracks) {
+		return errors.New("no such MP4 track")
+	}
+	isVideo := fw.tracks[track].isVideo()
+	if isVideo {
+		data = annexBToAVCC(data)
+	} else {
+		// every Opus packet can be decoded on its own
+		keyFrame = true
+	}
+
+	sampleTime := int64(math.Round(elapsed.Seconds() * float64(fw.timescale(track))))
+	samples := fw.samples[track]
+	if n := len(samples); n != 0 {
+		if sampleTime < samples[n-1].time {
+			sampleTime = samples[n-1].time
+		}
+		samples[n-1].duration = uint32(sampleTime - samples[n-1].time)
+	} else if sampleTime < fw.ends[track] {
+		sampleTime = fw.ends[track]
+	}
+
+	var startFragment bool
+	if isVideo {
+		startFragment = keyFrame
+	} else if !fw.hasVideo && len(samples) != 0 {
+		startFragment = time.Duration(sampleTime-samples[0].time)*time.Second/mp4AudioTimescale >=
+			fmp4MaxAudioFragmentDuration
+	}
+	if startFragment {
+		if err := fw.flushFragment(track); err != nil {
+			return err
+		}
+	}
+
+	fw.samples[track] = append(fw.samples[track], fmp4Sample{data: data, time: sampleTime, keyFrame: keyFrame})
+	fw.buffered += int64(len(data))
+	return nil
+}
+
+// annexBToAVCC converts an H.264 access unit in Annex B format to one whose NAL units
+// are prefixed with their length, leaving out the parameter sets, which are in the
+// initialization segment, and access unit delimiters.
+func annexBToAVCC(data []byte) []byte {
+	avcc := make([]byte, 0, len(data)+4)
+	for _, nal := range splitAnnexB(data) {
+		if len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & 0x1F {
+		case h264NALTypeSPS, h264NALTypePPS, h264NALTypeAUD:
+			continue
+		}
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(nal)))
+		avcc = append(avcc, length[:]...)
+		avcc = append(avcc, nal...)
+	}
+	return avcc
+}
+
+// flushFragment writes the samples waiting to be written as a fragment, except for the
+// last sample of each track other than the given one, whose durations are not known yet.
+// If track is negative, every sample is written and the last of each track lasts as long
+// as the one before it.
+func (fw *fmp4Writer) flushFragment(track int) error {
+	fragmentSamples := make([][]fmp4Sample, len(fw.tracks))
+	empty := true
+	for i, samples := range fw.samples {
+		n := len(samples)
+		if n == 0 {
+			continue
+		}
+		if track < 0 {
+			switch {
+			case n > 1:
+				samples[n-1].duration = samples[n-2].duration
+			case fw.tracks[i].isVideo():
+				samples[n-1].duration = mp4DefaultVideoSampleDuration
+			default:
+				samples[n-1].duration = mp4DefaultAudioSampleDuration
+			}
+		} else if i != track {
+			n--
+		}
+		if n == 0 {
+			continue
+		}
+		fragmentSamples[i] = samples[:n]
+		fw.samples[i] = append([]fmp4Sample(nil), samples[n:]...)
+		last := samples[n-1]
+		fw.ends[i] = last.time + int64(last.duration)
+		empty = false
+	}
+	if empty {
+		return nil
+	}
+
+	fw.sequence++
+	var fragment mp4Buffer
+	var dataOffsetsAt []int
+	fragment.box("moof", func(b *mp4Buffer) {
+		b.fullBox("mfhd", 0, 0, func(b *mp4Buffer) {
+			b.u32(fw.sequence)
+		})
+		for i, samples := range fragmentSamples {
+			if len(samples) == 0 {
+				continue
+			}
+			// the data of each track run is found from the start of the moof
+			b.box("traf", func(b *mp4Buffer) {
+				b.fullBox("tfhd", 0, 0x020000, func(b *mp4Buffer) {
+					b.u32(uint32(i + 1))
+				})
+				b.fullBox("tfdt", 1, 0, func(b *mp4Buffer) {
+					b.u64(uint64(samples[0].time))
+				})
+				// sample durations, sizes and flags follow the data offset
+				b.fullBox("trun", 0, 0x000701, func(b *mp4Buffer) {
+					b.u32(uint32(len(samples)))
+					dataOffsetsAt = append(dataOffsetsAt, b.Len())
+					b.u32(0)
+					for _, sample := range samples {
+						b.u32(sample.duration)
+						b.u32(uint32(len(sample.data)))
+						if sample.keyFrame {
+							b.u32(mp4SampleFlagsSync)
+						} else {
+							b.u32(mp4SampleFlagsNonSync)
+						}
+					}
+				})
+			})
+		}
+	})
+
+	// the data of the tracks follows the header of the mdat in order
+	dataOffset := fragment.Len() + 8
+	var dataSize int
+	trackRun := 0
+	for _, samples := range fragmentSamples {
+		if len(samples) == 0 {
+			continue
+		}
+		binary.BigEndian.PutUint32(fragment.Bytes()[dataOffsetsAt[trackRun]:], uint32(dataOffset+dataSize))
+		trackRun++
+		for _, sample := range samples {
+			dataSize += len(sample.data)
+		}
+	}
+	fragment.u32(uint32(8 + dataSize))
+	fragment.WriteString("mdat")
+	for _, samples := range fragmentSamples {
+		for _, sample := range samples {
+			fragment.Write(sample.data)
+		}
+	}
+	fw.buffered -= int64(dataSize)
+	return fw.write(fragment.Bytes())
+}
+
+// close writes the frames that are still waiting to be written. The writer it writes to
+// is left open.
+func (fw *fmp4Writer) close() error {
+	return fw.flushFragment(-1)
+}
+
+// mp4IdentityMatrix is the transformation matrix of movies and tracks that are shown as is.
+var mp4IdentityMatrix = [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// initSegment returns the file type box and the movie box that describes the tracks.
+func (fw *fmp4Writer) initSegment() []byte {
+	var b mp4Buffer
+	b.box("ftyp", func(b *mp4Buffer) {
+		b.WriteString("iso5")
+		b.u32(512)
+		b.WriteString("iso5iso6mp41")
+	})
+	b.box("moov", func(b *mp4Buffer) {
+		b.fullBox("mvhd", 0, 0, func(b *mp4Buffer) {
+			// creation and modification times, timescale and duration
+			b.u32(0)
+			b.u32(0)
+			b.u32(1000)
+			b.u32(0)
+			// rate, volume and reserved
+			b.u32(0x00010000)
+			b.u16(0x0100)
+			b.Write(make([]byte, 10))
+			for _, value := range mp4IdentityMatrix {
+				b.u32(value)
+			}
+			b.Write(make([]byte, 24))
+			b.u32(uint32(len(fw.tracks) + 1))
+		})
+		for i := range fw.tracks {
+			fw.trackBox(b, i)
+		}
+		b.box("mvex", func(b *mp4Buffer) {
+			for i := range fw.tracks {
+				b.fullBox("trex", 0, 0, func(b *mp4Buffer) {
+					b.u32(uint32(i + 1))
+					// sample description index, then the default duration, size and flags
+					// of samples, which every track run sets
+					b.u32(1)
+					b.u32(0)
+					b.u32(0)
+					b.u32(0)
+				})
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// trackBox writes the box describing the given track, which has no samples of its own
+// since they are all in fragments.
+func (fw *fmp4Writer) trackBox(b *mp4Buffer, track int) {
+	t := fw.tracks[track]
+	b.box("trak", func(b *mp4Buffer) {
+		// the track is enabled and in the movie
+		b.fullBox("tkhd", 0, 3, func(b *mp4Buffer) {
+			// creation and modification times, track ID, reserved and duration
+			b.u32(0)
+			b.u32(0)
+			b.u32(uint32(track + 1))
+			b.u32(0)
+			b.u32(0)
+			// reserved, layer and alternate group
+			b.Write(make([]byte, 12))
+			if t.isVideo() {
+				b.u16(0)
+			} else {
+				b.u16(0x0100)
+			}
+			b.u16(0)
+			for _, value := range mp4IdentityMatrix {
+				b.u32(value)
+			}
+			b.u32(uint32(t.width) << 16)
+			b.u32(uint32(t.height) << 16)
+		})
+		b.box("mdia", func(b *mp4Buffer) {
+			b.fullBox("mdhd", 0, 0, func(b *mp4Buffer) {
+				b.u32(0)
+				b.u32(0)
+				b.u32(fw.timescale(track))
+				b.u32(0)
+				// "und" as a packed ISO-639-2 language code
+				b.u16(0x55C4)
+				b.u16(0)
+			})
+			b.fullBox("hdlr", 0, 0, func(b *mp4Buffer) {
+				b.u32(0)
+				if t.isVideo() {
+					b.WriteString("vide")
+				} else {
+					b.WriteString("soun")
+				}
+				b.Write(make([]byte, 12))
+				b.WriteString("gostream\x00")
+			})
+			b.box("minf", func(b *mp4Buffer) {
+				if t.isVideo() {
+					b.fullBox("vmhd", 0, 1, func(b *mp4Buffer) {
+						b.Write(make([]byte, 8))
+					})
+				} else {
+					b.fullBox("smhd", 0, 0, func(b *mp4Buffer) {
+						b.Write(make([]byte, 4))
+					})
+				}
+				b.box("dinf", func(b *mp4Buffer) {
+					b.fullBox("dref", 0, 0, func(b *mp4Buffer) {
+						b.u32(1)
+						// the media is in the same file
+						b.fullBox("url ", 0, 1, func(b *mp4Buffer) {})
+					})
+				})
+				b.box("stbl", func(b *mp4Buffer) {
+					b.fullBox("stsd", 0, 0, func(b *mp4Buffer) {
+						b.u32(1)
+						if t.isVideo() {
+							avc1SampleEntry(b, t)
+						} else {
+							opusSampleEntry(b, t)
+						}
+					})
+					for _, typ := range []string{"stts", "stsc", "stco"} {
+						b.fullBox(typ, 0, 0, func(b *mp4Buffer) {
+							b.u32(0)
+						})
+					}
+					b.fullBox("stsz", 0, 0, func(b *mp4Buffer) {
+						b.u32(0)
+						b.u32(0)
+					})
+				})
+			})
+		})
+	})
+}
+
+// avc1SampleEntry writes the description of an H.264 track.
+// See section 5.4.2.1 of ISO/IEC 14496-15.
+func avc1SampleEntry(b *mp4Buffer, t muxedTrack) {
+	b.box("avc1", func(b *mp4Buffer) {
+		// reserved and data reference index
+		b.Write(make([]byte, 6))
+		b.u16(1)
+		b.Write(make([]byte, 16))
+		b.u16(uint16(t.width))
+		b.u16(uint16(t.height))
+		// 72 dpi horizontally and vertically
+		b.u32(0x00480000)
+		b.u32(0x00480000)
+		b.u32(0)
+		// frame count, compressor name, depth and pre-defined
+		b.u16(1)
+		b.Write(make([]byte, 32))
+		b.u16(0x0018)
+		b.u16(0xFFFF)
+		b.box("avcC", func(b *mp4Buffer) {
+			// version, then the profile, its compatibility and the level from the SPS
+			b.u8(1)
+			b.Write(t.sps[1:4])
+			// NAL units are prefixed with four byte lengths
+			b.u8(0xFF)
+			b.u8(0xE1)
+			b.u16(uint16(len(t.sps)))
+			b.Write(t.sps)
+			b.u8(1)
+			b.u16(uint16(len(t.pps)))
+			b.Write(t.pps)
+		})
+	})
+}
+
+// opusSampleEntry writes the description of an Opus track.
+// See https://opus-codec.org/docs/opus_in_isobmff.html.
+func opusSampleEntry(b *mp4Buffer, t muxedTrack) {
+	b.box("Opus", func(b *mp4Buffer) {
+		// reserved and data reference index
+		b.Write(make([]byte, 6))
+		b.u16(1)
+		b.Write(make([]byte, 8))
+		b.u16(uint16(t.channels))
+		// sample size, pre-defined, reserved and sample rate
+		b.u16(16)
+		b.u32(0)
+		b.u32(mp4AudioTimescale << 16)
+		b.box("dOps", func(b *mp4Buffer) {
+			// version, channels, pre-skip, input sample rate, output gain and channel
+			// mapping family
+			b.u8(0)
+			b.u8(uint8(t.channels))
+			b.u16(0)
+			b.u32(mp4AudioTimescale)
+			b.u16(0)
+			b.u8(0)
+		})
+	})
+}
+
+// An mp4Buffer builds ISO base media file format boxes.
+type mp4Buffer struct {
+	bytes.Buffer
+}
+
+func (b *mp4Buffer) u8(value uint8) {
+	b.WriteByte(value)
+}
+
+func (b *mp4Buffer) u16(value uint16) {
+	var data [2]byte
+	binary.BigEndian.PutUint16(data[:], value)
+	b.Write(data[:])
+}
+
+func (b *mp4Buffer) u32(value uint32) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], value)
+	b.Write(data[:])
+}
+
+func (b *mp4Buffer) u64(value uint64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], value)
+	b.Write(data[:])
+}
+
+// box writes a box of the given type whose contents are written by children.
+func (b *mp4Buffer) box(typ string, children func(b *mp4Buffer)) {
+	start := b.Len()
+	b.u32(0)
+	b.WriteString(typ)
+	children(b)
+	binary.BigEndian.PutUint32(b.Bytes()[start:], uint32(b.Len()-start))
+}
+
+// fullBox writes a box with a version and flags.
+func (b *mp4Buffer) fullBox(typ string, version uint8, flags uint32, children func(b *mp4Buffer)) {
+	b.box(typ, func(b *mp4Buffer) {
+		b.u32(uint32(version)<<24 | flags)
+		children(b)
+	})
+}
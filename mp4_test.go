@@ -0,0 +1,289 @@
+package gostream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+// mp4Box is a box read back from an MP4 file. data is its content after its header
+// and offset is where it starts in the data it was read from.
+type mp4Box struct {
+	typ    string
+	data   []byte
+	offset int
+}
+
+// readMP4Boxes reads the boxes at the top level of the given data.
+func readMP4Boxes(t *testing.T, data []byte) []mp4Box {
+	t.Helper()
+	var boxes []mp4Box
+	offset := 0
+	for offset < len(data) {
+		test.That(t, len(data)-offset, test.ShouldBeGreaterThanOrEqualTo, 8)
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		test.That(t, size, test.ShouldBeGreaterThanOrEqualTo, 8)
+		test.That(t, offset+size, test.ShouldBeLessThanOrEqualTo, len(data))
+		boxes = append(boxes, mp4Box{
+			typ:    string(data[offset+4 : offset+8]),
+			data:   data[offset+8 : offset+size],
+			offset: offset,
+		})
+		offset += size
+	}
+	return boxes
+}
+
+// mp4Types returns the types of the given boxes in order.
+func mp4Types(boxes []mp4Box) []string {
+	types := make([]string, 0, len(boxes))
+	for _, box := range boxes {
+		types = append(types, box.typ)
+	}
+	return types
+}
+
+// findMP4Box returns the first box of the given type among the given boxes.
+func findMP4Box(t *testing.T, boxes []mp4Box, typ string) mp4Box {
+	t.Helper()
+	for _, box := range boxes {
+		if box.typ == typ {
+			return box
+		}
+	}
+	t.Fatalf("no %q box in %v", typ, mp4Types(boxes))
+	return mp4Box{}
+}
+
+// mp4Path reads the boxes along the given path of types, where the content of full boxes
+// is given after their version and flags.
+func mp4Path(t *testing.T, data []byte, path ...string) mp4Box {
+	t.Helper()
+	var box mp4Box
+	for _, typ := range path {
+		box = findMP4Box(t, readMP4Boxes(t, data), typ)
+		data = box.data
+	}
+	return box
+}
+
+// mp4TrackRun is a track run read back from a movie fragment.
+type mp4TrackRun struct {
+	trackID    uint32
+	baseTime   uint64
+	dataOffset int
+	durations  []uint32
+	sizes      []uint32
+	flags      []uint32
+}
+
+// readMP4Fragment reads the track runs of a movie fragment.
+func readMP4Fragment(t *testing.T, moof mp4Box) (uint32, []mp4TrackRun) {
+	t.Helper()
+	boxes := readMP4Boxes(t, moof.data)
+	sequence := binary.BigEndian.Uint32(findMP4Box(t, boxes, "mfhd").data[4:])
+	var runs []mp4TrackRun
+	for _, traf := range boxes[1:] {
+		test.That(t, traf.typ, test.ShouldEqual, "traf")
+		trafBoxes := readMP4Boxes(t, traf.data)
+		test.That(t, mp4Types(trafBoxes), test.ShouldResemble, []string{"tfhd", "tfdt", "trun"})
+		tfhd, tfdt, trun := trafBoxes[0].data, trafBoxes[1].data, trafBoxes[2].data
+		// default-base-is-moof and a 64-bit base time
+		test.That(t, binary.BigEndian.Uint32(tfhd), test.ShouldEqual, 0x020000)
+		test.That(t, tfdt[0], test.ShouldEqual, 1)
+		run := mp4TrackRun{
+			trackID:    binary.BigEndian.Uint32(tfhd[4:]),
+			baseTime:   binary.BigEndian.Uint64(tfdt[4:]),
+			dataOffset: int(binary.BigEndian.Uint32(trun[8:])),
+		}
+		test.That(t, binary.BigEndian.Uint32(trun), test.ShouldEqual, 0x000701)
+		count := int(binary.BigEndian.Uint32(trun[4:]))
+		test.That(t, trun[12:], test.ShouldHaveLength, count*12)
+		for i := 0; i < count; i++ {
+			sample := trun[12+i*12:]
+			run.durations = append(run.durations, binary.BigEndian.Uint32(sample))
+			run.sizes = append(run.sizes, binary.BigEndian.Uint32(sample[4:]))
+			run.flags = append(run.flags, binary.BigEndian.Uint32(sample[8:]))
+		}
+		runs = append(runs, run)
+	}
+	return sequence, runs
+}
+
+func TestFMP4Writer(t *testing.T) {
+	var file bytes.Buffer
+	_, err := newFMP4Writer(&file, nil)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = newFMP4Writer(&file, []muxedTrack{{mimeType: webrtc.MimeTypeVP8}})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = newFMP4Writer(&file, []muxedTrack{{mimeType: webrtc.MimeTypeH264}})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	videoTrack, _, _ := describeVideoKeyFrame(webrtc.MimeTypeH264, testH264KeyFrame())
+	fw, err := newFMP4Writer(&file, []muxedTrack{
+		videoTrack,
+		{mimeType: webrtc.MimeTypeOpus, channels: 2},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	initSize := file.Len()
+	test.That(t, fw.size(), test.ShouldEqual, initSize)
+
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	test.That(t, fw.writeFrame(0, testH264KeyFrame(), true, 0), test.ShouldBeNil)
+	test.That(t, fw.writeFrame(1, []byte{1}, true, ms(0)), test.ShouldBeNil)
+	test.That(t, fw.writeFrame(1, []byte{2}, true, ms(20)), test.ShouldBeNil)
+	test.That(t, fw.writeFrame(0, testH264Frame, false, ms(100)), test.ShouldBeNil)
+	test.That(t, fw.writeFrame(1, []byte{3}, true, ms(40)), test.ShouldBeNil)
+	// nothing is written until the next key frame
+	test.That(t, file.Len(), test.ShouldEqual, initSize)
+	test.That(t, fw.size(), test.ShouldBeGreaterThan, initSize)
+	test.That(t, fw.writeFrame(0, testH264KeyFrame(), true, ms(200)), test.ShouldBeNil)
+	test.That(t, fw.writeFrame(1, []byte{4}, true, ms(60)), test.ShouldBeNil)
+	test.That(t, fw.writeFrame(2, []byte{4}, true, ms(60)), test.ShouldNotBeNil)
+	test.That(t, fw.close(), test.ShouldBeNil)
+
+	data := file.Bytes()
+	boxes := readMP4Boxes(t, data)
+	test.That(t, mp4Types(boxes), test.ShouldResemble, []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"})
+	test.That(t, string(boxes[0].data[:4]), test.ShouldEqual, "iso5")
+
+	moov := readMP4Boxes(t, boxes[1].data)
+	test.That(t, mp4Types(moov), test.ShouldResemble, []string{"mvhd", "trak", "trak", "mvex"})
+	tkhd := mp4Path(t, moov[1].data, "tkhd").data
+	test.That(t, binary.BigEndian.Uint32(tkhd[12:]), test.ShouldEqual, 1)
+	test.That(t, binary.BigEndian.Uint32(tkhd[76:]), test.ShouldEqual, 64<<16)
+	test.That(t, binary.BigEndian.Uint32(tkhd[80:]), test.ShouldEqual, 48<<16)
+	mdhd := mp4Path(t, moov[1].data, "mdia", "mdhd").data
+	test.That(t, binary.BigEndian.Uint32(mdhd[12:]), test.ShouldEqual, mp4VideoTimescale)
+	stsd := mp4Path(t, moov[1].data, "mdia", "minf", "stbl", "stsd").data
+	avc1 := readMP4Boxes(t, stsd[8:])
+	test.That(t, mp4Types(avc1), test.ShouldResemble, []string{"avc1"})
+	avcC := mp4Path(t, avc1[0].data[78:], "avcC").data
+	test.That(t, avcC[:5], test.ShouldResemble, []byte{1, 0x42, 0xC0, 0x1E, 0xFF})
+	test.That(t, avcC[8:8+len(testH264SPS)], test.ShouldResemble, testH264SPS)
+	test.That(t, avcC[len(avcC)-len(testH264PPS):], test.ShouldResemble, testH264PPS)
+
+	mdhd = mp4Path(t, moov[2].data, "mdia", "mdhd").data
+	test.That(t, binary.BigEndian.Uint32(mdhd[12:]), test.ShouldEqual, mp4AudioTimescale)
+	stsd = mp4Path(t, moov[2].data, "mdia", "minf", "stbl", "stsd").data
+	opus := readMP4Boxes(t, stsd[8:])
+	test.That(t, mp4Types(opus), test.ShouldResemble, []string{"Opus"})
+	dOps := mp4Path(t, opus[0].data[28:], "dOps").data
+	test.That(t, dOps[:2], test.ShouldResemble, []byte{0, 2})
+	test.That(t, mp4Types(readMP4Boxes(t, moov[3].data)), test.ShouldResemble, []string{"trex", "trex"})
+
+	// the first fragment has every video frame before the second key frame but only the
+	// audio whose duration was known
+	sequence, runs := readMP4Fragment(t, boxes[2])
+	test.That(t, sequence, test.ShouldEqual, 1)
+	test.That(t, runs, test.ShouldHaveLength, 2)
+	test.That(t, runs[0].trackID, test.ShouldEqual, 1)
+	test.That(t, runs[0].baseTime, test.ShouldEqual, 0)
+	test.That(t, runs[0].durations, test.ShouldResemble, []uint32{9000, 9000})
+	test.That(t, runs[0].flags, test.ShouldResemble, []uint32{mp4SampleFlagsSync, mp4SampleFlagsNonSync})
+	test.That(t, runs[1].trackID, test.ShouldEqual, 2)
+	test.That(t, runs[1].durations, test.ShouldResemble, []uint32{960, 960})
+	test.That(t, runs[1].sizes, test.ShouldResemble, []uint32{1, 1})
+
+	// the data offsets point into the mdat that follows the moof
+	mdat := boxes[3]
+	sample := data[boxes[2].offset+runs[0].dataOffset:][:runs[0].sizes[0]]
+	// the parameter sets and access unit delimiter are left out and the IDR slice is
+	// prefixed with its length
+	test.That(t, sample, test.ShouldResemble, []byte{0, 0, 0, 4, 0x65, 0x88, 0x84, 0x21})
+	test.That(t, boxes[2].offset+runs[0].dataOffset, test.ShouldEqual, mdat.offset+8)
+	test.That(t, data[boxes[2].offset+runs[1].dataOffset:][:2], test.ShouldResemble, []byte{1, 2})
+	test.That(t, mdat.data, test.ShouldHaveLength, 8+7+2)
+
+	// the last samples of a file last as long as the ones before them
+	sequence, runs = readMP4Fragment(t, boxes[4])
+	test.That(t, sequence, test.ShouldEqual, 2)
+	test.That(t, runs[0].baseTime, test.ShouldEqual, 18000)
+	test.That(t, runs[0].durations, test.ShouldResemble, []uint32{mp4DefaultVideoSampleDuration})
+	test.That(t, runs[1].baseTime, test.ShouldEqual, 1920)
+	test.That(t, runs[1].durations, test.ShouldResemble, []uint32{960, 960})
+	test.That(t, data[boxes[4].offset+runs[1].dataOffset:][:2], test.ShouldResemble, []byte{3, 4})
+}
+
+func TestFMP4WriterAudio(t *testing.T) {
+	var file bytes.Buffer
+	fw, err := newFMP4Writer(&file, []muxedTrack{{mimeType: webrtc.MimeTypeOpus, channels: 1}})
+	test.That(t, err, test.ShouldBeNil)
+	for i := 0; i < 120; i++ {
+		test.That(t, fw.writeFrame(0, []byte{byte(i)}, false, time.Duration(i)*20*time.Millisecond), test.ShouldBeNil)
+	}
+	test.That(t, fw.close(), test.ShouldBeNil)
+
+	// without video, fragments are cut once they hold enough audio
+	boxes := readMP4Boxes(t, file.Bytes())
+	test.That(t, mp4Types(boxes), test.ShouldResemble, []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat", "moof", "mdat"})
+	for i, expected := range []struct {
+		baseTime uint64
+		samples  int
+	}{{0, 50}, {48000, 50}, {96000, 20}} {
+		_, runs := readMP4Fragment(t, boxes[2+2*i])
+		test.That(t, runs, test.ShouldHaveLength, 1)
+		test.That(t, runs[0].baseTime, test.ShouldEqual, expected.baseTime)
+		test.That(t, runs[0].sizes, test.ShouldHaveLength, expected.samples)
+		test.That(t, runs[0].flags[0], test.ShouldEqual, mp4SampleFlagsSync)
+	}
+}
+
+func TestStreamRecorderFragmentedMP4(t *testing.T) {
+	stream, err := NewStream(StreamConfig{Name: "cam", EncodedVideoMIMEType: webrtc.MimeTypeH264})
+	test.That(t, err, test.ShouldBeNil)
+
+	// WebM cannot hold H.264
+	_, err = NewStreamRecorder(stream, RecorderConfig{})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = NewStreamRecorder(stream, RecorderConfig{Format: "avi"})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	dir := t.TempDir()
+	files := newRecordedFiles()
+	rec, err := NewStreamRecorder(stream, RecorderConfig{
+		Format: RecordingFormatFragmentedMP4,
+		Dir:    dir,
+		OnFile: files.onFile,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	defer rec.Close()
+	test.That(t, rec.Start(), test.ShouldBeNil)
+
+	stream.Start()
+	input, err := stream.InputEncodedVideo(prop.Video{})
+	test.That(t, err, test.ShouldBeNil)
+	released := make(chan struct{})
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	frames := [][]byte{testH264Frame, testH264KeyFrame(), testH264Frame, testH264Frame, testH264KeyFrame(), testH264Frame}
+	for i, frame := range frames {
+		input <- MediaReleasePair[EncodedVideoFrame]{
+			Media:     EncodedVideoFrame{Data: frame, KeyFrame: i%3 == 1},
+			Release:   func() { released <- struct{}{} },
+			Timestamp: start.Add(time.Duration(i) * 100 * time.Millisecond),
+		}
+		<-released
+	}
+	stream.Stop()
+
+	path := <-files.ch
+	test.That(t, path, test.ShouldEqual, filepath.Join(dir, "cam-20230102T030405.100Z.mp4"))
+	data, err := os.ReadFile(path)
+	test.That(t, err, test.ShouldBeNil)
+	boxes := readMP4Boxes(t, data)
+	test.That(t, mp4Types(boxes), test.ShouldResemble, []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"})
+	// each fragment starts at a key frame
+	for i, expected := range []uint64{0, 27000} {
+		_, runs := readMP4Fragment(t, boxes[2+2*i])
+		test.That(t, runs, test.ShouldHaveLength, 1)
+		test.That(t, runs[0].baseTime, test.ShouldEqual, expected)
+		test.That(t, runs[0].flags[0], test.ShouldEqual, mp4SampleFlagsSync)
+	}
+}
@@ -0,0 +1,103 @@
+package gostream
+
+import (
+	"sync"
+	"time"
+)
+
+// A FramePacing determines how a stream paces the video frames that it takes as input.
+type FramePacing string
+
+// The set of known frame pacings.
+const (
+	// FramePacingFixed takes a frame at every tick of the target frame rate. Frames from
+	// slow sources wait for the next tick and fast sources are only read as often as the
+	// ticker allows.
+	FramePacingFixed FramePacing = "fixed"
+
+	// FramePacingAdaptive takes frames as soon as they arrive and drops those that arrive
+	// faster than the lower of the target frame rate and the frame rate of the source.
+	FramePacingAdaptive FramePacing = "adaptive"
+)
+
+// A framePacer decides which frames arriving at a stream with adaptive pacing are encoded.
+// Frames are scheduled at the target interval and a frame is accepted if it arrives no
+// earlier than a third of an interval before it is due, which lets jittery sources keep
+// their frame rate while evenly thinning out sources that are faster than the target. It
+// is less than half an interval so that sources at twice the target rate are not on the
+// edge of it.
+type framePacer struct {
+	mu              sync.Mutex
+	targetFrameRate float64
+	sourceFrameRate float64
+	nextDue         time.Time
+}
+
+func newFramePacer(targetFrameRate int) *framePacer {
+	return &framePacer{targetFrameRate: float64(targetFrameRate)}
+}
+
+// setSourceFrameRate sets the frame rate the source claims to produce frames at, or
+// zero if it is unknown.
+func (p *framePacer) setSourceFrameRate(frameRate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sourceFrameRate = frameRate
+}
+
+// accept returns whether a frame arriving at the given time should be encoded.
+func (p *framePacer) accept(arrival time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	frameRate := p.targetFrameRate
+	if p.sourceFrameRate > 0 && p.sourceFrameRate < frameRate {
+		frameRate = p.sourceFrameRate
+	}
+	interval := time.Duration(float64(time.Second) / frameRate)
+
+	if arrival.Before(p.nextDue.Add(-interval / 3)) {
+		return false
+	}
+	p.nextDue = p.nextDue.Add(interval)
+	// a source that stalls or is slower than the target should not be allowed to make
+	// up for it with a burst of frames.
+	if p.nextDue.Before(arrival) {
+		p.nextDue = arrival.Add(interval)
+	}
+	return true
+}
+
+// frameRateWindow is how far back a frameRateMeter counts frames.
+const frameRateWindow = time.Second
+
+// A frameRateMeter measures the rate of frames over the last second.
+type frameRateMeter struct {
+	mu     sync.Mutex
+	frames []time.Time
+}
+
+// tick records a frame at the given time.
+func (m *frameRateMeter) tick(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trim(now)
+	m.frames = append(m.frames, now)
+}
+
+// rate returns the number of frames per second recorded over the last second.
+func (m *frameRateMeter) rate(now time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trim(now)
+	return float64(len(m.frames)) / frameRateWindow.Seconds()
+}
+
+// trim forgets frames that are out of the window. It assumes mu is held.
+func (m *frameRateMeter) trim(now time.Time) {
+	cutoff := now.Add(-frameRateWindow)
+	i := 0
+	for i < len(m.frames) && !m.frames[i].After(cutoff) {
+		i++
+	}
+	m.frames = append(m.frames[:0], m.frames[i:]...)
+}
@@ -0,0 +1,71 @@
+package gostream
+
+import (
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestFramePacer(t *testing.T) {
+	start := time.Now()
+	// accepted counts the frames accepted out of ten seconds of frames arriving at the given rate.
+	accepted := func(pacer *framePacer, frameRate int) int {
+		var n int
+		for i := 0; i < 10*frameRate; i++ {
+			if pacer.accept(start.Add(time.Duration(i) * time.Second / time.Duration(frameRate))) {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("thins out fast sources", func(t *testing.T) {
+		test.That(t, accepted(newFramePacer(30), 60), test.ShouldBeBetweenOrEqual, 300, 301)
+		test.That(t, accepted(newFramePacer(30), 45), test.ShouldBeBetweenOrEqual, 300, 301)
+		test.That(t, accepted(newFramePacer(30), 31), test.ShouldBeBetweenOrEqual, 300, 301)
+	})
+
+	t.Run("keeps slow sources", func(t *testing.T) {
+		test.That(t, accepted(newFramePacer(30), 15), test.ShouldEqual, 150)
+	})
+
+	t.Run("tolerates jitter", func(t *testing.T) {
+		pacer := newFramePacer(30)
+		interval := time.Second / 30
+		var n int
+		for i := 0; i < 30; i++ {
+			jitter := time.Duration(i%3-1) * interval / 4
+			if pacer.accept(start.Add(time.Duration(i)*interval + jitter)) {
+				n++
+			}
+		}
+		test.That(t, n, test.ShouldEqual, 30)
+	})
+
+	t.Run("does not burst after a stall", func(t *testing.T) {
+		pacer := newFramePacer(30)
+		test.That(t, pacer.accept(start), test.ShouldBeTrue)
+		stalled := start.Add(time.Second)
+		test.That(t, pacer.accept(stalled), test.ShouldBeTrue)
+		test.That(t, pacer.accept(stalled.Add(time.Millisecond)), test.ShouldBeFalse)
+	})
+
+	t.Run("respects source frame rate", func(t *testing.T) {
+		pacer := newFramePacer(30)
+		pacer.setSourceFrameRate(10)
+		test.That(t, accepted(pacer, 60), test.ShouldBeBetweenOrEqual, 100, 101)
+	})
+}
+
+func TestFrameRateMeter(t *testing.T) {
+	var meter frameRateMeter
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		meter.tick(start.Add(time.Duration(i) * 50 * time.Millisecond))
+	}
+	last := start.Add(19 * 50 * time.Millisecond)
+	test.That(t, meter.rate(last), test.ShouldEqual, 20)
+	test.That(t, meter.rate(last.Add(500*time.Millisecond)), test.ShouldEqual, 10)
+	test.That(t, meter.rate(last.Add(time.Second)), test.ShouldEqual, 0)
+}
@@ -0,0 +1,253 @@
+package gostream
+
+import (
+	"context"
+	"image"
+	"sort"
+	"sync"
+	"time"
+
+	"go.viam.com/utils"
+)
+
+// DefaultPipelineQueueSize is how many frames can wait for each stage of a video pipeline
+// by default. Keeping it small keeps latency low since waiting frames only grow older.
+const DefaultPipelineQueueSize = 1
+
+// A PipelineConfig configures pipelined video encoding. By default, a stream converts, encodes
+// and packetizes each frame before taking the next one, so the time spent in any stage lowers
+// the frame rate. In a pipeline, each stage runs on its own goroutine and works on the next
+// frame as soon as it hands the current one to the stage after it.
+type PipelineConfig struct {
+	// Enabled turns on pipelined encoding.
+	Enabled bool
+
+	// QueueSize is how many frames can wait for each stage and, once encoded, for each
+	// codec of each simulcast layer. When a queue is full, the oldest frame in it is
+	// dropped to make room. Dropping an encoded frame makes the next frame of its layer
+	// and codec a key frame so that peers can keep decoding. Defaults to
+	// DefaultPipelineQueueSize.
+	QueueSize int
+}
+
+// PipelineStageStats describe the work done by one stage of video encoding.
+type PipelineStageStats struct {
+	// Frames is how many frames the stage has finished.
+	Frames uint64
+
+	// Dropped is how many frames were dropped while waiting for the stage. Frames are
+	// only dropped by pipelined encoding.
+	Dropped uint64
+
+	// Busy is the total time the stage has spent working on frames.
+	Busy time.Duration
+
+	// Waiting is the total time that frames have waited for the stage.
+	Waiting time.Duration
+
+	// BusyHistogram counts frames by how long the stage spent working on them. Each count is
+	// of the frames that took no longer than the bound at the same index of PipelineBusyBuckets
+	// but longer than the bound before it. Frames that took longer than every bound are only
+	// counted in Frames.
+	BusyHistogram []uint64
+}
+
+// PipelineBusyBuckets are the upper bounds of the buckets of PipelineStageStats.BusyHistogram.
+var PipelineBusyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// PipelineStats describe where time is spent turning input frames into packets sent to peers.
+type PipelineStats struct {
+	// Convert scales frames for each video layer and converts them to I420.
+	Convert PipelineStageStats
+
+	// Encode encodes frames in every codec that peers are receiving.
+	Encode PipelineStageStats
+
+	// Packetize packetizes encoded frames and writes them to peers.
+	Packetize PipelineStageStats
+}
+
+// pipelineStats guards the stats of a stream, which are updated by every stage.
+type pipelineStats struct {
+	mu    sync.Mutex
+	stats PipelineStats
+}
+
+// done records that the given stage of the stats finished a frame.
+func (ps *pipelineStats) done(stage func(*PipelineStats) *PipelineStageStats, waiting, busy time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	stageStats := stage(&ps.stats)
+	stageStats.Frames++
+	stageStats.Waiting += waiting
+	stageStats.Busy += busy
+	if bucket := sort.Search(len(PipelineBusyBuckets), func(i int) bool {
+		return busy <= PipelineBusyBuckets[i]
+	}); bucket < len(PipelineBusyBuckets) {
+		if stageStats.BusyHistogram == nil {
+			stageStats.BusyHistogram = make([]uint64, len(PipelineBusyBuckets))
+		}
+		stageStats.BusyHistogram[bucket]++
+	}
+}
+
+// dropped records that a frame was dropped while waiting for the given stage of the stats.
+func (ps *pipelineStats) dropped(stage func(*PipelineStats) *PipelineStageStats) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	stage(&ps.stats).Dropped++
+}
+
+func (ps *pipelineStats) snapshot() PipelineStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	stats := ps.stats
+	for _, stage := range []func(*PipelineStats) *PipelineStageStats{convertStage, encodeStage, packetizeStage} {
+		stageStats := stage(&stats)
+		stageStats.BusyHistogram = append([]uint64(nil), stageStats.BusyHistogram...)
+	}
+	return stats
+}
+
+func convertStage(stats *PipelineStats) *PipelineStageStats   { return &stats.Convert }
+func encodeStage(stats *PipelineStats) *PipelineStageStats    { return &stats.Encode }
+func packetizeStage(stats *PipelineStats) *PipelineStageStats { return &stats.Packetize }
+
+// A dropOldestQueue is a bounded queue that makes room for new items by dropping the oldest.
+type dropOldestQueue[T any] struct {
+	ch     chan T
+	onDrop func(item T)
+}
+
+func newDropOldestQueue[T any](size int, onDrop func(item T)) *dropOldestQueue[T] {
+	return &dropOldestQueue[T]{ch: make(chan T, size), onDrop: onDrop}
+}
+
+// push adds the item to the queue, dropping the oldest items if it is full. It must not
+// be called concurrently.
+func (q *dropOldestQueue[T]) push(item T) {
+	for {
+		select {
+		case q.ch <- item:
+			return
+		default:
+		}
+		select {
+		case oldest := <-q.ch:
+			q.onDrop(oldest)
+		default:
+		}
+	}
+}
+
+// drain drops every item left in the queue.
+func (q *dropOldestQueue[T]) drain() {
+	for {
+		select {
+		case item := <-q.ch:
+			q.onDrop(item)
+		default:
+			return
+		}
+	}
+}
+
+// An encodingKey identifies the frames encoded in one codec for one video layer.
+type encodingKey struct {
+	layer    *videoLayer
+	mimeType string
+}
+
+// packetizeQueues hold the encoded frames of a pipeline that wait to be packetized. Each
+// codec of each layer has its own queue that is packetized on its own goroutine, so that
+// frames only wait for and are only dropped because of frames of the same layer and codec.
+type packetizeQueues struct {
+	ctx     context.Context
+	size    int
+	onDrop  func(frame encodedMedia)
+	write   func(frame encodedMedia)
+	queues  map[encodingKey]*dropOldestQueue[encodedMedia]
+	workers sync.WaitGroup
+}
+
+// newPacketizeQueues returns queues of the given size whose frames are written until the
+// context is done.
+func newPacketizeQueues(
+	ctx context.Context,
+	size int,
+	onDrop, write func(frame encodedMedia),
+) *packetizeQueues {
+	return &packetizeQueues{
+		ctx:    ctx,
+		size:   size,
+		onDrop: onDrop,
+		write:  write,
+		queues: map[encodingKey]*dropOldestQueue[encodedMedia]{},
+	}
+}
+
+// push adds the frame to the queue of its layer and codec, which is created the first time
+// a frame is pushed to it. It must not be called concurrently.
+func (pq *packetizeQueues) push(frame encodedMedia) {
+	key := encodingKey{layer: frame.layer, mimeType: frame.mimeType}
+	queue, ok := pq.queues[key]
+	if !ok {
+		queue = newDropOldestQueue(pq.size, pq.onDrop)
+		pq.queues[key] = queue
+		pq.workers.Add(1)
+		utils.ManagedGo(func() {
+			for {
+				select {
+				case <-pq.ctx.Done():
+					return
+				case queued := <-queue.ch:
+					pq.write(queued)
+				}
+			}
+		}, pq.workers.Done)
+	}
+	queue.push(frame)
+}
+
+// wait waits for every queue to stop being packetized once the context is done. Frames
+// left in the queues are not written.
+func (pq *packetizeQueues) wait() {
+	pq.workers.Wait()
+}
+
+// A queuedFrame is an input frame waiting to be converted.
+type queuedFrame struct {
+	frame    MediaReleasePair[image.Image]
+	queuedAt time.Time
+}
+
+// A convertedFrame is an input frame that has been converted for each video layer and
+// is ready to be encoded.
+type convertedFrame struct {
+	// images has the image for each video layer, in the order of the layers.
+	images    []image.Image
+	timestamp time.Time
+	queuedAt  time.Time
+
+	// releases release the images and then the input frame once they are encoded.
+	releases []func()
+}
+
+func (f convertedFrame) release() {
+	for _, release := range f.releases {
+		if release != nil {
+			release()
+		}
+	}
+}
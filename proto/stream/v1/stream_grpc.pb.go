@@ -30,6 +30,15 @@ type StreamServiceClient interface {
 	// is the last to be receiving the stream, it will attempt to be stopped to
 	// conserve resources.
 	RemoveStream(ctx context.Context, in *RemoveStreamRequest, opts ...grpc.CallOption) (*RemoveStreamResponse, error)
+	// GetStreamStats returns counters describing the media of streams and what
+	// has been sent to each peer receiving them.
+	GetStreamStats(ctx context.Context, in *GetStreamStatsRequest, opts ...grpc.CallOption) (*GetStreamStatsResponse, error)
+	// SaveStreamEvent saves the media that a stream has kept in memory, and the media
+	// that follows it for the given duration, to a file on the server. It returns once
+	// the file is finalized.
+	SaveStreamEvent(ctx context.Context, in *SaveStreamEventRequest, opts ...grpc.CallOption) (*SaveStreamEventResponse, error)
+	// GetSnapshot returns the most recent video frame given to a stream as an image.
+	GetSnapshot(ctx context.Context, in *GetSnapshotRequest, opts ...grpc.CallOption) (*GetSnapshotResponse, error)
 }
 
 type streamServiceClient struct {
@@ -67,6 +76,33 @@ func (c *streamServiceClient) RemoveStream(ctx context.Context, in *RemoveStream
 	return out, nil
 }
 
+func (c *streamServiceClient) GetStreamStats(ctx context.Context, in *GetStreamStatsRequest, opts ...grpc.CallOption) (*GetStreamStatsResponse, error) {
+	out := new(GetStreamStatsResponse)
+	err := c.cc.Invoke(ctx, "/proto.stream.v1.StreamService/GetStreamStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *streamServiceClient) SaveStreamEvent(ctx context.Context, in *SaveStreamEventRequest, opts ...grpc.CallOption) (*SaveStreamEventResponse, error) {
+	out := new(SaveStreamEventResponse)
+	err := c.cc.Invoke(ctx, "/proto.stream.v1.StreamService/SaveStreamEvent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *streamServiceClient) GetSnapshot(ctx context.Context, in *GetSnapshotRequest, opts ...grpc.CallOption) (*GetSnapshotResponse, error) {
+	out := new(GetSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/proto.stream.v1.StreamService/GetSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StreamServiceServer is the server API for StreamService service.
 // All implementations must embed UnimplementedStreamServiceServer
 // for forward compatibility
@@ -79,6 +115,15 @@ type StreamServiceServer interface {
 	// is the last to be receiving the stream, it will attempt to be stopped to
 	// conserve resources.
 	RemoveStream(context.Context, *RemoveStreamRequest) (*RemoveStreamResponse, error)
+	// GetStreamStats returns counters describing the media of streams and what
+	// has been sent to each peer receiving them.
+	GetStreamStats(context.Context, *GetStreamStatsRequest) (*GetStreamStatsResponse, error)
+	// SaveStreamEvent saves the media that a stream has kept in memory, and the media
+	// that follows it for the given duration, to a file on the server. It returns once
+	// the file is finalized.
+	SaveStreamEvent(context.Context, *SaveStreamEventRequest) (*SaveStreamEventResponse, error)
+	// GetSnapshot returns the most recent video frame given to a stream as an image.
+	GetSnapshot(context.Context, *GetSnapshotRequest) (*GetSnapshotResponse, error)
 	mustEmbedUnimplementedStreamServiceServer()
 }
 
@@ -95,6 +140,15 @@ func (UnimplementedStreamServiceServer) AddStream(context.Context, *AddStreamReq
 func (UnimplementedStreamServiceServer) RemoveStream(context.Context, *RemoveStreamRequest) (*RemoveStreamResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveStream not implemented")
 }
+func (UnimplementedStreamServiceServer) GetStreamStats(context.Context, *GetStreamStatsRequest) (*GetStreamStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStreamStats not implemented")
+}
+func (UnimplementedStreamServiceServer) SaveStreamEvent(context.Context, *SaveStreamEventRequest) (*SaveStreamEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SaveStreamEvent not implemented")
+}
+func (UnimplementedStreamServiceServer) GetSnapshot(context.Context, *GetSnapshotRequest) (*GetSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSnapshot not implemented")
+}
 func (UnimplementedStreamServiceServer) mustEmbedUnimplementedStreamServiceServer() {}
 
 // UnsafeStreamServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -162,6 +216,60 @@ func _StreamService_RemoveStream_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StreamService_GetStreamStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStreamStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StreamServiceServer).GetStreamStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.stream.v1.StreamService/GetStreamStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StreamServiceServer).GetStreamStats(ctx, req.(*GetStreamStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StreamService_SaveStreamEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SaveStreamEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StreamServiceServer).SaveStreamEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.stream.v1.StreamService/SaveStreamEvent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StreamServiceServer).SaveStreamEvent(ctx, req.(*SaveStreamEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StreamService_GetSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StreamServiceServer).GetSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.stream.v1.StreamService/GetSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StreamServiceServer).GetSnapshot(ctx, req.(*GetSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // StreamService_ServiceDesc is the grpc.ServiceDesc for StreamService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -181,6 +289,18 @@ var StreamService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveStream",
 			Handler:    _StreamService_RemoveStream_Handler,
 		},
+		{
+			MethodName: "GetStreamStats",
+			Handler:    _StreamService_GetStreamStats_Handler,
+		},
+		{
+			MethodName: "SaveStreamEvent",
+			Handler:    _StreamService_SaveStreamEvent_Handler,
+		},
+		{
+			MethodName: "GetSnapshot",
+			Handler:    _StreamService_GetSnapshot_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/stream/v1/stream.proto",
@@ -0,0 +1,621 @@
+package gostream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edaniels/golog"
+	"go.uber.org/multierr"
+	"go.viam.com/utils"
+)
+
+// A Recorder muxes video and audio that are already encoded into files, without
+// re-encoding them. The codecs it can record depend on its RecordingFormat.
+type Recorder interface {
+	// Start starts recording. A file is only created once media arrives and, if video is
+	// recorded, it starts at a video key frame.
+	Start() error
+
+	// Stop finalizes the file being recorded, if any. Media is ignored until the recorder
+	// is started again.
+	Stop() error
+
+	// WriteVideo records a frame of encoded video captured at the given time.
+	WriteVideo(frame EncodedVideoFrame, timestamp time.Time) error
+
+	// WriteAudio records a chunk of encoded audio captured at the given time.
+	WriteAudio(data []byte, timestamp time.Time) error
+
+	// Close stops recording and, if the recorder is attached to a stream, detaches it.
+	Close() error
+}
+
+// A RecordingFormat is the container format that a Recorder records in.
+type RecordingFormat string
+
+// The set of known recording formats.
+const (
+	// RecordingFormatWebM records VP8 or VP9 video and Opus audio in WebM files.
+	RecordingFormatWebM RecordingFormat = "webm"
+
+	// RecordingFormatFragmentedMP4 records H.264 video and Opus audio in fragmented MP4
+	// files, with a fragment starting at each video key frame. The H.264 video must not
+	// have B-frames.
+	RecordingFormatFragmentedMP4 RecordingFormat = "fmp4"
+)
+
+// canRecord returns whether files of the format can hold the codec of the given MIME type.
+func (f RecordingFormat) canRecord(mimeType string) bool {
+	switch f {
+	case RecordingFormatWebM:
+		_, ok := webmCodecID(mimeType)
+		return ok
+	case RecordingFormatFragmentedMP4:
+		return fmp4CanMux(mimeType)
+	default:
+		return false
+	}
+}
+
+// extension returns the file name extension of files of the format.
+func (f RecordingFormat) extension() string {
+	if f == RecordingFormatFragmentedMP4 {
+		return ".mp4"
+	}
+	return ".webm"
+}
+
+// newMuxer writes the header of a file of the format with the given tracks and returns
+// a muxer of their frames.
+func (f RecordingFormat) newMuxer(w io.WriteSeeker, tracks []muxedTrack) (recordingMuxer, error) {
+	if f == RecordingFormatFragmentedMP4 {
+		return newFMP4Writer(w, tracks)
+	}
+	return newWebMWriter(w, tracks)
+}
+
+// A recordingMuxer muxes the frames of the tracks of one recorded file.
+type recordingMuxer interface {
+	// writeFrame writes a frame of the given track (indexed from zero), captured the given
+	// time after the start of the file.
+	writeFrame(track int, data []byte, keyFrame bool, elapsed time.Duration) error
+
+	// size returns how many bytes of the file have been written or are waiting to be.
+	size() int64
+
+	// close writes what is left of the file, which is left open.
+	close() error
+}
+
+// A muxedTrack describes a track of a recorded file.
+type muxedTrack struct {
+	mimeType string
+
+	// width and height are only set for video.
+	width, height int
+
+	// sps and pps are the parameter sets of H.264 video.
+	sps, pps []byte
+
+	// channels is only set for audio, which is always sampled at 48kHz since it is Opus.
+	channels int
+}
+
+func (t muxedTrack) isVideo() bool {
+	return strings.HasPrefix(strings.ToLower(t.mimeType), "video/")
+}
+
+// sameFormat returns whether video of the other track can be recorded as this track.
+func (t muxedTrack) sameFormat(other muxedTrack) bool {
+	return t.width == other.width && t.height == other.height &&
+		bytes.Equal(t.sps, other.sps) && bytes.Equal(t.pps, other.pps)
+}
+
+// RecorderConfig configures a Recorder.
+type RecorderConfig struct {
+	// Format is the container format of the recorded files. It defaults to
+	// RecordingFormatWebM.
+	Format RecordingFormat
+
+	// Dir is the directory that files are recorded in. It defaults to the working directory.
+	Dir string
+
+	// Name begins the name of each file, which is followed by when its media was captured.
+	// It defaults to the name of the recorded stream or, without one, to "recording".
+	Name string
+
+	// VideoMIMEType and AudioMIMEType are the codecs of the video and audio to record.
+	// Without a stream, either can be left empty to record no video or no audio. With a
+	// stream, they default to the most preferred codecs of the stream that the format can
+	// hold.
+	VideoMIMEType string
+	AudioMIMEType string
+
+	// AudioChannels is how many channels the audio has. It defaults to 2.
+	AudioChannels int
+
+	// MaxFileSize, in bytes, and MaxFileDuration limit each file. Once either is reached,
+	// the next file starts at the next video key frame or, without video, straight away.
+	// Files are not limited if they are zero.
+	MaxFileSize     int64
+	MaxFileDuration time.Duration
+
+	// OnFile, if set, is called with the path of each file once it is finalized. It must
+	// not call the recorder.
+	OnFile func(path string)
+
+	Logger golog.Logger
+}
+
+// recorderQueueSize is how many frames a recorder attached to a stream holds while
+// earlier frames are written. Frames arriving while it is full are dropped.
+const recorderQueueSize = 64
+
+// recorderTimeFormat formats when the media of a file was captured into its name.
+const recorderTimeFormat = "20060102T150405.000Z"
+
+// NewRecorder returns a recorder of the video and audio given to it.
+func NewRecorder(config RecorderConfig) (Recorder, error) {
+	if config.Name == "" {
+		config.Name = "recording"
+	}
+	return newRecorder(config)
+}
+
+// withDefaultFormat returns the config with its format defaulted, or an error if the
+// format is unknown.
+func (config RecorderConfig) withDefaultFormat() (RecorderConfig, error) {
+	switch config.Format {
+	case "":
+		config.Format = RecordingFormatWebM
+	case RecordingFormatWebM, RecordingFormatFragmentedMP4:
+	default:
+		return config, fmt.Errorf("unknown recording format %q", config.Format)
+	}
+	return config, nil
+}
+
+// NewStreamRecorder returns a recorder attached to the given stream, which must have
+// been created by NewStream. It records the encoded output of the stream, which keeps
+// encoding in the recorded codecs whether or not any peer receives them. The stream is
+// kept started while the recorder is recording, from Start until Stop or Close. The file
+// being recorded is finalized whenever the stream stops, and a new one starts once media
+// flows again.
+func NewStreamRecorder(stream Stream, config RecorderConfig) (Recorder, error) {
+	bs, ok := stream.(*basicStream)
+	if !ok {
+		return nil, errors.New("can only record streams created by NewStream")
+	}
+	config, err := config.forStream(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRecorder(config)
+	if err != nil {
+		return nil, err
+	}
+	r.requestKeyFrame = bs.requestKeyFrame
+	r.holdStream = bs.hold
+	r.queue = make(chan recordedMedia, recorderQueueSize)
+	r.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(r.processQueue, r.activeBackgroundWorkers.Done)
+
+	stop := func() {
+		r.queue <- recordedMedia{stop: true}
+	}
+	if config.VideoMIMEType != "" {
+		// frames after a dropped one cannot be decoded until the next key frame. dropped
+		// is only used with the track's lock held.
+		var dropped bool
+		sink := &trackSink{mimeType: config.VideoMIMEType, stop: stop}
+		sink.write = func(data []byte, keyFrame bool, timestamp time.Time) {
+			media := recordedMedia{
+				video:     true,
+				data:      append([]byte(nil), data...),
+				keyFrame:  keyFrame,
+				timestamp: timestamp,
+				afterDrop: dropped,
+			}
+			select {
+			case r.queue <- media:
+				dropped = false
+			default:
+				dropped = true
+			}
+		}
+		bs.videoTrackLocal.addSink(sink)
+		r.detach = append(r.detach, func() { bs.videoTrackLocal.removeSink(sink) })
+		// the first frames of the stream's encoder may have been sent before the sink was added
+		bs.requestKeyFrame()
+	}
+	if config.AudioMIMEType != "" {
+		sink := &trackSink{mimeType: config.AudioMIMEType, stop: stop}
+		sink.write = func(data []byte, keyFrame bool, timestamp time.Time) {
+			select {
+			case r.queue <- recordedMedia{data: append([]byte(nil), data...), timestamp: timestamp}:
+			default:
+			}
+		}
+		bs.audioTrackLocal.addSink(sink)
+		r.detach = append(r.detach, func() { bs.audioTrackLocal.removeSink(sink) })
+	}
+	return r, nil
+}
+
+// forStream returns the config with its name, format and codecs defaulted for recording
+// the given stream, or an error if the stream cannot be recorded as configured.
+func (config RecorderConfig) forStream(bs *basicStream) (RecorderConfig, error) {
+	if config.Name == "" {
+		config.Name = bs.name
+	}
+	config, err := config.withDefaultFormat()
+	if err != nil {
+		return config, err
+	}
+
+	if (bs.videoTrackLocal == nil && config.VideoMIMEType != "") ||
+		(bs.audioTrackLocal == nil && config.AudioMIMEType != "") {
+		return config, fmt.Errorf("stream %q does not have the media to record", bs.name)
+	}
+	if bs.videoTrackLocal != nil {
+		config.VideoMIMEType, err = recordedMIMEType(bs.videoTrackLocal, config.Format, config.VideoMIMEType)
+		if err != nil {
+			return config, err
+		}
+	}
+	if bs.audioTrackLocal != nil {
+		config.AudioMIMEType, err = recordedMIMEType(bs.audioTrackLocal, config.Format, config.AudioMIMEType)
+		if err != nil {
+			return config, err
+		}
+	}
+	if config.VideoMIMEType == "" && config.AudioMIMEType == "" {
+		return config, fmt.Errorf("stream %q has no video or audio that can be recorded", bs.name)
+	}
+	return config, nil
+}
+
+// recordedMIMEType returns the given MIME type if it is one of the codecs of the track or,
+// if it is empty, the most preferred codec of the track that the format can hold, if any.
+func recordedMIMEType(track *trackLocalStaticSample, format RecordingFormat, mimeType string) (string, error) {
+	for _, c := range track.rtpTrack.codecs {
+		if mimeType == "" {
+			if format.canRecord(c.MimeType) {
+				return c.MimeType, nil
+			}
+			continue
+		}
+		if strings.EqualFold(c.MimeType, mimeType) {
+			return c.MimeType, nil
+		}
+	}
+	if mimeType == "" {
+		return "", nil
+	}
+	return "", fmt.Errorf("stream has no %s in %q", track.Kind(), mimeType)
+}
+
+func newRecorder(config RecorderConfig) (*recorder, error) {
+	config, err := config.withDefaultFormat()
+	if err != nil {
+		return nil, err
+	}
+	if config.VideoMIMEType == "" && config.AudioMIMEType == "" {
+		return nil, errors.New("at least one of video or audio must be recorded")
+	}
+	if config.VideoMIMEType != "" {
+		if !config.Format.canRecord(config.VideoMIMEType) ||
+			!strings.HasPrefix(strings.ToLower(config.VideoMIMEType), "video/") {
+			return nil, fmt.Errorf("cannot record video in %q as %s", config.VideoMIMEType, config.Format)
+		}
+	}
+	if config.AudioMIMEType != "" {
+		if !config.Format.canRecord(config.AudioMIMEType) ||
+			!strings.HasPrefix(strings.ToLower(config.AudioMIMEType), "audio/") {
+			return nil, fmt.Errorf("cannot record audio in %q as %s", config.AudioMIMEType, config.Format)
+		}
+	}
+	if config.AudioChannels == 0 {
+		config.AudioChannels = 2
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = golog.Global()
+	}
+	return &recorder{
+		config:          config,
+		requestKeyFrame: func() {},
+		logger:          logger,
+	}, nil
+}
+
+// recordedMedia is a frame of video or chunk of audio queued to be recorded, or a
+// request to finalize the file being recorded.
+type recordedMedia struct {
+	video     bool
+	data      []byte
+	keyFrame  bool
+	timestamp time.Time
+
+	// afterDrop is set for the first video frame queued after one was dropped.
+	afterDrop bool
+
+	stop bool
+}
+
+type recorder struct {
+	mu        sync.Mutex
+	config    RecorderConfig
+	recording bool
+	closed    bool
+
+	// file is only open while recording, from the first media recorded in it. videoTrack
+	// describes its video, if any.
+	file             *os.File
+	muxer            recordingMuxer
+	fileStart        time.Time
+	videoTrack       muxedTrack
+	awaitingKeyFrame bool
+
+	// requestKeyFrame asks the recorded stream, if any, for a key frame.
+	requestKeyFrame func()
+
+	// holdStream keeps the stream the recorder is attached to, if any, started until
+	// releaseStream is called. Stopping the stream sends to the queue, which is read with
+	// mu held, so the stream is only held and released without it.
+	holdStream    func() func()
+	holdMu        sync.Mutex
+	releaseStream func()
+
+	// queue and detach are only set for recorders attached to a stream.
+	queue                   chan recordedMedia
+	detach                  []func()
+	activeBackgroundWorkers sync.WaitGroup
+	logger                  golog.Logger
+}
+
+func (r *recorder) Start() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errors.New("recorder is closed")
+	}
+	starting := !r.recording
+	r.recording = true
+	r.mu.Unlock()
+
+	if starting {
+		r.hold()
+		r.requestKeyFrame()
+	}
+	return nil
+}
+
+func (r *recorder) Stop() error {
+	r.mu.Lock()
+	r.recording = false
+	err := r.finishFile()
+	r.mu.Unlock()
+
+	r.release()
+	return err
+}
+
+// hold keeps the stream the recorder is attached to, if any, started.
+func (r *recorder) hold() {
+	r.holdMu.Lock()
+	defer r.holdMu.Unlock()
+	if r.holdStream != nil && r.releaseStream == nil {
+		r.releaseStream = r.holdStream()
+	}
+}
+
+// release lets the stream the recorder is attached to stop once nothing else holds it.
+func (r *recorder) release() {
+	r.holdMu.Lock()
+	releaseStream := r.releaseStream
+	r.releaseStream = nil
+	r.holdMu.Unlock()
+	if releaseStream != nil {
+		releaseStream()
+	}
+}
+
+func (r *recorder) WriteVideo(frame EncodedVideoFrame, timestamp time.Time) error {
+	if frame.MIMEType != "" && !strings.EqualFold(frame.MIMEType, r.config.VideoMIMEType) {
+		return fmt.Errorf("cannot record video in %q", frame.MIMEType)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recordVideo(frame.Data, frame.KeyFrame, timestamp)
+}
+
+func (r *recorder) WriteAudio(data []byte, timestamp time.Time) error {
+	if r.config.AudioMIMEType == "" {
+		return errors.New("no audio is recorded")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recordAudio(data, timestamp)
+}
+
+func (r *recorder) Close() error {
+	for _, detach := range r.detach {
+		detach()
+	}
+	r.detach = nil
+	// the stream no longer sends to the queue once detached
+	r.release()
+	if r.queue != nil {
+		close(r.queue)
+		r.activeBackgroundWorkers.Wait()
+		r.queue = nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.recording = false
+	return r.finishFile()
+}
+
+// processQueue records the media queued by the sinks of the stream until the recorder
+// is closed.
+func (r *recorder) processQueue() {
+	for media := range r.queue {
+		if err := r.record(media); err != nil {
+			r.logger.Errorw("error recording", "error", err)
+		}
+	}
+}
+
+// record records queued media, or finalizes the file being recorded if the media is a
+// request to.
+func (r *recorder) record(media recordedMedia) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case media.stop:
+		return r.finishFile()
+	case media.video:
+		if media.afterDrop {
+			r.awaitingKeyFrame = true
+		}
+		return r.recordVideo(media.data, media.keyFrame, media.timestamp)
+	default:
+		return r.recordAudio(media.data, media.timestamp)
+	}
+}
+
+// recordVideo records a frame of video. Files with video start at a key frame, which
+// must be one that the video can be described from. A new file is started if the video
+// changes format. It assumes mu is held.
+func (r *recorder) recordVideo(data []byte, keyFrame bool, timestamp time.Time) error {
+	if !r.recording || r.config.VideoMIMEType == "" {
+		return nil
+	}
+	track, isKeyFrame, described := describeVideoKeyFrame(r.config.VideoMIMEType, data)
+	keyFrame = keyFrame || isKeyFrame
+	if r.muxer != nil && described && (r.limitReached(timestamp) || !track.sameFormat(r.videoTrack)) {
+		if err := r.finishFile(); err != nil {
+			return err
+		}
+	}
+	if r.muxer == nil {
+		if !described {
+			r.requestKeyFrame()
+			return nil
+		}
+		if err := r.startFile(timestamp, track); err != nil {
+			return err
+		}
+	}
+	if r.awaitingKeyFrame {
+		if !keyFrame {
+			r.requestKeyFrame()
+			return nil
+		}
+		r.awaitingKeyFrame = false
+	}
+	if r.limitReached(timestamp) {
+		r.requestKeyFrame()
+	}
+	return r.muxer.writeFrame(0, data, keyFrame, r.fileTime(timestamp))
+}
+
+// recordAudio records a chunk of audio. Audio captured before the file started is
+// dropped. It assumes mu is held.
+func (r *recorder) recordAudio(data []byte, timestamp time.Time) error {
+	if !r.recording {
+		return nil
+	}
+	withVideo := r.config.VideoMIMEType != ""
+	if r.muxer != nil && !withVideo && r.limitReached(timestamp) {
+		if err := r.finishFile(); err != nil {
+			return err
+		}
+	}
+	if r.muxer == nil {
+		if withVideo {
+			return nil
+		}
+		if err := r.startFile(timestamp, muxedTrack{}); err != nil {
+			return err
+		}
+	}
+	if timestamp.Before(r.fileStart) {
+		return nil
+	}
+	track := 0
+	if withVideo {
+		track = 1
+	}
+	// every Opus packet can be decoded on its own
+	return r.muxer.writeFrame(track, data, true, r.fileTime(timestamp))
+}
+
+// limitReached returns whether the file being recorded has reached the size or duration
+// that it is limited to. It assumes mu is held and that a file is being recorded.
+func (r *recorder) limitReached(timestamp time.Time) bool {
+	return (r.config.MaxFileSize > 0 && r.muxer.size() >= r.config.MaxFileSize) ||
+		(r.config.MaxFileDuration > 0 && timestamp.Sub(r.fileStart) >= r.config.MaxFileDuration)
+}
+
+// fileTime returns how long into the file being recorded the given time is.
+func (r *recorder) fileTime(timestamp time.Time) time.Duration {
+	if elapsed := timestamp.Sub(r.fileStart); elapsed > 0 {
+		return elapsed
+	}
+	return 0
+}
+
+// startFile creates a file whose media starts at the given time and writes its header.
+// The video track is only used if video is recorded. It assumes mu is held.
+func (r *recorder) startFile(start time.Time, videoTrack muxedTrack) error {
+	var tracks []muxedTrack
+	if r.config.VideoMIMEType != "" {
+		tracks = append(tracks, videoTrack)
+	}
+	if r.config.AudioMIMEType != "" {
+		tracks = append(tracks, muxedTrack{mimeType: r.config.AudioMIMEType, channels: r.config.AudioChannels})
+	}
+
+	name := r.config.Name + "-" + start.UTC().Format(recorderTimeFormat) + r.config.Format.extension()
+	file, err := os.Create(filepath.Join(r.config.Dir, name))
+	if err != nil {
+		return err
+	}
+	muxer, err := r.config.Format.newMuxer(file, tracks)
+	if err != nil {
+		return multierr.Combine(err, file.Close(), os.Remove(file.Name()))
+	}
+	r.file = file
+	r.muxer = muxer
+	r.fileStart = start
+	r.videoTrack = videoTrack
+	r.awaitingKeyFrame = false
+	return nil
+}
+
+// finishFile finalizes the file being recorded, if any. It assumes mu is held.
+func (r *recorder) finishFile() error {
+	if r.muxer == nil {
+		return nil
+	}
+	file := r.file
+	err := multierr.Combine(r.muxer.close(), file.Close())
+	r.file = nil
+	r.muxer = nil
+	if r.config.OnFile != nil {
+		r.config.OnFile(file.Name())
+	}
+	return err
+}
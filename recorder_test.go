@@ -0,0 +1,223 @@
+package gostream
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+// recordedFiles collects the files finalized by a recorder.
+type recordedFiles struct {
+	mu    sync.Mutex
+	paths []string
+	ch    chan string
+}
+
+func newRecordedFiles() *recordedFiles {
+	return &recordedFiles{ch: make(chan string, 10)}
+}
+
+func (f *recordedFiles) onFile(path string) {
+	f.mu.Lock()
+	f.paths = append(f.paths, path)
+	f.mu.Unlock()
+	f.ch <- path
+}
+
+func (f *recordedFiles) Paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.paths...)
+}
+
+// recordedClusters returns the clusters of the recorded WebM file at the given path.
+func recordedClusters(t *testing.T, path string) [][]ebmlElement {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	test.That(t, err, test.ShouldBeNil)
+	elements := readEBML(t, data)
+	test.That(t, elements, test.ShouldHaveLength, 2)
+	var clusters [][]ebmlElement
+	for _, cluster := range findEBML(readEBML(t, elements[1].data), mkvIDCluster) {
+		clusters = append(clusters, readEBML(t, cluster.data))
+	}
+	return clusters
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	files := newRecordedFiles()
+	rec, err := NewRecorder(RecorderConfig{
+		Dir:             dir,
+		VideoMIMEType:   webrtc.MimeTypeVP8,
+		AudioMIMEType:   webrtc.MimeTypeOpus,
+		MaxFileDuration: time.Second,
+		OnFile:          files.onFile,
+	})
+	test.That(t, err, test.ShouldBeNil)
+
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	// nothing is recorded until the recorder is started
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: testVP8KeyFrame(64, 48)}, start), test.ShouldBeNil)
+	test.That(t, rec.Start(), test.ShouldBeNil)
+
+	// files start at a key frame
+	test.That(t, rec.WriteAudio([]byte{1}, at(0)), test.ShouldBeNil)
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: []byte{0x11}}, at(0)), test.ShouldBeNil)
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: testVP8KeyFrame(64, 48)}, at(100)), test.ShouldBeNil)
+	test.That(t, rec.WriteAudio([]byte{2}, at(120)), test.ShouldBeNil)
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: []byte{0x11}}, at(600)), test.ShouldBeNil)
+	// the duration is reached but the next file waits for a key frame
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: []byte{0x11}}, at(1200)), test.ShouldBeNil)
+	test.That(t, files.Paths(), test.ShouldBeEmpty)
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: testVP8KeyFrame(64, 48)}, at(1300)), test.ShouldBeNil)
+	test.That(t, files.Paths(), test.ShouldHaveLength, 1)
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: []byte{0x11}}, at(1400)), test.ShouldBeNil)
+	test.That(t, rec.Stop(), test.ShouldBeNil)
+	test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: testVP8KeyFrame(64, 48)}, at(1500)), test.ShouldBeNil)
+
+	paths := files.Paths()
+	test.That(t, paths, test.ShouldResemble, []string{
+		filepath.Join(dir, "recording-20230102T030405.100Z.webm"),
+		filepath.Join(dir, "recording-20230102T030406.300Z.webm"),
+	})
+	clusters := recordedClusters(t, paths[0])
+	test.That(t, clusters, test.ShouldHaveLength, 1)
+	test.That(t, ebmlUint(findEBML(clusters[0], mkvIDTimecode)[0].data), test.ShouldEqual, 0)
+	blocks := findEBML(clusters[0], mkvIDSimpleBlock)
+	test.That(t, blocks, test.ShouldHaveLength, 4)
+	// the audio is on the second track, 20ms after the key frame
+	test.That(t, blocks[1].data, test.ShouldResemble, []byte{0x82, 0, 20, 0x80, 2})
+	test.That(t, blocks[3].data[:3], test.ShouldResemble, []byte{0x81, 0x04, 0x4C})
+	clusters = recordedClusters(t, paths[1])
+	test.That(t, clusters, test.ShouldHaveLength, 1)
+	test.That(t, findEBML(clusters[0], mkvIDSimpleBlock), test.ShouldHaveLength, 2)
+
+	test.That(t, rec.Close(), test.ShouldBeNil)
+	test.That(t, rec.Start(), test.ShouldNotBeNil)
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewRecorder(RecorderConfig{})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewRecorder(RecorderConfig{VideoMIMEType: webrtc.MimeTypeH264})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewRecorder(RecorderConfig{VideoMIMEType: webrtc.MimeTypeOpus})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewRecorder(RecorderConfig{AudioMIMEType: webrtc.MimeTypeVP8})
+		test.That(t, err, test.ShouldNotBeNil)
+
+		rec, err := NewRecorder(RecorderConfig{AudioMIMEType: webrtc.MimeTypeOpus})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, rec.WriteVideo(EncodedVideoFrame{Data: testVP8KeyFrame(64, 48)}, start), test.ShouldBeNil)
+		test.That(t, rec.WriteVideo(EncodedVideoFrame{MIMEType: webrtc.MimeTypeVP9}, start), test.ShouldNotBeNil)
+		test.That(t, rec.Close(), test.ShouldBeNil)
+	})
+}
+
+func TestRecorderAudioRotation(t *testing.T) {
+	files := newRecordedFiles()
+	rec, err := NewRecorder(RecorderConfig{
+		Dir:           t.TempDir(),
+		Name:          "audio",
+		AudioMIMEType: webrtc.MimeTypeOpus,
+		MaxFileSize:   200,
+		OnFile:        files.onFile,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, rec.Start(), test.ShouldBeNil)
+
+	start := time.Now()
+	chunk := make([]byte, 50)
+	for i := 0; i < 10; i++ {
+		test.That(t, rec.WriteAudio(chunk, start.Add(time.Duration(i)*20*time.Millisecond)), test.ShouldBeNil)
+	}
+	test.That(t, rec.Close(), test.ShouldBeNil)
+
+	// without video, files are rotated as soon as they are too big
+	paths := files.Paths()
+	test.That(t, len(paths), test.ShouldBeGreaterThan, 1)
+	var blocks int
+	for _, path := range paths {
+		test.That(t, strings.HasPrefix(filepath.Base(path), "audio-"), test.ShouldBeTrue)
+		for _, cluster := range recordedClusters(t, path) {
+			blocks += len(findEBML(cluster, mkvIDSimpleBlock))
+		}
+	}
+	test.That(t, blocks, test.ShouldEqual, 10)
+}
+
+func TestStreamRecorder(t *testing.T) {
+	stream, err := NewStream(StreamConfig{Name: "cam", EncodedVideoMIMEType: webrtc.MimeTypeVP8})
+	test.That(t, err, test.ShouldBeNil)
+
+	_, err = NewStreamRecorder(stream, RecorderConfig{AudioMIMEType: webrtc.MimeTypeOpus})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = NewStreamRecorder(stream, RecorderConfig{VideoMIMEType: webrtc.MimeTypeVP9})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	dir := t.TempDir()
+	files := newRecordedFiles()
+	rec, err := NewStreamRecorder(stream, RecorderConfig{Dir: dir, OnFile: files.onFile})
+	test.That(t, err, test.ShouldBeNil)
+	defer rec.Close()
+	test.That(t, streamStarted(stream), test.ShouldBeFalse)
+	test.That(t, rec.Start(), test.ShouldBeNil)
+	// the stream runs while recording
+	test.That(t, streamStarted(stream), test.ShouldBeTrue)
+
+	// the recorder receives frames without any peer
+	bs := stream.(*basicStream)
+	test.That(t, bs.videoTrackLocal.boundMIMETypes(), test.ShouldResemble, []string{webrtc.MimeTypeVP8})
+	writeFrames := func(start time.Time) {
+		stream.Start()
+		input, err := stream.InputEncodedVideo(prop.Video{})
+		test.That(t, err, test.ShouldBeNil)
+		released := make(chan struct{})
+		for i, data := range [][]byte{{0x11}, testVP8KeyFrame(64, 48), {0x11}, {0x11}} {
+			input <- MediaReleasePair[EncodedVideoFrame]{
+				Media:     EncodedVideoFrame{Data: data},
+				Release:   func() { released <- struct{}{} },
+				Timestamp: start.Add(time.Duration(i) * 100 * time.Millisecond),
+			}
+			<-released
+		}
+		// stopping the stream finalizes the file
+		stream.Stop()
+	}
+
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFrames(start)
+	path := <-files.ch
+	test.That(t, path, test.ShouldEqual, filepath.Join(dir, "cam-20230102T030405.100Z.webm"))
+	clusters := recordedClusters(t, path)
+	test.That(t, clusters, test.ShouldHaveLength, 1)
+	test.That(t, findEBML(clusters[0], mkvIDSimpleBlock), test.ShouldHaveLength, 3)
+
+	// a new file starts when the stream does
+	writeFrames(start.Add(time.Minute))
+	path = <-files.ch
+	test.That(t, path, test.ShouldEqual, filepath.Join(dir, "cam-20230102T030505.100Z.webm"))
+
+	// peers leaving do not stop the stream while recording
+	peers := &streamState{stream: stream}
+	peers.Start()
+	test.That(t, rec.Stop(), test.ShouldBeNil)
+	test.That(t, streamStarted(stream), test.ShouldBeTrue)
+	test.That(t, rec.Start(), test.ShouldBeNil)
+	peers.Stop()
+	test.That(t, streamStarted(stream), test.ShouldBeTrue)
+
+	test.That(t, rec.Close(), test.ShouldBeNil)
+	test.That(t, streamStarted(stream), test.ShouldBeFalse)
+	test.That(t, bs.videoTrackLocal.boundMIMETypes(), test.ShouldBeEmpty)
+}
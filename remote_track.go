@@ -0,0 +1,354 @@
+package gostream
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/interceptor"
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/mediadevices/pkg/wave"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"go.uber.org/multierr"
+	"go.viam.com/utils"
+
+	"github.com/viamrobotics/gostream/codec"
+)
+
+// remoteVideoMaxLate is how many packets the video of a remote track may arrive out of
+// order by before frames missing packets are dropped.
+const remoteVideoMaxLate = 256
+
+// remoteAudioBufferSize is how many decoded audio chunks of a remote track are kept
+// for reading before the oldest ones are dropped.
+const remoteAudioBufferSize = 16
+
+// A remoteTrack is the part of a *webrtc.TrackRemote that is read from.
+type remoteTrack interface {
+	Codec() webrtc.RTPCodecParameters
+	ReadRTP() (*rtp.Packet, interceptor.Attributes, error)
+	SetReadDeadline(deadline time.Time) error
+}
+
+// NewVideoSourceFromTrack returns a video source of the video received on the given track,
+// such as one passed to a webrtc.PeerConnection's OnTrack handler. The video is decoded with
+// the first of the given factories that decodes the codec of the track. It waits for the first
+// frame to be decoded so that the properties of the source can report its resolution.
+func NewVideoSourceFromTrack(
+	ctx context.Context,
+	track *webrtc.TrackRemote,
+	logger golog.Logger,
+	factories ...codec.VideoDecoderFactory,
+) (VideoSource, error) {
+	return newVideoSourceFromTrack(ctx, track, logger, factories)
+}
+
+func newVideoSourceFromTrack(
+	ctx context.Context,
+	track remoteTrack,
+	logger golog.Logger,
+	factories []codec.VideoDecoderFactory,
+) (VideoSource, error) {
+	trackCodec := track.Codec()
+	factory := findCodecFactory(factories, trackCodec.MimeType)
+	if factory == nil {
+		return nil, fmt.Errorf("no video decoder for %q", trackCodec.MimeType)
+	}
+	depacketizer, err := depacketizerForCodec(trackCodec.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := factory.New(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	reader := &remoteVideoReader{
+		remoteTrackReader: remoteTrackReader{
+			track:      track,
+			cancelCtx:  cancelCtx,
+			cancelFunc: cancelFunc,
+			logger:     logger,
+		},
+		decoder: decoder,
+		ready:   make(chan struct{}),
+	}
+	reader.start(func() {
+		reader.run(samplebuilder.New(remoteVideoMaxLate, depacketizer, trackCodec.ClockRate))
+	}, decoder.Close)
+
+	img, err := reader.peek(ctx)
+	if err != nil {
+		return nil, multierr.Combine(err, reader.Close(ctx))
+	}
+	bounds := img.Bounds()
+	return NewVideoSource(reader, prop.Video{Width: bounds.Dx(), Height: bounds.Dy()}), nil
+}
+
+// NewAudioSourceFromTrack returns an audio source of the audio received on the given track,
+// such as one passed to a webrtc.PeerConnection's OnTrack handler. The audio is decoded with
+// the first of the given factories that decodes the codec of the track.
+func NewAudioSourceFromTrack(
+	track *webrtc.TrackRemote,
+	logger golog.Logger,
+	factories ...codec.AudioDecoderFactory,
+) (AudioSource, error) {
+	return newAudioSourceFromTrack(track, logger, factories)
+}
+
+func newAudioSourceFromTrack(
+	track remoteTrack,
+	logger golog.Logger,
+	factories []codec.AudioDecoderFactory,
+) (AudioSource, error) {
+	trackCodec := track.Codec()
+	factory := findCodecFactory(factories, trackCodec.MimeType)
+	if factory == nil {
+		return nil, fmt.Errorf("no audio decoder for %q", trackCodec.MimeType)
+	}
+	depacketizer, err := depacketizerForCodec(trackCodec.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	channelCount := int(trackCodec.Channels)
+	if channelCount == 0 {
+		channelCount = 1
+	}
+	sampleRate := int(trackCodec.ClockRate)
+	decoder, err := factory.New(sampleRate, channelCount, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	reader := &remoteAudioReader{
+		remoteTrackReader: remoteTrackReader{
+			track:      track,
+			cancelCtx:  cancelCtx,
+			cancelFunc: cancelFunc,
+			logger:     logger,
+		},
+		decoder: decoder,
+		chunks:  make(chan wave.Audio, remoteAudioBufferSize),
+		done:    make(chan struct{}),
+	}
+	reader.start(func() { reader.run(depacketizer) }, decoder.Close)
+
+	return NewAudioSource(reader, prop.Audio{
+		ChannelCount: channelCount,
+		SampleRate:   sampleRate,
+	}), nil
+}
+
+// depacketizerForCodec returns the depacketizer of RTP packets in the codec of the given MIME type.
+func depacketizerForCodec(mimeType string) (rtp.Depacketizer, error) {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return &codecs.H264Packet{}, nil
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return &codecs.VP8Packet{}, nil
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return &codecs.VP9Packet{}, nil
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return &codecs.OpusPacket{}, nil
+	default:
+		return nil, fmt.Errorf("cannot depacketize %q", mimeType)
+	}
+}
+
+// A remoteTrackReader reads the RTP packets of a remote track in the background until
+// the track ends or the reader is closed.
+type remoteTrackReader struct {
+	track                   remoteTrack
+	cancelCtx               context.Context
+	cancelFunc              func()
+	activeBackgroundWorkers sync.WaitGroup
+	logger                  golog.Logger
+}
+
+// start runs the given function in the background and then the given cleanup once it returns.
+func (r *remoteTrackReader) start(run, cleanup func()) {
+	r.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(run, func() {
+		defer r.activeBackgroundWorkers.Done()
+		cleanup()
+	})
+}
+
+// readRTP reads the next packet of the track.
+func (r *remoteTrackReader) readRTP() (*rtp.Packet, error) {
+	pkt, _, err := r.track.ReadRTP()
+	if err != nil && r.cancelCtx.Err() != nil {
+		return nil, r.cancelCtx.Err()
+	}
+	return pkt, err
+}
+
+func (r *remoteTrackReader) Close(_ context.Context) error {
+	r.cancelFunc()
+	// unblock any read in progress
+	err := r.track.SetReadDeadline(time.Now())
+	r.activeBackgroundWorkers.Wait()
+	return err
+}
+
+// A remoteVideoReader decodes every frame of a remote track and reads the latest one.
+type remoteVideoReader struct {
+	remoteTrackReader
+	decoder codec.VideoDecoder
+
+	mu     sync.Mutex
+	latest image.Image
+	unread bool
+	err    error
+	// ready is closed and replaced whenever a frame is decoded or reading stops.
+	ready chan struct{}
+}
+
+func (r *remoteVideoReader) run(sb *samplebuilder.SampleBuilder) {
+	for {
+		pkt, err := r.readRTP()
+		if err != nil {
+			r.publish(nil, err)
+			return
+		}
+		sb.Push(pkt)
+		for sample := sb.Pop(); sample != nil; sample = sb.Pop() {
+			img, err := r.decoder.Decode(r.cancelCtx, sample.Data)
+			if err != nil {
+				// later frames may still decode, such as after the next key frame.
+				r.logger.Debugw("error decoding video frame", "error", err)
+				continue
+			}
+			if img != nil {
+				r.publish(img, nil)
+			}
+		}
+	}
+}
+
+func (r *remoteVideoReader) publish(img image.Image, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.err = err
+	} else {
+		r.latest = img
+		r.unread = true
+	}
+	close(r.ready)
+	r.ready = make(chan struct{})
+}
+
+// peek waits for the first frame to be decoded and returns it without marking it as read.
+func (r *remoteVideoReader) peek(ctx context.Context) (image.Image, error) {
+	for {
+		r.mu.Lock()
+		latest, err, ready := r.latest, r.err, r.ready
+		r.mu.Unlock()
+		if latest != nil {
+			return latest, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ready:
+		}
+	}
+}
+
+// Read waits for a frame that has not been read yet and returns it.
+func (r *remoteVideoReader) Read(ctx context.Context) (image.Image, func(), error) {
+	for {
+		r.mu.Lock()
+		if r.unread {
+			r.unread = false
+			latest := r.latest
+			r.mu.Unlock()
+			return latest, func() {}, nil
+		}
+		err, ready := r.err, r.ready
+		r.mu.Unlock()
+		if err != nil {
+			return nil, nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-ready:
+		}
+	}
+}
+
+// A remoteAudioReader decodes every packet of a remote track and reads the decoded chunks in order.
+type remoteAudioReader struct {
+	remoteTrackReader
+	decoder codec.AudioDecoder
+	chunks  chan wave.Audio
+	// done is closed once reading stops, after which err is set.
+	done chan struct{}
+	err  error
+}
+
+func (r *remoteAudioReader) run(depacketizer rtp.Depacketizer) {
+	defer close(r.done)
+	for {
+		pkt, err := r.readRTP()
+		if err != nil {
+			r.err = err
+			return
+		}
+		data, err := depacketizer.Unmarshal(pkt.Payload)
+		if err != nil {
+			r.logger.Debugw("error depacketizing audio", "error", err)
+			continue
+		}
+		chunk, err := r.decoder.Decode(r.cancelCtx, data)
+		if err != nil {
+			r.logger.Debugw("error decoding audio", "error", err)
+			continue
+		}
+		if chunk == nil {
+			continue
+		}
+		select {
+		case r.chunks <- chunk:
+		default:
+			// the reader is falling behind so drop the oldest chunk to keep latency down.
+			select {
+			case <-r.chunks:
+			default:
+			}
+			r.chunks <- chunk
+		}
+	}
+}
+
+// Read returns the next decoded chunk.
+func (r *remoteAudioReader) Read(ctx context.Context) (wave.Audio, func(), error) {
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case chunk := <-r.chunks:
+		return chunk, func() {}, nil
+	case <-r.done:
+		// drain what was decoded before reading stopped.
+		select {
+		case chunk := <-r.chunks:
+			return chunk, func() {}, nil
+		default:
+		}
+		return nil, nil, r.err
+	}
+}
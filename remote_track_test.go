@@ -0,0 +1,189 @@
+package gostream
+
+import (
+	"context"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/interceptor"
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/mediadevices/pkg/wave"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+
+	"github.com/viamrobotics/gostream/codec"
+)
+
+type fakeRemoteTrack struct {
+	codec        webrtc.RTPCodecParameters
+	pkts         chan *rtp.Packet
+	deadline     chan struct{}
+	deadlineOnce sync.Once
+}
+
+func newFakeRemoteTrack(mimeType string, clockRate uint32, channels uint16) *fakeRemoteTrack {
+	return &fakeRemoteTrack{
+		codec: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: clockRate, Channels: channels},
+		},
+		pkts:     make(chan *rtp.Packet, 64),
+		deadline: make(chan struct{}),
+	}
+}
+
+func (t *fakeRemoteTrack) Codec() webrtc.RTPCodecParameters {
+	return t.codec
+}
+
+func (t *fakeRemoteTrack) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	select {
+	case <-t.deadline:
+		return nil, nil, os.ErrDeadlineExceeded
+	case pkt, ok := <-t.pkts:
+		if !ok {
+			return nil, nil, io.EOF
+		}
+		return pkt, nil, nil
+	}
+}
+
+func (t *fakeRemoteTrack) SetReadDeadline(_ time.Time) error {
+	t.deadlineOnce.Do(func() { close(t.deadline) })
+	return nil
+}
+
+func (t *fakeRemoteTrack) write(seq uint16, ts uint32, marker bool, payload ...byte) {
+	t.pkts <- &rtp.Packet{
+		Header:  rtp.Header{SequenceNumber: seq, Timestamp: ts, Marker: marker},
+		Payload: payload,
+	}
+}
+
+// fakeVideoDecoder decodes frames into images as wide as the frame is long.
+type fakeVideoDecoder struct {
+	closed chan struct{}
+}
+
+func (d *fakeVideoDecoder) Decode(_ context.Context, data []byte) (image.Image, error) {
+	return image.NewGray(image.Rect(0, 0, len(data), 1)), nil
+}
+
+func (d *fakeVideoDecoder) Close() {
+	close(d.closed)
+}
+
+type fakeVideoDecoderFactory struct {
+	mimeType string
+	decoder  *fakeVideoDecoder
+}
+
+func (f *fakeVideoDecoderFactory) New(_ golog.Logger) (codec.VideoDecoder, error) {
+	return f.decoder, nil
+}
+
+func (f *fakeVideoDecoderFactory) MIMEType() string {
+	return f.mimeType
+}
+
+// fakeAudioDecoder decodes packets into chunks as long as the packet.
+type fakeAudioDecoder struct{}
+
+func (d *fakeAudioDecoder) Decode(_ context.Context, data []byte) (wave.Audio, error) {
+	return wave.NewInt16Interleaved(wave.ChunkInfo{Len: len(data), Channels: 2, SamplingRate: 48000}), nil
+}
+
+func (d *fakeAudioDecoder) Close() {}
+
+type fakeAudioDecoderFactory struct{}
+
+func (f *fakeAudioDecoderFactory) New(_, _ int, _ golog.Logger) (codec.AudioDecoder, error) {
+	return &fakeAudioDecoder{}, nil
+}
+
+func (f *fakeAudioDecoderFactory) MIMEType() string {
+	return webrtc.MimeTypeOpus
+}
+
+func TestVideoSourceFromTrack(t *testing.T) {
+	logger := golog.NewTestLogger(t)
+	ctx := context.Background()
+
+	t.Run("no decoder", func(t *testing.T) {
+		track := newFakeRemoteTrack(webrtc.MimeTypeH264, 90000, 0)
+		factory := &fakeVideoDecoderFactory{mimeType: webrtc.MimeTypeVP8}
+		_, err := newVideoSourceFromTrack(ctx, track, logger, []codec.VideoDecoderFactory{factory})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no video decoder")
+	})
+
+	t.Run("depacketizes and decodes", func(t *testing.T) {
+		track := newFakeRemoteTrack("video/VP8", 90000, 0)
+		decoder := &fakeVideoDecoder{closed: make(chan struct{})}
+		factory := &fakeVideoDecoderFactory{mimeType: "video/vp8", decoder: decoder}
+
+		// a frame of six bytes split across two packets followed by a frame of three bytes.
+		track.write(1, 100, false, 0x10, 1, 2, 3)
+		track.write(2, 100, true, 0x00, 4, 5, 6)
+		track.write(3, 200, true, 0x10, 7, 8, 9)
+
+		source, err := newVideoSourceFromTrack(ctx, track, logger, []codec.VideoDecoderFactory{factory})
+		test.That(t, err, test.ShouldBeNil)
+		props, err := source.(VideoPropertyProvider).MediaProperties(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, props, test.ShouldResemble, prop.Video{Width: 6, Height: 1})
+
+		img, release, err := ReadImage(ctx, source)
+		test.That(t, err, test.ShouldBeNil)
+		release()
+		test.That(t, img.Bounds().Dx(), test.ShouldEqual, 6)
+
+		test.That(t, source.Close(ctx), test.ShouldBeNil)
+		<-decoder.closed
+	})
+
+	t.Run("track ends before a frame", func(t *testing.T) {
+		track := newFakeRemoteTrack(webrtc.MimeTypeVP8, 90000, 0)
+		decoder := &fakeVideoDecoder{closed: make(chan struct{})}
+		factory := &fakeVideoDecoderFactory{mimeType: webrtc.MimeTypeVP8, decoder: decoder}
+		close(track.pkts)
+		_, err := newVideoSourceFromTrack(ctx, track, logger, []codec.VideoDecoderFactory{factory})
+		test.That(t, err, test.ShouldBeError, io.EOF)
+		<-decoder.closed
+	})
+}
+
+func TestAudioSourceFromTrack(t *testing.T) {
+	logger := golog.NewTestLogger(t)
+	ctx := context.Background()
+
+	track := newFakeRemoteTrack(webrtc.MimeTypeOpus, 48000, 2)
+	source, err := newAudioSourceFromTrack(track, logger, []codec.AudioDecoderFactory{&fakeAudioDecoderFactory{}})
+	test.That(t, err, test.ShouldBeNil)
+	props, err := source.(AudioPropertyProvider).MediaProperties(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, props, test.ShouldResemble, prop.Audio{ChannelCount: 2, SampleRate: 48000})
+
+	track.write(1, 0, true, 1)
+	track.write(2, 960, true, 1, 2)
+	track.write(3, 1920, true, 1, 2, 3)
+	close(track.pkts)
+
+	stream, err := source.Stream(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	for i := 1; i <= 3; i++ {
+		chunk, release, err := stream.Next(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, chunk.ChunkInfo().Len, test.ShouldEqual, i)
+		release()
+	}
+	_, _, err = stream.Next(ctx)
+	test.That(t, err, test.ShouldBeError, io.EOF)
+	test.That(t, stream.Close(ctx), test.ShouldBeNil)
+	test.That(t, source.Close(ctx), test.ShouldBeNil)
+}
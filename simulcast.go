@@ -0,0 +1,123 @@
+package gostream
+
+import (
+	"image"
+	"math"
+	"time"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/viamrobotics/gostream/codec"
+)
+
+// A SimulcastLayer is one of the encodings of the video of a simulcast stream. Each layer
+// is encoded from the same input frames and sent to peers as an encoding identified by its
+// RID, letting peers with less bandwidth pick a lower layer.
+type SimulcastLayer struct {
+	// RID identifies the layer to peers (e.g. "f", "h" or "q"). It must be unique within a stream.
+	RID string
+
+	// ScaleResolutionDownBy divides the width and height of input frames before they
+	// are encoded for this layer. Values below 1 keep the original resolution.
+	ScaleResolutionDownBy float64
+
+	// BitRate is the target bit rate, in bits per second, of this layer. If zero, the bit
+	// rate of the stream is divided by the square of ScaleResolutionDownBy and follows any
+	// changes to the bit rate of the stream.
+	BitRate int
+}
+
+// DefaultSimulcastLayers encode video at full, half and quarter resolution.
+var DefaultSimulcastLayers = []SimulcastLayer{
+	{RID: "f", ScaleResolutionDownBy: 1},
+	{RID: "h", ScaleResolutionDownBy: 2},
+	{RID: "q", ScaleResolutionDownBy: 4},
+}
+
+// A videoLayer encodes the video of a stream for a single track. Streams have one
+// layer unless they are simulcast.
+type videoLayer struct {
+	config SimulcastLayer
+	track  *trackLocalStaticSample
+
+	// the following are guarded by the encoderMu of the stream. There is an
+	// encoder for each codec that the layer is currently sent in.
+	encoders      map[string]*videoLayerEncoder
+	width, height int
+}
+
+// A videoLayerEncoder encodes a video layer in one codec.
+type videoLayerEncoder struct {
+	encoder codec.VideoEncoder
+	stale   bool
+
+	// keyFrameRequested is set when a frame of the encoder was dropped, which makes its
+	// next frame a key frame at most once every minKeyFrameInterval, as of lastKeyFrame.
+	keyFrameRequested bool
+	lastKeyFrame      time.Time
+
+	// lastBitRateRebuild is when the encoder was last marked stale to change its bit rate.
+	lastBitRateRebuild time.Time
+}
+
+func newVideoLayer(config SimulcastLayer, track *trackLocalStaticSample) *videoLayer {
+	return &videoLayer{config: config, track: track, encoders: map[string]*videoLayerEncoder{}}
+}
+
+// scaleDownBy returns how much the layer divides the resolution of input frames by.
+func (l *videoLayer) scaleDownBy() float64 {
+	return math.Max(1, l.config.ScaleResolutionDownBy)
+}
+
+// bitRate returns the target bit rate of the layer given the bit rate of the stream.
+func (l *videoLayer) bitRate(streamBitRate int) int {
+	if l.config.BitRate != 0 {
+		return l.config.BitRate
+	}
+	scale := l.scaleDownBy()
+	return int(float64(streamBitRate) / (scale * scale))
+}
+
+// scale resizes the image to the resolution of the layer. Dimensions are kept even
+// since most encoders subsample chroma.
+func (l *videoLayer) scale(img image.Image) image.Image {
+	scale := l.scaleDownBy()
+	if scale == 1 {
+		return img
+	}
+	bounds := img.Bounds()
+	width := int(float64(bounds.Dx())/scale) &^ 1
+	height := int(float64(bounds.Dy())/scale) &^ 1
+	if width < 2 {
+		width = 2
+	}
+	if height < 2 {
+		height = 2
+	}
+	return imaging.Resize(img, width, height, imaging.NearestNeighbor)
+}
+
+// encoderFor returns the encoder of the layer for the given MIME type, adding a
+// stale one if there is none yet. It assumes the encoderMu of the stream is held.
+func (l *videoLayer) encoderFor(mimeType string) *videoLayerEncoder {
+	enc, ok := l.encoders[mimeType]
+	if !ok {
+		enc = &videoLayerEncoder{stale: true}
+		l.encoders[mimeType] = enc
+	}
+	return enc
+}
+
+// closeUnless closes the encoders of the layer except for the given MIME types. It
+// assumes the encoderMu of the stream is held.
+func (l *videoLayer) closeUnless(mimeTypes ...string) {
+	for mimeType, enc := range l.encoders {
+		if containsString(mimeTypes, mimeType) {
+			continue
+		}
+		if enc.encoder != nil {
+			enc.encoder.Close()
+		}
+		delete(l.encoders, mimeType)
+	}
+}
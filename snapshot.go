@@ -0,0 +1,277 @@
+package gostream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"go.viam.com/utils"
+)
+
+// The image formats that snapshots can be encoded in.
+const (
+	SnapshotMIMETypeJPEG = "image/jpeg"
+	SnapshotMIMETypePNG  = "image/png"
+)
+
+// snapshotJPEGQuality is the quality that JPEG snapshots are encoded at.
+const snapshotJPEGQuality = 90
+
+// A videoSourceAttacher is a stream that can read from the video source streamed to it.
+type videoSourceAttacher interface {
+	// attachVideoSource makes the stream read its video frames from the given video source,
+	// which is streamed to it with the given context, until the returned function is called.
+	attachVideoSource(ctx context.Context, source VideoSource) func()
+}
+
+func (bs *basicStream) attachVideoSource(ctx context.Context, source VideoSource) func() {
+	bs.videoSourceMu.Lock()
+	defer bs.videoSourceMu.Unlock()
+	bs.videoSource = source
+	bs.videoSourceMIMEType = MIMETypeHint(ctx, "")
+	return func() {
+		bs.videoSourceMu.Lock()
+		defer bs.videoSourceMu.Unlock()
+		if bs.videoSource == source {
+			bs.videoSource = nil
+		}
+	}
+}
+
+// videoFrames returns a stream of the video frames of the stream. If a video source is being
+// streamed to the stream, the frames are read from it, along with the stream, so that the
+// stream does not need to be started. Otherwise, they are copies of the input frames taken
+// for encoding while the stream is started.
+func (bs *basicStream) videoFrames(ctx context.Context) (VideoStream, error) {
+	if len(bs.videoEncoderFactories) == 0 {
+		return nil, errors.New("no video in stream")
+	}
+	bs.videoSourceMu.Lock()
+	source, mimeType := bs.videoSource, bs.videoSourceMIMEType
+	bs.videoSourceMu.Unlock()
+	if source != nil {
+		// the same MIME type hint shares reads with the stream
+		return source.Stream(WithMIMETypeHint(ctx, mimeType))
+	}
+	return bs.frameTaps.tap(), nil
+}
+
+// closeWhenDone closes the video stream once the context is done or the returned function is
+// called, which then waits for it to be closed. Streams of a source only return from Next once
+// the source produces, so a source that has stopped producing needs its stream to be closed.
+func closeWhenDone(ctx context.Context, stream VideoStream) func() {
+	done := make(chan struct{})
+	closed := make(chan struct{})
+	utils.PanicCapturingGo(func() {
+		defer close(closed)
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		utils.UncheckedError(stream.Close(ctx))
+	})
+	return func() {
+		close(done)
+		<-closed
+	}
+}
+
+// frameTaps hands copies of the input frames of a stream to the taps waiting for one. Frames
+// are only copied while a tap is waiting.
+type frameTaps struct {
+	mu   sync.Mutex
+	taps map[*frameTap]struct{}
+}
+
+// tap returns a stream of copies of the input frames taken after each call to Next.
+func (ft *frameTaps) tap() *frameTap {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.taps == nil {
+		ft.taps = map[*frameTap]struct{}{}
+	}
+	tap := &frameTap{taps: ft, frames: make(chan MediaReleasePair[image.Image], 1)}
+	ft.taps[tap] = struct{}{}
+	return tap
+}
+
+// offer gives a copy of the input frame to each waiting tap.
+func (ft *frameTaps) offer(frame MediaReleasePair[image.Image]) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	var copied image.Image
+	for tap := range ft.taps {
+		if !tap.waiting {
+			continue
+		}
+		if copied == nil {
+			copied = copyImage(nil, frame.Media)
+		}
+		tap.waiting = false
+		tap.frames <- MediaReleasePair[image.Image]{Media: copied, Timestamp: frame.Timestamp}
+	}
+}
+
+// A frameTap is a stream of copies of the input frames of a stream. The copies are shared by
+// taps, so they must not be modified, and need no release.
+type frameTap struct {
+	taps *frameTaps
+	// waiting is guarded by the mutex of taps. A frame is only sent to frames while it is set.
+	waiting bool
+	frames  chan MediaReleasePair[image.Image]
+}
+
+func (t *frameTap) Next(ctx context.Context) (image.Image, func(), error) {
+	img, release, _, err := t.NextTimestamped(ctx)
+	return img, release, err
+}
+
+func (t *frameTap) NextTimestamped(ctx context.Context) (image.Image, func(), time.Time, error) {
+	t.taps.mu.Lock()
+	t.waiting = true
+	t.taps.mu.Unlock()
+	select {
+	case frame := <-t.frames:
+		return frame.Media, nil, frame.Timestamp, nil
+	case <-ctx.Done():
+		t.taps.mu.Lock()
+		defer t.taps.mu.Unlock()
+		t.waiting = false
+		// a frame sent meanwhile would be stale by the next call
+		select {
+		case <-t.frames:
+		default:
+		}
+		return nil, nil, time.Time{}, ctx.Err()
+	}
+}
+
+func (t *frameTap) Close(ctx context.Context) error {
+	t.taps.mu.Lock()
+	defer t.taps.mu.Unlock()
+	delete(t.taps.taps, t)
+	return nil
+}
+
+// copyImage returns a copy of src, reusing the memory of dst if it is an earlier copy of an
+// image of the same type. Images of types without pixel buffers are copied as RGBA.
+func copyImage(dst, src image.Image) image.Image {
+	switch src := src.(type) {
+	case *image.YCbCr:
+		var reuse image.YCbCr
+		if d, ok := dst.(*image.YCbCr); ok {
+			reuse = *d
+		}
+		c := *src
+		c.Y = append(reuse.Y[:0], src.Y...)
+		c.Cb = append(reuse.Cb[:0], src.Cb...)
+		c.Cr = append(reuse.Cr[:0], src.Cr...)
+		return &c
+	case *image.NRGBA:
+		var reuse []uint8
+		if d, ok := dst.(*image.NRGBA); ok {
+			reuse = d.Pix
+		}
+		c := *src
+		c.Pix = append(reuse[:0], src.Pix...)
+		return &c
+	case *image.Gray:
+		var reuse []uint8
+		if d, ok := dst.(*image.Gray); ok {
+			reuse = d.Pix
+		}
+		c := *src
+		c.Pix = append(reuse[:0], src.Pix...)
+		return &c
+	default:
+		bounds := src.Bounds()
+		d, ok := dst.(*image.RGBA)
+		if !ok || d.Rect != bounds {
+			d = image.NewRGBA(bounds)
+		}
+		draw.Draw(d, bounds, src, bounds.Min, draw.Src)
+		return d
+	}
+}
+
+// checkSnapshotSize returns an error unless a frame of the given size can be resized to the
+// given width and height. Frames can only be scaled down so that a request cannot make the
+// resized image take up more memory than the frame itself.
+func checkSnapshotSize(frameSize image.Point, width, height int) error {
+	if width < 0 || height < 0 {
+		return errors.New("snapshot size cannot be negative")
+	}
+	if width > frameSize.X || height > frameSize.Y {
+		return fmt.Errorf("snapshot size %dx%d cannot be larger than the frame size %dx%d",
+			width, height, frameSize.X, frameSize.Y)
+	}
+	return nil
+}
+
+// encodeSnapshot encodes the image in the format of the given MIME type, which defaults to
+// JPEG, after resizing it to the given size. If only one of width or height is given, the
+// other keeps the aspect ratio of the image. It returns the image along with its MIME type.
+func encodeSnapshot(img image.Image, mimeType string, width, height int) ([]byte, string, error) {
+	if mimeType == "" {
+		mimeType = SnapshotMIMETypeJPEG
+	}
+	mimeType = strings.ToLower(mimeType)
+	if mimeType != SnapshotMIMETypeJPEG && mimeType != SnapshotMIMETypePNG {
+		return nil, "", fmt.Errorf("cannot encode snapshots as %q", mimeType)
+	}
+	if err := checkSnapshotSize(img.Bounds().Size(), width, height); err != nil {
+		return nil, "", err
+	}
+	if width != 0 || height != 0 {
+		img = imaging.Resize(img, width, height, imaging.Linear)
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if mimeType == SnapshotMIMETypePNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: snapshotJPEGQuality})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mimeType, nil
+}
+
+func (bs *basicStream) LatestFrame(ctx context.Context) (image.Image, time.Time, error) {
+	bs.videoSourceMu.Lock()
+	hasSource := bs.videoSource != nil
+	bs.videoSourceMu.Unlock()
+	bs.mu.RLock()
+	started := bs.started
+	bs.mu.RUnlock()
+	if !hasSource && !started {
+		return nil, time.Time{}, fmt.Errorf("stream %q has no video source and is not started", bs.name)
+	}
+
+	frames, err := bs.videoFrames(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer closeWhenDone(ctx, frames)()
+	img, release, captured, err := nextTimestamped(ctx, frames)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if release != nil {
+		// the frame is shared with the other readers of the source until it is released
+		defer release()
+		img = copyImage(nil, img)
+	}
+	return img, captured, nil
+}
@@ -0,0 +1,258 @@
+package gostream
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/prop"
+	"go.viam.com/test"
+	"go.viam.com/utils"
+	"goji.io"
+	"goji.io/pat"
+)
+
+// testSnapshotFrame returns a white RGBA image of the given size whose first pixel has
+// less red.
+func testSnapshotFrame(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	img.Pix[0] = 0x80
+	return img
+}
+
+func TestCopyImage(t *testing.T) {
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	ycbcr.Y[0] = 1
+	ycbcr.Cb[0] = 2
+	copied := copyImage(nil, ycbcr).(*image.YCbCr)
+	test.That(t, copied, test.ShouldResemble, ycbcr)
+	ycbcr.Y[0] = 3
+	test.That(t, copied.Y[0], test.ShouldEqual, 1)
+	// an earlier copy of an image of the same type is reused
+	recopied := copyImage(copied, ycbcr).(*image.YCbCr)
+	test.That(t, recopied.Y[0], test.ShouldEqual, 3)
+	test.That(t, &recopied.Y[0], test.ShouldEqual, &copied.Y[0])
+
+	rgba := testSnapshotFrame(4, 2)
+	copiedRGBA := copyImage(recopied, rgba).(*image.RGBA)
+	test.That(t, copiedRGBA.Pix, test.ShouldResemble, rgba.Pix)
+	test.That(t, &copiedRGBA.Pix[0], test.ShouldNotEqual, &rgba.Pix[0])
+	test.That(t, &copyImage(copiedRGBA, rgba).(*image.RGBA).Pix[0], test.ShouldEqual, &copiedRGBA.Pix[0])
+
+	// images without a pixel buffer are copied as RGBA
+	paletted := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Gray{Y: 0x40}})
+	copiedRGBA = copyImage(nil, paletted).(*image.RGBA)
+	test.That(t, copiedRGBA.Pix[:4], test.ShouldResemble, []uint8{0x40, 0x40, 0x40, 0xFF})
+}
+
+// streamTestVideoSource streams a video source of the given frame, captured at the given
+// time, to the stream until the test ends. It returns once the stream reads from the source.
+func streamTestVideoSource(t *testing.T, stream Stream, img image.Image, captured time.Time) {
+	t.Helper()
+	source := NewVideoSource(&timestampedImageSource{img: img, captured: captured}, prop.Video{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		utils.UncheckedError(StreamVideoSource(ctx, source, stream))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		test.That(t, source.Close(context.Background()), test.ShouldBeNil)
+	})
+	bs := stream.(*basicStream)
+	for i := 0; ; i++ {
+		bs.videoSourceMu.Lock()
+		attached := bs.videoSource == source
+		bs.videoSourceMu.Unlock()
+		if attached {
+			return
+		}
+		test.That(t, i, test.ShouldBeLessThan, 5000)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFrameTaps(t *testing.T) {
+	var taps frameTaps
+	first := taps.tap()
+	second := taps.tap()
+	rgba := testSnapshotFrame(4, 2)
+
+	// frames are only copied for taps waiting for one
+	taps.offer(MediaReleasePair[image.Image]{Media: rgba})
+	test.That(t, first.frames, test.ShouldHaveLength, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan image.Image)
+	go func() {
+		img, _, _, err := first.NextTimestamped(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		result <- img
+	}()
+	captured := time.Now()
+	var img image.Image
+	for img == nil {
+		taps.offer(MediaReleasePair[image.Image]{Media: rgba, Timestamp: captured})
+		select {
+		case img = <-result:
+		case <-time.After(time.Millisecond):
+		}
+	}
+	test.That(t, img.(*image.RGBA).Pix, test.ShouldResemble, rgba.Pix)
+	test.That(t, &img.(*image.RGBA).Pix[0], test.ShouldNotEqual, &rgba.Pix[0])
+	test.That(t, second.frames, test.ShouldHaveLength, 0)
+
+	// a tap that stops waiting does not keep a stale frame
+	cancel()
+	_, _, _, err := second.NextTimestamped(ctx)
+	test.That(t, err, test.ShouldBeError, context.Canceled)
+	taps.offer(MediaReleasePair[image.Image]{Media: rgba})
+	test.That(t, second.frames, test.ShouldHaveLength, 0)
+
+	test.That(t, first.Close(context.Background()), test.ShouldBeNil)
+	test.That(t, second.Close(context.Background()), test.ShouldBeNil)
+	test.That(t, taps.taps, test.ShouldBeEmpty)
+}
+
+func TestEncodeSnapshot(t *testing.T) {
+	img := testSnapshotFrame(40, 20)
+
+	data, mimeType, err := encodeSnapshot(img, "", 0, 0)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, mimeType, test.ShouldEqual, SnapshotMIMETypeJPEG)
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds(), test.ShouldResemble, img.Bounds())
+
+	// resizing keeps the aspect ratio unless both dimensions are given
+	data, mimeType, err = encodeSnapshot(img, "image/PNG", 10, 0)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, mimeType, test.ShouldEqual, SnapshotMIMETypePNG)
+	decoded, err = png.Decode(bytes.NewReader(data))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds().Size(), test.ShouldResemble, image.Pt(10, 5))
+	data, _, err = encodeSnapshot(img, SnapshotMIMETypePNG, 10, 10)
+	test.That(t, err, test.ShouldBeNil)
+	decoded, err = png.Decode(bytes.NewReader(data))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds().Size(), test.ShouldResemble, image.Pt(10, 10))
+
+	_, _, err = encodeSnapshot(img, "image/gif", 0, 0)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, _, err = encodeSnapshot(img, "", -1, 0)
+	test.That(t, err, test.ShouldNotBeNil)
+	// snapshots can only be scaled down
+	_, _, err = encodeSnapshot(img, "", 41, 0)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, _, err = encodeSnapshot(img, "", 0, 100000)
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestStreamLatestFrame(t *testing.T) {
+	factory := newFakeVideoEncoderFactory(false)
+	stream, err := NewStream(StreamConfig{Name: "camera", VideoEncoderFactory: factory, TargetFrameRate: 1000})
+	test.That(t, err, test.ShouldBeNil)
+	_, _, err = stream.LatestFrame(context.Background())
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// without a video source, the next input frame is taken while the stream is started
+	bindTestPeer(t, stream)
+	stream.Start()
+	input, err := stream.InputVideoFrames(prop.Video{})
+	test.That(t, err, test.ShouldBeNil)
+	img := testSnapshotFrame(4, 2)
+	captured := time.Now().Add(-time.Second)
+	type latestResult struct {
+		img       image.Image
+		timestamp time.Time
+		err       error
+	}
+	result := make(chan latestResult)
+	go func() {
+		var latest latestResult
+		latest.img, latest.timestamp, latest.err = stream.LatestFrame(context.Background())
+		result <- latest
+	}()
+	var latest latestResult
+	for latest.img == nil && latest.err == nil {
+		frame := testSnapshotFrame(4, 2)
+		input <- MediaReleasePair[image.Image]{
+			Media: frame,
+			// the input frame can be reused once it is released
+			Release:   func() { frame.Pix[0] = 0 },
+			Timestamp: captured,
+		}
+		<-factory.encodedFrames
+		select {
+		case latest = <-result:
+		default:
+		}
+	}
+	test.That(t, latest.err, test.ShouldBeNil)
+	test.That(t, latest.timestamp, test.ShouldEqual, captured)
+	test.That(t, latest.img.Bounds(), test.ShouldResemble, img.Bounds())
+	test.That(t, latest.img.(*image.RGBA).Pix[0], test.ShouldEqual, 0x80)
+	stream.Stop()
+
+	// a video source streamed to the stream is read from without starting the stream
+	_, _, err = stream.LatestFrame(context.Background())
+	test.That(t, err, test.ShouldNotBeNil)
+	streamTestVideoSource(t, stream, img, captured)
+	frame, timestamp, err := stream.LatestFrame(context.Background())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, timestamp, test.ShouldEqual, captured)
+	test.That(t, frame.(*image.RGBA).Pix, test.ShouldResemble, img.Pix)
+	test.That(t, &frame.(*image.RGBA).Pix[0], test.ShouldNotEqual, &img.Pix[0])
+	test.That(t, streamStarted(stream), test.ShouldBeFalse)
+}
+
+func TestStandaloneStreamServerSnapshot(t *testing.T) {
+	stream, err := NewStream(StreamConfig{Name: "camera", VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+	test.That(t, err, test.ShouldBeNil)
+	server, err := NewStandaloneStreamServer(0, golog.NewTestLogger(t), nil, stream)
+	test.That(t, err, test.ShouldBeNil)
+	mux := goji.NewMux()
+	mux.Handle(pat.Get("/snapshot/:stream"), server.(*standaloneStreamServer).snapshotHandler())
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	test.That(t, get("/snapshot/unknown").Code, test.ShouldEqual, http.StatusNotFound)
+	test.That(t, get("/snapshot/camera").Code, test.ShouldEqual, http.StatusServiceUnavailable)
+
+	captured := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	streamTestVideoSource(t, stream, testSnapshotFrame(40, 20), captured)
+	recorder := get("/snapshot/camera")
+	test.That(t, recorder.Code, test.ShouldEqual, http.StatusOK)
+	test.That(t, recorder.Header().Get("Content-Type"), test.ShouldEqual, SnapshotMIMETypeJPEG)
+	test.That(t, recorder.Header().Get("Last-Modified"), test.ShouldEqual, "Mon, 02 Jan 2023 03:04:05 GMT")
+	_, err = jpeg.Decode(recorder.Body)
+	test.That(t, err, test.ShouldBeNil)
+
+	recorder = get("/snapshot/camera?format=png&height=10")
+	test.That(t, recorder.Code, test.ShouldEqual, http.StatusOK)
+	test.That(t, recorder.Header().Get("Content-Type"), test.ShouldEqual, SnapshotMIMETypePNG)
+	decoded, err := png.Decode(recorder.Body)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds().Size(), test.ShouldResemble, image.Pt(20, 10))
+
+	test.That(t, get("/snapshot/camera?format=gif").Code, test.ShouldEqual, http.StatusBadRequest)
+	test.That(t, get("/snapshot/camera?width=-1").Code, test.ShouldEqual, http.StatusBadRequest)
+	test.That(t, get("/snapshot/camera?width=big").Code, test.ShouldEqual, http.StatusBadRequest)
+	test.That(t, get("/snapshot/camera?width=100000&height=100000").Code, test.ShouldEqual, http.StatusBadRequest)
+}
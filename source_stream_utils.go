@@ -9,9 +9,9 @@ import (
 
 // StreamVideoSource streams the given video source to the stream forever until context signals cancellation.
 func StreamVideoSource(ctx context.Context, vs VideoSource, stream Stream) error {
-	return streamMediaSource(ctx, vs, stream, func(ctx context.Context, frameErr error) {
+	return streamVideoSource(ctx, vs, stream, func(ctx context.Context, frameErr error) {
 		golog.Global().Debugw("error getting frame", "error", frameErr)
-	}, stream.InputVideoFrames)
+	})
 }
 
 // StreamAudioSource streams the given video source to the stream forever until context signals cancellation.
@@ -21,12 +21,20 @@ func StreamAudioSource(ctx context.Context, as AudioSource, stream Stream) error
 	}, stream.InputAudioChunks)
 }
 
+// StreamEncodedVideoSource streams the given encoded video source to the stream forever until
+// context signals cancellation.
+func StreamEncodedVideoSource(ctx context.Context, vs EncodedVideoSource, stream Stream) error {
+	return streamMediaSource(ctx, vs, stream, func(ctx context.Context, frameErr error) {
+		golog.Global().Debugw("error getting encoded frame", "error", frameErr)
+	}, stream.InputEncodedVideo)
+}
+
 // StreamVideoSourceWithErrorHandler streams the given video source to the stream forever
 // until context signals cancellation, frame errors are sent via the error handler.
 func StreamVideoSourceWithErrorHandler(
 	ctx context.Context, vs VideoSource, stream Stream, errHandler ErrorHandler,
 ) error {
-	return streamMediaSource(ctx, vs, stream, errHandler, stream.InputVideoFrames)
+	return streamVideoSource(ctx, vs, stream, errHandler)
 }
 
 // StreamAudioSourceWithErrorHandler streams the given audio source to the stream forever
@@ -37,6 +45,16 @@ func StreamAudioSourceWithErrorHandler(
 	return streamMediaSource(ctx, as, stream, errHandler, stream.InputAudioChunks)
 }
 
+// streamVideoSource streams the video source to the stream, which reads its snapshots from
+// the source meanwhile.
+func streamVideoSource(ctx context.Context, vs VideoSource, stream Stream, errHandler ErrorHandler) error {
+	if attacher, ok := stream.(videoSourceAttacher); ok {
+		detach := attacher.attachVideoSource(ctx, vs)
+		defer detach()
+	}
+	return streamMediaSource(ctx, vs, stream, errHandler, stream.InputVideoFrames)
+}
+
 // streamMediaSource will stream a source of media forever to the stream until the given context tells it to cancel.
 func streamMediaSource[T, U any](
 	ctx context.Context,
@@ -81,7 +99,7 @@ func streamMediaSource[T, U any](
 				return nil
 			default:
 			}
-			media, release, err := mediaStream.Next(ctx)
+			media, release, captured, err := nextTimestamped(ctx, mediaStream)
 			if err != nil {
 				continue
 			}
@@ -90,7 +108,7 @@ func streamMediaSource[T, U any](
 				return ctx.Err()
 			case <-readyCtx.Done():
 				return nil
-			case input <- MediaReleasePair[T]{media, release}:
+			case input <- MediaReleasePair[T]{Media: media, Release: release, Timestamp: captured}:
 			}
 		}
 	}
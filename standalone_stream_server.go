@@ -8,10 +8,14 @@ import (
 	"net/http"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"sync"
 
 	"github.com/edaniels/golog"
 	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/multierr"
 	"go.viam.com/utils"
 	"go.viam.com/utils/rpc"
@@ -41,6 +45,9 @@ type standaloneStreamServer struct {
 	opts                    StandaloneStreamServerOptions
 	logger                  golog.Logger
 	activeBackgroundWorkers sync.WaitGroup
+
+	mjpegMu           sync.Mutex
+	mjpegBroadcasters map[string]*mjpegBroadcaster
 }
 
 // NewStandaloneStreamServer returns a server that will run on the given port and initially starts
@@ -61,10 +68,11 @@ func NewStandaloneStreamServer(
 		return nil, err
 	}
 	return &standaloneStreamServer{
-		port:         port,
-		streamServer: streamServer,
-		opts:         sOpts,
-		logger:       logger,
+		port:              port,
+		streamServer:      streamServer,
+		opts:              sOpts,
+		logger:            logger,
+		mjpegBroadcasters: map[string]*mjpegBroadcaster{},
 	}, nil
 }
 
@@ -146,12 +154,21 @@ func (ss *standaloneStreamServer) Start(ctx context.Context) error {
 		}
 	})
 	mux.Handle(pat.Get("/static/*"), http.StripPrefix("/static", http.FileServer(http.Dir(filepath.Join(thisDirPath, "frontend/dist")))))
+	if ss.opts.metrics {
+		mux.Handle(pat.Get("/metrics"), ss.metricsHandler())
+	}
+	mux.Handle(pat.Get("/snapshot/:stream"), ss.snapshotHandler())
+	// MJPEG responses last until their clients go away, so they are ended on shutdown
+	mjpegCtx, mjpegCancel := context.WithCancel(context.Background())
+	mux.Handle(pat.Get("/mjpeg/:stream"), ss.mjpegHandler(mjpegCtx))
 	mux.Handle(pat.New("/*"), rpcServer.GRPCHandler())
 
 	httpServer, err := utils.NewPlainTextHTTP2Server(mux)
 	if err != nil {
+		mjpegCancel()
 		return err
 	}
+	httpServer.RegisterOnShutdown(mjpegCancel)
 	httpServer.Addr = listener.Addr().String()
 	ss.httpServer = httpServer
 
@@ -179,6 +196,78 @@ func (ss *standaloneStreamServer) Start(ctx context.Context) error {
 	return nil
 }
 
+// metricsHandler returns a handler serving the metrics of the streams of the server, along
+// with those of the process, for prometheus to scrape.
+func (ss *standaloneStreamServer) metricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		newMetricsCollector(ss.streamServer.(*streamServer)),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// snapshotHandler serves the most recent video frame of the stream named in the path as an
+// image. The format query parameter is either jpeg, the default, or png, while the width and
+// height parameters resize the image as in the GetSnapshot RPC, which can only scale it down.
+func (ss *standaloneStreamServer) snapshotHandler() http.Handler {
+	streamServer := ss.streamServer.(*streamServer)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := pat.Param(r, "stream")
+		stream, ok := streamServer.namedStream(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no stream for %q", name), http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+		var mimeType string
+		switch format := query.Get("format"); format {
+		case "", "jpeg", "jpg":
+			mimeType = SnapshotMIMETypeJPEG
+		case "png":
+			mimeType = SnapshotMIMETypePNG
+		default:
+			http.Error(w, fmt.Sprintf("unknown snapshot format %q", format), http.StatusBadRequest)
+			return
+		}
+		var size [2]int
+		for i, param := range []string{"width", "height"} {
+			value := query.Get(param)
+			if value == "" {
+				continue
+			}
+			var err error
+			if size[i], err = strconv.Atoi(value); err != nil || size[i] < 0 {
+				http.Error(w, fmt.Sprintf("invalid snapshot %s %q", param, value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		img, captured, err := stream.LatestFrame(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err := checkSnapshotSize(img.Bounds().Size(), size[0], size[1]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, mimeType, err := encodeSnapshot(img, mimeType, size[0], size[1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", mimeType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Last-Modified", captured.UTC().Format(http.TimeFormat))
+		if _, err := w.Write(data); err != nil {
+			ss.logger.Debugw("error writing snapshot", "error", err)
+		}
+	})
+}
+
 func (ss *standaloneStreamServer) Stop(ctx context.Context) (err error) {
 	defer ss.activeBackgroundWorkers.Wait()
 	defer func() {
@@ -199,6 +288,9 @@ type StandaloneStreamServerOptions struct {
 	// allowReceive sets whether or not this stream server wants to receive
 	// media.
 	allowReceive bool
+	// metrics sets whether or not this stream server serves prometheus metrics
+	// at /metrics.
+	metrics bool
 }
 
 // StandaloneStreamServerOption configures how we set up the server.
@@ -246,3 +338,11 @@ func WithStandaloneAllowReceive(allowReceive bool) StandaloneStreamServerOption
 		o.allowReceive = allowReceive
 	})
 }
+
+// WithStandaloneMetrics returns an Option which sets whether or not this
+// stream server serves prometheus metrics at /metrics.
+func WithStandaloneMetrics(metrics bool) StandaloneStreamServerOption {
+	return newFuncOption(func(o *StandaloneStreamServerOptions) {
+		o.metrics = metrics
+	})
+}
@@ -0,0 +1,111 @@
+package gostream
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// StreamStats are counters describing the media of a stream since it was created.
+type StreamStats struct {
+	// VideoFramesIn is how many video frames the stream has been given, whether or not
+	// they were encoded.
+	VideoFramesIn uint64
+
+	// VideoFramesOut is how many encoded video frames have been written to peers. A frame
+	// encoded for more than one simulcast layer or codec is counted once for each.
+	VideoFramesOut uint64
+
+	// VideoFramesDropped is how many video frames were dropped by frame pacing, pipelined
+	// encoding, or for being encoded in the wrong codec.
+	VideoFramesDropped uint64
+
+	// AudioChunksIn is how many audio chunks the stream has been given.
+	AudioChunksIn uint64
+
+	// AudioChunksOut is how many encoded audio chunks have been written to peers.
+	AudioChunksOut uint64
+
+	// EncodeLatency is the average time spent encoding a video frame.
+	EncodeLatency time.Duration
+
+	// FrameRate is the rate, in frames per second, at which input video frames have been
+	// taken for encoding over the last second.
+	FrameRate float64
+
+	// PacketsSent and BytesSent count the RTP packets, and the bytes of their payloads,
+	// sent to every peer, including those no longer receiving the stream.
+	PacketsSent uint64
+	BytesSent   uint64
+
+	// PeerTracks describe what has been sent to the peers receiving the stream.
+	PeerTracks []PeerTrackStats
+
+	// Pipeline describes where time has been spent turning input frames into packets.
+	Pipeline PipelineStats
+}
+
+// PeerTrackStats describe what has been sent to one peer on one track of a stream. A peer
+// receiving both video and audio, or more than one simulcast layer, is described once for
+// each of them.
+type PeerTrackStats struct {
+	// SSRC identifies the RTP stream sent to the peer.
+	SSRC uint32
+
+	// Kind is whether the track is video or audio.
+	Kind webrtc.RTPCodecType
+
+	// RID is the simulcast layer of the track, if any.
+	RID string
+
+	// MIMEType is the codec that the peer receives the track in.
+	MIMEType string
+
+	// PacketsSent and BytesSent count the RTP packets, and the bytes of their payloads,
+	// sent to the peer.
+	PacketsSent uint64
+	BytesSent   uint64
+}
+
+// streamCounters count the media passing through a stream. They are updated atomically
+// since each is updated by a different goroutine.
+type streamCounters struct {
+	videoFramesIn      uint64
+	videoFramesOut     uint64
+	videoFramesDropped uint64
+	audioChunksIn      uint64
+	audioChunksOut     uint64
+}
+
+func (bs *basicStream) Stats() StreamStats {
+	pipeline := bs.pipelineStats.snapshot()
+	stats := StreamStats{
+		VideoFramesIn:  atomic.LoadUint64(&bs.counters.videoFramesIn),
+		VideoFramesOut: atomic.LoadUint64(&bs.counters.videoFramesOut),
+		VideoFramesDropped: atomic.LoadUint64(&bs.counters.videoFramesDropped) +
+			pipeline.Convert.Dropped + pipeline.Encode.Dropped + pipeline.Packetize.Dropped,
+		AudioChunksIn:  atomic.LoadUint64(&bs.counters.audioChunksIn),
+		AudioChunksOut: atomic.LoadUint64(&bs.counters.audioChunksOut),
+		FrameRate:      bs.FrameRate(),
+		Pipeline:       pipeline,
+	}
+	if pipeline.Encode.Frames != 0 {
+		stats.EncodeLatency = pipeline.Encode.Busy / time.Duration(pipeline.Encode.Frames)
+	}
+
+	tracks := make([]*trackLocalStaticSample, 0, len(bs.videoLayers)+1)
+	for _, layer := range bs.videoLayers {
+		tracks = append(tracks, layer.track)
+	}
+	if bs.audioTrackLocal != nil {
+		tracks = append(tracks, bs.audioTrackLocal)
+	}
+	for _, track := range tracks {
+		peerTracks, packetsSent, bytesSent := track.stats()
+		stats.PeerTracks = append(stats.PeerTracks, peerTracks...)
+		stats.PacketsSent += packetsSent
+		stats.BytesSent += bytesSent
+	}
+	return stats
+}
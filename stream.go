@@ -4,8 +4,11 @@ package gostream
 import (
 	"context"
 	"errors"
+	"fmt"
 	"image"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/edaniels/golog"
@@ -14,10 +17,13 @@ import (
 	_ "github.com/pion/mediadevices/pkg/driver/microphone"
 	"github.com/pion/mediadevices/pkg/prop"
 	"github.com/pion/mediadevices/pkg/wave"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
+	"go.uber.org/multierr"
 	"go.viam.com/utils"
 
 	"github.com/viamrobotics/gostream/codec"
+	"github.com/viamrobotics/gostream/internal/yuv"
 )
 
 // A Stream is sink that accepts any image frames for the purpose
@@ -39,6 +45,48 @@ type Stream interface {
 
 	InputAudioChunks(props prop.Audio) (chan<- MediaReleasePair[wave.Audio], error)
 
+	// InputEncodedVideo returns where to send video that is already encoded. Frames are
+	// sent to peers as they are, without being rate limited or re-encoded. It is only
+	// available if StreamConfig.EncodedVideoMIMEType is set.
+	InputEncodedVideo(props prop.Video) (chan<- MediaReleasePair[EncodedVideoFrame], error)
+
+	// SetVideoBitrate changes the target bit rate, in bits per second, of the video encoder.
+	// Encoders that cannot be retuned while running (those that do not implement
+	// codec.BitRateController, such as vpx) are restarted with the new bit rate before the
+	// next frame is encoded, so that frame is a key frame. Connected peers keep receiving on
+	// the same track either way.
+	SetVideoBitrate(bitRate int) error
+
+	// SetAudioBitrate changes the target bit rate, in bits per second, of the audio encoder.
+	// The audio encoder must support changing its bit rate while running.
+	SetAudioBitrate(bitRate int) error
+
+	// FrameRate returns the rate, in frames per second, at which input video frames have
+	// been taken for encoding over the last second.
+	FrameRate() float64
+
+	// PipelineStats returns where time has been spent turning input frames into packets
+	// sent to peers.
+	PipelineStats() PipelineStats
+
+	// Stats returns counters describing the media of the stream and what has been sent
+	// to each of its peers.
+	Stats() StreamStats
+
+	// SaveEvent saves the media kept by the event buffer of the stream (see
+	// StreamConfig.EventBuffer), and the media that follows it for the given duration, to
+	// a file and returns its path once the file is finalized. Media already saved by an
+	// earlier event is not saved again. If an event is already being saved, it is extended
+	// to last the given duration from now instead. The file is finalized early if the
+	// stream stops. If ctx is done first, SaveEvent returns without waiting for the file.
+	SaveEvent(ctx context.Context, after time.Duration) (string, error)
+
+	// LatestFrame returns a copy of the latest video frame of the stream along with when it
+	// was captured. The frame is read from the video source streamed to the stream (see
+	// StreamVideoSource) if there is one, in which case the stream need not be started.
+	// Otherwise, it is the next input frame taken for encoding.
+	LatestFrame(ctx context.Context) (image.Image, time.Time, error)
+
 	// Stop stops further processing of frames.
 	Stop()
 }
@@ -54,6 +102,24 @@ type internalStream interface {
 type MediaReleasePair[T any] struct {
 	Media   T
 	Release func()
+
+	// Timestamp is when the media was captured. It determines the RTP timestamps of
+	// the media sent to peers. If it is zero, the time the stream receives the media
+	// is used instead.
+	Timestamp time.Time
+}
+
+// encodedMedia is encoded media along with when it was captured.
+type encodedMedia struct {
+	data      []byte
+	timestamp time.Time
+	mimeType  string
+
+	// queuedAt is when the media was queued to be written. It is only set for video.
+	queuedAt time.Time
+
+	// layer is the video layer that the media was encoded for. It is unset for audio.
+	layer *videoLayer
 }
 
 // NewStream returns a newly configured stream that can begin to handle
@@ -63,12 +129,30 @@ func NewStream(config StreamConfig) (Stream, error) {
 	if logger == nil {
 		logger = golog.Global()
 	}
-	if config.VideoEncoderFactory == nil && config.AudioEncoderFactory == nil {
+	videoEncoderFactories := config.VideoEncoderFactories
+	if config.VideoEncoderFactory != nil {
+		videoEncoderFactories = append([]codec.VideoEncoderFactory{config.VideoEncoderFactory}, videoEncoderFactories...)
+	}
+	audioEncoderFactories := config.AudioEncoderFactories
+	if config.AudioEncoderFactory != nil {
+		audioEncoderFactories = append([]codec.AudioEncoderFactory{config.AudioEncoderFactory}, audioEncoderFactories...)
+	}
+	if len(videoEncoderFactories) == 0 && len(audioEncoderFactories) == 0 && config.EncodedVideoMIMEType == "" {
 		return nil, errors.New("at least one audio or video encoder factory must be set")
 	}
 	if config.TargetFrameRate == 0 {
 		config.TargetFrameRate = codec.DefaultKeyFrameInterval
 	}
+	switch config.FramePacing {
+	case "":
+		config.FramePacing = FramePacingFixed
+	case FramePacingFixed, FramePacingAdaptive:
+	default:
+		return nil, fmt.Errorf("unknown frame pacing %q", config.FramePacing)
+	}
+	if config.Pipeline.QueueSize <= 0 {
+		config.Pipeline.QueueSize = DefaultPipelineQueueSize
+	}
 
 	name := config.Name
 	if name == "" {
@@ -76,21 +160,38 @@ func NewStream(config StreamConfig) (Stream, error) {
 	}
 
 	var trackLocal *trackLocalStaticSample
-	if config.VideoEncoderFactory != nil {
-		trackLocal = newVideoTrackLocalStaticSample(
-			webrtc.RTPCodecCapability{MimeType: config.VideoEncoderFactory.MIMEType()},
-			"video",
-			name,
-		)
+	var videoLayers []*videoLayer
+	if len(videoEncoderFactories) != 0 {
+		if config.EncodedVideoMIMEType != "" {
+			return nil, errors.New("cannot have video encoder factories for already encoded video")
+		}
+		videoCodecs, err := codecCapabilities(videoEncoderFactories)
+		if err != nil {
+			return nil, err
+		}
+		videoLayers, err = newVideoLayers(videoCodecs, name, config.SimulcastLayers)
+		if err != nil {
+			return nil, err
+		}
+		trackLocal = videoLayers[0].track
+	} else if config.EncodedVideoMIMEType != "" {
+		var err error
+		videoLayers, err = newEncodedVideoLayers(config.EncodedVideoMIMEType, name, config.SimulcastLayers)
+		if err != nil {
+			return nil, err
+		}
+		trackLocal = videoLayers[0].track
+	} else if len(config.SimulcastLayers) != 0 {
+		return nil, errors.New("simulcast layers require a video encoder factory")
 	}
 
 	var audioTrackLocal *trackLocalStaticSample
-	if config.AudioEncoderFactory != nil {
-		audioTrackLocal = newAudioTrackLocalStaticSample(
-			webrtc.RTPCodecCapability{MimeType: config.AudioEncoderFactory.MIMEType()},
-			"audio",
-			name,
-		)
+	if len(audioEncoderFactories) != 0 {
+		audioCodecs, err := codecCapabilities(audioEncoderFactories)
+		if err != nil {
+			return nil, err
+		}
+		audioTrackLocal = newAudioTrackLocalStaticSample(audioCodecs, "audio", name)
 	}
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
@@ -99,38 +200,166 @@ func NewStream(config StreamConfig) (Stream, error) {
 		config:           config,
 		streamingReadyCh: make(chan struct{}),
 
-		videoTrackLocal: trackLocal,
-		inputImageChan:  make(chan MediaReleasePair[image.Image]),
-		outputVideoChan: make(chan []byte),
+		videoEncoderFactories: videoEncoderFactories,
+		videoTrackLocal:       trackLocal,
+		videoLayers:           videoLayers,
+		inputImageChan:        make(chan MediaReleasePair[image.Image]),
+		inputEncodedVideoChan: make(chan MediaReleasePair[EncodedVideoFrame]),
+		outputVideoChan:       make(chan encodedMedia),
+		framePacer:            newFramePacer(config.TargetFrameRate),
 
-		audioTrackLocal: audioTrackLocal,
-		inputAudioChan:  make(chan MediaReleasePair[wave.Audio]),
-		outputAudioChan: make(chan []byte),
+		audioEncoderFactories: audioEncoderFactories,
+		audioTrackLocal:       audioTrackLocal,
+		inputAudioChan:        make(chan MediaReleasePair[wave.Audio]),
+		outputAudioChan:       make(chan encodedMedia),
+		audioEncoders:         map[string]codec.AudioEncoder{},
 
 		logger:            logger,
 		shutdownCtx:       ctx,
 		shutdownCtxCancel: cancelFunc,
 	}
+	if config.CongestionControl.Enabled && len(videoEncoderFactories) != 0 {
+		bs.congestionController = newCongestionController(
+			config.CongestionControl,
+			config.VideoEncoderOptions.WithDefaults().BitRate,
+			bs.retuneVideoBitRate,
+			bs.sentVideoPacket,
+			logger,
+		)
+	}
+	for _, layer := range videoLayers {
+		// new viewers cannot decode anything until they receive a key frame
+		layer.track.onBind = bs.requestKeyFrame
+	}
+	if trackLocal != nil && audioTrackLocal != nil {
+		// so that viewers can synchronize audio with video
+		trackLocal.clock.synced = true
+		audioTrackLocal.clock = trackLocal.clock
+	}
+	if config.EventBuffer.Duration != 0 {
+		var err error
+		bs.eventBuffer, err = newEventBuffer(bs, config.EventBuffer)
+		if err != nil {
+			return nil, err
+		}
+		// media is kept for events whether or not there are peers, so the stream is never
+		// released
+		bs.hold()
+	}
 
 	return bs, nil
 }
 
+// newEncodedVideoLayers returns the single video layer of a stream of already encoded video.
+// Its viewers wait for a key frame since the stream cannot force one.
+func newEncodedVideoLayers(mimeType, streamID string, simulcastLayers []SimulcastLayer) ([]*videoLayer, error) {
+	if len(simulcastLayers) != 0 {
+		return nil, errors.New("already encoded video cannot be simulcast")
+	}
+	codecCapability := webrtc.RTPCodecCapability{MimeType: mimeType}
+	if _, err := payloaderForCodec(codecCapability); err != nil {
+		return nil, fmt.Errorf("cannot send already encoded %q video: %w", mimeType, err)
+	}
+	track := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{codecCapability}, "video", streamID)
+	track.awaitKeyFrames = true
+	return []*videoLayer{newVideoLayer(SimulcastLayer{}, track)}, nil
+}
+
+// newVideoLayers returns a single video layer, or one per simulcast layer if there are any.
+func newVideoLayers(
+	codecs []webrtc.RTPCodecCapability,
+	streamID string,
+	simulcastLayers []SimulcastLayer,
+) ([]*videoLayer, error) {
+	if len(simulcastLayers) == 0 {
+		return []*videoLayer{newVideoLayer(SimulcastLayer{}, newVideoTrackLocalStaticSample(codecs, "video", streamID))}, nil
+	}
+
+	layers := make([]*videoLayer, 0, len(simulcastLayers))
+	seenRIDs := map[string]bool{}
+	for _, layerConfig := range simulcastLayers {
+		if layerConfig.RID == "" {
+			return nil, errors.New("simulcast layers must have a RID")
+		}
+		if seenRIDs[layerConfig.RID] {
+			return nil, fmt.Errorf("duplicate simulcast layer RID %q", layerConfig.RID)
+		}
+		seenRIDs[layerConfig.RID] = true
+		track := newSimulcastVideoTrackLocalStaticSample(codecs, "video", layerConfig.RID, streamID)
+		if len(layers) != 0 {
+			track.clock = layers[0].track.clock
+		}
+		layers = append(layers, newVideoLayer(layerConfig, track))
+	}
+	return layers, nil
+}
+
 type basicStream struct {
+	// counters is first so that it is 64-bit aligned for atomic operations.
+	counters streamCounters
+
 	mu               sync.RWMutex
 	name             string
 	config           StreamConfig
 	started          bool
 	streamingReadyCh chan struct{}
 
-	videoTrackLocal *trackLocalStaticSample
-	inputImageChan  chan MediaReleasePair[image.Image]
-	outputVideoChan chan []byte
-	videoEncoder    codec.VideoEncoder
+	// holds counts what keeps the stream started (see hold), such as its peers, its event
+	// buffer and the recorders attached to it that are recording.
+	holds int
+
+	// videoEncoderFactories are in order of preference. videoTrackLocal is the track
+	// of the first video layer.
+	videoEncoderFactories []codec.VideoEncoderFactory
+	videoTrackLocal       *trackLocalStaticSample
+	videoLayers           []*videoLayer
+	inputImageChan        chan MediaReleasePair[image.Image]
+	inputEncodedVideoChan chan MediaReleasePair[EncodedVideoFrame]
+
+	// encoded frames are packetized from outputVideoChan, or from packetizeQueues while
+	// a pipeline is running.
+	outputVideoChan chan encodedMedia
+	packetizeQueues *packetizeQueues
+
+	audioEncoderFactories []codec.AudioEncoderFactory
+	audioTrackLocal       *trackLocalStaticSample
+	inputAudioChan        chan MediaReleasePair[wave.Audio]
+	outputAudioChan       chan encodedMedia
+
+	// encoderMu guards the encoders and their bit rates since they can be changed
+	// while frames are being processed. Encoders are keyed by MIME type and only
+	// exist for the codecs that media is currently sent in.
+	encoderMu     sync.Mutex
+	audioEncoders map[string]codec.AudioEncoder
+	audioBitRate  int
 
-	audioTrackLocal *trackLocalStaticSample
-	inputAudioChan  chan MediaReleasePair[wave.Audio]
-	outputAudioChan chan []byte
-	audioEncoder    codec.AudioEncoder
+	// keyFrameMu guards key frame requests from viewers, which can arrive at
+	// any time and are throttled so that a burst of them only costs one key frame.
+	keyFrameMu        sync.Mutex
+	keyFrameRequested bool
+	lastKeyFrame      time.Time
+
+	// videoSourceMu guards the video source being streamed to the stream, if any, and the
+	// MIME type hint it is streamed with. Snapshots and MJPEG viewers read from it so that
+	// the stream does not need to be started for them.
+	videoSourceMu       sync.Mutex
+	videoSource         VideoSource
+	videoSourceMIMEType string
+
+	// frameTaps hand out copies of input frames when there is no video source to read from.
+	frameTaps frameTaps
+
+	// framePacer is only used by adaptive pacing while frameRateMeter measures the rate of
+	// input frames that are encoded regardless of pacing.
+	framePacer     *framePacer
+	frameRateMeter frameRateMeter
+	pipelineStats  pipelineStats
+
+	// congestionController is only set if congestion control is enabled.
+	congestionController *congestionController
+
+	// eventBuffer is only set if the stream keeps media for events.
+	eventBuffer *eventBuffer
 
 	// audioLatency specifies how long in between audio samples. This must be guaranteed
 	// by all streamed audio.
@@ -150,14 +379,19 @@ func (bs *basicStream) Name() string {
 func (bs *basicStream) Start() {
 	bs.mu.Lock()
 	defer bs.mu.Unlock()
+	bs.start()
+}
 
+// start starts processing frames if the stream is not already. It assumes mu is held.
+func (bs *basicStream) start() {
 	if bs.started {
 		return
 	}
 	bs.started = true
 	close(bs.streamingReadyCh)
-	bs.activeBackgroundWorkers.Add(4)
+	bs.activeBackgroundWorkers.Add(5)
 	utils.ManagedGo(bs.processInputFrames, bs.activeBackgroundWorkers.Done)
+	utils.ManagedGo(bs.processInputEncodedVideo, bs.activeBackgroundWorkers.Done)
 	utils.ManagedGo(bs.processOutputFrames, bs.activeBackgroundWorkers.Done)
 	utils.ManagedGo(bs.processInputAudioChunks, bs.activeBackgroundWorkers.Done)
 	utils.ManagedGo(bs.processOutputAudioChunks, bs.activeBackgroundWorkers.Done)
@@ -166,7 +400,32 @@ func (bs *basicStream) Start() {
 func (bs *basicStream) Stop() {
 	bs.mu.Lock()
 	defer bs.mu.Unlock()
+	bs.stop()
+}
+
+// hold starts the stream, if it is not already, and keeps it started until the returned
+// function is called. Stop still stops a held stream, which a later hold starts again.
+func (bs *basicStream) hold() func() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.holds++
+	bs.start()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			bs.mu.Lock()
+			defer bs.mu.Unlock()
+			bs.holds--
+			if bs.holds == 0 {
+				bs.stop()
+			}
+		})
+	}
+}
 
+// stop stops processing frames and finalizes what the encoded output of the stream is
+// recorded to. It assumes mu is held.
+func (bs *basicStream) stop() {
 	if !bs.started {
 		close(bs.streamingReadyCh)
 	}
@@ -174,13 +433,26 @@ func (bs *basicStream) Stop() {
 	bs.started = false
 	bs.shutdownCtxCancel()
 	bs.activeBackgroundWorkers.Wait()
-	if bs.audioEncoder != nil {
-		bs.audioEncoder.Close()
+	bs.encoderMu.Lock()
+	for _, layer := range bs.videoLayers {
+		layer.closeUnless()
+	}
+	bs.closeAudioEncodersUnless()
+	bs.encoderMu.Unlock()
+	if bs.videoTrackLocal != nil {
+		bs.videoTrackLocal.stopSinks()
+	}
+	if bs.audioTrackLocal != nil {
+		bs.audioTrackLocal.stopSinks()
+	}
+	if bs.eventBuffer != nil {
+		// events being saved are finalized once their sinks stop
+		bs.eventBuffer.activeBackgroundWorkers.Wait()
 	}
 
 	// reset
-	bs.outputVideoChan = make(chan []byte)
-	bs.outputAudioChan = make(chan []byte)
+	bs.outputVideoChan = make(chan encodedMedia)
+	bs.outputAudioChan = make(chan encodedMedia)
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	bs.shutdownCtx = ctx
 	bs.shutdownCtxCancel = cancelFunc
@@ -194,14 +466,25 @@ func (bs *basicStream) StreamingReady() (<-chan struct{}, context.Context) {
 }
 
 func (bs *basicStream) InputVideoFrames(props prop.Video) (chan<- MediaReleasePair[image.Image], error) {
-	if bs.config.VideoEncoderFactory == nil {
+	if bs.config.EncodedVideoMIMEType != "" {
+		return nil, errors.New("video of stream is already encoded")
+	}
+	if len(bs.videoEncoderFactories) == 0 {
 		return nil, errors.New("no video in stream")
 	}
+	bs.framePacer.setSourceFrameRate(float64(props.FrameRate))
 	return bs.inputImageChan, nil
 }
 
+func (bs *basicStream) InputEncodedVideo(props prop.Video) (chan<- MediaReleasePair[EncodedVideoFrame], error) {
+	if bs.config.EncodedVideoMIMEType == "" {
+		return nil, errors.New("no already encoded video in stream")
+	}
+	return bs.inputEncodedVideoChan, nil
+}
+
 func (bs *basicStream) InputAudioChunks(props prop.Audio) (chan<- MediaReleasePair[wave.Audio], error) {
-	if bs.config.AudioEncoderFactory == nil {
+	if len(bs.audioEncoderFactories) == 0 {
 		return nil, errors.New("no audio in stream")
 	}
 	bs.mu.Lock()
@@ -214,6 +497,207 @@ func (bs *basicStream) InputAudioChunks(props prop.Audio) (chan<- MediaReleasePa
 	return bs.inputAudioChan, nil
 }
 
+func (bs *basicStream) SetVideoBitrate(bitRate int) error {
+	return bs.setVideoBitRate(bitRate, 0, time.Now())
+}
+
+// minBitRateRebuildInterval is how often congestion control may rebuild an encoder that
+// cannot change its bit rate while running, since every rebuilt encoder starts with a key
+// frame, the largest of frames.
+const minBitRateRebuildInterval = 2 * time.Second
+
+// errBitRateRebuildThrottled is returned when an encoder was rebuilt for its bit rate too
+// recently to be rebuilt again.
+var errBitRateRebuildThrottled = errors.New("video encoder was rebuilt for its bit rate too recently")
+
+// retuneVideoBitRate is like SetVideoBitrate but rebuilds encoders that cannot change their
+// bit rate at most once every minBitRateRebuildInterval, measured up to now. If any encoder
+// could not be rebuilt yet, it returns errBitRateRebuildThrottled so that the bit rate can
+// be set again later.
+func (bs *basicStream) retuneVideoBitRate(bitRate int, now time.Time) error {
+	return bs.setVideoBitRate(bitRate, minBitRateRebuildInterval, now)
+}
+
+// setVideoBitRate changes the target bit rate of the video encoders, rebuilding those that
+// cannot change it if they were not last rebuilt for their bit rate within rebuildInterval
+// of now.
+func (bs *basicStream) setVideoBitRate(bitRate int, rebuildInterval time.Duration, now time.Time) error {
+	if bs.config.EncodedVideoMIMEType != "" {
+		return errors.New("cannot change bit rate of already encoded video")
+	}
+	if len(bs.videoEncoderFactories) == 0 {
+		return errors.New("no video in stream")
+	}
+	if bitRate <= 0 {
+		return fmt.Errorf("bit rate must be positive; got %d", bitRate)
+	}
+
+	bs.encoderMu.Lock()
+	defer bs.encoderMu.Unlock()
+	bs.config.VideoEncoderOptions.BitRate = bitRate
+	var throttled bool
+	for _, layer := range bs.videoLayers {
+		if layer.config.BitRate != 0 {
+			continue
+		}
+		for _, enc := range layer.encoders {
+			if enc.encoder == nil || enc.stale {
+				// the next encoder will be created with the new bit rate.
+				continue
+			}
+			if controller, ok := enc.encoder.(codec.BitRateController); ok {
+				err := controller.SetBitRate(layer.bitRate(bitRate))
+				if err == nil {
+					continue
+				}
+				bs.logger.Debugw("error setting video bit rate; rebuilding encoder", "error", err)
+			}
+			if now.Sub(enc.lastBitRateRebuild) < rebuildInterval {
+				throttled = true
+				continue
+			}
+			enc.stale = true
+			enc.lastBitRateRebuild = now
+		}
+	}
+	if throttled {
+		return errBitRateRebuildThrottled
+	}
+	return nil
+}
+
+func (bs *basicStream) SetAudioBitrate(bitRate int) error {
+	if len(bs.audioEncoderFactories) == 0 {
+		return errors.New("no audio in stream")
+	}
+	if bitRate <= 0 {
+		return fmt.Errorf("bit rate must be positive; got %d", bitRate)
+	}
+
+	bs.encoderMu.Lock()
+	defer bs.encoderMu.Unlock()
+	bs.audioBitRate = bitRate
+	// encoders created later will have the bit rate set once they are created.
+	var errs error
+	for mimeType, encoder := range bs.audioEncoders {
+		if err := bs.setAudioEncoderBitRate(encoder); err != nil {
+			errs = multierr.Combine(errs, fmt.Errorf("%s: %w", mimeType, err))
+		}
+	}
+	return errs
+}
+
+// setAudioEncoderBitRate applies the desired audio bit rate to the given audio
+// encoder. It assumes encoderMu is held.
+func (bs *basicStream) setAudioEncoderBitRate(encoder codec.AudioEncoder) error {
+	controller, ok := encoder.(codec.BitRateController)
+	if !ok {
+		return errors.New("audio encoder does not support changing its bit rate")
+	}
+	return controller.SetBitRate(bs.audioBitRate)
+}
+
+// handleRTCPFeedback acts upon RTCP packets sent by a peer receiving one of the stream's tracks.
+func (bs *basicStream) handleRTCPFeedback(sender *webrtc.RTPSender, pkts []rtcp.Packet) {
+	if bs.videoTrackLocal == nil || sender.Track() != bs.videoTrackLocal {
+		return
+	}
+	for _, pkt := range pkts {
+		switch pkt.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			bs.requestKeyFrame()
+		}
+	}
+	if bs.congestionController != nil {
+		bs.congestionController.handleRTCP(sender, pkts)
+	}
+}
+
+// minKeyFrameInterval is the shortest time allowed between key frames forced by
+// viewer requests. Requests arriving sooner are honored once it has passed.
+const minKeyFrameInterval = 200 * time.Millisecond
+
+// requestKeyFrame asks for the next encoded video frame to be a key frame.
+func (bs *basicStream) requestKeyFrame() {
+	bs.keyFrameMu.Lock()
+	defer bs.keyFrameMu.Unlock()
+	bs.keyFrameRequested = true
+}
+
+// requestEncoderKeyFrame asks for the next frame of the layer encoded in the codec of the
+// given MIME type to be a key frame, leaving the other layers and codecs as they are.
+func (bs *basicStream) requestEncoderKeyFrame(layer *videoLayer, mimeType string) {
+	bs.encoderMu.Lock()
+	defer bs.encoderMu.Unlock()
+	if enc, ok := layer.encoders[mimeType]; ok {
+		enc.keyFrameRequested = true
+	}
+}
+
+// forceRequestedKeyFrame makes the video encoders produce a key frame next if one
+// has been requested of them or of the whole stream. Encoders that cannot force key
+// frames are rebuilt instead. At most one key frame is forced every minKeyFrameInterval,
+// which is measured up to now.
+func (bs *basicStream) forceRequestedKeyFrame(now time.Time) {
+	bs.encoderMu.Lock()
+	defer bs.encoderMu.Unlock()
+	bs.keyFrameMu.Lock()
+	defer bs.keyFrameMu.Unlock()
+	all := bs.keyFrameRequested && now.Sub(bs.lastKeyFrame) >= minKeyFrameInterval
+	if all {
+		bs.keyFrameRequested = false
+		bs.lastKeyFrame = now
+	}
+
+	for _, layer := range bs.videoLayers {
+		for _, enc := range layer.encoders {
+			if !all && (!enc.keyFrameRequested || now.Sub(enc.lastKeyFrame) < minKeyFrameInterval) {
+				continue
+			}
+			enc.keyFrameRequested = false
+			enc.lastKeyFrame = now
+			if enc.encoder == nil || enc.stale {
+				// new encoders always start with a key frame
+				continue
+			}
+			if controller, ok := enc.encoder.(codec.KeyFrameController); ok {
+				err := controller.ForceKeyFrame()
+				if err == nil {
+					continue
+				}
+				bs.logger.Debugw("error forcing key frame; rebuilding encoder", "error", err)
+			}
+			enc.stale = true
+		}
+	}
+}
+
+// sentVideoPacket returns when the video packet with the given transport-wide sequence
+// number was sent to the peer with the given SSRC, if it is remembered.
+func (bs *basicStream) sentVideoPacket(ssrc webrtc.SSRC, sequenceNumber uint16) (sentPacket, bool) {
+	for _, layer := range bs.videoLayers {
+		if packet, ok := layer.track.sentPacket(ssrc, sequenceNumber); ok {
+			return packet, true
+		}
+	}
+	return sentPacket{}, false
+}
+
+// removeRTCPFeedbackSender forgets about a peer that no longer receives one of the stream's tracks.
+func (bs *basicStream) removeRTCPFeedbackSender(sender *webrtc.RTPSender) {
+	if bs.congestionController != nil {
+		bs.congestionController.removeSender(sender)
+	}
+}
+
+func (bs *basicStream) FrameRate() float64 {
+	return bs.frameRateMeter.rate(time.Now())
+}
+
+func (bs *basicStream) PipelineStats() PipelineStats {
+	return bs.pipelineStats.snapshot()
+}
+
 func (bs *basicStream) VideoTrackLocal() (webrtc.TrackLocal, bool) {
 	return bs.videoTrackLocal, bs.videoTrackLocal != nil
 }
@@ -222,68 +706,313 @@ func (bs *basicStream) AudioTrackLocal() (webrtc.TrackLocal, bool) {
 	return bs.audioTrackLocal, bs.audioTrackLocal != nil
 }
 
+// videoSimulcastTrackLocals returns the tracks of all video layers after the first.
+func (bs *basicStream) videoSimulcastTrackLocals() []webrtc.TrackLocal {
+	if len(bs.videoLayers) < 2 {
+		return nil
+	}
+	tracks := make([]webrtc.TrackLocal, 0, len(bs.videoLayers)-1)
+	for _, layer := range bs.videoLayers[1:] {
+		tracks = append(tracks, layer.track)
+	}
+	return tracks
+}
+
 func (bs *basicStream) processInputFrames() {
-	frameLimiterDur := time.Second / time.Duration(bs.config.TargetFrameRate)
+	if bs.config.Pipeline.Enabled {
+		bs.processInputFramesPipelined()
+		return
+	}
 	defer close(bs.outputVideoChan)
-	var dx, dy int
-	ticker := time.NewTicker(frameLimiterDur)
-	defer ticker.Stop()
+	ticker := bs.newFrameTicker()
+	if ticker != nil {
+		defer ticker.Stop()
+	}
+	var size image.Point
 	for {
-		select {
-		case <-bs.shutdownCtx.Done():
+		framePair, ok := bs.nextInputFrame(bs.shutdownCtx, ticker, &size)
+		if !ok {
 			return
-		default:
 		}
-		select {
-		case <-bs.shutdownCtx.Done():
+		frame, err := bs.convertFrame(framePair, time.Time{})
+		if err != nil {
+			bs.logger.Errorw("error converting frame", "error", err)
+			continue
+		}
+		if !bs.encodeFrame(frame) {
 			return
-		case <-ticker.C:
+		}
+	}
+}
+
+// processInputFramesPipelined converts, encodes and packetizes frames on separate goroutines
+// connected by queues that drop their oldest frames when full.
+func (bs *basicStream) processInputFramesPipelined() {
+	queueSize := bs.config.Pipeline.QueueSize
+	convertQueue := newDropOldestQueue(queueSize, func(queued queuedFrame) {
+		if queued.frame.Release != nil {
+			queued.frame.Release()
+		}
+		bs.pipelineStats.dropped(convertStage)
+	})
+	encodeQueue := newDropOldestQueue(queueSize, func(frame convertedFrame) {
+		frame.release()
+		bs.pipelineStats.dropped(encodeStage)
+	})
+
+	// the pipeline stops early if an encoder cannot be created.
+	pipelineCtx, pipelineCancel := context.WithCancel(bs.shutdownCtx)
+	bs.packetizeQueues = newPacketizeQueues(pipelineCtx, queueSize, func(dropped encodedMedia) {
+		// peers cannot decode the frames after a dropped one until the next key frame
+		// of its layer and codec
+		bs.requestEncoderKeyFrame(dropped.layer, dropped.mimeType)
+		bs.pipelineStats.dropped(packetizeStage)
+	}, bs.writeEncodedFrame)
+	var stages sync.WaitGroup
+	defer func() {
+		pipelineCancel()
+		stages.Wait()
+		bs.packetizeQueues.wait()
+		convertQueue.drain()
+		encodeQueue.drain()
+		close(bs.outputVideoChan)
+	}()
+
+	stages.Add(2)
+	utils.ManagedGo(func() {
+		for {
+			var queued queuedFrame
+			select {
+			case <-pipelineCtx.Done():
+				return
+			case queued = <-convertQueue.ch:
+			}
+			frame, err := bs.convertFrame(queued.frame, queued.queuedAt)
+			if err != nil {
+				bs.logger.Errorw("error converting frame", "error", err)
+				continue
+			}
+			frame.queuedAt = time.Now()
+			encodeQueue.push(frame)
+		}
+	}, stages.Done)
+	utils.ManagedGo(func() {
+		for {
+			var frame convertedFrame
+			select {
+			case <-pipelineCtx.Done():
+				return
+			case frame = <-encodeQueue.ch:
+			}
+			if !bs.encodeFrame(frame) {
+				pipelineCancel()
+				return
+			}
+		}
+	}, stages.Done)
+
+	ticker := bs.newFrameTicker()
+	if ticker != nil {
+		defer ticker.Stop()
+	}
+	var size image.Point
+	for {
+		framePair, ok := bs.nextInputFrame(pipelineCtx, ticker, &size)
+		if !ok {
+			return
+		}
+		convertQueue.push(queuedFrame{frame: framePair, queuedAt: time.Now()})
+	}
+}
+
+// newFrameTicker returns the ticker that paces input frames at the target frame rate, or nil
+// if frames are paced adaptively.
+func (bs *basicStream) newFrameTicker() *time.Ticker {
+	if bs.config.FramePacing == FramePacingAdaptive {
+		return nil
+	}
+	return time.NewTicker(time.Second / time.Duration(bs.config.TargetFrameRate))
+}
+
+// nextInputFrame waits for the next input frame to encode, which is timestamped with when it
+// is received if it has no timestamp. With a ticker, it waits for the next tick before taking
+// a frame; otherwise, it takes every frame and drops those the frame pacer does not accept.
+// It returns false once the context is done. size is the size of the last input frame and is
+// used to log changes.
+func (bs *basicStream) nextInputFrame(
+	ctx context.Context,
+	ticker *time.Ticker,
+	size *image.Point,
+) (MediaReleasePair[image.Image], bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return MediaReleasePair[image.Image]{}, false
+		default:
+		}
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				return MediaReleasePair[image.Image]{}, false
+			case <-ticker.C:
+			}
 		}
 		var framePair MediaReleasePair[image.Image]
 		select {
 		case framePair = <-bs.inputImageChan:
-		case <-bs.shutdownCtx.Done():
-			return
+		case <-ctx.Done():
+			return MediaReleasePair[image.Image]{}, false
 		}
 		if framePair.Media == nil {
 			continue
 		}
-		var initErr bool
-		func() {
+		atomic.AddUint64(&bs.counters.videoFramesIn, 1)
+		now := time.Now()
+		if ticker == nil && !bs.framePacer.accept(now) {
+			atomic.AddUint64(&bs.counters.videoFramesDropped, 1)
 			if framePair.Release != nil {
-				defer framePair.Release()
+				framePair.Release()
 			}
+			continue
+		}
+		bs.frameRateMeter.tick(now)
+		if framePair.Timestamp.IsZero() {
+			framePair.Timestamp = now
+		}
+		if newSize := framePair.Media.Bounds().Size(); newSize != *size {
+			*size = newSize
+			bs.logger.Infow("detected new image bounds", "width", size.X, "height", size.Y)
+		}
+		bs.frameTaps.offer(framePair)
+		return framePair, true
+	}
+}
 
-			bounds := framePair.Media.Bounds()
-			newDx, newDy := bounds.Dx(), bounds.Dy()
-			if bs.videoEncoder == nil || dx != newDx || dy != newDy {
-				dx, dy = newDx, newDy
-				bs.logger.Infow("detected new image bounds", "width", dx, "height", dy)
+// convertFrame scales the frame for each video layer. In a pipeline, it also converts the
+// scaled images to I420 so that encoders, which otherwise convert images themselves, do not
+// spend the time of the encode stage on it. The frame is released once the converted frame is.
+func (bs *basicStream) convertFrame(framePair MediaReleasePair[image.Image], queuedAt time.Time) (convertedFrame, error) {
+	start := time.Now()
+	frame := convertedFrame{
+		images:    make([]image.Image, 0, len(bs.videoLayers)),
+		timestamp: framePair.Timestamp,
+	}
+	for _, layer := range bs.videoLayers {
+		img := layer.scale(framePair.Media)
+		if !bs.config.Pipeline.Enabled {
+			frame.images = append(frame.images, img)
+			continue
+		}
+		img, release, err := yuv.ToI420(img)
+		frame.releases = append(frame.releases, release)
+		if err != nil {
+			frame.releases = append(frame.releases, framePair.Release)
+			frame.release()
+			return convertedFrame{}, err
+		}
+		frame.images = append(frame.images, img)
+	}
+	frame.releases = append(frame.releases, framePair.Release)
 
-				if err := bs.initVideoCodec(dx, dy); err != nil {
-					bs.logger.Error(err)
-					initErr = true
-					return
-				}
-			}
+	var waiting time.Duration
+	if !queuedAt.IsZero() {
+		waiting = start.Sub(queuedAt)
+	}
+	bs.pipelineStats.done(convertStage, waiting, time.Since(start))
+	return frame, nil
+}
 
-			// thread-safe because the size is static
-			encodedFrame, err := bs.videoEncoder.Encode(bs.shutdownCtx, framePair.Media)
-			if err != nil {
-				bs.logger.Error(err)
-				return
+// encodeFrame encodes the converted images of a frame for each video layer and sends them to be
+// packetized. It releases the frame and returns false if an encoder cannot be created.
+func (bs *basicStream) encodeFrame(frame convertedFrame) bool {
+	defer frame.release()
+	var waiting, busy time.Duration
+	if !frame.queuedAt.IsZero() {
+		waiting = time.Since(frame.queuedAt)
+	}
+	defer func() {
+		bs.pipelineStats.done(encodeStage, waiting, busy)
+	}()
+
+	bs.forceRequestedKeyFrame(time.Now())
+	for i, layer := range bs.videoLayers {
+		start := time.Now()
+		encodedFrames, err := bs.encodeLayerFrame(layer, frame.images[i], frame.timestamp)
+		busy += time.Since(start)
+		if err != nil {
+			bs.logger.Error(err)
+			return false
+		}
+		for _, encodedFrame := range encodedFrames {
+			if !bs.sendEncodedFrame(encodedFrame) {
+				return true
 			}
-			if encodedFrame != nil {
-				select {
-				case <-bs.shutdownCtx.Done():
-					return
-				case bs.outputVideoChan <- encodedFrame:
-				}
+		}
+	}
+	return true
+}
+
+// encodeLayerFrame encodes the image for the layer in every codec that the layer is sent in.
+// Errors encoding are logged; an error is only returned if an encoder cannot be created.
+func (bs *basicStream) encodeLayerFrame(layer *videoLayer, img image.Image, timestamp time.Time) ([]encodedMedia, error) {
+	bs.encoderMu.Lock()
+	defer bs.encoderMu.Unlock()
+
+	mimeTypes := activeMIMETypes(layer.track)
+	layer.closeUnless(mimeTypes...)
+	bounds := img.Bounds()
+	if layer.width != bounds.Dx() || layer.height != bounds.Dy() {
+		layer.width, layer.height = bounds.Dx(), bounds.Dy()
+		for _, enc := range layer.encoders {
+			enc.stale = true
+		}
+	}
+
+	var encodedFrames []encodedMedia
+	for _, mimeType := range mimeTypes {
+		enc := layer.encoderFor(mimeType)
+		if enc.stale {
+			if err := bs.initVideoCodec(layer, mimeType, enc); err != nil {
+				return nil, err
 			}
-		}()
-		if initErr {
-			return
+			enc.stale = false
+		}
+
+		// thread-safe because the size is static
+		encodedFrame, err := enc.encoder.Encode(bs.shutdownCtx, img)
+		if err != nil {
+			bs.logger.Errorw("error encoding frame", "mime_type", mimeType, "error", err)
+			continue
+		}
+		if encodedFrame != nil {
+			encodedFrames = append(encodedFrames, encodedMedia{
+				data:      encodedFrame,
+				timestamp: timestamp,
+				mimeType:  mimeType,
+				layer:     layer,
+			})
+		}
+	}
+	return encodedFrames, nil
+}
+
+// sendEncodedFrame sends the encoded frame to be packetized. In a pipeline, the oldest waiting
+// frame of its layer and codec is dropped if too many are waiting; otherwise this waits for the
+// frame to be taken. It returns false if the stream is shutting down.
+func (bs *basicStream) sendEncodedFrame(encodedFrame encodedMedia) bool {
+	encodedFrame.queuedAt = time.Now()
+	if bs.config.Pipeline.Enabled {
+		if bs.shutdownCtx.Err() != nil {
+			return false
 		}
+		bs.packetizeQueues.push(encodedFrame)
+		return true
+	}
+	select {
+	case <-bs.shutdownCtx.Done():
+		return false
+	case bs.outputVideoChan <- encodedFrame:
+		return true
 	}
 }
 
@@ -305,32 +1034,64 @@ func (bs *basicStream) processInputAudioChunks() {
 		if audioChunkPair.Media == nil {
 			continue
 		}
+		atomic.AddUint64(&bs.counters.audioChunksIn, 1)
+		if audioChunkPair.Timestamp.IsZero() {
+			audioChunkPair.Timestamp = time.Now()
+		}
 		var initErr bool
 		func() {
 			if audioChunkPair.Release != nil {
 				defer audioChunkPair.Release()
 			}
 
-			info := audioChunkPair.Media.ChunkInfo()
-			newSamplingRate, newChannels := info.SamplingRate, info.Channels
-			if samplingRate != newSamplingRate || channels != newChannels {
-				samplingRate, channels = newSamplingRate, newChannels
-				bs.logger.Infow("detected new audio info", "sampling_rate", samplingRate, "channels", channels)
+			encodedChunks, err := func() ([]encodedMedia, error) {
+				bs.encoderMu.Lock()
+				defer bs.encoderMu.Unlock()
 
-				bs.audioTrackLocal.setAudioLatency(bs.audioLatency)
-				if err := bs.initAudioCodec(samplingRate, channels); err != nil {
-					bs.logger.Error(err)
-					initErr = true
-					return
+				info := audioChunkPair.Media.ChunkInfo()
+				newSamplingRate, newChannels := info.SamplingRate, info.Channels
+				if samplingRate != newSamplingRate || channels != newChannels {
+					samplingRate, channels = newSamplingRate, newChannels
+					bs.logger.Infow("detected new audio info", "sampling_rate", samplingRate, "channels", channels)
+
+					bs.audioTrackLocal.setAudioLatency(bs.audioLatency)
+					bs.closeAudioEncodersUnless()
 				}
-			}
 
-			encodedChunk, ready, err := bs.audioEncoder.Encode(bs.shutdownCtx, audioChunkPair.Media)
+				mimeTypes := activeMIMETypes(bs.audioTrackLocal)
+				bs.closeAudioEncodersUnless(mimeTypes...)
+				var encodedChunks []encodedMedia
+				for _, mimeType := range mimeTypes {
+					encoder, ok := bs.audioEncoders[mimeType]
+					if !ok {
+						var err error
+						encoder, err = bs.initAudioCodec(mimeType, samplingRate, channels)
+						if err != nil {
+							initErr = true
+							return nil, err
+						}
+					}
+
+					encodedChunk, ready, err := encoder.Encode(bs.shutdownCtx, audioChunkPair.Media)
+					if err != nil {
+						bs.logger.Errorw("error encoding audio chunk", "mime_type", mimeType, "error", err)
+						continue
+					}
+					if ready && encodedChunk != nil {
+						encodedChunks = append(encodedChunks, encodedMedia{
+							data:      encodedChunk,
+							timestamp: audioChunkPair.Timestamp,
+							mimeType:  mimeType,
+						})
+					}
+				}
+				return encodedChunks, nil
+			}()
 			if err != nil {
 				bs.logger.Error(err)
 				return
 			}
-			if ready && encodedChunk != nil {
+			for _, encodedChunk := range encodedChunks {
 				select {
 				case <-bs.shutdownCtx.Done():
 					return
@@ -344,6 +1105,42 @@ func (bs *basicStream) processInputAudioChunks() {
 	}
 }
 
+// processInputEncodedVideo sends already encoded frames straight to peers. None are
+// dropped since later frames cannot be decoded without the ones before them.
+func (bs *basicStream) processInputEncodedVideo() {
+	for {
+		var framePair MediaReleasePair[EncodedVideoFrame]
+		select {
+		case framePair = <-bs.inputEncodedVideoChan:
+		case <-bs.shutdownCtx.Done():
+			return
+		}
+		atomic.AddUint64(&bs.counters.videoFramesIn, 1)
+		if framePair.Timestamp.IsZero() {
+			framePair.Timestamp = time.Now()
+		}
+		frame := framePair.Media
+		if frame.MIMEType == "" {
+			frame.MIMEType = bs.config.EncodedVideoMIMEType
+		}
+		if !strings.EqualFold(frame.MIMEType, bs.config.EncodedVideoMIMEType) {
+			atomic.AddUint64(&bs.counters.videoFramesDropped, 1)
+			bs.logger.Errorw(
+				"dropping encoded frame of unexpected codec",
+				"mime_type", frame.MIMEType,
+				"expected", bs.config.EncodedVideoMIMEType,
+			)
+		} else if err := bs.videoTrackLocal.WriteEncodedVideoFrame(frame, framePair.Timestamp); err != nil {
+			bs.logger.Errorw("error writing encoded frame", "error", err)
+		} else {
+			atomic.AddUint64(&bs.counters.videoFramesOut, 1)
+		}
+		if framePair.Release != nil {
+			framePair.Release()
+		}
+	}
+}
+
 func (bs *basicStream) processOutputFrames() {
 	framesSent := 0
 	for outputFrame := range bs.outputVideoChan {
@@ -353,9 +1150,7 @@ func (bs *basicStream) processOutputFrames() {
 		default:
 		}
 		now := time.Now()
-		if err := bs.videoTrackLocal.WriteData(outputFrame); err != nil {
-			bs.logger.Errorw("error writing frame", "error", err)
-		}
+		bs.writeEncodedFrame(outputFrame)
 		framesSent++
 		if Debug {
 			bs.logger.Debugw("wrote sample", "frames_sent", framesSent, "write_time", time.Since(now))
@@ -363,6 +1158,17 @@ func (bs *basicStream) processOutputFrames() {
 	}
 }
 
+// writeEncodedFrame packetizes the encoded frame and writes it to the peers of its layer.
+func (bs *basicStream) writeEncodedFrame(outputFrame encodedMedia) {
+	now := time.Now()
+	if err := outputFrame.layer.track.WriteData(outputFrame.mimeType, outputFrame.data, outputFrame.timestamp); err != nil {
+		bs.logger.Errorw("error writing frame", "error", err)
+	} else {
+		atomic.AddUint64(&bs.counters.videoFramesOut, 1)
+	}
+	bs.pipelineStats.done(packetizeStage, now.Sub(outputFrame.queuedAt), time.Since(now))
+}
+
 func (bs *basicStream) processOutputAudioChunks() {
 	chunksSent := 0
 	for outputChunk := range bs.outputAudioChan {
@@ -372,8 +1178,10 @@ func (bs *basicStream) processOutputAudioChunks() {
 		default:
 		}
 		now := time.Now()
-		if err := bs.audioTrackLocal.WriteData(outputChunk); err != nil {
+		if err := bs.audioTrackLocal.WriteData(outputChunk.mimeType, outputChunk.data, outputChunk.timestamp); err != nil {
 			bs.logger.Errorw("error writing audio chunk", "error", err)
+		} else {
+			atomic.AddUint64(&bs.counters.audioChunksOut, 1)
 		}
 		chunksSent++
 		if Debug {
@@ -382,17 +1190,56 @@ func (bs *basicStream) processOutputAudioChunks() {
 	}
 }
 
-func (bs *basicStream) initVideoCodec(width, height int) error {
+// initVideoCodec replaces the given encoder of the layer with one for the layer's
+// dimensions in the codec of the given MIME type. It assumes encoderMu is held.
+func (bs *basicStream) initVideoCodec(layer *videoLayer, mimeType string, enc *videoLayerEncoder) error {
+	if enc.encoder != nil {
+		enc.encoder.Close()
+		enc.encoder = nil
+	}
+	factory := findCodecFactory(bs.videoEncoderFactories, mimeType)
+	opts := bs.config.VideoEncoderOptions
+	streamBitRate := opts.WithDefaults().BitRate
+	opts.BitRate = layer.bitRate(streamBitRate)
+	opts.MaxBitRate = int(float64(opts.MaxBitRate) * float64(opts.BitRate) / float64(streamBitRate))
+	opts.FrameRate = bs.config.TargetFrameRate
+
 	var err error
-	bs.videoEncoder, err = bs.config.VideoEncoderFactory.New(width, height, bs.config.TargetFrameRate, bs.logger)
+	enc.encoder, err = factory.New(
+		layer.width,
+		layer.height,
+		bs.config.TargetFrameRate,
+		opts,
+		bs.logger,
+	)
 	return err
 }
 
-func (bs *basicStream) initAudioCodec(sampleRate, channelCount int) error {
-	var err error
-	if bs.audioEncoder != nil {
-		bs.audioEncoder.Close()
+// initAudioCodec creates an audio encoder for the given audio info in the codec of
+// the given MIME type. It assumes encoderMu is held.
+func (bs *basicStream) initAudioCodec(mimeType string, sampleRate, channelCount int) (codec.AudioEncoder, error) {
+	factory := findCodecFactory(bs.audioEncoderFactories, mimeType)
+	encoder, err := factory.New(sampleRate, channelCount, bs.audioLatency, bs.logger)
+	if err != nil {
+		return nil, err
+	}
+	bs.audioEncoders[mimeType] = encoder
+	if bs.audioBitRate != 0 {
+		if err := bs.setAudioEncoderBitRate(encoder); err != nil {
+			bs.logger.Errorw("error setting audio bit rate", "bit_rate", bs.audioBitRate, "error", err)
+		}
+	}
+	return encoder, nil
+}
+
+// closeAudioEncodersUnless closes the audio encoders except for the given MIME types.
+// It assumes encoderMu is held.
+func (bs *basicStream) closeAudioEncodersUnless(mimeTypes ...string) {
+	for mimeType, encoder := range bs.audioEncoders {
+		if containsString(mimeTypes, mimeType) {
+			continue
+		}
+		encoder.Close()
+		delete(bs.audioEncoders, mimeType)
 	}
-	bs.audioEncoder, err = bs.config.AudioEncoderFactory.New(sampleRate, channelCount, bs.audioLatency, bs.logger)
-	return err
 }
@@ -0,0 +1,58 @@
+package gostream
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// A codecFactory is any of the encoder or decoder factories of the codec package.
+type codecFactory interface {
+	MIMEType() string
+}
+
+// codecCapabilities returns the codecs produced by the given encoder factories, which
+// must each produce a different codec.
+func codecCapabilities[T codecFactory](factories []T) ([]webrtc.RTPCodecCapability, error) {
+	codecs := make([]webrtc.RTPCodecCapability, 0, len(factories))
+	for _, factory := range factories {
+		mimeType := factory.MIMEType()
+		for _, c := range codecs {
+			if strings.EqualFold(c.MimeType, mimeType) {
+				return nil, fmt.Errorf("multiple encoder factories for %q", mimeType)
+			}
+		}
+		codecs = append(codecs, webrtc.RTPCodecCapability{MimeType: mimeType})
+	}
+	return codecs, nil
+}
+
+// findCodecFactory returns the factory for the codec of the given MIME type.
+func findCodecFactory[T codecFactory](factories []T, mimeType string) T {
+	for _, factory := range factories {
+		if strings.EqualFold(factory.MIMEType(), mimeType) {
+			return factory
+		}
+	}
+	var zero T
+	return zero
+}
+
+// activeMIMETypes returns the MIME types that the media of the given track should be
+// encoded in: those of the codecs bound by peers or asked for by sinks such as recorders.
+// Nothing is encoded for a track that no one receives, such as a simulcast layer that no
+// peer has chosen; its encoders are created once a peer is bound, which also asks for a
+// key frame.
+func activeMIMETypes(track *trackLocalStaticSample) []string {
+	return track.boundMIMETypes()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
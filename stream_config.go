@@ -12,8 +12,48 @@ type StreamConfig struct {
 	VideoEncoderFactory codec.VideoEncoderFactory
 	AudioEncoderFactory codec.AudioEncoderFactory
 
+	// VideoEncoderFactories and AudioEncoderFactories are additional encoder factories,
+	// in order of preference after VideoEncoderFactory and AudioEncoderFactory, that
+	// each produce a different codec. All of the codecs are offered to peers and each
+	// peer receives media in the most preferred codec that it supports. Media is only
+	// encoded in the codecs that peers, recorders or event buffers are receiving.
+	VideoEncoderFactories []codec.VideoEncoderFactory
+	AudioEncoderFactories []codec.AudioEncoderFactory
+
+	// EncodedVideoMIMEType, if set, makes the video of the stream come already encoded in
+	// the codec of this MIME type (e.g. webrtc.MimeTypeH264) through InputEncodedVideo
+	// instead of being encoded by the stream. It cannot be set along with video encoder
+	// factories or simulcast layers.
+	EncodedVideoMIMEType string
+
+	// VideoEncoderOptions are passed to the VideoEncoderFactory each time a video
+	// encoder is created. The zero value keeps the encoder defaults.
+	VideoEncoderOptions codec.VideoEncoderOptions
+
+	// SimulcastLayers, if set, makes the stream send its video as one encoding per layer
+	// (see DefaultSimulcastLayers) instead of a single encoding. Layers should be ordered
+	// from highest to lowest quality since peers that do not support simulcast only
+	// receive the first. Layers that no peer receives are not encoded.
+	SimulcastLayers []SimulcastLayer
+
+	// CongestionControl configures adapting the video bit rate to the bandwidth available
+	// to connected peers.
+	CongestionControl CongestionControlConfig
+
+	// Pipeline configures encoding video in a pipeline so that slow stages lower the frame
+	// rate less.
+	Pipeline PipelineConfig
+
+	// EventBuffer configures keeping the latest encoded media of the stream in memory so
+	// that it can be saved with SaveEvent.
+	EventBuffer EventBufferConfig
+
 	// TargetFrameRate will hint to the stream to try to maintain this frame rate.
 	TargetFrameRate int
 
+	// FramePacing determines how input frames are paced to the target frame rate.
+	// Defaults to FramePacingFixed.
+	FramePacing FramePacing
+
 	Logger golog.Logger
 }
@@ -4,12 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"go.uber.org/multierr"
 	"go.viam.com/utils"
 	"go.viam.com/utils/rpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	streampb "github.com/viamrobotics/gostream/proto/stream/v1"
 )
@@ -62,6 +69,16 @@ type streamState struct {
 	mu          sync.Mutex
 	stream      Stream
 	activePeers int
+
+	// release lets the stream stop once it has no peers, if it is a heldStream.
+	release func()
+}
+
+// A heldStream is a stream that can be kept started by more than its peers, so that the
+// last of its peers going away only stops it if nothing else holds it.
+type heldStream interface {
+	// hold starts the stream and keeps it started until the returned function is called.
+	hold() func()
 }
 
 func (ss *streamState) Start() {
@@ -69,7 +86,11 @@ func (ss *streamState) Start() {
 	defer ss.mu.Unlock()
 	ss.activePeers++
 	if ss.activePeers == 1 {
-		ss.stream.Start()
+		if held, ok := ss.stream.(heldStream); ok {
+			ss.release = held.hold()
+		} else {
+			ss.stream.Start()
+		}
 	}
 }
 
@@ -79,11 +100,113 @@ func (ss *streamState) Stop() {
 	ss.activePeers--
 	if ss.activePeers <= 0 {
 		ss.activePeers = 0
-		ss.stream.Stop()
+		if ss.release != nil {
+			ss.release()
+			ss.release = nil
+		} else {
+			ss.stream.Stop()
+		}
 	}
 }
 
+// An rtcpFeedbackHandler is a stream that acts upon RTCP feedback sent by the peers
+// receiving its tracks.
+type rtcpFeedbackHandler interface {
+	handleRTCPFeedback(sender *webrtc.RTPSender, pkts []rtcp.Packet)
+	removeRTCPFeedbackSender(sender *webrtc.RTPSender)
+}
+
+// A simulcastStream is a stream whose video is also sent as additional simulcast encodings.
+type simulcastStream interface {
+	videoSimulcastTrackLocals() []webrtc.TrackLocal
+}
+
+// A senderReporter is a track that can describe what it has sent to a peer in RTCP
+// sender reports.
+type senderReporter interface {
+	senderReport(ssrc webrtc.SSRC, now time.Time) (*rtcp.SenderReport, bool)
+}
+
+// senderReportInterval is how often sender reports are sent to each peer.
+const senderReportInterval = time.Second
+
+// serveRTCP reads RTCP feedback from the peer receiving tracks through the given sender
+// and periodically sends it sender reports for the tracks, until the sender is stopped.
+// The tracks are those of each encoding of the sender, starting with its main track.
+// Reading is required even when nothing else is interested in the packets since
+// interceptors (e.g. NACK responders) only see packets that are read.
+func serveRTCP(
+	pc *webrtc.PeerConnection,
+	sender *webrtc.RTPSender,
+	tracks []webrtc.TrackLocal,
+	handler rtcpFeedbackHandler,
+) {
+	readRTCP := func(read func() ([]rtcp.Packet, interceptor.Attributes, error)) {
+		for {
+			pkts, _, err := read()
+			if err != nil {
+				return
+			}
+			if handler != nil {
+				handler.handleRTCPFeedback(sender, pkts)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	utils.PanicCapturingGo(func() {
+		defer cancel()
+		if handler != nil {
+			defer handler.removeRTCPFeedbackSender(sender)
+		}
+		readRTCP(sender.ReadRTCP)
+	})
+	for _, track := range tracks[1:] {
+		rid := track.RID()
+		utils.PanicCapturingGo(func() {
+			readRTCP(func() ([]rtcp.Packet, interceptor.Attributes, error) {
+				return sender.ReadSimulcastRTCP(rid)
+			})
+		})
+	}
+
+	reporters := map[string]senderReporter{}
+	for _, track := range tracks {
+		if reporter, ok := track.(senderReporter); ok {
+			reporters[track.RID()] = reporter
+		}
+	}
+	if len(reporters) == 0 {
+		return
+	}
+	utils.PanicCapturingGo(func() {
+		ticker := time.NewTicker(senderReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, encoding := range sender.GetParameters().Encodings {
+				reporter, ok := reporters[encoding.RID]
+				if !ok {
+					continue
+				}
+				report, ok := reporter.senderReport(encoding.SSRC, time.Now())
+				if !ok {
+					continue
+				}
+				// a peer that is going away stops the sender, which ends the loop above
+				utils.UncheckedError(pc.WriteRTCP([]rtcp.Packet{report}))
+			}
+		}
+	})
+}
+
 type peerState struct {
+	// id identifies the peer in stats for as long as it receives the stream.
+	id      string
 	stream  *streamState
 	senders []*webrtc.RTPSender
 }
@@ -133,12 +256,26 @@ func (ss *streamServer) addStream(stream Stream) error {
 	return nil
 }
 
+// namedStream returns the stream with the given name, if any.
+func (ss *streamServer) namedStream(name string) (Stream, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	stream, ok := ss.nameToStream[name]
+	return stream, ok
+}
+
 func (ss *streamServer) Close() error {
 	ss.mu.RLock()
 	defer ss.mu.RUnlock()
 	for _, stream := range ss.streams {
+		stream.mu.Lock()
+		if stream.release != nil {
+			stream.release()
+			stream.release = nil
+		}
 		stream.stream.Stop()
 		stream.activePeers = 0
+		stream.mu.Unlock()
 	}
 	ss.activeBackgroundWorkers.Wait()
 	return nil
@@ -215,7 +352,7 @@ func (srs *streamRPCServer) AddStream(ctx context.Context, req *streampb.AddStre
 
 	ps, ok := pcStreams[req.Name]
 	if !ok {
-		ps = &peerState{stream: streamToAdd}
+		ps = &peerState{id: uuid.NewString(), stream: streamToAdd}
 		pcStreams[req.Name] = ps
 	}
 
@@ -228,17 +365,28 @@ func (srs *streamRPCServer) AddStream(ctx context.Context, req *streampb.AddStre
 		}
 	}()
 
-	addTrack := func(track webrtc.TrackLocal) error {
+	feedbackHandler, _ := streamToAdd.stream.(rtcpFeedbackHandler)
+	addTrack := func(track webrtc.TrackLocal, simulcastTracks ...webrtc.TrackLocal) error {
 		sender, err := pc.AddTrack(track)
 		if err != nil {
 			return err
 		}
 		ps.senders = append(ps.senders, sender)
+		for _, simulcastTrack := range simulcastTracks {
+			if err := sender.AddEncoding(simulcastTrack); err != nil {
+				return err
+			}
+		}
+		serveRTCP(pc, sender, append([]webrtc.TrackLocal{track}, simulcastTracks...), feedbackHandler)
 		return nil
 	}
 
 	if trackLocal, haveTrackLocal := streamToAdd.stream.VideoTrackLocal(); haveTrackLocal {
-		if err := addTrack(trackLocal); err != nil {
+		var simulcastTracks []webrtc.TrackLocal
+		if simulcast, ok := streamToAdd.stream.(simulcastStream); ok {
+			simulcastTracks = simulcast.videoSimulcastTrackLocals()
+		}
+		if err := addTrack(trackLocal, simulcastTracks...); err != nil {
 			return nil, err
 		}
 	}
@@ -296,3 +444,114 @@ func (srs *streamRPCServer) RemoveStream(ctx context.Context, req *streampb.Remo
 
 	return &streampb.RemoveStreamResponse{}, nil
 }
+
+func (srs *streamRPCServer) GetStreamStats(
+	ctx context.Context,
+	req *streampb.GetStreamStatsRequest,
+) (*streampb.GetStreamStatsResponse, error) {
+	srs.ss.mu.RLock()
+	defer srs.ss.mu.RUnlock()
+
+	streams := make([]Stream, 0, len(srs.ss.streams))
+	if len(req.Names) == 0 {
+		for _, stream := range srs.ss.streams {
+			streams = append(streams, stream.stream)
+		}
+	}
+	for _, name := range req.Names {
+		stream, ok := srs.ss.nameToStream[name]
+		if !ok {
+			return nil, fmt.Errorf("no stream for %q", name)
+		}
+		streams = append(streams, stream)
+	}
+
+	resp := &streampb.GetStreamStatsResponse{Streams: make([]*streampb.StreamStats, 0, len(streams))}
+	for _, stream := range streams {
+		stats := stream.Stats()
+		var peers []*streampb.PeerStats
+		for _, pcStreams := range srs.ss.activePeerStreams {
+			if ps, ok := pcStreams[stream.Name()]; ok {
+				peers = append(peers, ps.stats(stats.PeerTracks))
+			}
+		}
+		sort.Slice(peers, func(i, j int) bool { return peers[i].Id < peers[j].Id })
+		resp.Streams = append(resp.Streams, &streampb.StreamStats{
+			Name:               stream.Name(),
+			VideoFramesIn:      stats.VideoFramesIn,
+			VideoFramesOut:     stats.VideoFramesOut,
+			VideoFramesDropped: stats.VideoFramesDropped,
+			AudioChunksIn:      stats.AudioChunksIn,
+			AudioChunksOut:     stats.AudioChunksOut,
+			EncodeLatency:      durationpb.New(stats.EncodeLatency),
+			FrameRate:          stats.FrameRate,
+			PacketsSent:        stats.PacketsSent,
+			BytesSent:          stats.BytesSent,
+			PeerCount:          uint32(len(peers)),
+			Peers:              peers,
+		})
+	}
+	return resp, nil
+}
+
+func (srs *streamRPCServer) SaveStreamEvent(
+	ctx context.Context,
+	req *streampb.SaveStreamEventRequest,
+) (*streampb.SaveStreamEventResponse, error) {
+	stream, ok := srs.ss.namedStream(req.Name)
+	if !ok {
+		return nil, fmt.Errorf("no stream for %q", req.Name)
+	}
+
+	// saving waits for the media that follows the event, so the server is not locked meanwhile
+	path, err := stream.SaveEvent(ctx, req.After.AsDuration())
+	if err != nil {
+		return nil, err
+	}
+	return &streampb.SaveStreamEventResponse{Path: path}, nil
+}
+
+func (srs *streamRPCServer) GetSnapshot(
+	ctx context.Context,
+	req *streampb.GetSnapshotRequest,
+) (*streampb.GetSnapshotResponse, error) {
+	stream, ok := srs.ss.namedStream(req.Name)
+	if !ok {
+		return nil, fmt.Errorf("no stream for %q", req.Name)
+	}
+	img, captured, err := stream.LatestFrame(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, mimeType, err := encodeSnapshot(img, req.MimeType, int(req.Width), int(req.Height))
+	if err != nil {
+		return nil, err
+	}
+	return &streampb.GetSnapshotResponse{
+		MimeType:   mimeType,
+		Image:      data,
+		CapturedAt: timestamppb.New(captured),
+	}, nil
+}
+
+// stats sums up what has been sent to the peer on each of the tracks it receives from the
+// given stats of the stream. The tracks of the peer are those sent by its senders, which are
+// told apart by the SSRCs of their encodings.
+func (ps *peerState) stats(peerTracks []PeerTrackStats) *streampb.PeerStats {
+	stats := &streampb.PeerStats{Id: ps.id}
+	for _, sender := range ps.senders {
+		for _, encoding := range sender.GetParameters().Encodings {
+			for _, peerTrack := range peerTracks {
+				if peerTrack.SSRC != uint32(encoding.SSRC) {
+					continue
+				}
+				stats.PacketsSent += peerTrack.PacketsSent
+				stats.BytesSent += peerTrack.BytesSent
+				if !containsString(stats.MimeTypes, peerTrack.MIMEType) {
+					stats.MimeTypes = append(stats.MimeTypes, peerTrack.MIMEType)
+				}
+			}
+		}
+	}
+	return stats
+}
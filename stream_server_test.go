@@ -0,0 +1,181 @@
+package gostream
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	streampb "github.com/viamrobotics/gostream/proto/stream/v1"
+)
+
+func TestStreamServerGetStreamStats(t *testing.T) {
+	stream1, err := NewStream(StreamConfig{Name: "stream1", VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+	test.That(t, err, test.ShouldBeNil)
+	stream2, err := NewStream(StreamConfig{Name: "stream2", VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+	test.That(t, err, test.ShouldBeNil)
+	server, err := NewStreamServer(stream1, stream2)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() {
+		test.That(t, server.Close(), test.ShouldBeNil)
+	}()
+
+	// a peer receiving the first stream whose track is bound as if it were negotiated.
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	test.That(t, err, test.ShouldBeNil)
+	defer func() {
+		test.That(t, pc.Close(), test.ShouldBeNil)
+	}()
+	track, _ := stream1.VideoTrackLocal()
+	sender, err := pc.AddTrack(track)
+	test.That(t, err, test.ShouldBeNil)
+	ss := server.(*streamServer)
+	ss.activePeerStreams[pc] = map[string]*peerState{
+		"stream1": {id: "peer", stream: ss.streams[0], senders: []*webrtc.RTPSender{sender}},
+	}
+	peer := newFakeTrackLocalContext("peer", sender.GetParameters().Encodings[0].SSRC, 96)
+	_, err = stream1.(*basicStream).videoTrackLocal.bind(peer)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, stream1.(*basicStream).videoTrackLocal.WriteData(webrtc.MimeTypeVP8, []byte{1, 2, 3}, time.Now()), test.ShouldBeNil)
+	packets := peer.writer.Packets()
+	test.That(t, packets, test.ShouldHaveLength, 1)
+
+	resp, err := server.ServiceServer().GetStreamStats(context.Background(), &streampb.GetStreamStatsRequest{})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp.Streams, test.ShouldHaveLength, 2)
+	test.That(t, resp.Streams[0].Name, test.ShouldEqual, "stream1")
+	test.That(t, resp.Streams[0].PeerCount, test.ShouldEqual, 1)
+	test.That(t, resp.Streams[0].PacketsSent, test.ShouldEqual, 1)
+	test.That(t, resp.Streams[0].Peers, test.ShouldHaveLength, 1)
+	test.That(t, resp.Streams[0].Peers[0].Id, test.ShouldEqual, "peer")
+	test.That(t, resp.Streams[0].Peers[0].MimeTypes, test.ShouldResemble, []string{webrtc.MimeTypeVP8})
+	test.That(t, resp.Streams[0].Peers[0].PacketsSent, test.ShouldEqual, 1)
+	test.That(t, resp.Streams[0].Peers[0].BytesSent, test.ShouldEqual, len(packets[0].Payload))
+	test.That(t, resp.Streams[1].Name, test.ShouldEqual, "stream2")
+	test.That(t, resp.Streams[1].Peers, test.ShouldBeEmpty)
+
+	resp, err = server.ServiceServer().GetStreamStats(context.Background(), &streampb.GetStreamStatsRequest{
+		Names: []string{"stream2"},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp.Streams, test.ShouldHaveLength, 1)
+	test.That(t, resp.Streams[0].Name, test.ShouldEqual, "stream2")
+
+	// peers are counted once whichever of the tracks of the stream they receive
+	otherPC := &webrtc.PeerConnection{}
+	ss.activePeerStreams[otherPC] = map[string]*peerState{
+		"stream1": {id: "other", stream: ss.streams[0]},
+	}
+	resp, err = server.ServiceServer().GetStreamStats(context.Background(), &streampb.GetStreamStatsRequest{
+		Names: []string{"stream1"},
+	})
+	delete(ss.activePeerStreams, otherPC)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp.Streams[0].PeerCount, test.ShouldEqual, 2)
+
+	_, err = server.ServiceServer().GetStreamStats(context.Background(), &streampb.GetStreamStatsRequest{
+		Names: []string{"unknown"},
+	})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "no stream")
+}
+
+func TestStreamServerSaveStreamEvent(t *testing.T) {
+	dir := t.TempDir()
+	stream, err := NewStream(StreamConfig{
+		Name:                 "cam",
+		EncodedVideoMIMEType: webrtc.MimeTypeVP8,
+		EventBuffer:          EventBufferConfig{Duration: time.Second, Recording: RecorderConfig{Dir: dir}},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	other, err := NewStream(StreamConfig{Name: "other", VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+	test.That(t, err, test.ShouldBeNil)
+	server, err := NewStreamServer(stream, other)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() {
+		test.That(t, server.Close(), test.ShouldBeNil)
+	}()
+
+	_, err = server.ServiceServer().SaveStreamEvent(context.Background(), &streampb.SaveStreamEventRequest{Name: "unknown"})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "no stream")
+	_, err = server.ServiceServer().SaveStreamEvent(context.Background(), &streampb.SaveStreamEventRequest{Name: "other"})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	bs := stream.(*basicStream)
+	test.That(t, bs.videoTrackLocal.WriteData(webrtc.MimeTypeVP8, testVP8KeyFrame(64, 48), start), test.ShouldBeNil)
+
+	type saveResult struct {
+		resp *streampb.SaveStreamEventResponse
+		err  error
+	}
+	result := make(chan saveResult, 1)
+	go func() {
+		resp, err := server.ServiceServer().SaveStreamEvent(context.Background(), &streampb.SaveStreamEventRequest{
+			Name:  "cam",
+			After: durationpb.New(100 * time.Millisecond),
+		})
+		result <- saveResult{resp, err}
+	}()
+	waitForEventSave(t, bs.eventBuffer, start.Add(100*time.Millisecond))
+	for i := 1; i < 3; i++ {
+		timestamp := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		test.That(t, bs.videoTrackLocal.WriteData(webrtc.MimeTypeVP8, []byte{0x11}, timestamp), test.ShouldBeNil)
+	}
+	saved := <-result
+	test.That(t, saved.err, test.ShouldBeNil)
+	test.That(t, saved.resp.Path, test.ShouldEqual, filepath.Join(dir, "cam-20230102T030405.000Z.webm"))
+	clusters := recordedClusters(t, saved.resp.Path)
+	test.That(t, clusters, test.ShouldHaveLength, 1)
+	test.That(t, findEBML(clusters[0], mkvIDSimpleBlock), test.ShouldHaveLength, 2)
+}
+
+func TestStreamServerGetSnapshot(t *testing.T) {
+	stream, err := NewStream(StreamConfig{Name: "camera", VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+	test.That(t, err, test.ShouldBeNil)
+	server, err := NewStreamServer(stream)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() {
+		test.That(t, server.Close(), test.ShouldBeNil)
+	}()
+
+	_, err = server.ServiceServer().GetSnapshot(context.Background(), &streampb.GetSnapshotRequest{Name: "unknown"})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "no stream")
+	_, err = server.ServiceServer().GetSnapshot(context.Background(), &streampb.GetSnapshotRequest{Name: "camera"})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	captured := time.Now()
+	streamTestVideoSource(t, stream, testSnapshotFrame(40, 20), captured)
+	resp, err := server.ServiceServer().GetSnapshot(context.Background(), &streampb.GetSnapshotRequest{
+		Name:     "camera",
+		MimeType: SnapshotMIMETypePNG,
+		Width:    20,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp.MimeType, test.ShouldEqual, SnapshotMIMETypePNG)
+	test.That(t, resp.CapturedAt.AsTime().Equal(captured), test.ShouldBeTrue)
+	decoded, err := png.Decode(bytes.NewReader(resp.Image))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, decoded.Bounds().Size(), test.ShouldResemble, image.Pt(20, 10))
+
+	_, err = server.ServiceServer().GetSnapshot(context.Background(), &streampb.GetSnapshotRequest{
+		Name:     "camera",
+		MimeType: "image/gif",
+	})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = server.ServiceServer().GetSnapshot(context.Background(), &streampb.GetSnapshotRequest{
+		Name:   "camera",
+		Width:  100000,
+		Height: 100000,
+	})
+	test.That(t, err, test.ShouldNotBeNil)
+}
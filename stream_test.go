@@ -0,0 +1,839 @@
+package gostream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+
+	"github.com/viamrobotics/gostream/codec"
+)
+
+// fakeVideoEncoderFactory creates fakeVideoEncoders and remembers all of them.
+type fakeVideoEncoderFactory struct {
+	mu                sync.Mutex
+	mimeType          string
+	encoders          []*fakeVideoEncoder
+	controlsBitRate   bool
+	controlsKeyFrames bool
+	encodedFrames     chan struct{}
+
+	// lastImage is the image last given to an encoder.
+	lastImage image.Image
+}
+
+func newFakeVideoEncoderFactory(controlsBitRate bool) *fakeVideoEncoderFactory {
+	return &fakeVideoEncoderFactory{
+		mimeType:        "video/vp8",
+		controlsBitRate: controlsBitRate,
+		encodedFrames:   make(chan struct{}),
+	}
+}
+
+func (f *fakeVideoEncoderFactory) New(
+	width, height, keyFrameInterval int,
+	opts codec.VideoEncoderOptions,
+	logger golog.Logger,
+) (codec.VideoEncoder, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	enc := &fakeVideoEncoder{
+		factory:   f,
+		width:     width,
+		height:    height,
+		bitRate:   opts.WithDefaults().BitRate,
+		frameRate: opts.FrameRate,
+	}
+	f.encoders = append(f.encoders, enc)
+	switch {
+	case f.controlsBitRate:
+		return &fakeBitRateVideoEncoder{enc}, nil
+	case f.controlsKeyFrames:
+		return &fakeKeyFrameVideoEncoder{enc}, nil
+	default:
+		return enc, nil
+	}
+}
+
+func (f *fakeVideoEncoderFactory) MIMEType() string {
+	return f.mimeType
+}
+
+func (f *fakeVideoEncoderFactory) LastImage() image.Image {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastImage
+}
+
+func (f *fakeVideoEncoderFactory) Encoders() []*fakeVideoEncoder {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*fakeVideoEncoder(nil), f.encoders...)
+}
+
+// fakeVideoEncoder encodes every image as its width and height.
+type fakeVideoEncoder struct {
+	factory       *fakeVideoEncoderFactory
+	width, height int
+	bitRate       int
+	frameRate     int
+	keyFrames     int
+	closed        bool
+}
+
+func (e *fakeVideoEncoder) Encode(ctx context.Context, img image.Image) ([]byte, error) {
+	e.factory.mu.Lock()
+	e.factory.lastImage = img
+	e.factory.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case e.factory.encodedFrames <- struct{}{}:
+	}
+	return []byte{byte(e.width), byte(e.height)}, nil
+}
+
+func (e *fakeVideoEncoder) Close() {
+	e.closed = true
+}
+
+// fakeBitRateVideoEncoder is a fakeVideoEncoder that can change its bit rate.
+type fakeBitRateVideoEncoder struct {
+	*fakeVideoEncoder
+}
+
+func (e *fakeBitRateVideoEncoder) SetBitRate(bitRate int) error {
+	e.bitRate = bitRate
+	return nil
+}
+
+// fakeKeyFrameVideoEncoder is a fakeVideoEncoder that can force key frames.
+type fakeKeyFrameVideoEncoder struct {
+	*fakeVideoEncoder
+}
+
+func (e *fakeKeyFrameVideoEncoder) ForceKeyFrame() error {
+	e.keyFrames++
+	return nil
+}
+
+// fakeAudioEncoderFactory only advertises a MIME type.
+type fakeAudioEncoderFactory struct{}
+
+func (f fakeAudioEncoderFactory) New(
+	sampleRate, channelCount int,
+	latency time.Duration,
+	logger golog.Logger,
+) (codec.AudioEncoder, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f fakeAudioEncoderFactory) MIMEType() string {
+	return "audio/opus"
+}
+
+// bindTestPeer binds a peer to every video layer of the stream in its most preferred codec,
+// since video is only encoded in the codecs that someone receives.
+func bindTestPeer(t *testing.T, stream Stream) {
+	t.Helper()
+	bs := stream.(*basicStream)
+	for i, layer := range bs.videoLayers {
+		peer := newFakeTrackLocalContext(fmt.Sprintf("peer-%d", i), webrtc.SSRC(9000+i), 96)
+		peer.codecs[0].MimeType = layer.track.Codec().MimeType
+		_, err := layer.track.bind(peer)
+		test.That(t, err, test.ShouldBeNil)
+	}
+	// the key frame that binding asks for is not needed since nothing has been encoded yet
+	bs.keyFrameMu.Lock()
+	defer bs.keyFrameMu.Unlock()
+	bs.keyFrameRequested = false
+}
+
+func inputTestFrame(t *testing.T, stream Stream, factory *fakeVideoEncoderFactory) {
+	t.Helper()
+	input, err := stream.InputVideoFrames(prop.Video{})
+	test.That(t, err, test.ShouldBeNil)
+	input <- MediaReleasePair[image.Image]{Media: image.NewRGBA(image.Rect(0, 0, 4, 2))}
+	<-factory.encodedFrames
+}
+
+func TestStreamSetVideoBitrate(t *testing.T) {
+	t.Run("rebuilds encoder", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		stream, err := NewStream(StreamConfig{VideoEncoderFactory: factory, TargetFrameRate: 1000})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		test.That(t, factory.Encoders(), test.ShouldHaveLength, 1)
+		test.That(t, factory.Encoders()[0].bitRate, test.ShouldEqual, codec.DefaultVideoBitRate)
+		test.That(t, factory.Encoders()[0].frameRate, test.ShouldEqual, 1000)
+
+		test.That(t, stream.SetVideoBitrate(300_000), test.ShouldBeNil)
+		inputTestFrame(t, stream, factory)
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 2)
+		test.That(t, encoders[0].closed, test.ShouldBeTrue)
+		test.That(t, encoders[1].bitRate, test.ShouldEqual, 300_000)
+		test.That(t, encoders[1].width, test.ShouldEqual, 4)
+		test.That(t, encoders[1].height, test.ShouldEqual, 2)
+	})
+
+	t.Run("retunes encoder", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(true)
+		stream, err := NewStream(StreamConfig{VideoEncoderFactory: factory, TargetFrameRate: 1000})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		test.That(t, stream.SetVideoBitrate(300_000), test.ShouldBeNil)
+		inputTestFrame(t, stream, factory)
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 1)
+		test.That(t, encoders[0].bitRate, test.ShouldEqual, 300_000)
+	})
+
+	t.Run("congestion control retunes encoder", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(true)
+		stream, err := NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			TargetFrameRate:     1000,
+			CongestionControl:   CongestionControlConfig{Enabled: true},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		setBitRate := stream.(*basicStream).congestionController.setBitRate
+		test.That(t, setBitRate(300_000, time.Now()), test.ShouldBeNil)
+		inputTestFrame(t, stream, factory)
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 1)
+		test.That(t, encoders[0].bitRate, test.ShouldEqual, 300_000)
+	})
+
+	t.Run("congestion control rebuilds encoder at most every interval", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		stream, err := NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			TargetFrameRate:     1000,
+			CongestionControl:   CongestionControlConfig{Enabled: true},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		setBitRate := stream.(*basicStream).congestionController.setBitRate
+		now := time.Now()
+		test.That(t, setBitRate(300_000, now), test.ShouldBeNil)
+		inputTestFrame(t, stream, factory)
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 2)
+		test.That(t, encoders[0].closed, test.ShouldBeTrue)
+		test.That(t, encoders[1].bitRate, test.ShouldEqual, 300_000)
+
+		// the bit rate is not applied, so the controller does not keep it
+		err = setBitRate(200_000, now.Add(minBitRateRebuildInterval-time.Millisecond))
+		test.That(t, err, test.ShouldEqual, errBitRateRebuildThrottled)
+		inputTestFrame(t, stream, factory)
+		test.That(t, factory.Encoders(), test.ShouldHaveLength, 2)
+
+		test.That(t, setBitRate(200_000, now.Add(minBitRateRebuildInterval)), test.ShouldBeNil)
+		inputTestFrame(t, stream, factory)
+		encoders = factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 3)
+		test.That(t, encoders[2].bitRate, test.ShouldEqual, 200_000)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		stream, err := NewStream(StreamConfig{VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, stream.SetVideoBitrate(0), test.ShouldNotBeNil)
+		test.That(t, stream.SetAudioBitrate(32000), test.ShouldNotBeNil)
+	})
+}
+
+func TestStreamKeyFrameRequests(t *testing.T) {
+	t.Run("rebuilds encoder", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		stream, err := NewStream(StreamConfig{VideoEncoderFactory: factory, TargetFrameRate: 1000})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		stream.(*basicStream).requestKeyFrame()
+		inputTestFrame(t, stream, factory)
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 2)
+		test.That(t, encoders[0].closed, test.ShouldBeTrue)
+	})
+
+	t.Run("forces key frame", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		factory.controlsKeyFrames = true
+		stream, err := NewStream(StreamConfig{VideoEncoderFactory: factory, TargetFrameRate: 1000})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		stream.(*basicStream).requestKeyFrame()
+		inputTestFrame(t, stream, factory)
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 1)
+		test.That(t, encoders[0].keyFrames, test.ShouldEqual, 1)
+
+		// a burst of requests is throttled into a single key frame
+		stream.(*basicStream).requestKeyFrame()
+		inputTestFrame(t, stream, factory)
+		test.That(t, encoders[0].keyFrames, test.ShouldEqual, 1)
+		// until the interval has passed since the last one
+		stream.(*basicStream).forceRequestedKeyFrame(time.Now().Add(minKeyFrameInterval))
+		test.That(t, encoders[0].keyFrames, test.ShouldEqual, 2)
+	})
+
+	t.Run("forces key frame of one layer", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		factory.controlsKeyFrames = true
+		stream, err := NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			TargetFrameRate:     1000,
+			SimulcastLayers:     DefaultSimulcastLayers,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		input, err := stream.InputVideoFrames(prop.Video{})
+		test.That(t, err, test.ShouldBeNil)
+		inputFrame := func() {
+			input <- MediaReleasePair[image.Image]{Media: image.NewRGBA(image.Rect(0, 0, 16, 8))}
+			for range DefaultSimulcastLayers {
+				<-factory.encodedFrames
+			}
+		}
+		inputFrame()
+		bs := stream.(*basicStream)
+		bs.requestEncoderKeyFrame(bs.videoLayers[1], factory.MIMEType())
+		inputFrame()
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 3)
+		test.That(t, encoders[0].keyFrames, test.ShouldEqual, 0)
+		test.That(t, encoders[1].keyFrames, test.ShouldEqual, 1)
+		test.That(t, encoders[2].keyFrames, test.ShouldEqual, 0)
+	})
+}
+
+func TestStreamSenderReports(t *testing.T) {
+	stream, err := NewStream(StreamConfig{
+		VideoEncoderFactory: newFakeVideoEncoderFactory(false),
+		AudioEncoderFactory: fakeAudioEncoderFactory{},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	bs := stream.(*basicStream)
+	videoCtx := newFakeTrackLocalContext("video", 1111, 96)
+	_, err = bs.videoTrackLocal.bind(videoCtx)
+	test.That(t, err, test.ShouldBeNil)
+	audioCtx := newFakeTrackLocalContext("audio", 2222, 111)
+	audioCtx.codecs[0].RTPCodecCapability = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000}
+	_, err = bs.audioTrackLocal.bind(audioCtx)
+	test.That(t, err, test.ShouldBeNil)
+	bs.audioTrackLocal.setAudioLatency(20 * time.Millisecond)
+
+	// video is captured every 40ms and ready to send 30ms later while audio is captured
+	// every 20ms and ready 5ms later
+	clock := bs.videoTrackLocal.clock
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		captured := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		sent := clock.sendAt(captured, captured.Add(5*time.Millisecond))
+		test.That(t, bs.audioTrackLocal.writeData(webrtc.MimeTypeOpus, []byte{2}, false, captured, sent), test.ShouldBeNil)
+		if i%2 == 0 {
+			sent := clock.sendAt(captured, captured.Add(30*time.Millisecond))
+			test.That(t, bs.videoTrackLocal.writeData(webrtc.MimeTypeVP8, []byte{1}, false, captured, sent), test.ShouldBeNil)
+		}
+	}
+
+	now := start.Add(time.Second)
+	videoReport, ok := bs.videoTrackLocal.senderReport(videoCtx.ssrc, now)
+	test.That(t, ok, test.ShouldBeTrue)
+	audioReport, ok := bs.audioTrackLocal.senderReport(audioCtx.ssrc, now)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, videoReport.NTPTime, test.ShouldEqual, audioReport.NTPTime)
+
+	// the reports map the same instant to the RTP times of video and audio captured at
+	// the same time, the send delay of the stream before it
+	behind := func(report *rtcp.SenderReport, packet *rtp.Packet, clockRate float64) float64 {
+		return float64(int32(report.RTPTime-packet.Timestamp)) / clockRate
+	}
+	videoPackets := videoCtx.writer.Packets()
+	test.That(t, videoPackets, test.ShouldHaveLength, 10)
+	audioPackets := audioCtx.writer.Packets()
+	test.That(t, audioPackets, test.ShouldHaveLength, 20)
+	delay := clock.sendDelay()
+	test.That(t, delay, test.ShouldAlmostEqual, 30*time.Millisecond, time.Millisecond)
+	for i, packet := range videoPackets {
+		videoBehind := behind(videoReport, packet, 90000)
+		captured := start.Add(time.Duration(i) * 40 * time.Millisecond)
+		test.That(t, videoBehind, test.ShouldAlmostEqual, now.Sub(captured.Add(delay)).Seconds(), 0.0001)
+		test.That(t, videoBehind, test.ShouldAlmostEqual, behind(audioReport, audioPackets[2*i], 48000), 0.0001)
+	}
+
+	// a stream without audio has nothing to synchronize its video with
+	stream, err = NewStream(StreamConfig{VideoEncoderFactory: newFakeVideoEncoderFactory(false)})
+	test.That(t, err, test.ShouldBeNil)
+	videoOnly := stream.(*basicStream).videoTrackLocal
+	test.That(t, videoOnly.clock.synced, test.ShouldBeFalse)
+	_, err = videoOnly.bind(newFakeTrackLocalContext("video", 3333, 96))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, videoOnly.WriteData(webrtc.MimeTypeVP8, []byte{1}, start), test.ShouldBeNil)
+	_, ok = videoOnly.senderReport(3333, now)
+	test.That(t, ok, test.ShouldBeFalse)
+}
+
+func TestStreamSimulcast(t *testing.T) {
+	factory := newFakeVideoEncoderFactory(true)
+	stream, err := NewStream(StreamConfig{
+		VideoEncoderFactory: factory,
+		TargetFrameRate:     1000,
+		SimulcastLayers:     DefaultSimulcastLayers,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	bindTestPeer(t, stream)
+	stream.Start()
+	defer stream.Stop()
+
+	bs := stream.(*basicStream)
+	test.That(t, bs.videoTrackLocal.RID(), test.ShouldEqual, "f")
+	simulcastTracks := bs.videoSimulcastTrackLocals()
+	test.That(t, simulcastTracks, test.ShouldHaveLength, 2)
+	for i, rid := range []string{"h", "q"} {
+		test.That(t, simulcastTracks[i].RID(), test.ShouldEqual, rid)
+		test.That(t, simulcastTracks[i].ID(), test.ShouldEqual, bs.videoTrackLocal.ID())
+		test.That(t, simulcastTracks[i].StreamID(), test.ShouldEqual, bs.videoTrackLocal.StreamID())
+		test.That(t, simulcastTracks[i].(*trackLocalStaticSample).clock, test.ShouldEqual, bs.videoTrackLocal.clock)
+	}
+
+	input, err := stream.InputVideoFrames(prop.Video{})
+	test.That(t, err, test.ShouldBeNil)
+	input <- MediaReleasePair[image.Image]{Media: image.NewRGBA(image.Rect(0, 0, 16, 8))}
+	for range DefaultSimulcastLayers {
+		<-factory.encodedFrames
+	}
+
+	encoders := factory.Encoders()
+	test.That(t, encoders, test.ShouldHaveLength, 3)
+	for i, expected := range []struct{ width, height, bitRate int }{
+		{16, 8, codec.DefaultVideoBitRate},
+		{8, 4, codec.DefaultVideoBitRate / 4},
+		{4, 2, codec.DefaultVideoBitRate / 16},
+	} {
+		test.That(t, encoders[i].width, test.ShouldEqual, expected.width)
+		test.That(t, encoders[i].height, test.ShouldEqual, expected.height)
+		test.That(t, encoders[i].bitRate, test.ShouldEqual, expected.bitRate)
+	}
+
+	// layers follow changes to the bit rate of the stream
+	test.That(t, stream.SetVideoBitrate(1_600_000), test.ShouldBeNil)
+	test.That(t, encoders[0].bitRate, test.ShouldEqual, 1_600_000)
+	test.That(t, encoders[1].bitRate, test.ShouldEqual, 400_000)
+	test.That(t, encoders[2].bitRate, test.ShouldEqual, 100_000)
+
+	t.Run("only encodes layers that peers receive", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		stream, err := NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			TargetFrameRate:     1000,
+			SimulcastLayers:     DefaultSimulcastLayers,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		bs := stream.(*basicStream)
+		_, err = bs.videoLayers[1].track.bind(newFakeTrackLocalContext("peer", 1111, 96))
+		test.That(t, err, test.ShouldBeNil)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		encoders := factory.Encoders()
+		test.That(t, encoders, test.ShouldHaveLength, 1)
+		test.That(t, encoders[0].width, test.ShouldEqual, 2)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			SimulcastLayers:     []SimulcastLayer{{RID: "f"}, {RID: "f"}},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			SimulcastLayers:     []SimulcastLayer{{}},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewStream(StreamConfig{
+			AudioEncoderFactory: fakeAudioEncoderFactory{},
+			SimulcastLayers:     DefaultSimulcastLayers,
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestStreamEncoderFactories(t *testing.T) {
+	vp8 := newFakeVideoEncoderFactory(false)
+	h264 := newFakeVideoEncoderFactory(false)
+	h264.mimeType = "video/h264"
+	stream, err := NewStream(StreamConfig{
+		VideoEncoderFactory:   vp8,
+		VideoEncoderFactories: []codec.VideoEncoderFactory{h264},
+		TargetFrameRate:       1000,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	stream.Start()
+	defer stream.Stop()
+
+	bs := stream.(*basicStream)
+	test.That(t, bs.videoTrackLocal.rtpTrack.codecs, test.ShouldHaveLength, 2)
+
+	// nothing is encoded until a peer receives the video
+	input, err := stream.InputVideoFrames(prop.Video{})
+	test.That(t, err, test.ShouldBeNil)
+	input <- MediaReleasePair[image.Image]{Media: image.NewRGBA(image.Rect(0, 0, 4, 2))}
+	deadline := time.Now().Add(5 * time.Second)
+	for stream.PipelineStats().Encode.Frames != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a frame to be encoded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	test.That(t, vp8.Encoders(), test.ShouldBeEmpty)
+	test.That(t, h264.Encoders(), test.ShouldBeEmpty)
+
+	// and then only in the codecs that peers receive
+	vp8Peer := newFakeTrackLocalContext("vp8", 1111, 96)
+	_, err = bs.videoTrackLocal.bind(vp8Peer)
+	test.That(t, err, test.ShouldBeNil)
+	inputTestFrame(t, stream, vp8)
+	test.That(t, vp8.Encoders(), test.ShouldHaveLength, 1)
+	test.That(t, h264.Encoders(), test.ShouldBeEmpty)
+
+	h264Peer := newFakeTrackLocalContext("h264", 2222, 102)
+	h264Peer.codecs[0].MimeType = webrtc.MimeTypeH264
+	_, err = bs.videoTrackLocal.bind(h264Peer)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, bs.videoTrackLocal.unbind(vp8Peer), test.ShouldBeNil)
+
+	inputTestFrame(t, stream, h264)
+	test.That(t, h264.Encoders(), test.ShouldHaveLength, 1)
+	test.That(t, vp8.Encoders()[0].closed, test.ShouldBeTrue)
+
+	t.Run("duplicate codecs", func(t *testing.T) {
+		_, err := NewStream(StreamConfig{
+			VideoEncoderFactory:   vp8,
+			VideoEncoderFactories: []codec.VideoEncoderFactory{newFakeVideoEncoderFactory(false)},
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestStreamEncodedVideo(t *testing.T) {
+	stream, err := NewStream(StreamConfig{EncodedVideoMIMEType: webrtc.MimeTypeH264})
+	test.That(t, err, test.ShouldBeNil)
+	stream.Start()
+	defer stream.Stop()
+
+	_, err = stream.InputVideoFrames(prop.Video{})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, stream.SetVideoBitrate(1_000_000), test.ShouldNotBeNil)
+
+	bs := stream.(*basicStream)
+	peer := newFakeTrackLocalContext("h264", 1111, 102)
+	peer.codecs[0].MimeType = webrtc.MimeTypeH264
+	_, err = bs.videoTrackLocal.bind(peer)
+	test.That(t, err, test.ShouldBeNil)
+
+	input, err := stream.InputEncodedVideo(prop.Video{})
+	test.That(t, err, test.ShouldBeNil)
+	released := make(chan struct{})
+	start := time.Now()
+	for i, keyFrame := range []bool{false, true, false, false} {
+		nalType := byte(0x41)
+		if keyFrame {
+			nalType = 0x65
+		}
+		input <- MediaReleasePair[EncodedVideoFrame]{
+			Media:     EncodedVideoFrame{Data: []byte{0, 0, 0, 1, nalType, 1, 2, 3}, KeyFrame: keyFrame},
+			Release:   func() { released <- struct{}{} },
+			Timestamp: start.Add(time.Duration(i) * 100 * time.Millisecond),
+		}
+		<-released
+	}
+	// every frame from the first key frame on is sent, however quickly they arrive
+	checkRTPStream(t, peer.writer.Packets(), peer.ssrc, 102, 3, 9000)
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewStream(StreamConfig{
+			EncodedVideoMIMEType: webrtc.MimeTypeH264,
+			VideoEncoderFactory:  newFakeVideoEncoderFactory(false),
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewStream(StreamConfig{EncodedVideoMIMEType: "video/unknown"})
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = NewStream(StreamConfig{EncodedVideoMIMEType: webrtc.MimeTypeH264, SimulcastLayers: DefaultSimulcastLayers})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestStreamPipeline(t *testing.T) {
+	waitFor := func(t *testing.T, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for condition")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("records stats without a pipeline", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		stream, err := NewStream(StreamConfig{VideoEncoderFactory: factory, TargetFrameRate: 1000})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		inputTestFrame(t, stream, factory)
+		waitFor(t, func() bool { return stream.PipelineStats().Packetize.Frames == 1 })
+		stats := stream.PipelineStats()
+		test.That(t, stats.Convert.Frames, test.ShouldEqual, 1)
+		test.That(t, stats.Encode.Frames, test.ShouldEqual, 1)
+		test.That(t, stats.Convert.Dropped+stats.Encode.Dropped+stats.Packetize.Dropped, test.ShouldEqual, 0)
+		// encoders convert images themselves
+		_, converted := factory.LastImage().(*image.YCbCr)
+		test.That(t, converted, test.ShouldBeFalse)
+	})
+
+	t.Run("queues encoded frames for each layer and codec", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		slow, fast := &videoLayer{}, &videoLayer{}
+		unblock := make(chan struct{})
+		var mu sync.Mutex
+		written := map[encodingKey]int{}
+		dropped := map[encodingKey]int{}
+		count := func(counts map[encodingKey]int, layer *videoLayer, mimeType string) int {
+			mu.Lock()
+			defer mu.Unlock()
+			return counts[encodingKey{layer, mimeType}]
+		}
+		queues := newPacketizeQueues(ctx, 1, func(frame encodedMedia) {
+			mu.Lock()
+			defer mu.Unlock()
+			dropped[encodingKey{frame.layer, frame.mimeType}]++
+		}, func(frame encodedMedia) {
+			if frame.layer == slow {
+				<-unblock
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			written[encodingKey{frame.layer, frame.mimeType}]++
+		})
+
+		for i := 0; i < 5; i++ {
+			queues.push(encodedMedia{layer: slow, mimeType: webrtc.MimeTypeVP8})
+			queues.push(encodedMedia{layer: fast, mimeType: webrtc.MimeTypeVP8})
+			queues.push(encodedMedia{layer: fast, mimeType: webrtc.MimeTypeH264})
+			waitFor(t, func() bool {
+				return count(written, fast, webrtc.MimeTypeVP8) == i+1 && count(written, fast, webrtc.MimeTypeH264) == i+1
+			})
+		}
+		// only the slow layer drops frames, since at most one of its frames is being
+		// written and one waits
+		test.That(t, count(dropped, slow, webrtc.MimeTypeVP8), test.ShouldBeGreaterThanOrEqualTo, 3)
+		test.That(t, count(dropped, fast, webrtc.MimeTypeVP8), test.ShouldEqual, 0)
+		test.That(t, count(dropped, fast, webrtc.MimeTypeH264), test.ShouldEqual, 0)
+
+		close(unblock)
+		cancel()
+		queues.wait()
+	})
+
+	t.Run("drops oldest frames", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		stream, err := NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			TargetFrameRate:     1000,
+			Pipeline:            PipelineConfig{Enabled: true},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+
+		input, err := stream.InputVideoFrames(prop.Video{})
+		test.That(t, err, test.ShouldBeNil)
+		var releasedMu sync.Mutex
+		var released []int
+		releasedFrames := func() []int {
+			releasedMu.Lock()
+			defer releasedMu.Unlock()
+			return append([]int(nil), released...)
+		}
+		inputFrame := func(i int) {
+			input <- MediaReleasePair[image.Image]{
+				Media: image.NewRGBA(image.Rect(0, 0, 4, 2)),
+				Release: func() {
+					releasedMu.Lock()
+					defer releasedMu.Unlock()
+					released = append(released, i)
+				},
+			}
+		}
+		inputFrame(1)
+		// the encoder is created once the first frame reaches it
+		waitFor(t, func() bool { return len(factory.Encoders()) == 1 })
+		for i := 2; i <= 5; i++ {
+			inputFrame(i)
+		}
+
+		// the first frame blocks the encoder and the last waits behind it, so the
+		// ones in between are dropped.
+		waitFor(t, func() bool { return len(releasedFrames()) == 3 })
+		test.That(t, releasedFrames(), test.ShouldResemble, []int{2, 3, 4})
+		stats := stream.PipelineStats()
+		test.That(t, stats.Convert.Dropped+stats.Encode.Dropped, test.ShouldEqual, 3)
+
+		// the first frame of each layer and codec also starts its packetizer
+		<-factory.encodedFrames
+		waitFor(t, func() bool { return stream.PipelineStats().Packetize.Frames == 1 })
+		<-factory.encodedFrames
+		waitFor(t, func() bool { return stream.PipelineStats().Packetize.Frames == 2 })
+		test.That(t, releasedFrames(), test.ShouldResemble, []int{2, 3, 4, 1, 5})
+		stats = stream.PipelineStats()
+		test.That(t, stats.Convert.Frames, test.ShouldEqual, 5-stats.Convert.Dropped)
+		test.That(t, stats.Encode.Frames, test.ShouldEqual, 2)
+		test.That(t, stats.Encode.Busy, test.ShouldBeGreaterThan, 0)
+		// frames are converted before they are encoded
+		_, converted := factory.LastImage().(*image.YCbCr)
+		test.That(t, converted, test.ShouldBeTrue)
+	})
+}
+
+func TestStreamFramePacing(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewStream(StreamConfig{VideoEncoderFactory: newFakeVideoEncoderFactory(false), FramePacing: "bursty"})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("adaptive", func(t *testing.T) {
+		factory := newFakeVideoEncoderFactory(false)
+		stream, err := NewStream(StreamConfig{
+			VideoEncoderFactory: factory,
+			TargetFrameRate:     1,
+			FramePacing:         FramePacingAdaptive,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		bindTestPeer(t, stream)
+		stream.Start()
+		defer stream.Stop()
+		test.That(t, stream.FrameRate(), test.ShouldEqual, 0)
+
+		input, err := stream.InputVideoFrames(prop.Video{})
+		test.That(t, err, test.ShouldBeNil)
+		var released int
+		var releasedMu sync.Mutex
+		for i := 0; i < 3; i++ {
+			input <- MediaReleasePair[image.Image]{
+				Media: image.NewRGBA(image.Rect(0, 0, 4, 2)),
+				Release: func() {
+					releasedMu.Lock()
+					defer releasedMu.Unlock()
+					released++
+				},
+			}
+			if i == 0 {
+				// the first frame is taken right away rather than waiting for a tick
+				<-factory.encodedFrames
+			}
+		}
+		// the rest arrive too quickly for the target frame rate and are dropped
+		test.That(t, stream.FrameRate(), test.ShouldEqual, 1)
+		releasedMu.Lock()
+		defer releasedMu.Unlock()
+		test.That(t, released, test.ShouldBeGreaterThanOrEqualTo, 2)
+	})
+}
+
+func TestStreamStats(t *testing.T) {
+	factory := newFakeVideoEncoderFactory(false)
+	stream, err := NewStream(StreamConfig{VideoEncoderFactory: factory, TargetFrameRate: 1000})
+	test.That(t, err, test.ShouldBeNil)
+	stream.Start()
+	defer stream.Stop()
+
+	stats := stream.Stats()
+	test.That(t, stats.VideoFramesIn, test.ShouldEqual, 0)
+	test.That(t, stats.PeerTracks, test.ShouldBeEmpty)
+
+	bs := stream.(*basicStream)
+	peer := newFakeTrackLocalContext("peer", 1111, 96)
+	_, err = bs.videoTrackLocal.bind(peer)
+	test.That(t, err, test.ShouldBeNil)
+
+	inputTestFrame(t, stream, factory)
+	inputTestFrame(t, stream, factory)
+	deadline := time.Now().Add(5 * time.Second)
+	for stream.Stats().VideoFramesOut != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for frames to be written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var bytesSent uint64
+	packets := peer.writer.Packets()
+	for _, packet := range packets {
+		bytesSent += uint64(len(packet.Payload))
+	}
+	stats = stream.Stats()
+	test.That(t, stats.VideoFramesIn, test.ShouldEqual, 2)
+	test.That(t, stats.VideoFramesDropped, test.ShouldEqual, 0)
+	test.That(t, stats.EncodeLatency, test.ShouldEqual, stats.Pipeline.Encode.Busy/2)
+	test.That(t, stats.PacketsSent, test.ShouldEqual, len(packets))
+	test.That(t, stats.BytesSent, test.ShouldEqual, bytesSent)
+	test.That(t, stats.PeerTracks, test.ShouldResemble, []PeerTrackStats{{
+		SSRC:        1111,
+		Kind:        webrtc.RTPCodecTypeVideo,
+		MIMEType:    webrtc.MimeTypeVP8,
+		PacketsSent: uint64(len(packets)),
+		BytesSent:   bytesSent,
+	}})
+
+	// what was sent to peers that are gone is still counted
+	test.That(t, bs.videoTrackLocal.unbind(peer), test.ShouldBeNil)
+	stats = stream.Stats()
+	test.That(t, stats.PeerTracks, test.ShouldBeEmpty)
+	test.That(t, stats.BytesSent, test.ShouldEqual, bytesSent)
+}
@@ -1,4 +1,4 @@
 #!/bin/bash
-args=$(go list -f '{{.Dir}}' ./... | grep -v mmal)
+args=$(go list -f '{{.Dir}}' ./... | grep -v -e mmal -e h264dec)
 set -euo pipefail
 go test -tags=no_skip -race $args -json -v 2>&1 | gotestfmt
@@ -3,6 +3,7 @@ package gostream
 import (
 	"context"
 	"image"
+	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/pion/mediadevices/pkg/prop"
@@ -31,15 +32,22 @@ func NewResizeVideoSource(src VideoSource, width, height int) VideoSource {
 
 // Read returns a resized image to Width x Height dimensions.
 func (rvs resizeVideoSource) Read(ctx context.Context) (image.Image, func(), error) {
-	img, release, err := rvs.stream.Next(ctx)
+	img, release, _, err := rvs.ReadTimestamped(ctx)
+	return img, release, err
+}
+
+// ReadTimestamped returns a resized image to Width x Height dimensions along with when the
+// original image was captured.
+func (rvs resizeVideoSource) ReadTimestamped(ctx context.Context) (image.Image, func(), time.Time, error) {
+	img, release, captured, err := nextTimestamped(ctx, rvs.stream)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, time.Time{}, err
 	}
 	if release != nil {
 		defer release()
 	}
 
-	return imaging.Resize(img, rvs.width, rvs.height, imaging.NearestNeighbor), func() {}, nil
+	return imaging.Resize(img, rvs.width, rvs.height, imaging.NearestNeighbor), func() {}, captured, nil
 }
 
 // Close closes the underlying source.
@@ -0,0 +1,429 @@
+package gostream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// The IDs of the EBML and Matroska elements written to WebM files.
+// See https://www.matroska.org/technical/elements.html.
+const (
+	ebmlIDHeader             = 0x1A45DFA3
+	ebmlIDVersion            = 0x4286
+	ebmlIDReadVersion        = 0x42F7
+	ebmlIDMaxIDLength        = 0x42F2
+	ebmlIDMaxSizeLength      = 0x42F3
+	ebmlIDDocType            = 0x4282
+	ebmlIDDocTypeVersion     = 0x4287
+	ebmlIDDocTypeReadVersion = 0x4285
+	ebmlIDVoid               = 0xEC
+
+	mkvIDSegment           = 0x18538067
+	mkvIDSeekHead          = 0x114D9B74
+	mkvIDSeek              = 0x4DBB
+	mkvIDSeekID            = 0x53AB
+	mkvIDSeekPosition      = 0x53AC
+	mkvIDInfo              = 0x1549A966
+	mkvIDTimecodeScale     = 0x2AD7B1
+	mkvIDMuxingApp         = 0x4D80
+	mkvIDWritingApp        = 0x5741
+	mkvIDDuration          = 0x4489
+	mkvIDTracks            = 0x1654AE6B
+	mkvIDTrackEntry        = 0xAE
+	mkvIDTrackNumber       = 0xD7
+	mkvIDTrackUID          = 0x73C5
+	mkvIDTrackType         = 0x83
+	mkvIDCodecID           = 0x86
+	mkvIDCodecPrivate      = 0x63A2
+	mkvIDCodecDelay        = 0x56AA
+	mkvIDSeekPreRoll       = 0x56BB
+	mkvIDVideo             = 0xE0
+	mkvIDPixelWidth        = 0xB0
+	mkvIDPixelHeight       = 0xBA
+	mkvIDAudio             = 0xE1
+	mkvIDSamplingFrequency = 0xB5
+	mkvIDChannels          = 0x9F
+	mkvIDCluster           = 0x1F43B675
+	mkvIDTimecode          = 0xE7
+	mkvIDSimpleBlock       = 0xA3
+	mkvIDCues              = 0x1C53BB6B
+	mkvIDCuePoint          = 0xBB
+	mkvIDCueTime           = 0xB3
+	mkvIDCueTrackPositions = 0xB7
+	mkvIDCueTrack          = 0xF7
+	mkvIDCueClusterPos     = 0xF1
+)
+
+const (
+	mkvTrackTypeVideo = 1
+	mkvTrackTypeAudio = 2
+
+	// webmSeekHeadSize is the space reserved at the start of a segment for the seek head,
+	// which can only be written once the position of the cues is known.
+	webmSeekHeadSize = 64
+
+	// webmMaxClusterDuration is the longest a cluster can last, in milliseconds, since
+	// the times of its blocks are 16-bit offsets from its own.
+	webmMaxClusterDuration = math.MaxInt16
+
+	// opusSeekPreRoll is how much audio, in nanoseconds, an Opus decoder needs to decode
+	// after seeking before its output is correct.
+	opusSeekPreRoll = 80_000_000
+)
+
+// webmCodecID returns the WebM codec ID of the codec of the given MIME type, or false
+// if WebM cannot hold it.
+func webmCodecID(mimeType string) (string, bool) {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return "V_VP8", true
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return "V_VP9", true
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return "A_OPUS", true
+	default:
+		return "", false
+	}
+}
+
+// A webmCue points to the cluster that starts at the given time.
+type webmCue struct {
+	time     int64
+	track    int
+	position int64
+}
+
+// A webmWriter muxes frames of already encoded VP8, VP9 and Opus into a WebM file. Each
+// cluster is kept in memory until the next one starts so that its size can be written
+// up front. The segment size, the duration, the cues and the seek head to them are only
+// written once the writer is closed.
+type webmWriter struct {
+	w      io.WriteSeeker
+	tracks []muxedTrack
+
+	// offset is how much has been written to w.
+	offset           int64
+	segmentSizeAt    int64
+	segmentDataStart int64
+	seekHeadAt       int64
+	infoAt           int64
+	tracksAt         int64
+	durationAt       int64
+
+	cluster     bytes.Buffer
+	clusterOpen bool
+	clusterTime int64
+	cues        []webmCue
+	duration    int64
+}
+
+// newWebMWriter writes the header of a WebM file with the given tracks, numbered from one
+// in order, and returns a writer of their frames.
+func newWebMWriter(w io.WriteSeeker, tracks []muxedTrack) (*webmWriter, error) {
+	if len(tracks) == 0 {
+		return nil, errors.New("a WebM file needs at least one track")
+	}
+	ww := &webmWriter{w: w, tracks: tracks}
+
+	var header ebmlBuffer
+	header.master(ebmlIDHeader, func(b *ebmlBuffer) {
+		b.uint(ebmlIDVersion, 1)
+		b.uint(ebmlIDReadVersion, 1)
+		b.uint(ebmlIDMaxIDLength, 4)
+		b.uint(ebmlIDMaxSizeLength, 8)
+		b.string(ebmlIDDocType, "webm")
+		b.uint(ebmlIDDocTypeVersion, 4)
+		b.uint(ebmlIDDocTypeReadVersion, 2)
+	})
+	header.id(mkvIDSegment)
+	ww.segmentSizeAt = int64(header.Len())
+	// the size is unknown until the writer is closed
+	header.Write([]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	ww.segmentDataStart = int64(header.Len())
+
+	ww.seekHeadAt = int64(header.Len())
+	header.void(webmSeekHeadSize)
+
+	ww.infoAt = int64(header.Len())
+	var durationOffset int
+	header.master(mkvIDInfo, func(b *ebmlBuffer) {
+		b.uint(mkvIDTimecodeScale, 1_000_000)
+		b.string(mkvIDMuxingApp, "gostream")
+		b.string(mkvIDWritingApp, "gostream")
+		durationOffset = b.Len()
+		b.float(mkvIDDuration, 0)
+	})
+	// the info element has a one byte size and the duration a two byte ID and one byte size
+	ww.durationAt = ww.infoAt + 4 + 1 + int64(durationOffset) + 3
+
+	ww.tracksAt = int64(header.Len())
+	var err error
+	header.master(mkvIDTracks, func(b *ebmlBuffer) {
+		for i, track := range tracks {
+			codecID, ok := webmCodecID(track.mimeType)
+			if !ok {
+				err = errors.New("WebM cannot hold " + track.mimeType)
+				return
+			}
+			b.master(mkvIDTrackEntry, func(b *ebmlBuffer) {
+				b.uint(mkvIDTrackNumber, uint64(i+1))
+				b.uint(mkvIDTrackUID, uint64(i+1))
+				b.string(mkvIDCodecID, codecID)
+				if track.isVideo() {
+					b.uint(mkvIDTrackType, mkvTrackTypeVideo)
+					b.master(mkvIDVideo, func(b *ebmlBuffer) {
+						b.uint(mkvIDPixelWidth, uint64(track.width))
+						b.uint(mkvIDPixelHeight, uint64(track.height))
+					})
+					return
+				}
+				b.uint(mkvIDTrackType, mkvTrackTypeAudio)
+				b.bytes(mkvIDCodecPrivate, opusHead(track.channels))
+				b.uint(mkvIDCodecDelay, 0)
+				b.uint(mkvIDSeekPreRoll, opusSeekPreRoll)
+				b.master(mkvIDAudio, func(b *ebmlBuffer) {
+					b.float(mkvIDSamplingFrequency, 48000)
+					b.uint(mkvIDChannels, uint64(track.channels))
+				})
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ww.write(header.Bytes()); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// opusHead returns the identification header of an Opus stream with the given number of
+// channels, which is what WebM expects as the private data of an Opus track.
+// See https://datatracker.ietf.org/doc/html/rfc7845#section-5.1.
+func opusHead(channels int) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint32(head[12:], 48000)
+	return head
+}
+
+func (ww *webmWriter) write(data []byte) error {
+	n, err := ww.w.Write(data)
+	ww.offset += int64(n)
+	return err
+}
+
+// size returns how many bytes of the file have been written or are waiting to be.
+func (ww *webmWriter) size() int64 {
+	return ww.offset + int64(ww.cluster.Len())
+}
+
+// writeFrame writes a frame of the given track (indexed from zero), captured the given
+// time after the start of the file. A new cluster is started at each video key frame so
+// that players can seek to it.
+func (ww *webmWriter) writeFrame(track int, data []byte, keyFrame bool, elapsed time.Duration) error {
+	if track < 0 || track >= len(ww.tracks) {
+		return errors.New("no such WebM track")
+	}
+	ms := elapsed.Milliseconds()
+	startCluster := !ww.clusterOpen ||
+		(keyFrame && ww.tracks[track].isVideo()) ||
+		ms-ww.clusterTime > webmMaxClusterDuration ||
+		ms < ww.clusterTime+math.MinInt16
+	if startCluster {
+		if err := ww.flushCluster(); err != nil {
+			return err
+		}
+		ww.clusterOpen = true
+		ww.clusterTime = ms
+		var timecode ebmlBuffer
+		timecode.uint(mkvIDTimecode, uint64(ms))
+		ww.cluster.Write(timecode.Bytes())
+		if keyFrame {
+			ww.cues = append(ww.cues, webmCue{time: ms, track: track, position: ww.offset - ww.segmentDataStart})
+		}
+	}
+
+	block := make([]byte, 4, 4+len(data))
+	// track numbers are small enough to always be one byte
+	block[0] = 0x80 | byte(track+1)
+	binary.BigEndian.PutUint16(block[1:], uint16(int16(ms-ww.clusterTime)))
+	if keyFrame {
+		block[3] = 0x80
+	}
+	block = append(block, data...)
+	var simpleBlock ebmlBuffer
+	simpleBlock.bytes(mkvIDSimpleBlock, block)
+	ww.cluster.Write(simpleBlock.Bytes())
+	if ms > ww.duration {
+		ww.duration = ms
+	}
+	return nil
+}
+
+// flushCluster writes the cluster being built, if any.
+func (ww *webmWriter) flushCluster() error {
+	if !ww.clusterOpen {
+		return nil
+	}
+	var cluster ebmlBuffer
+	cluster.bytes(mkvIDCluster, ww.cluster.Bytes())
+	ww.cluster.Reset()
+	ww.clusterOpen = false
+	return ww.write(cluster.Bytes())
+}
+
+// close writes what is left of the file along with what could not be known until now. The
+// writer it writes to is left open.
+func (ww *webmWriter) close() error {
+	if err := ww.flushCluster(); err != nil {
+		return err
+	}
+
+	cuesAt := ww.offset
+	if len(ww.cues) != 0 {
+		var cues ebmlBuffer
+		cues.master(mkvIDCues, func(b *ebmlBuffer) {
+			for _, cue := range ww.cues {
+				b.master(mkvIDCuePoint, func(b *ebmlBuffer) {
+					b.uint(mkvIDCueTime, uint64(cue.time))
+					b.master(mkvIDCueTrackPositions, func(b *ebmlBuffer) {
+						b.uint(mkvIDCueTrack, uint64(cue.track+1))
+						b.uint(mkvIDCueClusterPos, uint64(cue.position))
+					})
+				})
+			}
+		})
+		if err := ww.write(cues.Bytes()); err != nil {
+			return err
+		}
+	}
+	end := ww.offset
+
+	var seekHead ebmlBuffer
+	seekHead.master(mkvIDSeekHead, func(b *ebmlBuffer) {
+		seek := func(id uint32, at int64) {
+			b.master(mkvIDSeek, func(b *ebmlBuffer) {
+				var seekID ebmlBuffer
+				seekID.id(id)
+				b.bytes(mkvIDSeekID, seekID.Bytes())
+				b.uint(mkvIDSeekPosition, uint64(at-ww.segmentDataStart))
+			})
+		}
+		seek(mkvIDInfo, ww.infoAt)
+		seek(mkvIDTracks, ww.tracksAt)
+		if len(ww.cues) != 0 {
+			seek(mkvIDCues, cuesAt)
+		}
+	})
+	seekHead.void(webmSeekHeadSize - seekHead.Len())
+
+	var segmentSize, duration [8]byte
+	binary.BigEndian.PutUint64(segmentSize[:], uint64(end-ww.segmentDataStart))
+	segmentSize[0] = 0x01
+	binary.BigEndian.PutUint64(duration[:], math.Float64bits(float64(ww.duration)))
+	for _, patch := range []struct {
+		at   int64
+		data []byte
+	}{
+		{ww.segmentSizeAt, segmentSize[:]},
+		{ww.seekHeadAt, seekHead.Bytes()},
+		{ww.durationAt, duration[:]},
+	} {
+		if _, err := ww.w.Seek(patch.at, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := ww.w.Write(patch.data); err != nil {
+			return err
+		}
+	}
+	_, err := ww.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// An ebmlBuffer builds EBML elements.
+type ebmlBuffer struct {
+	bytes.Buffer
+}
+
+// id writes an element ID, which already carries the marker of its length.
+func (b *ebmlBuffer) id(id uint32) {
+	switch {
+	case id >= 1<<24:
+		b.Write([]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)})
+	case id >= 1<<16:
+		b.Write([]byte{byte(id >> 16), byte(id >> 8), byte(id)})
+	case id >= 1<<8:
+		b.Write([]byte{byte(id >> 8), byte(id)})
+	default:
+		b.WriteByte(byte(id))
+	}
+}
+
+// size writes the size of an element as a variable length integer of the fewest bytes
+// that can hold it. A value of all ones is reserved for unknown sizes so it is avoided.
+func (b *ebmlBuffer) size(size uint64) {
+	length := 1
+	for size >= 1<<(7*length)-1 {
+		length++
+	}
+	data := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		data[i] = byte(size)
+		size >>= 8
+	}
+	data[0] |= 0x80 >> (length - 1)
+	b.Write(data)
+}
+
+func (b *ebmlBuffer) bytes(id uint32, data []byte) {
+	b.id(id)
+	b.size(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *ebmlBuffer) uint(id uint32, value uint64) {
+	length := 1
+	for length < 8 && value>>(8*length) != 0 {
+		length++
+	}
+	data := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		data[i] = byte(value)
+		value >>= 8
+	}
+	b.bytes(id, data)
+}
+
+func (b *ebmlBuffer) float(id uint32, value float64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], math.Float64bits(value))
+	b.bytes(id, data[:])
+}
+
+func (b *ebmlBuffer) string(id uint32, value string) {
+	b.bytes(id, []byte(value))
+}
+
+func (b *ebmlBuffer) master(id uint32, children func(b *ebmlBuffer)) {
+	var body ebmlBuffer
+	children(&body)
+	b.bytes(id, body.Bytes())
+}
+
+// void writes a void element that takes up exactly the given number of bytes, which must
+// be between two and 128 so that its size fits in one byte.
+func (b *ebmlBuffer) void(size int) {
+	b.id(ebmlIDVoid)
+	b.size(uint64(size - 2))
+	b.Write(make([]byte, size-2))
+}
@@ -0,0 +1,157 @@
+package gostream
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+// ebmlElement is an element read back from an EBML document.
+type ebmlElement struct {
+	id   uint32
+	data []byte
+}
+
+// readEBML reads the elements at the top level of the given data.
+func readEBML(t *testing.T, data []byte) []ebmlElement {
+	t.Helper()
+	var elements []ebmlElement
+	for len(data) != 0 {
+		idLength := 1
+		for data[0]&(0x80>>(idLength-1)) == 0 {
+			idLength++
+		}
+		var id uint32
+		for _, b := range data[:idLength] {
+			id = id<<8 | uint32(b)
+		}
+		data = data[idLength:]
+
+		sizeLength := 1
+		for data[0]&(0x80>>(sizeLength-1)) == 0 {
+			sizeLength++
+		}
+		size := uint64(data[0] & (0xFF >> sizeLength))
+		for _, b := range data[1:sizeLength] {
+			size = size<<8 | uint64(b)
+		}
+		data = data[sizeLength:]
+		test.That(t, size, test.ShouldBeLessThanOrEqualTo, len(data))
+		elements = append(elements, ebmlElement{id: id, data: data[:size]})
+		data = data[size:]
+	}
+	return elements
+}
+
+// findEBML returns the elements with the given ID among the given elements.
+func findEBML(elements []ebmlElement, id uint32) []ebmlElement {
+	var found []ebmlElement
+	for _, e := range elements {
+		if e.id == id {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func TestWebMWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.webm")
+	file, err := os.Create(path)
+	test.That(t, err, test.ShouldBeNil)
+	defer file.Close()
+
+	_, err = newWebMWriter(file, nil)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = newWebMWriter(file, []muxedTrack{{mimeType: webrtc.MimeTypeH264}})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	ww, err := newWebMWriter(file, []muxedTrack{
+		{mimeType: webrtc.MimeTypeVP8, width: 640, height: 480},
+		{mimeType: webrtc.MimeTypeOpus, channels: 2},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, ww.writeFrame(0, testVP8KeyFrame(640, 480), true, 0), test.ShouldBeNil)
+	test.That(t, ww.writeFrame(1, []byte{1}, true, 10*time.Millisecond), test.ShouldBeNil)
+	test.That(t, ww.writeFrame(0, []byte{0x11}, false, 33*time.Millisecond), test.ShouldBeNil)
+	test.That(t, ww.writeFrame(0, testVP8KeyFrame(640, 480), true, time.Second), test.ShouldBeNil)
+	// too long after the start of the cluster for a relative time
+	test.That(t, ww.writeFrame(0, []byte{0x11}, false, 40*time.Second), test.ShouldBeNil)
+	test.That(t, ww.writeFrame(2, []byte{1}, true, 40*time.Second), test.ShouldNotBeNil)
+	test.That(t, ww.close(), test.ShouldBeNil)
+	test.That(t, file.Close(), test.ShouldBeNil)
+
+	data, err := os.ReadFile(path)
+	test.That(t, err, test.ShouldBeNil)
+	elements := readEBML(t, data)
+	test.That(t, elements, test.ShouldHaveLength, 2)
+	header := readEBML(t, elements[0].data)
+	test.That(t, string(findEBML(header, ebmlIDDocType)[0].data), test.ShouldEqual, "webm")
+	test.That(t, elements[1].id, test.ShouldEqual, mkvIDSegment)
+
+	segment := readEBML(t, elements[1].data)
+	seekHead := readEBML(t, findEBML(segment, mkvIDSeekHead)[0].data)
+	test.That(t, seekHead, test.ShouldHaveLength, 3)
+	for _, seek := range seekHead {
+		seek := readEBML(t, seek.data)
+		position := ebmlUint(findEBML(seek, mkvIDSeekPosition)[0].data)
+		// each seek points to the element whose ID it holds
+		test.That(t,
+			data[ww.segmentDataStart+int64(position):][:4],
+			test.ShouldResemble,
+			findEBML(seek, mkvIDSeekID)[0].data,
+		)
+	}
+
+	info := readEBML(t, findEBML(segment, mkvIDInfo)[0].data)
+	duration := math.Float64frombits(binary.BigEndian.Uint64(findEBML(info, mkvIDDuration)[0].data))
+	test.That(t, duration, test.ShouldEqual, 40000)
+
+	tracks := findEBML(readEBML(t, findEBML(segment, mkvIDTracks)[0].data), mkvIDTrackEntry)
+	test.That(t, tracks, test.ShouldHaveLength, 2)
+	video := readEBML(t, tracks[0].data)
+	test.That(t, string(findEBML(video, mkvIDCodecID)[0].data), test.ShouldEqual, "V_VP8")
+	dimensions := readEBML(t, findEBML(video, mkvIDVideo)[0].data)
+	test.That(t, ebmlUint(findEBML(dimensions, mkvIDPixelWidth)[0].data), test.ShouldEqual, 640)
+	test.That(t, ebmlUint(findEBML(dimensions, mkvIDPixelHeight)[0].data), test.ShouldEqual, 480)
+	audio := readEBML(t, tracks[1].data)
+	test.That(t, string(findEBML(audio, mkvIDCodecID)[0].data), test.ShouldEqual, "A_OPUS")
+	test.That(t, string(findEBML(audio, mkvIDCodecPrivate)[0].data[:8]), test.ShouldEqual, "OpusHead")
+
+	clusters := findEBML(segment, mkvIDCluster)
+	test.That(t, clusters, test.ShouldHaveLength, 3)
+	for i, expected := range []struct {
+		time   uint64
+		blocks int
+	}{{0, 3}, {1000, 1}, {40000, 1}} {
+		cluster := readEBML(t, clusters[i].data)
+		test.That(t, ebmlUint(findEBML(cluster, mkvIDTimecode)[0].data), test.ShouldEqual, expected.time)
+		test.That(t, findEBML(cluster, mkvIDSimpleBlock), test.ShouldHaveLength, expected.blocks)
+	}
+	blocks := findEBML(readEBML(t, clusters[0].data), mkvIDSimpleBlock)
+	// track number, relative time and flags
+	test.That(t, blocks[0].data[:4], test.ShouldResemble, []byte{0x81, 0, 0, 0x80})
+	test.That(t, blocks[1].data[:4], test.ShouldResemble, []byte{0x82, 0, 10, 0x80})
+	test.That(t, blocks[2].data, test.ShouldResemble, []byte{0x81, 0, 33, 0, 0x11})
+
+	// only clusters starting at key frames are cued
+	cues := findEBML(readEBML(t, findEBML(segment, mkvIDCues)[0].data), mkvIDCuePoint)
+	test.That(t, cues, test.ShouldHaveLength, 2)
+	cue := readEBML(t, cues[1].data)
+	test.That(t, ebmlUint(findEBML(cue, mkvIDCueTime)[0].data), test.ShouldEqual, 1000)
+	position := ebmlUint(findEBML(readEBML(t, findEBML(cue, mkvIDCueTrackPositions)[0].data), mkvIDCueClusterPos)[0].data)
+	test.That(t, data[ww.segmentDataStart+int64(position):][:4], test.ShouldResemble, []byte{0x1F, 0x43, 0xB6, 0x75})
+}
@@ -1,11 +1,14 @@
 package gostream
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"math"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
@@ -24,22 +27,72 @@ type trackBinding struct {
 	id          string
 	ssrc        webrtc.SSRC
 	payloadType webrtc.PayloadType
+	mimeType    string
+	clockRate   uint32
 	writeStream webrtc.TrackLocalWriter
+
+	// packetizer is only set for bindings of a trackLocalStaticSample. Each binding
+	// has its own so that every peer sees a contiguous sequence of packets with its
+	// own SSRC and payload type, no matter when it joined.
+	packetizer rtp.Packetizer
+	started    bool
+
+	// sequenceNumberOffset and timestampOffset are only set for bindings of a
+	// trackLocalStaticRTP, whose packets are rewritten with them. They are random so that,
+	// as with a packetizer, every peer sees its own sequence numbers and timestamps no
+	// matter when it joined, while gaps between written packets are kept so that peers
+	// can still tell when packets were lost.
+	sequenceNumberOffset uint16
+	timestampOffset      uint32
+
+	// awaitingKeyFrame is set for bindings of a track that awaits key frames until the
+	// first key frame is written to them, since nothing before it can be decoded.
+	awaitingKeyFrame bool
+
+	// lastRTPTime is the RTP timestamp of the last packet sent to the binding and
+	// lastCaptured is how many samples after the origin of the track's clock it was
+	// captured. Sender reports relate wall clock time to RTP timestamps through them.
+	// Sender reports wrap the packet and octet counts around at 32 bits.
+	lastRTPTime  uint32
+	lastCaptured int64
+	sent         bool
+	packetCount  uint64
+	octetCount   uint64
+
+	// transportCCID is the ID of the transport-wide sequence number header extension if
+	// it was negotiated, in which case sentPackets remembers when the latest packets were
+	// sent. The sequence numbers are set by pion's interceptor as packets are written.
+	transportCCID uint8
+	sentPackets   *sentPackets
+}
+
+// transportCCURI identifies the transport-wide sequence number header extension.
+const transportCCURI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+
+// trackLocalContext is the part of a webrtc.TrackLocalContext that is needed
+// to bind a track. It allows for binding tracks without a PeerConnection.
+type trackLocalContext interface {
+	ID() string
+	SSRC() webrtc.SSRC
+	CodecParameters() []webrtc.RTPCodecParameters
+	HeaderExtensions() []webrtc.RTPHeaderExtensionParameter
+	WriteStream() webrtc.TrackLocalWriter
 }
 
-// trackLocalStaticRTP  is a TrackLocal that has a pre-set codec and accepts RTP Packets.
+// trackLocalStaticRTP  is a TrackLocal that has a pre-set list of codecs and accepts RTP Packets.
+// Each peer is bound with the most preferred codec that it supports.
 // If you wish to send a media.Sample use trackLocalStaticSample.
 type trackLocalStaticRTP struct {
 	mu                sync.RWMutex
 	bindings          []trackBinding
-	codec             webrtc.RTPCodecCapability
+	codecs            []webrtc.RTPCodecCapability
 	id, rid, streamID string
 }
 
-// newtrackLocalStaticRTP returns a trackLocalStaticRTP.
-func newtrackLocalStaticRTP(c webrtc.RTPCodecCapability, id, streamID string) *trackLocalStaticRTP {
+// newtrackLocalStaticRTP returns a trackLocalStaticRTP with the given codecs in order of preference.
+func newtrackLocalStaticRTP(codecs []webrtc.RTPCodecCapability, id, streamID string) *trackLocalStaticRTP {
 	return &trackLocalStaticRTP{
-		codec:    c,
+		codecs:   codecs,
 		bindings: []trackBinding{},
 		id:       id,
 		streamID: streamID,
@@ -50,26 +103,82 @@ func newtrackLocalStaticRTP(c webrtc.RTPCodecCapability, id, streamID string) *t
 // This asserts that the code requested is supported by the remote peer.
 // If so it setups all the state (SSRC and PayloadType) to have a call.
 func (s *trackLocalStaticRTP) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.bind(&t)
+}
 
-	parameters := webrtc.RTPCodecParameters{RTPCodecCapability: s.codec}
-	if codec, err := codecParametersFuzzySearch(parameters, t.CodecParameters()); err == nil {
-		s.bindings = append(s.bindings, trackBinding{
-			ssrc:        t.SSRC(),
-			payloadType: codec.PayloadType,
-			writeStream: t.WriteStream(),
-			id:          t.ID(),
-		})
-		return codec, nil
+func (s *trackLocalStaticRTP) bind(t trackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := s.negotiateCodec(t)
+	if err != nil {
+		return codec, err
 	}
+	binding := newTrackBinding(t, codec)
+	offsets, err := randomUint32s(2)
+	if err != nil {
+		return webrtc.RTPCodecParameters{}, err
+	}
+	binding.sequenceNumberOffset = uint16(offsets[0])
+	binding.timestampOffset = offsets[1]
+	s.addBinding(binding)
+	return codec, nil
+}
 
+// randomUint32s returns n random numbers.
+func randomUint32s(n int) ([]uint32, error) {
+	buf := make([]byte, 4*n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	values := make([]uint32, n)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint32(buf[4*i:])
+	}
+	return values, nil
+}
+
+// negotiateCodec finds the parameters of the most preferred of the track's codecs that
+// is supported by the peer.
+func (s *trackLocalStaticRTP) negotiateCodec(t trackLocalContext) (webrtc.RTPCodecParameters, error) {
+	for _, c := range s.codecs {
+		parameters := webrtc.RTPCodecParameters{RTPCodecCapability: c}
+		if codec, err := codecParametersFuzzySearch(parameters, t.CodecParameters()); err == nil {
+			return codec, nil
+		}
+	}
 	return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
 }
 
+func newTrackBinding(t trackLocalContext, codec webrtc.RTPCodecParameters) trackBinding {
+	binding := trackBinding{
+		ssrc:        t.SSRC(),
+		payloadType: codec.PayloadType,
+		mimeType:    codec.MimeType,
+		clockRate:   codec.ClockRate,
+		writeStream: t.WriteStream(),
+		id:          t.ID(),
+	}
+	for _, ext := range t.HeaderExtensions() {
+		if ext.URI == transportCCURI {
+			binding.transportCCID = uint8(ext.ID)
+			binding.sentPackets = &sentPackets{}
+			break
+		}
+	}
+	return binding
+}
+
+func (s *trackLocalStaticRTP) addBinding(binding trackBinding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bindings = append(s.bindings, binding)
+}
+
 // Unbind implements the teardown logic when the track is no longer needed. This happens
 // because a track has been stopped.
 func (s *trackLocalStaticRTP) Unbind(t webrtc.TrackLocalContext) error {
+	return s.unbind(&t)
+}
+
+func (s *trackLocalStaticRTP) unbind(t trackLocalContext) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -98,24 +207,25 @@ func (s *trackLocalStaticRTP) StreamID() string { return s.streamID }
 // Kind controls if this TrackLocal is audio or video.
 func (s *trackLocalStaticRTP) Kind() webrtc.RTPCodecType {
 	switch {
-	case strings.HasPrefix(s.codec.MimeType, "audio/"):
+	case strings.HasPrefix(s.codecs[0].MimeType, "audio/"):
 		return webrtc.RTPCodecTypeAudio
-	case strings.HasPrefix(s.codec.MimeType, "video/"):
+	case strings.HasPrefix(s.codecs[0].MimeType, "video/"):
 		return webrtc.RTPCodecTypeVideo
 	default:
 		return webrtc.RTPCodecType(0)
 	}
 }
 
-// Codec gets the Codec of the track.
+// Codec gets the most preferred Codec of the track.
 func (s *trackLocalStaticRTP) Codec() webrtc.RTPCodecCapability {
-	return s.codec
+	return s.codecs[0]
 }
 
 // WriteRTP writes a RTP Packet to the trackLocalStaticRTP
 // If one PeerConnection fails the packets will still be sent to
 // all PeerConnections. The error message will contain the ID of the failed
-// PeerConnections so you can remove them.
+// PeerConnections so you can remove them. The sequence number and timestamp of
+// the packet are offset differently for each PeerConnection.
 func (s *trackLocalStaticRTP) WriteRTP(p *rtp.Packet) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -126,6 +236,8 @@ func (s *trackLocalStaticRTP) WriteRTP(p *rtp.Packet) error {
 	for _, b := range s.bindings {
 		outboundPacket.Header.SSRC = uint32(b.ssrc)
 		outboundPacket.Header.PayloadType = uint8(b.payloadType)
+		outboundPacket.Header.SequenceNumber = p.SequenceNumber + b.sequenceNumberOffset
+		outboundPacket.Header.Timestamp = p.Timestamp + b.timestampOffset
 		if _, err := b.writeStream.WriteRTP(&outboundPacket.Header, outboundPacket.Payload); err != nil {
 			writeErrs = append(writeErrs, err)
 		}
@@ -147,32 +259,86 @@ func (s *trackLocalStaticRTP) Write(b []byte) (n int, err error) {
 	return len(b), s.WriteRTP(packet)
 }
 
-// trackLocalStaticSample is a TrackLocal that has a pre-set codec and accepts Samples.
+// trackLocalStaticSample is a TrackLocal that has a pre-set list of codecs and accepts Samples.
+// Samples are only sent to the peers bound with the codec they are encoded in.
 // If you wish to send a RTP Packet use trackLocalStaticRTP.
 type trackLocalStaticSample struct {
-	packetizer   rtp.Packetizer
 	rtpTrack     *trackLocalStaticRTP
-	sampler      samplerFunc
 	isAudio      bool
-	clockRate    uint32
 	audioLatency time.Duration
+
+	// clock is shared with the other tracks of a stream. timings are keyed by
+	// lowercase MIME type and only exist for codecs that have been bound.
+	clock   *mediaClock
+	timings map[string]*codecTiming
+
+	// onBind, if set, is called whenever a new peer is bound to the track.
+	onBind func()
+
+	// awaitKeyFrames makes new peers skip frames until a key frame is written with
+	// WriteEncodedVideoFrame. It is for video that the stream cannot force key frames of.
+	awaitKeyFrames bool
+
+	// packetsSent and bytesSent count what has been sent to every peer, including those
+	// no longer bound.
+	packetsSent uint64
+	bytesSent   uint64
+
+	// sinks receive every frame written to the track in their codec, whether or not a
+	// peer is bound with it.
+	sinks []*trackSink
 }
 
-// newVideoTrackLocalStaticSample returns a trackLocalStaticSample for video.
-func newVideoTrackLocalStaticSample(c webrtc.RTPCodecCapability, id, streamID string) *trackLocalStaticSample {
+// A trackSink receives a copy of every frame written to a track in its codec. write is
+// called with the track's lock held so it must not block. stop is called when the
+// stream of the track stops.
+type trackSink struct {
+	mimeType string
+	write    func(data []byte, keyFrame bool, timestamp time.Time)
+	stop     func()
+}
+
+// A codecTiming relates capture times to RTP timestamps for one codec of a track.
+// lastSamples is how many samples of the clock rate after the origin of the track's
+// clock the most recent sample was captured, if any has been.
+type codecTiming struct {
+	clockRate   uint32
+	lastSamples int64
+	started     bool
+}
+
+// newVideoTrackLocalStaticSample returns a trackLocalStaticSample for video with the given codecs
+// in order of preference.
+func newVideoTrackLocalStaticSample(codecs []webrtc.RTPCodecCapability, id, streamID string) *trackLocalStaticSample {
 	return &trackLocalStaticSample{
-		rtpTrack: newtrackLocalStaticRTP(c, id, streamID),
+		rtpTrack: newtrackLocalStaticRTP(codecs, id, streamID),
+		clock:    &mediaClock{},
+		timings:  map[string]*codecTiming{},
 	}
 }
 
-// newAudioTrackLocalStaticSample returns a trackLocalStaticSample for audio.
+// newSimulcastVideoTrackLocalStaticSample returns a trackLocalStaticSample for one
+// simulcast encoding of video, identified by the given RID.
+func newSimulcastVideoTrackLocalStaticSample(
+	codecs []webrtc.RTPCodecCapability,
+	id, rid, streamID string,
+) *trackLocalStaticSample {
+	track := newVideoTrackLocalStaticSample(codecs, id, streamID)
+	track.rtpTrack.rid = rid
+	return track
+}
+
+// newAudioTrackLocalStaticSample returns a trackLocalStaticSample for audio with the given codecs
+// in order of preference.
 func newAudioTrackLocalStaticSample(
-	c webrtc.RTPCodecCapability,
+	codecs []webrtc.RTPCodecCapability,
 	id, streamID string,
 ) *trackLocalStaticSample {
 	return &trackLocalStaticSample{
-		rtpTrack: newtrackLocalStaticRTP(c, id, streamID),
+		rtpTrack: newtrackLocalStaticRTP(codecs, id, streamID),
 		isAudio:  true,
+		clock:    &mediaClock{},
+		timings:  map[string]*codecTiming{},
 	}
 }
 
@@ -190,38 +356,93 @@ func (s *trackLocalStaticSample) RID() string { return s.rtpTrack.RID() }
 // Kind controls if this TrackLocal is audio or video.
 func (s *trackLocalStaticSample) Kind() webrtc.RTPCodecType { return s.rtpTrack.Kind() }
 
-// Codec gets the Codec of the track.
+// Codec gets the most preferred Codec of the track.
 func (s *trackLocalStaticSample) Codec() webrtc.RTPCodecCapability {
 	return s.rtpTrack.Codec()
 }
 
+// boundMIMETypes returns the MIME types of the codecs of the track that at least one
+// peer is bound with or sink receives, in order of preference.
+func (s *trackLocalStaticSample) boundMIMETypes() []string {
+	s.rtpTrack.mu.RLock()
+	defer s.rtpTrack.mu.RUnlock()
+
+	var mimeTypes []string
+	for _, c := range s.rtpTrack.codecs {
+		bound := false
+		for _, b := range s.rtpTrack.bindings {
+			if strings.EqualFold(b.mimeType, c.MimeType) {
+				bound = true
+				break
+			}
+		}
+		for _, sink := range s.sinks {
+			if strings.EqualFold(sink.mimeType, c.MimeType) {
+				bound = true
+				break
+			}
+		}
+		if bound {
+			mimeTypes = append(mimeTypes, c.MimeType)
+		}
+	}
+	return mimeTypes
+}
+
+// addSink makes the sink receive the frames written to the track from now on.
+func (s *trackLocalStaticSample) addSink(sink *trackSink) {
+	s.rtpTrack.mu.Lock()
+	defer s.rtpTrack.mu.Unlock()
+	s.sinks = append(s.sinks, sink)
+}
+
+// removeSink stops the sink from receiving frames. Once it returns, the sink is no
+// longer called.
+func (s *trackLocalStaticSample) removeSink(sink *trackSink) {
+	s.rtpTrack.mu.Lock()
+	defer s.rtpTrack.mu.Unlock()
+	for i, other := range s.sinks {
+		if other == sink {
+			s.sinks = append(s.sinks[:i], s.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+// stopSinks tells the sinks of the track that its stream has stopped.
+func (s *trackLocalStaticSample) stopSinks() {
+	s.rtpTrack.mu.RLock()
+	defer s.rtpTrack.mu.RUnlock()
+	for _, sink := range s.sinks {
+		if sink.stop != nil {
+			sink.stop()
+		}
+	}
+}
+
 const rtpOutboundMTU = 1200
 
 // Bind is called by the PeerConnection after negotiation is complete
 // This asserts that the code requested is supported by the remote peer.
 // If so it setups all the state (SSRC and PayloadType) to have a call.
 func (s *trackLocalStaticSample) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
-	codec, err := s.rtpTrack.Bind(t)
+	return s.bind(&t)
+}
+
+func (s *trackLocalStaticSample) bind(t trackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := s.rtpTrack.negotiateCodec(t)
 	if err != nil {
 		return codec, err
 	}
 
-	s.rtpTrack.mu.Lock()
-	defer s.rtpTrack.mu.Unlock()
-
-	// We only need one packetizer. But isn't that confusing with other clock rates
-	// from other codecs?
-	if s.packetizer != nil {
-		return codec, nil
-	}
-
 	payloader, err := payloaderForCodec(codec.RTPCodecCapability)
 	if err != nil {
-		return codec, err
+		return webrtc.RTPCodecParameters{}, err
 	}
 
-	// TODO(erd): I think we need to do this for each bind
-	s.packetizer = rtp.NewPacketizer(
+	binding := newTrackBinding(t, codec)
+	binding.awaitingKeyFrame = s.awaitKeyFrames
+	binding.packetizer = rtp.NewPacketizer(
 		rtpOutboundMTU,
 		uint8(codec.PayloadType),
 		uint32(t.SSRC()),
@@ -230,7 +451,17 @@ func (s *trackLocalStaticSample) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCod
 		codec.ClockRate,
 	)
 
-	s.clockRate = codec.RTPCodecCapability.ClockRate
+	s.rtpTrack.mu.Lock()
+	s.rtpTrack.bindings = append(s.rtpTrack.bindings, binding)
+	timingKey := strings.ToLower(codec.MimeType)
+	if _, ok := s.timings[timingKey]; !ok {
+		s.timings[timingKey] = &codecTiming{clockRate: codec.ClockRate}
+	}
+	s.rtpTrack.mu.Unlock()
+
+	if s.onBind != nil {
+		s.onBind()
+	}
 	return codec, nil
 }
 
@@ -243,48 +474,275 @@ func (s *trackLocalStaticSample) setAudioLatency(latency time.Duration) {
 // Unbind implements the teardown logic when the track is no longer needed. This happens
 // because a track has been stopped.
 func (s *trackLocalStaticSample) Unbind(t webrtc.TrackLocalContext) error {
-	return s.rtpTrack.Unbind(t)
+	return s.unbind(&t)
 }
 
-// WriteData writes already encoded data to the trackLocalStaticSample
-// If one PeerConnection fails the packets will still be sent to
-// all PeerConnections. The error message will contain the ID of the failed
-// PeerConnections so you can remove them.
-func (s *trackLocalStaticSample) WriteData(frame []byte) error {
+func (s *trackLocalStaticSample) unbind(t trackLocalContext) error {
+	return s.rtpTrack.unbind(t)
+}
+
+// WriteData writes already encoded data of the given MIME type, captured at the given time,
+// to the peers of the trackLocalStaticSample bound with that codec. If one PeerConnection
+// fails the packets will still be sent to all PeerConnections. The error message will contain
+// the ID of the failed PeerConnections so you can remove them. If the track's clock is
+// synced, it first waits until the data should be sent.
+func (s *trackLocalStaticSample) WriteData(mimeType string, frame []byte, timestamp time.Time) error {
+	return s.writeData(mimeType, frame, false, timestamp, s.waitToSend(timestamp))
+}
+
+// WriteEncodedVideoFrame is like WriteData but also starts sending to the peers that are
+// awaiting a key frame if the frame is one.
+func (s *trackLocalStaticSample) WriteEncodedVideoFrame(frame EncodedVideoFrame, timestamp time.Time) error {
+	return s.writeData(frame.MIMEType, frame.Data, frame.KeyFrame, timestamp, s.waitToSend(timestamp))
+}
+
+// waitToSend waits until media captured at the given time should be sent according to the
+// track's clock and returns the time after waiting.
+func (s *trackLocalStaticSample) waitToSend(timestamp time.Time) time.Time {
+	now := time.Now()
+	at := s.clock.sendAt(timestamp, now)
+	if !at.After(now) {
+		return now
+	}
+	time.Sleep(at.Sub(now))
+	return time.Now()
+}
+
+// writeData writes the frame, captured at the given time, at the given time now.
+func (s *trackLocalStaticSample) writeData(mimeType string, frame []byte, keyFrame bool, timestamp, now time.Time) error {
 	s.rtpTrack.mu.Lock()
-	p := s.packetizer
-	if p == nil {
-		s.rtpTrack.mu.Unlock()
+	defer s.rtpTrack.mu.Unlock()
+
+	for _, sink := range s.sinks {
+		if strings.EqualFold(sink.mimeType, mimeType) {
+			sink.write(frame, keyFrame, timestamp)
+		}
+	}
+
+	// nothing can be sent until a peer has told us the clock rate
+	timing, ok := s.timings[strings.ToLower(mimeType)]
+	if !ok {
 		return nil
 	}
 	if s.isAudio && s.audioLatency == 0 {
 		return nil
 	}
-	sampler := s.sampler
-	if sampler == nil {
-		if s.isAudio {
-			s.sampler = newAudioSampler(s.clockRate, s.audioLatency)
-		} else {
-			s.sampler = newVideoSampler(s.clockRate)
+	samples := s.capturedSamples(timing, timestamp)
+	elapsed := uint32(samples - timing.lastSamples)
+	timing.lastSamples = samples
+	timing.started = true
+
+	writeErrs := []error{}
+	for i := range s.rtpTrack.bindings {
+		b := &s.rtpTrack.bindings[i]
+		if !strings.EqualFold(b.mimeType, mimeType) {
+			continue
+		}
+		if b.awaitingKeyFrame {
+			if !keyFrame {
+				continue
+			}
+			b.awaitingKeyFrame = false
+		}
+		// the packetizer of a binding starts at a random timestamp and is then
+		// advanced by the time elapsed between captures.
+		if b.started {
+			b.packetizer.SkipSamples(elapsed)
+		}
+		packets := b.packetizer.Packetize(frame, 0)
+		if len(packets) != 0 {
+			b.started = true
+		}
+		for _, p := range packets {
+			if _, err := b.writeStream.WriteRTP(&p.Header, p.Payload); err != nil {
+				writeErrs = append(writeErrs, err)
+				continue
+			}
+			b.lastRTPTime = p.Timestamp
+			b.lastCaptured = samples
+			b.sent = true
+			if b.transportCCID != 0 {
+				var ext rtp.TransportCCExtension
+				if err := ext.Unmarshal(p.Header.GetExtension(b.transportCCID)); err == nil {
+					b.sentPackets.add(sentPacket{sequenceNumber: ext.TransportSequence, at: now, size: p.MarshalSize()})
+				}
+			}
+			b.packetCount++
+			b.octetCount += uint64(len(p.Payload))
+			s.packetsSent++
+			s.bytesSent += uint64(len(p.Payload))
 		}
 	}
 
-	s.rtpTrack.mu.Unlock()
+	return multierr.Combine(writeErrs...)
+}
 
-	if s.sampler == nil {
-		return nil
+// capturedSamples returns how many samples of the codec's clock rate after the clock's origin
+// a sample captured at the given time is. Audio is expected to be contiguous so its
+// samples are spaced exactly by the audio latency unless the capture time drifts by
+// more than that, as it would after a gap in the audio. Neither ever goes backwards.
+// It assumes the track's lock is held.
+func (s *trackLocalStaticSample) capturedSamples(timing *codecTiming, timestamp time.Time) int64 {
+	clockRate := float64(timing.clockRate)
+	samples := int64(math.Round(s.clock.since(timestamp).Seconds() * clockRate))
+	if s.isAudio && timing.started {
+		latencySamples := int64(math.Round(s.audioLatency.Seconds() * clockRate))
+		expected := timing.lastSamples + latencySamples
+		if drift := samples - expected; drift > -latencySamples && drift < latencySamples {
+			samples = expected
+		}
+	}
+	if samples < timing.lastSamples {
+		return timing.lastSamples
 	}
-	samples := s.sampler()
-	packets := p.Packetize(frame, samples)
+	return samples
+}
 
-	writeErrs := []error{}
-	for _, p := range packets {
-		if err := s.rtpTrack.WriteRTP(p); err != nil {
-			writeErrs = append(writeErrs, err)
+// senderReport returns an RTCP sender report for the binding with the given SSRC that
+// relates the given wall clock time to the RTP timestamp of media captured the send delay
+// of the track's clock before it, so that the reports of every track of a stream relate
+// their RTP timestamps to the same capture times. It returns false if nothing has been
+// sent to the binding yet or if the track's clock is not synced, in which case there is
+// nothing to synchronize the track with and pion's sender report interceptor reports on it.
+func (s *trackLocalStaticSample) senderReport(ssrc webrtc.SSRC, now time.Time) (*rtcp.SenderReport, bool) {
+	if !s.clock.synced {
+		return nil, false
+	}
+
+	s.rtpTrack.mu.RLock()
+	defer s.rtpTrack.mu.RUnlock()
+
+	for _, b := range s.rtpTrack.bindings {
+		if b.ssrc != ssrc || !b.sent {
+			continue
 		}
+		captured := s.clock.since(now) - s.clock.sendDelay()
+		elapsed := int64(math.Round(captured.Seconds()*float64(b.clockRate))) - b.lastCaptured
+		return &rtcp.SenderReport{
+			SSRC:        uint32(ssrc),
+			NTPTime:     ntpTime(now),
+			RTPTime:     b.lastRTPTime + uint32(elapsed),
+			PacketCount: uint32(b.packetCount),
+			OctetCount:  uint32(b.octetCount),
+		}, true
 	}
+	return nil, false
+}
 
-	return multierr.Combine(writeErrs...)
+// sentPacket returns when the packet with the given transport-wide sequence number was sent
+// to the binding with the given SSRC, if it was one of the latest sent to it.
+func (s *trackLocalStaticSample) sentPacket(ssrc webrtc.SSRC, sequenceNumber uint16) (sentPacket, bool) {
+	s.rtpTrack.mu.RLock()
+	defer s.rtpTrack.mu.RUnlock()
+
+	for _, b := range s.rtpTrack.bindings {
+		if b.ssrc == ssrc && b.sentPackets != nil {
+			return b.sentPackets.get(sequenceNumber)
+		}
+	}
+	return sentPacket{}, false
+}
+
+// stats returns what has been sent to each peer bound to the track along with what has
+// been sent to every peer, in packets and then bytes.
+func (s *trackLocalStaticSample) stats() ([]PeerTrackStats, uint64, uint64) {
+	s.rtpTrack.mu.RLock()
+	defer s.rtpTrack.mu.RUnlock()
+
+	peerTracks := make([]PeerTrackStats, 0, len(s.rtpTrack.bindings))
+	for _, b := range s.rtpTrack.bindings {
+		peerTracks = append(peerTracks, PeerTrackStats{
+			SSRC:        uint32(b.ssrc),
+			Kind:        s.Kind(),
+			RID:         s.rtpTrack.rid,
+			MIMEType:    b.mimeType,
+			PacketsSent: b.packetCount,
+			BytesSent:   b.octetCount,
+		})
+	}
+	return peerTracks, s.packetsSent, s.bytesSent
+}
+
+// A mediaClock is the origin that the tracks of a stream measure capture times from.
+// The tracks of a stream share one so that they all space their RTP timestamps by
+// capture time from the same instant.
+//
+// The clock of a stream with both audio and video is synced. Its media is sent a delay
+// after it was captured, which follows the latency of the slowest media, so that pion's
+// sender report interceptor, which every peer connection has and which relates the RTP
+// timestamp of the last packet to when it was sent, agrees with the sender reports of the
+// tracks, which relate it to when it was captured.
+type mediaClock struct {
+	mu     sync.Mutex
+	origin time.Time
+	synced bool
+	delay  time.Duration
+}
+
+const (
+	// maxSendDelay is the longest that media of a synced clock is held back for. Media that
+	// takes longer to be ready is sent as soon as it is.
+	maxSendDelay = 250 * time.Millisecond
+	// sendDelayDecay is how much of the difference between the send delay and the latency
+	// of media that is ready sooner the delay shrinks by, so that one slow frame does not
+	// delay the stream forever.
+	sendDelayDecay = 0.01
+)
+
+// sendAt returns when media captured at the given time that is ready to send now should be
+// sent. The media of a clock that is not synced is sent as soon as it is ready.
+func (c *mediaClock) sendAt(captured, now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.synced {
+		return now
+	}
+	latency := now.Sub(captured)
+	switch {
+	case latency > maxSendDelay:
+		c.delay = maxSendDelay
+	case latency > c.delay:
+		c.delay = latency
+	case latency > 0:
+		c.delay -= time.Duration(float64(c.delay-latency) * sendDelayDecay)
+	}
+	wait := c.delay - latency
+	if wait <= 0 {
+		return now
+	}
+	if wait > c.delay {
+		// media captured in the future is not held back for longer than the delay
+		wait = c.delay
+	}
+	return now.Add(wait)
+}
+
+// sendDelay returns how long after it is captured media of the clock is sent.
+func (c *mediaClock) sendDelay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delay
+}
+
+// since returns how long after the origin of the clock the given time is. The first
+// time it is called sets the origin.
+func (c *mediaClock) since(t time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.origin.IsZero() {
+		c.origin = t
+	}
+	return t.Sub(c.origin)
+}
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the Unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// ntpTime converts the given time into the 64-bit NTP timestamp format.
+func ntpTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
 }
 
 // Do a fuzzy find for a codec in the list of codecs
@@ -312,12 +770,18 @@ func payloaderForCodec(codec webrtc.RTPCodecCapability) (rtp.Payloader, error) {
 	switch strings.ToLower(codec.MimeType) {
 	case strings.ToLower(webrtc.MimeTypeH264):
 		return &codecs.H264Payloader{}, nil
+	case strings.ToLower(webrtc.MimeTypeH265):
+		return &h265Payloader{}, nil
 	case strings.ToLower(webrtc.MimeTypeOpus):
 		return &codecs.OpusPayloader{}, nil
 	case strings.ToLower(webrtc.MimeTypeVP8):
 		return &codecs.VP8Payloader{}, nil
 	case strings.ToLower(webrtc.MimeTypeVP9):
 		return &codecs.VP9Payloader{}, nil
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		return &av1Payloader{}, nil
+	case strings.ToLower(MimeTypeJPEG):
+		return &jpegPayloader{}, nil
 	case strings.ToLower(webrtc.MimeTypeG722):
 		return &codecs.G722Payloader{}, nil
 	case strings.ToLower(webrtc.MimeTypePCMU), strings.ToLower(webrtc.MimeTypePCMA):
@@ -326,29 +790,3 @@ func payloaderForCodec(codec webrtc.RTPCodecCapability) (rtp.Payloader, error) {
 		return nil, webrtc.ErrNoPayloaderForCodec
 	}
 }
-
-type samplerFunc func() uint32
-
-// newVideoSampler creates a video sampler that uses the actual video frame rate and
-// the codec's clock rate to come up with a duration for each sample.
-func newVideoSampler(clockRate uint32) samplerFunc {
-	clockRateFloat := float64(clockRate)
-	lastTimestamp := time.Now()
-
-	return samplerFunc(func() uint32 {
-		now := time.Now()
-		duration := now.Sub(lastTimestamp).Seconds()
-		samples := uint32(math.Round(clockRateFloat * duration))
-		lastTimestamp = now
-		return samples
-	})
-}
-
-// newAudioSampler creates a audio sampler that uses a fixed latency and
-// the codec's clock rate to come up with a duration for each sample.
-func newAudioSampler(clockRate uint32, latency time.Duration) samplerFunc {
-	samples := uint32(math.Round(float64(clockRate) * latency.Seconds()))
-	return samplerFunc(func() uint32 {
-		return samples
-	})
-}
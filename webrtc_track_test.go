@@ -0,0 +1,550 @@
+package gostream
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"go.viam.com/test"
+)
+
+// fakeTrackLocalContext binds a track to a fakeTrackLocalWriter without a PeerConnection.
+type fakeTrackLocalContext struct {
+	id     string
+	ssrc   webrtc.SSRC
+	codecs []webrtc.RTPCodecParameters
+	writer *fakeTrackLocalWriter
+
+	headerExtensions []webrtc.RTPHeaderExtensionParameter
+
+	// stream, if set, is written to in place of writer.
+	stream webrtc.TrackLocalWriter
+}
+
+func newFakeTrackLocalContext(id string, ssrc webrtc.SSRC, payloadType webrtc.PayloadType) *fakeTrackLocalContext {
+	return &fakeTrackLocalContext{
+		id:   id,
+		ssrc: ssrc,
+		codecs: []webrtc.RTPCodecParameters{{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+			PayloadType:        payloadType,
+		}},
+		writer: &fakeTrackLocalWriter{},
+	}
+}
+
+func (c *fakeTrackLocalContext) ID() string                                   { return c.id }
+func (c *fakeTrackLocalContext) SSRC() webrtc.SSRC                            { return c.ssrc }
+func (c *fakeTrackLocalContext) CodecParameters() []webrtc.RTPCodecParameters { return c.codecs }
+
+func (c *fakeTrackLocalContext) HeaderExtensions() []webrtc.RTPHeaderExtensionParameter {
+	return c.headerExtensions
+}
+
+func (c *fakeTrackLocalContext) WriteStream() webrtc.TrackLocalWriter {
+	if c.stream != nil {
+		return c.stream
+	}
+	return c.writer
+}
+
+// fakeTrackLocalWriter remembers every packet written to it.
+type fakeTrackLocalWriter struct {
+	mu      sync.Mutex
+	packets []*rtp.Packet
+}
+
+func (w *fakeTrackLocalWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.packets = append(w.packets, &rtp.Packet{Header: *header, Payload: append([]byte(nil), payload...)})
+	return len(payload), nil
+}
+
+func (w *fakeTrackLocalWriter) Write(b []byte) (int, error) {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(b); err != nil {
+		return 0, err
+	}
+	return w.WriteRTP(&packet.Header, packet.Payload)
+}
+
+func (w *fakeTrackLocalWriter) Packets() []*rtp.Packet {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*rtp.Packet(nil), w.packets...)
+}
+
+// interceptedTrackLocalWriter writes packets through an interceptor, as the writers of
+// peer connections do.
+type interceptedTrackLocalWriter struct {
+	writer interceptor.RTPWriter
+}
+
+func (w *interceptedTrackLocalWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	return w.writer.Write(header, payload, interceptor.Attributes{})
+}
+
+func (w *interceptedTrackLocalWriter) Write(b []byte) (int, error) {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(b); err != nil {
+		return 0, err
+	}
+	return w.WriteRTP(&packet.Header, packet.Payload)
+}
+
+// checkRTPStream checks that the packets form a single contiguous RTP stream of
+// the given number of frames, each the given number of samples apart.
+func checkRTPStream(
+	t *testing.T,
+	packets []*rtp.Packet,
+	ssrc webrtc.SSRC,
+	payloadType webrtc.PayloadType,
+	frames int,
+	frameSamples uint32,
+) {
+	t.Helper()
+	test.That(t, packets, test.ShouldNotBeEmpty)
+	var markers int
+	for i, packet := range packets {
+		test.That(t, packet.SSRC, test.ShouldEqual, uint32(ssrc))
+		test.That(t, packet.PayloadType, test.ShouldEqual, uint8(payloadType))
+		if packet.Marker {
+			markers++
+		}
+		if i == 0 {
+			continue
+		}
+		prev := packets[i-1]
+		test.That(t, packet.SequenceNumber, test.ShouldEqual, prev.SequenceNumber+1)
+		if prev.Marker {
+			test.That(t, packet.Timestamp, test.ShouldEqual, prev.Timestamp+frameSamples)
+		} else {
+			// packets of the same frame share its timestamp
+			test.That(t, packet.Timestamp, test.ShouldEqual, prev.Timestamp)
+		}
+	}
+	test.That(t, markers, test.ShouldEqual, frames)
+	test.That(t, packets[len(packets)-1].Marker, test.ShouldBeTrue)
+}
+
+func TestTrackLocalStaticRTPWriteRTP(t *testing.T) {
+	track := newtrackLocalStaticRTP([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+	first := newFakeTrackLocalContext("first", 1111, 96)
+	_, err := track.bind(first)
+	test.That(t, err, test.ShouldBeNil)
+	second := newFakeTrackLocalContext("second", 2222, 120)
+	_, err = track.bind(second)
+	test.That(t, err, test.ShouldBeNil)
+
+	// the packet with sequence number 1 was lost
+	for _, packet := range []rtp.Packet{
+		{Header: rtp.Header{SequenceNumber: 65535, Timestamp: 1000}, Payload: []byte{1}},
+		{Header: rtp.Header{SequenceNumber: 0, Timestamp: 4000}, Payload: []byte{2}},
+		{Header: rtp.Header{SequenceNumber: 2, Timestamp: 10000}, Payload: []byte{3}},
+	} {
+		packet := packet
+		test.That(t, track.WriteRTP(&packet), test.ShouldBeNil)
+	}
+
+	// each peer sees its own sequence numbers and timestamps, spaced as they were written
+	firstPackets := first.writer.Packets()
+	secondPackets := second.writer.Packets()
+	for _, tc := range []struct {
+		packets     []*rtp.Packet
+		ssrc        webrtc.SSRC
+		payloadType uint8
+	}{{firstPackets, first.ssrc, 96}, {secondPackets, second.ssrc, 120}} {
+		test.That(t, tc.packets, test.ShouldHaveLength, 3)
+		for i, packet := range tc.packets {
+			test.That(t, packet.SSRC, test.ShouldEqual, uint32(tc.ssrc))
+			test.That(t, packet.PayloadType, test.ShouldEqual, tc.payloadType)
+			test.That(t, packet.Payload, test.ShouldResemble, []byte{byte(i + 1)})
+		}
+		test.That(t, tc.packets[1].SequenceNumber-tc.packets[0].SequenceNumber, test.ShouldEqual, 1)
+		test.That(t, tc.packets[2].SequenceNumber-tc.packets[1].SequenceNumber, test.ShouldEqual, 2)
+		test.That(t, tc.packets[1].Timestamp-tc.packets[0].Timestamp, test.ShouldEqual, 3000)
+		test.That(t, tc.packets[2].Timestamp-tc.packets[1].Timestamp, test.ShouldEqual, 6000)
+	}
+	test.That(t, [2]uint32{uint32(firstPackets[0].SequenceNumber), firstPackets[0].Timestamp},
+		test.ShouldNotResemble, [2]uint32{uint32(secondPackets[0].SequenceNumber), secondPackets[0].Timestamp})
+}
+
+func TestTrackLocalStaticSampleBindings(t *testing.T) {
+	track := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+	var binds int
+	track.onBind = func() { binds++ }
+
+	// large enough to be split across multiple packets
+	frame := bytes.Repeat([]byte{1}, 3*rtpOutboundMTU)
+	captured := time.Now()
+	writeFrames := func(n int) {
+		for i := 0; i < n; i++ {
+			test.That(t, track.WriteData(webrtc.MimeTypeVP8, frame, captured), test.ShouldBeNil)
+			captured = captured.Add(100 * time.Millisecond)
+		}
+	}
+
+	// nothing is sent before there is a peer
+	writeFrames(1)
+
+	first := newFakeTrackLocalContext("first", 1111, 96)
+	codec, err := track.bind(first)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, codec.PayloadType, test.ShouldEqual, webrtc.PayloadType(96))
+	writeFrames(2)
+
+	second := newFakeTrackLocalContext("second", 2222, 120)
+	_, err = track.bind(second)
+	test.That(t, err, test.ShouldBeNil)
+	writeFrames(3)
+
+	test.That(t, track.unbind(first), test.ShouldBeNil)
+	writeFrames(1)
+
+	test.That(t, binds, test.ShouldEqual, 2)
+	checkRTPStream(t, first.writer.Packets(), first.ssrc, 96, 5, 9000)
+	checkRTPStream(t, second.writer.Packets(), second.ssrc, 120, 4, 9000)
+
+	t.Run("unsupported codec", func(t *testing.T) {
+		unsupported := newFakeTrackLocalContext("unsupported", 3333, 96)
+		unsupported.codecs[0].MimeType = webrtc.MimeTypeH264
+		_, err := track.bind(unsupported)
+		test.That(t, err, test.ShouldBeError, webrtc.ErrUnsupportedCodec)
+		test.That(t, track.unbind(unsupported), test.ShouldBeError, webrtc.ErrUnbindFailed)
+		test.That(t, binds, test.ShouldEqual, 2)
+	})
+}
+
+func TestTrackLocalStaticSampleCodecs(t *testing.T) {
+	track := newVideoTrackLocalStaticSample(
+		[]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}, {MimeType: webrtc.MimeTypeH264}},
+		"video",
+		"test",
+	)
+	test.That(t, track.boundMIMETypes(), test.ShouldBeEmpty)
+
+	// h264 is the only codec this peer supports
+	h264Only := newFakeTrackLocalContext("h264", 1111, 102)
+	h264Only.codecs[0].MimeType = webrtc.MimeTypeH264
+	codec, err := track.bind(h264Only)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, codec.MimeType, test.ShouldEqual, webrtc.MimeTypeH264)
+	test.That(t, track.boundMIMETypes(), test.ShouldResemble, []string{webrtc.MimeTypeH264})
+
+	// vp8 is preferred when the peer supports both
+	both := newFakeTrackLocalContext("both", 2222, 102)
+	both.codecs[0].MimeType = webrtc.MimeTypeH264
+	both.codecs = append(both.codecs, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		PayloadType:        96,
+	})
+	codec, err = track.bind(both)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, codec.MimeType, test.ShouldEqual, webrtc.MimeTypeVP8)
+	test.That(t, track.boundMIMETypes(), test.ShouldResemble, []string{webrtc.MimeTypeVP8, webrtc.MimeTypeH264})
+
+	// each peer only receives frames of its codec
+	start := time.Now()
+	test.That(t, track.WriteData(webrtc.MimeTypeH264, []byte{1, 2, 3}, start), test.ShouldBeNil)
+	test.That(t, track.WriteData(webrtc.MimeTypeVP8, []byte{4, 5, 6}, start), test.ShouldBeNil)
+	test.That(t, track.WriteData(webrtc.MimeTypeH264, []byte{1, 2, 3}, start.Add(100*time.Millisecond)), test.ShouldBeNil)
+	checkRTPStream(t, h264Only.writer.Packets(), h264Only.ssrc, 102, 2, 9000)
+	checkRTPStream(t, both.writer.Packets(), both.ssrc, 96, 1, 9000)
+
+	test.That(t, track.unbind(h264Only), test.ShouldBeNil)
+	test.That(t, track.boundMIMETypes(), test.ShouldResemble, []string{webrtc.MimeTypeVP8})
+}
+
+func TestTrackLocalStaticSampleAwaitKeyFrames(t *testing.T) {
+	track := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+	track.awaitKeyFrames = true
+
+	first := newFakeTrackLocalContext("first", 1111, 96)
+	_, err := track.bind(first)
+	test.That(t, err, test.ShouldBeNil)
+
+	captured := time.Now()
+	writeFrame := func(keyFrame bool) {
+		frame := EncodedVideoFrame{Data: []byte{1, 2, 3}, MIMEType: webrtc.MimeTypeVP8, KeyFrame: keyFrame}
+		test.That(t, track.WriteEncodedVideoFrame(frame, captured), test.ShouldBeNil)
+		captured = captured.Add(100 * time.Millisecond)
+	}
+	writeFrame(false)
+	test.That(t, first.writer.Packets(), test.ShouldBeEmpty)
+	writeFrame(true)
+	writeFrame(false)
+
+	// a peer that joins later waits for the next key frame too
+	second := newFakeTrackLocalContext("second", 2222, 96)
+	_, err = track.bind(second)
+	test.That(t, err, test.ShouldBeNil)
+	writeFrame(false)
+	test.That(t, second.writer.Packets(), test.ShouldBeEmpty)
+	writeFrame(true)
+
+	checkRTPStream(t, first.writer.Packets(), first.ssrc, 96, 4, 9000)
+	checkRTPStream(t, second.writer.Packets(), second.ssrc, 96, 1, 9000)
+}
+
+func TestTrackLocalStaticSampleTimestamps(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	t.Run("video follows capture time", func(t *testing.T) {
+		track := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+		timing := &codecTiming{clockRate: 90000}
+		test.That(t, track.capturedSamples(timing, at(0)), test.ShouldEqual, 0)
+		timing.lastSamples = track.capturedSamples(timing, at(33))
+		test.That(t, timing.lastSamples, test.ShouldEqual, 2970)
+		timing.lastSamples = track.capturedSamples(timing, at(100))
+		test.That(t, timing.lastSamples, test.ShouldEqual, 9000)
+		// never goes backwards
+		test.That(t, track.capturedSamples(timing, at(50)), test.ShouldEqual, 9000)
+	})
+
+	t.Run("audio is spaced by latency", func(t *testing.T) {
+		track := newAudioTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeOpus}}, "audio", "test")
+		timing := &codecTiming{clockRate: 48000}
+		track.audioLatency = 20 * time.Millisecond
+		test.That(t, track.capturedSamples(timing, at(0)), test.ShouldEqual, 0)
+		timing.started = true
+		// jitter is smoothed out
+		timing.lastSamples = track.capturedSamples(timing, at(25))
+		test.That(t, timing.lastSamples, test.ShouldEqual, 960)
+		timing.lastSamples = track.capturedSamples(timing, at(35))
+		test.That(t, timing.lastSamples, test.ShouldEqual, 1920)
+		// but gaps are not
+		timing.lastSamples = track.capturedSamples(timing, at(200))
+		test.That(t, timing.lastSamples, test.ShouldEqual, 9600)
+	})
+
+	t.Run("audio starts at its capture time", func(t *testing.T) {
+		// the clock is shared with video captured first
+		track := newAudioTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeOpus}}, "audio", "test")
+		track.clock.since(at(0))
+		timing := &codecTiming{clockRate: 48000}
+		track.audioLatency = 20 * time.Millisecond
+		test.That(t, track.capturedSamples(timing, at(5)), test.ShouldEqual, 240)
+	})
+}
+
+func TestTrackLocalStaticSampleSenderReports(t *testing.T) {
+	var nowMu sync.Mutex
+	var now time.Time
+	setNow := func(t time.Time) {
+		nowMu.Lock()
+		defer nowMu.Unlock()
+		now = t
+	}
+	getNow := func() time.Time {
+		nowMu.Lock()
+		defer nowMu.Unlock()
+		return now
+	}
+	factory, err := report.NewSenderInterceptor(report.SenderNow(getNow), report.SenderInterval(time.Millisecond))
+	test.That(t, err, test.ShouldBeNil)
+	senderInterceptor, err := factory.NewInterceptor("")
+	test.That(t, err, test.ShouldBeNil)
+	defer func() {
+		test.That(t, senderInterceptor.Close(), test.ShouldBeNil)
+	}()
+	pionReports := make(chan *rtcp.SenderReport, 100)
+	senderInterceptor.BindRTCPWriter(interceptor.RTCPWriterFunc(
+		func(pkts []rtcp.Packet, _ interceptor.Attributes) (int, error) {
+			for _, pkt := range pkts {
+				if report, ok := pkt.(*rtcp.SenderReport); ok {
+					select {
+					case pionReports <- report:
+					default:
+					}
+				}
+			}
+			return 0, nil
+		}))
+	// pionReport waits for pion to report on the given SSRC at the given time.
+	pionReport := func(ssrc webrtc.SSRC, at time.Time) *rtcp.SenderReport {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case report := <-pionReports:
+				// pion converts to NTP time slightly differently
+				if report.SSRC == uint32(ssrc) && math.Abs(float64(int64(report.NTPTime-ntpTime(at)))) < 1<<12 {
+					return report
+				}
+			case <-timeout:
+				t.Fatalf("no report from pion for %d", ssrc)
+			}
+		}
+	}
+
+	video := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+	audio := newAudioTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeOpus}}, "audio", "test")
+	video.clock.synced = true
+	audio.clock = video.clock
+	audio.setAudioLatency(20 * time.Millisecond)
+	bind := func(track *trackLocalStaticSample, ctx *fakeTrackLocalContext) {
+		ctx.stream = &interceptedTrackLocalWriter{senderInterceptor.BindLocalStream(
+			&interceptor.StreamInfo{SSRC: uint32(ctx.ssrc), ClockRate: ctx.codecs[0].ClockRate},
+			interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+				return ctx.writer.WriteRTP(header, payload)
+			}),
+		)}
+		_, err := track.bind(ctx)
+		test.That(t, err, test.ShouldBeNil)
+	}
+	videoCtx := newFakeTrackLocalContext("video", 1111, 96)
+	bind(video, videoCtx)
+	audioCtx := newFakeTrackLocalContext("audio", 2222, 111)
+	audioCtx.codecs[0].RTPCodecCapability = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000}
+	bind(audio, audioCtx)
+
+	_, ok := video.senderReport(videoCtx.ssrc, time.Now())
+	test.That(t, ok, test.ShouldBeFalse)
+
+	// video is captured every 40ms and ready to send 30ms later while audio is captured
+	// every 20ms and ready 5ms later, so audio is held back to be sent as late as video
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		captured := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		sent := video.clock.sendAt(captured, captured.Add(5*time.Millisecond))
+		if i > 1 {
+			test.That(t, sent.Sub(captured), test.ShouldBeGreaterThan, 25*time.Millisecond)
+		}
+		setNow(sent)
+		test.That(t, audio.writeData(webrtc.MimeTypeOpus, []byte{4, 5, 6}, false, captured, sent), test.ShouldBeNil)
+		if i%2 == 0 {
+			sent := video.clock.sendAt(captured, captured.Add(30*time.Millisecond))
+			test.That(t, sent, test.ShouldEqual, captured.Add(30*time.Millisecond))
+			setNow(sent)
+			test.That(t, video.writeData(webrtc.MimeTypeVP8, []byte{1, 2, 3}, false, captured, sent), test.ShouldBeNil)
+		}
+	}
+
+	// every peer connection also has pion's sender report interceptor, which relates RTP
+	// times to when the last packet was sent rather than when it was captured, so the
+	// reports of both must describe the same mapping for peers to only ever see one.
+	at := start.Add(time.Second)
+	setNow(at)
+	for _, tc := range []struct {
+		track     *trackLocalStaticSample
+		ctx       *fakeTrackLocalContext
+		clockRate float64
+	}{{video, videoCtx, 90000}, {audio, audioCtx, 48000}} {
+		report, ok := tc.track.senderReport(tc.ctx.ssrc, at)
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, report.NTPTime, test.ShouldEqual, ntpTime(at))
+		expected := pionReport(tc.ctx.ssrc, at)
+		// the send delay shrinks slightly between frames of video
+		test.That(t, float64(int32(report.RTPTime-expected.RTPTime))/tc.clockRate, test.ShouldAlmostEqual, 0, 0.001)
+		test.That(t, report.PacketCount, test.ShouldEqual, expected.PacketCount)
+		test.That(t, report.OctetCount, test.ShouldEqual, expected.OctetCount)
+	}
+	_, ok = video.senderReport(audioCtx.ssrc, at)
+	test.That(t, ok, test.ShouldBeFalse)
+	videoReport, _ := video.senderReport(videoCtx.ssrc, at)
+	test.That(t, videoReport.PacketCount, test.ShouldEqual, 10)
+	audioReport, _ := audio.senderReport(audioCtx.ssrc, at)
+	test.That(t, audioReport.PacketCount, test.ShouldEqual, 20)
+	test.That(t, audioReport.OctetCount, test.ShouldEqual, 60)
+
+	// tracks of streams with nothing to synchronize them with leave reporting to pion
+	alone := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+	aloneCtx := newFakeTrackLocalContext("video", 3333, 96)
+	_, err = alone.bind(aloneCtx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, alone.WriteData(webrtc.MimeTypeVP8, []byte{1}, start), test.ShouldBeNil)
+	test.That(t, aloneCtx.writer.Packets(), test.ShouldHaveLength, 1)
+	_, ok = alone.senderReport(aloneCtx.ssrc, at)
+	test.That(t, ok, test.ShouldBeFalse)
+}
+
+func TestMediaClockSendAt(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	var clock mediaClock
+	test.That(t, clock.sendAt(at(0), at(30)), test.ShouldEqual, at(30))
+	test.That(t, clock.sendAt(at(20), at(25)), test.ShouldEqual, at(25))
+
+	clock.synced = true
+	// the delay follows the slowest media
+	test.That(t, clock.sendAt(at(40), at(70)), test.ShouldEqual, at(70))
+	test.That(t, clock.sendDelay(), test.ShouldEqual, 30*time.Millisecond)
+	sent := clock.sendAt(at(60), at(65))
+	test.That(t, sent.Sub(at(60)), test.ShouldAlmostEqual, 30*time.Millisecond, time.Millisecond)
+	// but shrinks towards that of faster media
+	test.That(t, clock.sendDelay(), test.ShouldBeLessThan, 30*time.Millisecond)
+	// and is capped
+	test.That(t, clock.sendAt(at(100), at(600)), test.ShouldEqual, at(600))
+	test.That(t, clock.sendDelay(), test.ShouldEqual, maxSendDelay)
+	// media captured in the future is held back for at most the delay
+	test.That(t, clock.sendAt(at(2000), at(1000)), test.ShouldEqual, at(1000).Add(maxSendDelay))
+}
+
+func TestTrackLocalStaticSampleSentPackets(t *testing.T) {
+	// pion's interceptor numbers the packets of both tracks sent to a peer
+	factory, err := twcc.NewHeaderExtensionInterceptor()
+	test.That(t, err, test.ShouldBeNil)
+	headerExtensionInterceptor, err := factory.NewInterceptor("")
+	test.That(t, err, test.ShouldBeNil)
+	headerExtensions := []webrtc.RTPHeaderExtensionParameter{{URI: transportCCURI, ID: 5}}
+	bind := func(track *trackLocalStaticSample, ctx *fakeTrackLocalContext) {
+		ctx.headerExtensions = headerExtensions
+		ctx.stream = &interceptedTrackLocalWriter{headerExtensionInterceptor.BindLocalStream(
+			&interceptor.StreamInfo{
+				SSRC:                uint32(ctx.ssrc),
+				RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: transportCCURI, ID: 5}},
+			},
+			interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+				return ctx.writer.WriteRTP(header, payload)
+			}),
+		)}
+		_, err := track.bind(ctx)
+		test.That(t, err, test.ShouldBeNil)
+	}
+	first := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+	firstCtx := newFakeTrackLocalContext("first", 1111, 96)
+	bind(first, firstCtx)
+	second := newVideoTrackLocalStaticSample([]webrtc.RTPCodecCapability{{MimeType: webrtc.MimeTypeVP8}}, "video", "test")
+	secondCtx := newFakeTrackLocalContext("second", 2222, 96)
+	bind(second, secondCtx)
+	// nothing is remembered for peers that cannot send transport-wide feedback
+	withoutFeedback := newFakeTrackLocalContext("third", 3333, 96)
+	_, err = first.bind(withoutFeedback)
+	test.That(t, err, test.ShouldBeNil)
+
+	sentAt := time.Now()
+	test.That(t, first.writeData(webrtc.MimeTypeVP8, []byte{1, 2, 3}, false, sentAt, sentAt), test.ShouldBeNil)
+	test.That(t, second.writeData(webrtc.MimeTypeVP8, []byte{1, 2, 3}, false, sentAt, sentAt.Add(time.Millisecond)), test.ShouldBeNil)
+
+	packet, ok := first.sentPacket(firstCtx.ssrc, 0)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, packet.at, test.ShouldEqual, sentAt)
+	test.That(t, packet.size, test.ShouldEqual, firstCtx.writer.Packets()[0].MarshalSize())
+	_, ok = first.sentPacket(firstCtx.ssrc, 1)
+	test.That(t, ok, test.ShouldBeFalse)
+	packet, ok = second.sentPacket(secondCtx.ssrc, 1)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, packet.at, test.ShouldEqual, sentAt.Add(time.Millisecond))
+	_, ok = first.sentPacket(withoutFeedback.ssrc, 0)
+	test.That(t, ok, test.ShouldBeFalse)
+}
+
+func TestNTPTime(t *testing.T) {
+	test.That(t, ntpTime(time.Unix(0, 0)), test.ShouldEqual, uint64(ntpEpochOffset)<<32)
+	test.That(t, ntpTime(time.Unix(1, int64(time.Second/2))), test.ShouldEqual, uint64(ntpEpochOffset+1)<<32|1<<31)
+}